@@ -5,6 +5,7 @@ import (
     "fmt"
     "log"
     "strconv"
+    "strings"
     "sync"
 
     _ "github.com/mattn/go-sqlite3"
@@ -110,7 +111,7 @@ func KvPut(key string, value string) bool {
 
     if (err != nil) {
         success = false
-        fmt.Println("Failed to write database value. Error: %s\n", err)
+        fmt.Printf("Failed to write database value. Error: %s\n", err)
         tx.Rollback()
     } else {
         tx.Commit()
@@ -119,6 +120,85 @@ func KvPut(key string, value string) bool {
     return success
 }
 
+/**
+ * Retrieves all key/value pairs whose keys start with a prefix.
+ *
+ * @param prefix The key prefix.
+ *
+ * @returns A map of key to value. Empty if nothing matched.
+ */
+func KvList(prefix string) map[string]string {
+
+    // Probably overkill, but we mutex database access
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    values := make(map[string]string)
+
+    db, err := sql.Open("sqlite3", dbPath)
+
+    if (err != nil) {
+        log.Fatal(err)
+    }
+    defer db.Close()
+
+    // Escape anything in the prefix that LIKE would treat as a wildcard
+    escaper := strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+    rows, err := db.Query("SELECT KEY, VALUE FROM KVSTORE " +
+                          "WHERE KEY LIKE ? ESCAPE '\\';",
+                          escaper.Replace(prefix) + "%")
+
+    if (err != nil) {
+        log.Fatal(err)
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var key   string
+        var value string
+
+        err = rows.Scan(&key, &value)
+
+        if (err != nil) {
+            log.Fatal(err)
+        }
+
+        values[key] = value
+    }
+
+    return values
+}
+
+/**
+ * Removes a value from the key/value store.
+ *
+ * @param key The key
+ *
+ * @retval bool Whether the operation was successful.
+ */
+func KvDelete(key string) bool {
+
+    // Probably overkill, but we mutex database access
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    db, err := sql.Open("sqlite3", dbPath)
+
+    if (err != nil) {
+        log.Fatal(err)
+    }
+    defer db.Close()
+
+    _, err = db.Exec("DELETE FROM KVSTORE WHERE KEY=?;", key)
+
+    if (err != nil) {
+        fmt.Printf("Failed to delete database value. Error: %s\n", err)
+    }
+
+    return (err == nil)
+}
+
 /**
  * Increments an integral value in the KV store by a specified amount.
  *
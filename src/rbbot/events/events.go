@@ -0,0 +1,199 @@
+/**
+ * A publish/subscribe stream of review lifecycle events.
+ *
+ * Anything in the bot may publish an event, and anything (requester plugins,
+ * sink plugins, core components) may subscribe to the stream. Publishing never
+ * blocks: each subscriber has its own buffered channel, and events are dropped
+ * for any subscriber which has fallen behind. Core components which can't
+ * afford to lose events subscribe reliably instead, and have them queued for
+ * as long as they take to catch up.
+ */
+package events
+
+import (
+    "fmt"
+    "sync"
+    "sync/atomic"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+const (
+    defaultBufferSize = 100
+)
+
+/**
+ * A single subscriber to the event stream.
+ */
+type subscriber struct {
+    name     string
+    events   chan reviewdata.Event
+    reliable bool   // Whether events are queued rather than dropped
+    dropped  uint64
+}
+
+var (
+    bufferSize  int = defaultBufferSize
+    mutex       sync.RWMutex
+    subscribers []*subscriber
+)
+
+/**
+ * Configures the event stream.
+ *
+ * @param subscriberBuffer The number of events buffered for each subscriber
+ *                         before events start being dropped. If zero, a default
+ *                         is used.
+ */
+func Configure(subscriberBuffer int) {
+    if (subscriberBuffer > 0) {
+        bufferSize = subscriberBuffer
+    }
+    fmt.Printf("Events: Subscriber buffer size is %d\n", bufferSize)
+}
+
+/**
+ * Subscribes to the event stream.
+ *
+ * @param name A name for the subscriber, used when reporting dropped events.
+ *
+ * @returns A channel from which all events published from now on can be read.
+ */
+func Subscribe(name string) <-chan reviewdata.Event {
+    sub := &subscriber{name:   name,
+                       events: make(chan reviewdata.Event, bufferSize)}
+
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    subscribers = append(subscribers, sub)
+
+    return sub.events
+}
+
+/**
+ * Subscribes to the event stream without ever losing events. However far the
+ * subscriber falls behind, events are queued for it.
+ *
+ * @param name A name for the subscriber.
+ *
+ * @returns A channel from which all events published from now on can be read.
+ */
+func SubscribeReliably(name string) <-chan reviewdata.Event {
+    sub := &subscriber{name:     name,
+                       events:   make(chan reviewdata.Event, bufferSize),
+                       reliable: true}
+    out := make(chan reviewdata.Event)
+
+    go queue(sub.events, out)
+
+    mutex.Lock()
+    defer mutex.Unlock()
+
+    subscribers = append(subscribers, sub)
+
+    return out
+}
+
+/**
+ * Passes events on from a reliable subscriber's channel, queueing as many as
+ * it needs to. Never blocks on reading, so publishing to it doesn't either.
+ *
+ * @param in  The channel that events are published to.
+ * @param out The channel that the subscriber reads.
+ */
+func queue(in <-chan reviewdata.Event, out chan <- reviewdata.Event) {
+    var queued []reviewdata.Event
+
+    for {
+        var send chan <- reviewdata.Event
+        var next reviewdata.Event
+
+        // Sending on a nil channel never happens, so only send when there's
+        // something to
+        if (len(queued) > 0) {
+            send = out
+            next = queued[0]
+        }
+
+        select {
+        case event := <-in:
+            queued = append(queued, event)
+        case send <- next:
+            queued = queued[1:]
+        }
+    }
+}
+
+/**
+ * Publishes an event to all subscribers. Never blocks.
+ *
+ * @param eventType The type of event.
+ * @param server    The name of the server on which the review lives.
+ * @param reviewId  The ID of the review to which the event relates.
+ * @param details   Any event-specific details. May be nil.
+ */
+func Publish(eventType reviewdata.EventType,
+             server    string,
+             reviewId  string,
+             details   map[string]string) {
+    PublishEvent(reviewdata.Event{Type:     eventType,
+                                  Server:   server,
+                                  ReviewId: reviewId,
+                                  Details:  details})
+}
+
+/**
+ * Publishes a fully-formed event to all subscribers. Never blocks.
+ *
+ * @param event The event. If its time is not set, it is set to now.
+ */
+func PublishEvent(event reviewdata.Event) {
+    if (event.Time.IsZero()) {
+        event.Time = time.Now()
+    }
+
+    mutex.RLock()
+    defer mutex.RUnlock()
+
+    for _, sub := range subscribers {
+        if (sub.reliable) {
+            sub.events <- event
+            continue
+        }
+
+        select {
+        case sub.events <- event:
+        default:
+            // The subscriber is too slow. Drop the event rather than hold up
+            // the review
+            dropped := atomic.AddUint64(&sub.dropped, 1)
+
+            fmt.Printf("Events: Subscriber %s is full, dropped %s event for " +
+                       "review %s (%d dropped)\n",
+                       sub.name,
+                       event.Type,
+                       event.ReviewId,
+                       dropped)
+        }
+    }
+}
+
+/**
+ * Retrieves the number of events dropped for each subscriber.
+ *
+ * @returns A map of subscriber name to dropped event count.
+ */
+func Dropped() map[string]uint64 {
+    mutex.RLock()
+    defer mutex.RUnlock()
+
+    dropped := make(map[string]uint64)
+
+    for _, sub := range subscribers {
+        dropped[sub.name] += atomic.LoadUint64(&sub.dropped)
+    }
+
+    return dropped
+}
@@ -8,12 +8,16 @@ import (
     "log"
     "encoding/json"
     "os"
+    "path/filepath"
     "runtime"
     "time"
 
     "rbplugindata/reviewdata"
     "rbbot/reviewer"
     "rbbot/db"
+    "rbbot/events"
+    "rbbot/notify"
+    "rbbot/report"
 )
 
 /**
@@ -26,19 +30,54 @@ type ReviewRequester interface {
     Run(chan <- reviewdata.ReviewRequest) // Runs the requester
 }
 
+/**
+ * A ReviewRequester may also provide the following function, in which case it
+ * is handed the review event stream.
+ */
+type EventListener interface {
+    Listen(<- chan reviewdata.Event) // Consumes events. Run as a goroutine
+}
+
+/**
+ * A ReviewRequester may also provide the following function, in which case it
+ * is given a way to look up the bot's review servers, and to check whether a
+ * review is stale, before it runs.
+ */
+type ServerUser interface {
+    UseServers(func(string) (reviewdata.ServerProfile, bool),
+               func(string, string) bool)
+}
+
+/**
+ * An EventSink plugin is something that provides the following functions.
+ */
+type EventSink interface {
+    Version()       (int,int,int) // The plguin's version (major minor micro)
+    CanonicalName() string // The plugin's canonical name
+    Configure(json.RawMessage)    // Configures itself
+    Run(<- chan reviewdata.Event) // Consumes events. Run as a goroutine
+}
+
 /**
  * The config structure.
  */
 type Config struct {
-    Version     string          // Config version
-    PluginPath  string          // Path under which plugins exist
-    DbPath      string          // String to the sqlite database
-    ReviewBoard json.RawMessage // Not parsed, passed to the reviewer to parse
-    Plugins struct {
+    Version       string          // Config version
+    PluginPath    string          // Path under which plugins exist
+    DbPath        string          // String to the sqlite database
+    ReviewBoard   json.RawMessage // Not parsed, passed to the reviewer to parse
+    Servers       json.RawMessage // Named servers. Passed to the reviewer
+    Notifications json.RawMessage // Not parsed, passed to notify to parse
+    Reports       json.RawMessage // Not parsed, passed to report to parse
+    Plugins       struct {
         Requester json.RawMessage
         Reviewer  json.RawMessage
+        Sink      json.RawMessage
+    }
+    Events        struct {
+        SubscriberBuffer int
     }
-    Stats       struct {
+    Stats         struct {
         Logstats       bool
         LogIntervalSec int
     }
@@ -107,6 +146,19 @@ func RunRequestPlugins(pluginDir         string,
             // Configure the plugin
             reviewRequester.Configure(config)
 
+            // If the plugin wants to know about the servers, tell it how
+            if user, ok := requester.(ServerUser); (ok) {
+                user.UseServers(reviewer.ServerProfile, reviewer.IsStale)
+            }
+
+            // If the plugin wants to hear about reviews, tell it. It's
+            // subscribed before it runs, so it hears about every review it
+            // requests
+            if listener, ok := requester.(EventListener); (ok) {
+                go listener.Listen(events.Subscribe(
+                                            reviewRequester.CanonicalName()))
+            }
+
             // Run the plugin
             go reviewRequester.Run(reviewRequestChan)
 
@@ -123,6 +175,73 @@ func RunRequestPlugins(pluginDir         string,
     return success
 }
 
+/**
+ * Manages event sink plugins.
+ *
+ * Sink plugins receive the review event stream, and do what they like with it.
+ * Sinks are optional, so a missing plugin directory is not an error.
+ *
+ * @param pluginDir The directory from which sink plugins should be loaded.
+ * @param config    Raw sink plugin config, from which the plugins can
+ *                  configure.
+ *
+ * @retval true  If all plugins loaded successfully.
+ * @retval false Otherwise (error message will have been printed).
+ */
+func RunSinkPlugins(pluginDir string, config json.RawMessage) bool {
+    var success = true
+
+    // Gather all of the files in the plugin directory
+    pluginFiles, err := ioutil.ReadDir(pluginDir)
+
+    if (err != nil) {
+        fmt.Printf("No sink plugins loaded from %s\n", pluginDir)
+    } else {
+        for _, file := range pluginFiles {
+            // Load the plugin
+            plug, err := plugin.Open(pluginDir + "/" + file.Name())
+            if err != nil {
+                fmt.Println(err)
+                success = false
+                break
+            }
+
+            // Look up the EventSink symbol, which the plugin must have exported
+            sink, err := plug.Lookup("EventSink")
+            if err != nil {
+                fmt.Println(err)
+                success = false
+                break
+            }
+
+            // Assert that the loaded symbol is an EventSink
+            var eventSink EventSink
+            eventSink, ok := sink.(EventSink)
+            if !ok {
+                fmt.Printf("Could not load EventSink symbol from %s\n", file)
+                success = false
+                break
+            }
+
+            // Configure the plugin
+            eventSink.Configure(config)
+
+            // Subscribe, then run the plugin
+            go eventSink.Run(events.Subscribe(eventSink.CanonicalName()))
+
+            major, minor, micro := eventSink.Version()
+
+            fmt.Printf("Loaded sink: %s at version %d.%d.%d\n",
+                       eventSink.CanonicalName(),
+                       major,
+                       minor,
+                       micro)
+        }
+    }
+
+    return success
+}
+
 /**
  * Logs stats every X seconds.
  *
@@ -132,6 +251,13 @@ func LogStats (interval int) {
     for {
         //Print the number of running goroutines
         fmt.Printf("%d goroutines currently running\n", runtime.NumGoroutine())
+
+        // Print any events that slow subscribers have missed
+        for name, dropped := range events.Dropped() {
+            if (dropped > 0) {
+                fmt.Printf("%d events dropped for %s\n", dropped, name)
+            }
+        }
         time.Sleep(time.Duration(interval) * time.Second)
     }
 }
@@ -172,6 +298,36 @@ func main() {
     // Let the db component know where its database lives
     db.Configure(config.DbPath)
 
+    // Size the event stream before anybody subscribes to it
+    events.Configure(config.Events.SubscriberBuffer)
+
+    // Notifications and sinks are set going first, so that they see every
+    // event
+    err := notify.Configure(config.Notifications)
+
+    if (err != nil) {
+        log.Fatal(err)
+    }
+
+    if (notify.Enabled()) {
+        go notify.Run(events.SubscribeReliably("Notifications"))
+    }
+
+    err = report.Configure(config.Reports)
+
+    if (err != nil) {
+        log.Fatal(err)
+    }
+
+    if (report.Enabled()) {
+        go report.Run(events.SubscribeReliably("Reports"))
+        go report.Serve()
+    }
+
+    if (!RunSinkPlugins(config.PluginPath + "/sink", config.Plugins.Sink)) {
+        log.Fatal("Failed to load sink plugins")
+    }
+
     // A channel into which review requests are placed for reviewing
     reviewRequests := make(chan reviewdata.ReviewRequest)
 
@@ -188,7 +344,9 @@ func main() {
 
     // Set the reviewer going
     reviewer.Go(config.PluginPath + "/review",
+                filepath.Dir(*cfgFilePtr),
                 config.ReviewBoard,
+                config.Servers,
                 config.Plugins.Reviewer,
                 reviewRequests)
 }
@@ -0,0 +1,395 @@
+/**
+ * Tells people about finished reviews, through configurable notification sinks.
+ *
+ * Notifications are sent when a review is published, to every sink configured
+ * for the review's server whose severity threshold the review's findings meet.
+ * Failed deliveries are stored in the database and retried periodically.
+ */
+package notify
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "strconv"
+    "strings"
+    "text/template"
+    "time"
+
+    "rbbot/db"
+    "rbplugindata/reviewdata"
+)
+
+const (
+    pendingPrefix = "NotifyPending_"
+
+    defaultTopFindings     = 5
+    defaultRetryInterval   = 60
+    defaultMaxAttempts     = 10
+    defaultTemplate        = "{{.Summary}} - {{.Link}}\n" +
+                             "{{.Issues}} issues, {{len .Findings}} findings" +
+                             "{{range $sev, $n := .Severities}}, " +
+                             "{{$n}} {{$sev}}{{end}}\n" +
+                             "{{range .TopFindings}}" +
+                             "- [{{.Comment.EffectiveSeverity}}] " +
+                             "{{.Filename}}: {{.Comment.Text}}\n{{end}}"
+    defaultSubjectTemplate = "Review {{.ReviewId}}: {{.Issues}} issues - " +
+                             "{{.Summary}}"
+)
+
+/**
+ * The configuration of a single sink. Which fields are used depends on the
+ * sink's type.
+ */
+type SinkConfig struct {
+    Type            string   // "webhook", "smtp" or "jsonl"
+    Name            string   // Unique, and used to track pending deliveries
+    Servers         []string // Servers whose reviews are notified. Empty for
+                             // all servers
+    MinSeverity     string   // Only notify if a finding is at least this
+                             // severe. Empty notifies on every review
+    TopFindings     int      // The number of findings given to templates
+    Template        string   // text/template for the message
+    SubjectTemplate string   // text/template for the subject, where relevant
+
+    // Webhook
+    Url     string
+    Headers map[string]string
+
+    // SMTP
+    SmtpHost string // host:port
+    Username string
+    Password string
+    From     string
+    To       []string
+
+    // JSONL
+    Path string
+}
+
+/**
+ * The notification config block.
+ */
+type Config struct {
+    RetryIntervalSec int
+    MaxAttempts      int
+    Sinks            []SinkConfig
+}
+
+/**
+ * A single notification, on its way to a single sink.
+ */
+type Delivery struct {
+    Sink     string
+    Subject  string
+    Message  string
+    Review   reviewdata.ReviewSummary
+    Attempts int
+}
+
+/**
+ * A Sink is something that can deliver notifications.
+ */
+type Sink interface {
+    Deliver(Delivery) error // Delivers a notification
+}
+
+/**
+ * The data passed to message and subject templates.
+ */
+type TemplateData struct {
+    reviewdata.ReviewSummary
+    TopFindings []reviewdata.Finding
+}
+
+/**
+ * A sink, along with everything needed to decide what to send it.
+ */
+type configuredSink struct {
+    config  SinkConfig
+    sink    Sink
+    message *template.Template
+    subject *template.Template
+}
+
+var (
+    config Config
+    sinks  = make(map[string]*configuredSink)
+
+    /**
+     * Constructors for each type of sink. New types of sink are added here.
+     */
+    sinkTypes = map[string]func(SinkConfig) (Sink, error){
+        "webhook": NewWebhookSink,
+        "smtp":    NewSmtpSink,
+        "jsonl":   NewJsonlSink,
+    }
+)
+
+/**
+ * Configures notifications.
+ *
+ * @param rawConfig A raw json message containing notification config.
+ *
+ * @retval error Error status
+ */
+func Configure(rawConfig json.RawMessage) error {
+    if (len(rawConfig) == 0) {
+        return nil
+    }
+
+    err := json.Unmarshal(rawConfig, &config)
+
+    if (err != nil) {
+        return err
+    }
+
+    if (config.RetryIntervalSec <= 0) {
+        config.RetryIntervalSec = defaultRetryInterval
+    }
+
+    if (config.MaxAttempts <= 0) {
+        config.MaxAttempts = defaultMaxAttempts
+    }
+
+    for _, sinkConfig := range config.Sinks {
+        newSink, found := sinkTypes[sinkConfig.Type]
+
+        if (!found) {
+            return errors.New("Unknown notification sink type: " +
+                              sinkConfig.Type)
+        } else if (sinkConfig.Name == "") {
+            return errors.New("Notification sinks must be named")
+        } else if (sinks[sinkConfig.Name] != nil) {
+            return errors.New("Duplicate notification sink: " +
+                              sinkConfig.Name)
+        }
+
+        if (sinkConfig.TopFindings <= 0) {
+            sinkConfig.TopFindings = defaultTopFindings
+        }
+
+        if (sinkConfig.Template == "") {
+            sinkConfig.Template = defaultTemplate
+        }
+
+        if (sinkConfig.SubjectTemplate == "") {
+            sinkConfig.SubjectTemplate = defaultSubjectTemplate
+        }
+
+        configured := &configuredSink{config: sinkConfig}
+
+        configured.message, err = template.New(sinkConfig.Name).Parse(
+                                                        sinkConfig.Template)
+        if (err != nil) {
+            return err
+        }
+
+        configured.subject, err = template.New(sinkConfig.Name).Parse(
+                                                sinkConfig.SubjectTemplate)
+        if (err != nil) {
+            return err
+        }
+
+        configured.sink, err = newSink(sinkConfig)
+        if (err != nil) {
+            return err
+        }
+
+        sinks[sinkConfig.Name] = configured
+
+        fmt.Printf("Notify: Configured %s sink %s\n",
+                   sinkConfig.Type,
+                   sinkConfig.Name)
+    }
+
+    return nil
+}
+
+/**
+ * Whether any sinks are configured.
+ */
+func Enabled() bool {
+    return len(sinks) > 0
+}
+
+/**
+ * Decides whether a sink wants to hear about a review.
+ *
+ * @param sinkConfig The sink's config.
+ * @param review     The review's summary.
+ *
+ * @returns Whether the sink should be notified.
+ */
+func Wants(sinkConfig SinkConfig, review *reviewdata.ReviewSummary) bool {
+    var serverMatch bool = (len(sinkConfig.Servers) == 0)
+
+    for _, server := range sinkConfig.Servers {
+        if (server == review.Server) {
+            serverMatch = true
+        }
+    }
+
+    if (!serverMatch) {
+        return false
+    } else if (sinkConfig.MinSeverity == "") {
+        return true
+    }
+
+    minRank := reviewdata.SeverityRank(sinkConfig.MinSeverity)
+
+    for severity, count := range review.Severities {
+        if (count > 0 && reviewdata.SeverityRank(severity) >= minRank) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Renders a template against a review summary.
+ */
+func Render(tmpl        *template.Template,
+            review      *reviewdata.ReviewSummary,
+            topFindings  int) (string, error) {
+    var out strings.Builder
+
+    data := TemplateData{ReviewSummary: *review,
+                         TopFindings:   review.Findings}
+
+    if (len(data.TopFindings) > topFindings) {
+        data.TopFindings = data.TopFindings[:topFindings]
+    }
+
+    err := tmpl.Execute(&out, data)
+
+    return out.String(), err
+}
+
+/**
+ * Attempts a delivery. On failure, the delivery is stored for retry, until it
+ * has been attempted too many times.
+ *
+ * @param sink     The sink to which the delivery is made.
+ * @param delivery The delivery.
+ * @param key      The key under which the delivery is stored, if it has
+ *                 previously failed. Empty otherwise.
+ */
+func Deliver(sink Sink, delivery Delivery, key string) {
+    err := sink.Deliver(delivery)
+    delivery.Attempts++
+
+    if (err == nil) {
+        if (key != "") {
+            db.KvDelete(key)
+        }
+    } else if (delivery.Attempts >= config.MaxAttempts) {
+        fmt.Printf("Notify: Giving up on %s notification for review %s " +
+                   "after %d attempts: %s\n",
+                   delivery.Sink,
+                   delivery.Review.ReviewId,
+                   delivery.Attempts,
+                   err)
+
+        if (key != "") {
+            db.KvDelete(key)
+        }
+    } else {
+        fmt.Printf("Notify: Failed to notify %s about review %s, will " +
+                   "retry: %s\n",
+                   delivery.Sink,
+                   delivery.Review.ReviewId,
+                   err)
+
+        if (key == "") {
+            key = pendingPrefix + delivery.Sink + "_" +
+                  strconv.FormatInt(time.Now().UnixNano(), 10)
+        }
+
+        encoded, err := json.Marshal(delivery)
+
+        if (err != nil) {
+            fmt.Printf("Notify: Could not store notification: %s\n", err)
+        } else {
+            db.KvPut(key, string(encoded))
+        }
+    }
+}
+
+/**
+ * Periodically retries any deliveries which previously failed.
+ */
+func RetryPending() {
+    for {
+        time.Sleep(time.Duration(config.RetryIntervalSec) * time.Second)
+
+        for key, value := range db.KvList(pendingPrefix) {
+            var delivery Delivery
+
+            err := json.Unmarshal([]byte(value), &delivery)
+            configured, found := sinks[delivery.Sink]
+
+            if (err != nil || !found) {
+                // Can't be delivered any more
+                fmt.Printf("Notify: Dropping undeliverable notification %s\n",
+                           key)
+                db.KvDelete(key)
+            } else {
+                Deliver(configured.sink, delivery, key)
+            }
+        }
+    }
+}
+
+/**
+ * Runs notifications, sending one to each interested sink whenever a review
+ * is published.
+ *
+ * @param events The review event stream.
+ */
+func Run(events <- chan reviewdata.Event) {
+    go RetryPending()
+
+    for event := range events {
+        if (event.Type != reviewdata.EventPublished || event.Review == nil) {
+            continue
+        }
+
+        for name, configured := range sinks {
+            if (!Wants(configured.config, event.Review)) {
+                continue
+            }
+
+            message, err := Render(configured.message,
+                                   event.Review,
+                                   configured.config.TopFindings)
+
+            if (err != nil) {
+                fmt.Printf("Notify: Could not render message for %s: %s\n",
+                           name,
+                           err)
+                continue
+            }
+
+            subject, err := Render(configured.subject,
+                                   event.Review,
+                                   configured.config.TopFindings)
+
+            if (err != nil) {
+                fmt.Printf("Notify: Could not render subject for %s: %s\n",
+                           name,
+                           err)
+                continue
+            }
+
+            // Deliver in parallel, so a slow sink doesn't hold up the others
+            go Deliver(configured.sink,
+                       Delivery{Sink:    name,
+                                Subject: subject,
+                                Message: message,
+                                Review:  *event.Review},
+                       "")
+        }
+    }
+}
@@ -0,0 +1,509 @@
+/**
+ * Tests configuring sinks, deciding who to notify, rendering notifications,
+ * delivering them, and retrying failed deliveries.
+ */
+package notify
+
+import (
+    "bufio"
+    "database/sql"
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "rbbot/db"
+    "rbplugindata/reviewdata"
+
+    _ "github.com/mattn/go-sqlite3"
+)
+
+/**
+ * Points the database at a fresh, empty, key/value store. Skips the test if
+ * sqlite isn't available.
+ */
+func useTestDb(t *testing.T) {
+    var dbPath string = filepath.Join(t.TempDir(), "db.sqlite3")
+
+    conn, err := sql.Open("sqlite3", dbPath)
+
+    if (err != nil) {
+        t.Skipf("No database: %s", err)
+    }
+    defer conn.Close()
+
+    _, err = conn.Exec("CREATE TABLE IF NOT EXISTS KVSTORE " +
+                       "(KEY TEXT UNIQUE, VALUE TEXT);")
+
+    if (err != nil) {
+        t.Skipf("No database: %s", err)
+    }
+
+    db.Configure(dbPath)
+}
+
+/**
+ * Forgets any previously configured sinks.
+ */
+func resetConfig() {
+    config = Config{}
+    sinks  = make(map[string]*configuredSink)
+}
+
+/**
+ * A sink which fails until it has been asked to deliver a given number of
+ * times.
+ */
+type flakySink struct {
+    failures   int
+    deliveries int
+}
+
+func (s *flakySink) Deliver(delivery Delivery) error {
+    s.deliveries++
+
+    if (s.deliveries <= s.failures) {
+        return errors.New("Unavailable")
+    }
+
+    return nil
+}
+
+/**
+ * A review with a finding of each severity.
+ */
+func testReview() *reviewdata.ReviewSummary {
+    return &reviewdata.ReviewSummary{
+               Server:     "main",
+               ReviewId:   "42",
+               Summary:    "Fix the widget",
+               Link:       "http://rb/r/42/",
+               Issues:     2,
+               Severities: map[string]int{reviewdata.SeverityError:   1,
+                                          reviewdata.SeverityWarning: 1,
+                                          reviewdata.SeverityInfo:    1},
+               Findings:   []reviewdata.Finding{
+                   {Filename: "a.go",
+                    Comment:  reviewdata.Comment{
+                                  Text:     "Broken",
+                                  Severity: reviewdata.SeverityError}},
+                   {Filename: "b.go",
+                    Comment:  reviewdata.Comment{Text:       "Dubious",
+                                                 RaiseIssue: true}},
+                   {Filename: "c.go",
+                    Comment:  reviewdata.Comment{Text: "Fine"}}}}
+}
+
+func TestConfigure(t *testing.T) {
+    var tests = []struct {
+        config  string
+        failure bool
+    }{
+        {``, false},
+        {`{"Sinks": [{"Type": "jsonl", "Name": "a", "Path": "a.jsonl"}]}`,
+         false},
+        {`{"Sinks": [{"Type": "pigeon", "Name": "a"}]}`, true},
+        {`{"Sinks": [{"Type": "jsonl", "Path": "a.jsonl"}]}`, true},
+        {`{"Sinks": [{"Type": "jsonl", "Name": "a", "Path": "a.jsonl"},
+                     {"Type": "jsonl", "Name": "a", "Path": "b.jsonl"}]}`,
+         true},
+        {`{"Sinks": [{"Type": "webhook", "Name": "a"}]}`, true},
+        {`{"Sinks": [{"Type": "smtp", "Name": "a", "SmtpHost": "mx:25"}]}`,
+         true},
+        {`{"Sinks": [{"Type": "jsonl", "Name": "a"}]}`, true},
+        {`{"Sinks": [{"Type": "jsonl", "Name": "a", "Path": "a.jsonl",
+                      "Template": "{{.Summary"}]}`,
+         true},
+        {`{"Sinks": [{"Type": "jsonl", "Name": "a", "Path": "a.jsonl",
+                      "SubjectTemplate": "{{end}}"}]}`,
+         true},
+        {`{"Sinks": "none"}`, true},
+    }
+
+    for _, test := range tests {
+        resetConfig()
+
+        err := Configure(json.RawMessage(test.config))
+
+        if ((err != nil) != test.failure) {
+            t.Errorf("Configure(%s) = %v; want error %t",
+                     test.config,
+                     err,
+                     test.failure)
+        }
+    }
+
+    // Defaults are filled in
+    resetConfig()
+    Configure(json.RawMessage(
+                `{"Sinks": [{"Type": "jsonl", "Name": "a", "Path": "a.jsonl"}]}`))
+
+    if (config.RetryIntervalSec != defaultRetryInterval ||
+        config.MaxAttempts != defaultMaxAttempts) {
+        t.Errorf("Configure() = %+v; want default retries", config)
+    }
+
+    if (!Enabled() ||
+        sinks["a"].config.TopFindings != defaultTopFindings ||
+        sinks["a"].config.Template != defaultTemplate ||
+        sinks["a"].config.SubjectTemplate != defaultSubjectTemplate) {
+        t.Errorf("Configure() = %+v; want a default sink", sinks["a"])
+    }
+}
+
+func TestWants(t *testing.T) {
+    var tests = []struct {
+        servers     []string
+        minSeverity string
+        wants       bool
+    }{
+        {nil, "", true},
+        {[]string{"main"}, "", true},
+        {[]string{"other", "main"}, "", true},
+        {[]string{"other"}, "", false},
+        {nil, reviewdata.SeverityInfo, true},
+        {nil, reviewdata.SeverityError, true},
+        {[]string{"other"}, reviewdata.SeverityInfo, false},
+    }
+
+    for _, test := range tests {
+        wants := Wants(SinkConfig{Servers:     test.servers,
+                                  MinSeverity: test.minSeverity},
+                       testReview())
+
+        if (wants != test.wants) {
+            t.Errorf("Wants(%v, %q) = %t; want %t",
+                     test.servers,
+                     test.minSeverity,
+                     wants,
+                     test.wants)
+        }
+    }
+
+    // Only findings which were actually made count towards the threshold
+    var review *reviewdata.ReviewSummary = testReview()
+    review.Severities[reviewdata.SeverityError] = 0
+
+    var thresholds = map[string]bool{reviewdata.SeverityError:   false,
+                                     reviewdata.SeverityWarning: true,
+                                     reviewdata.SeverityInfo:    true}
+
+    for minSeverity, want := range thresholds {
+        wants := Wants(SinkConfig{MinSeverity: minSeverity}, review)
+
+        if (wants != want) {
+            t.Errorf("Wants(%q) without errors = %t; want %t",
+                     minSeverity,
+                     wants,
+                     want)
+        }
+    }
+}
+
+func TestRender(t *testing.T) {
+    resetConfig()
+
+    err := Configure(json.RawMessage(`{"Sinks": [
+                        {"Type": "jsonl", "Name": "default", "Path": "a",
+                         "TopFindings": 2},
+                        {"Type": "jsonl", "Name": "custom", "Path": "b",
+                         "Template": "{{range .TopFindings}}{{.Filename}} {{end}}",
+                         "SubjectTemplate": "[{{.Server}}] {{.Summary}}"}]}`))
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    var tests = []struct {
+        sink    string
+        message string
+        subject string
+    }{
+        {"default",
+         "Fix the widget - http://rb/r/42/\n" +
+         "2 issues, 3 findings, 1 error, 1 info, 1 warning\n" +
+         "- [error] a.go: Broken\n" +
+         "- [warning] b.go: Dubious\n",
+         "Review 42: 2 issues - Fix the widget"},
+        {"custom",
+         "a.go b.go c.go ",
+         "[main] Fix the widget"},
+    }
+
+    for _, test := range tests {
+        configured := sinks[test.sink]
+
+        message, err := Render(configured.message,
+                               testReview(),
+                               configured.config.TopFindings)
+
+        if (err != nil || message != test.message) {
+            t.Errorf("Render(%s message) = %q, %v; want %q",
+                     test.sink,
+                     message,
+                     err,
+                     test.message)
+        }
+
+        subject, err := Render(configured.subject,
+                               testReview(),
+                               configured.config.TopFindings)
+
+        if (err != nil || subject != test.subject) {
+            t.Errorf("Render(%s subject) = %q, %v; want %q",
+                     test.sink,
+                     subject,
+                     err,
+                     test.subject)
+        }
+    }
+}
+
+func TestEncodeSubject(t *testing.T) {
+    var tests = []struct {
+        subject string
+        encoded string
+    }{
+        {"Review 42: 2 issues", "Review 42: 2 issues"},
+        {"Fix\r\nBcc: everyone@example.com", "Fix Bcc: everyone@example.com"},
+        {"One\nTwo\n\nThree", "One Two Three"},
+        {"Café", "=?UTF-8?q?Caf=C3=A9?="},
+        {"Café\r\nMenu", "=?UTF-8?q?Caf=C3=A9_Menu?="},
+    }
+
+    for _, test := range tests {
+        encoded := EncodeSubject(test.subject)
+
+        if (encoded != test.encoded) {
+            t.Errorf("EncodeSubject(%q) = %q; want %q",
+                     test.subject,
+                     encoded,
+                     test.encoded)
+        }
+    }
+}
+
+func TestWebhookSink(t *testing.T) {
+    var status int = http.StatusOK
+    var body   struct {
+        Text   string
+        Review reviewdata.ReviewSummary
+    }
+
+    hook := httptest.NewServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.Method != "POST" ||
+                r.Header.Get("Content-Type") != "application/json" ||
+                r.Header.Get("X-Token") != "abc") {
+                t.Errorf("Webhook got %s, %v", r.Method, r.Header)
+            }
+
+            if err := json.NewDecoder(r.Body).Decode(&body); (err != nil) {
+                t.Error(err)
+            }
+
+            w.WriteHeader(status)
+        }))
+    defer hook.Close()
+
+    sink, err := NewWebhookSink(SinkConfig{Name:    "hook",
+                                           Url:     hook.URL,
+                                           Headers: map[string]string{
+                                                        "X-Token": "abc"}})
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    var delivery = Delivery{Sink:    "hook",
+                            Message: "Hello",
+                            Review:  *testReview()}
+
+    if err := sink.Deliver(delivery); (err != nil) {
+        t.Errorf("Deliver() = %v", err)
+    }
+
+    if (body.Text != "Hello" ||
+        body.Review.ReviewId != "42" ||
+        len(body.Review.Findings) != 3) {
+        t.Errorf("Webhook got %+v", body)
+    }
+
+    status = http.StatusInternalServerError
+
+    if err := sink.Deliver(delivery); (err == nil) {
+        t.Errorf("Deliver() to a failing webhook succeeded")
+    }
+}
+
+func TestJsonlSink(t *testing.T) {
+    var path string = filepath.Join(t.TempDir(), "reviews.jsonl")
+
+    sink, err := NewJsonlSink(SinkConfig{Name: "file", Path: path})
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    for _, message := range []string{"First", "Second"} {
+        err = sink.Deliver(Delivery{Sink:    "file",
+                                    Message: message,
+                                    Review:  *testReview()})
+
+        if (err != nil) {
+            t.Fatal(err)
+        }
+    }
+
+    file, err := os.Open(path)
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer file.Close()
+
+    var messages []string
+    scanner := bufio.NewScanner(file)
+
+    for scanner.Scan() {
+        var line struct {
+            Message string
+            Review  reviewdata.ReviewSummary
+        }
+
+        if err := json.Unmarshal(scanner.Bytes(), &line); (err != nil) {
+            t.Fatalf("Line %q: %s", scanner.Text(), err)
+        }
+
+        if (line.Review.ReviewId != "42") {
+            t.Errorf("Line %q has the wrong review", scanner.Text())
+        }
+
+        messages = append(messages, line.Message)
+    }
+
+    if (len(messages) != 2 ||
+        messages[0] != "First" ||
+        messages[1] != "Second") {
+        t.Errorf("JSONL messages = %v; want [First Second]", messages)
+    }
+
+    // Unwritable paths fail the delivery
+    sink, _ = NewJsonlSink(SinkConfig{Name: "file",
+                                      Path: filepath.Join(path, "nope")})
+
+    if err := sink.Deliver(Delivery{Sink: "file"}); (err == nil) {
+        t.Errorf("Deliver() to an unwritable path succeeded")
+    }
+}
+
+/**
+ * Reads back the pending deliveries.
+ */
+func pending(t *testing.T) map[string]Delivery {
+    var deliveries = make(map[string]Delivery)
+
+    for key, value := range db.KvList(pendingPrefix) {
+        var delivery Delivery
+
+        if err := json.Unmarshal([]byte(value), &delivery); (err != nil) {
+            t.Fatal(err)
+        }
+
+        deliveries[key] = delivery
+    }
+
+    return deliveries
+}
+
+func TestDeliverRetries(t *testing.T) {
+    useTestDb(t)
+    resetConfig()
+    config.MaxAttempts = 3
+
+    // Fails twice, then succeeds
+    var sink *flakySink = &flakySink{failures: 2}
+
+    Deliver(sink, Delivery{Sink: "flaky", Message: "Hi"}, "")
+
+    stored := pending(t)
+
+    if (len(stored) != 1) {
+        t.Fatalf("Pending after one failure = %v; want one", stored)
+    }
+
+    for key, delivery := range stored {
+        if (delivery.Attempts != 1 || delivery.Message != "Hi") {
+            t.Errorf("Pending delivery = %+v; want one attempt", delivery)
+        }
+
+        // The retry fails, and is stored under the same key
+        Deliver(sink, delivery, key)
+        stored = pending(t)
+
+        if (len(stored) != 1 || stored[key].Attempts != 2) {
+            t.Errorf("Pending after two failures = %v; want two attempts " +
+                     "under %s",
+                     stored,
+                     key)
+        }
+
+        // The next retry succeeds, and is forgotten
+        Deliver(sink, stored[key], key)
+
+        if stored = pending(t); (len(stored) != 0) {
+            t.Errorf("Pending after success = %v; want none", stored)
+        }
+    }
+
+    if (sink.deliveries != 3) {
+        t.Errorf("Delivered %d times; want 3", sink.deliveries)
+    }
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+    useTestDb(t)
+    resetConfig()
+    config.MaxAttempts = 2
+
+    var sink *flakySink = &flakySink{failures: 10}
+
+    Deliver(sink, Delivery{Sink: "flaky"}, "")
+
+    for key, delivery := range pending(t) {
+        Deliver(sink, delivery, key)
+    }
+
+    if stored := pending(t); (len(stored) != 0) {
+        t.Errorf("Pending after %d attempts = %v; want none",
+                 config.MaxAttempts,
+                 stored)
+    }
+
+    if (sink.deliveries != 2) {
+        t.Errorf("Delivered %d times; want 2", sink.deliveries)
+    }
+
+    // A first attempt that succeeds is never stored
+    Deliver(&flakySink{}, Delivery{Sink: "working"}, "")
+
+    if stored := pending(t); (len(stored) != 0) {
+        t.Errorf("Pending after success = %v; want none", stored)
+    }
+}
+
+func TestDeliverOneAttempt(t *testing.T) {
+    useTestDb(t)
+    resetConfig()
+    config.MaxAttempts = 1
+
+    // With a single attempt allowed, failures are never retried
+    Deliver(&flakySink{failures: 1}, Delivery{Sink: "flaky"}, "")
+
+    if stored := pending(t); (len(stored) != 0) {
+        t.Errorf("Pending with one attempt = %v; want none", stored)
+    }
+}
@@ -0,0 +1,200 @@
+/**
+ * The notification sinks which come with the bot.
+ */
+package notify
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "mime"
+    "net/http"
+    "net/smtp"
+    "os"
+    "strings"
+    "sync"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * Posts a JSON payload to a URL, e.g. a chat tool's incoming webhook. The
+ * message is sent as "text", alongside the whole review summary.
+ */
+type WebhookSink struct {
+    url     string
+    headers map[string]string
+    client  *http.Client
+}
+
+/**
+ * Sends a plain text email summary over SMTP.
+ */
+type SmtpSink struct {
+    host string
+    auth smtp.Auth
+    from string
+    to   []string
+}
+
+/**
+ * Appends one JSON object per notification to a file.
+ */
+type JsonlSink struct {
+    path  string
+    mutex sync.Mutex
+}
+
+/**
+ * Creates a webhook sink.
+ */
+func NewWebhookSink(sinkConfig SinkConfig) (Sink, error) {
+    if (sinkConfig.Url == "") {
+        return nil, errors.New("Webhook sink " + sinkConfig.Name +
+                               " has no URL")
+    }
+
+    return &WebhookSink{url:     sinkConfig.Url,
+                        headers: sinkConfig.Headers,
+                        client:  &http.Client{Timeout: 30 * time.Second}},
+           nil
+}
+
+/**
+ * Delivers a notification to a webhook.
+ */
+func (s *WebhookSink) Deliver(delivery Delivery) error {
+    payload, err := json.Marshal(struct {
+                                     Text   string                   `json:"text"`
+                                     Review reviewdata.ReviewSummary `json:"review"`
+                                 }{delivery.Message, delivery.Review})
+
+    if (err != nil) {
+        return err
+    }
+
+    req, err := http.NewRequest("POST", s.url, bytes.NewReader(payload))
+
+    if (err != nil) {
+        return err
+    }
+
+    req.Header.Set("Content-Type", "application/json")
+
+    for k, v := range s.headers {
+        req.Header.Set(k, v)
+    }
+
+    resp, err := s.client.Do(req)
+
+    if (err != nil) {
+        return err
+    }
+    defer resp.Body.Close()
+
+    if (resp.StatusCode < 200 || resp.StatusCode > 299) {
+        return errors.New("Webhook returned " + resp.Status)
+    }
+
+    return nil
+}
+
+/**
+ * Creates an SMTP sink.
+ */
+func NewSmtpSink(sinkConfig SinkConfig) (Sink, error) {
+    if (sinkConfig.SmtpHost == "" ||
+        sinkConfig.From == "" ||
+        len(sinkConfig.To) == 0) {
+        return nil, errors.New("SMTP sink " + sinkConfig.Name +
+                               " needs a host, a sender and recipients")
+    }
+
+    sink := &SmtpSink{host: sinkConfig.SmtpHost,
+                      from: sinkConfig.From,
+                      to:   sinkConfig.To}
+
+    if (sinkConfig.Username != "") {
+        sink.auth = smtp.PlainAuth("",
+                                   sinkConfig.Username,
+                                   sinkConfig.Password,
+                                   strings.Split(sinkConfig.SmtpHost, ":")[0])
+    }
+
+    return sink, nil
+}
+
+/**
+ * Makes a subject fit to be an email header. Headers can't span lines, and
+ * must be ASCII, so line breaks are replaced with spaces and anything else is
+ * Q-encoded.
+ */
+func EncodeSubject(subject string) string {
+    subject = strings.Join(strings.FieldsFunc(subject,
+                                              func(r rune) bool {
+                                                  return r == '\r' ||
+                                                         r == '\n'
+                                              }),
+                           " ")
+
+    return mime.QEncoding.Encode("UTF-8", subject)
+}
+
+/**
+ * Delivers a notification by email.
+ */
+func (s *SmtpSink) Deliver(delivery Delivery) error {
+    subject := EncodeSubject(delivery.Subject)
+
+    message := "From: " + s.from + "\r\n" +
+               "To: " + strings.Join(s.to, ", ") + "\r\n" +
+               "Subject: " + subject + "\r\n" +
+               "Content-Type: text/plain; charset=UTF-8\r\n" +
+               "\r\n" +
+               strings.Replace(delivery.Message, "\n", "\r\n", -1)
+
+    return smtp.SendMail(s.host, s.auth, s.from, s.to, []byte(message))
+}
+
+/**
+ * Creates a JSONL file sink.
+ */
+func NewJsonlSink(sinkConfig SinkConfig) (Sink, error) {
+    if (sinkConfig.Path == "") {
+        return nil, errors.New("JSONL sink " + sinkConfig.Name + " has no path")
+    }
+
+    return &JsonlSink{path: sinkConfig.Path}, nil
+}
+
+/**
+ * Delivers a notification by appending it to a file.
+ */
+func (s *JsonlSink) Deliver(delivery Delivery) error {
+    line, err := json.Marshal(struct {
+                                  Time    time.Time
+                                  Message string
+                                  Review  reviewdata.ReviewSummary
+                              }{time.Now(), delivery.Message, delivery.Review})
+
+    if (err != nil) {
+        return err
+    }
+
+    s.mutex.Lock()
+    defer s.mutex.Unlock()
+
+    file, err := os.OpenFile(s.path,
+                             os.O_APPEND | os.O_CREATE | os.O_WRONLY,
+                             0644)
+
+    if (err != nil) {
+        return err
+    }
+    defer file.Close()
+
+    _, err = file.Write(append(line, '\n'))
+
+    return err
+}
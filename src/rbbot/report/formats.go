@@ -0,0 +1,291 @@
+/**
+ * Generates reports in each supported format.
+ */
+package report
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "sort"
+    "strconv"
+    "strings"
+
+    "rbplugindata/reviewdata"
+)
+
+const (
+    toolName = "GoReviewbot"
+    toolUri  = "https://github.com/FifteenFifty/GoReviewbot"
+)
+
+/**
+ * The parts of SARIF 2.1.0 that we produce.
+ */
+type SarifLog struct {
+    Schema  string     `json:"$schema"`
+    Version string     `json:"version"`
+    Runs    []SarifRun `json:"runs"`
+}
+
+type SarifRun struct {
+    Tool struct {
+        Driver struct {
+            Name           string      `json:"name"`
+            InformationUri string      `json:"informationUri"`
+            Rules          []SarifRule `json:"rules"`
+        } `json:"driver"`
+    } `json:"tool"`
+    Results []SarifResult `json:"results"`
+}
+
+type SarifRule struct {
+    Id         string            `json:"id"`
+    Properties map[string]string `json:"properties"`
+}
+
+type SarifResult struct {
+    RuleId     string                 `json:"ruleId"`
+    Level      string                 `json:"level"`
+    Message    SarifMessage           `json:"message"`
+    Locations  []SarifLocation        `json:"locations"`
+    Properties map[string]interface{} `json:"properties"`
+}
+
+type SarifMessage struct {
+    Text string `json:"text"`
+}
+
+type SarifLocation struct {
+    PhysicalLocation struct {
+        ArtifactLocation struct {
+            Uri string `json:"uri"`
+        } `json:"artifactLocation"`
+        Region *SarifRegion `json:"region,omitempty"`
+    } `json:"physicalLocation"`
+}
+
+type SarifRegion struct {
+    StartLine int `json:"startLine"`
+    EndLine   int `json:"endLine"`
+}
+
+/**
+ * The parts of JUnit XML that we produce.
+ */
+type JunitSuites struct {
+    XMLName xml.Name     `xml:"testsuites"`
+    Suites  []JunitSuite `xml:"testsuite"`
+}
+
+type JunitSuite struct {
+    Name     string      `xml:"name,attr"`
+    Tests    int         `xml:"tests,attr"`
+    Failures int         `xml:"failures,attr"`
+    Cases    []JunitCase `xml:"testcase"`
+}
+
+type JunitCase struct {
+    Name      string        `xml:"name,attr"`
+    ClassName string        `xml:"classname,attr"`
+    Failure   *JunitFailure `xml:"failure,omitempty"`
+    SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type JunitFailure struct {
+    Message string `xml:"message,attr"`
+    Type    string `xml:"type,attr"`
+    Text    string `xml:",chardata"`
+}
+
+/**
+ * Identifies the rule which produced a finding: the plugin, and the plugin's
+ * own rule if it gave one.
+ */
+func RuleId(comment reviewdata.Comment) string {
+    if (comment.Rule == "") {
+        return comment.Plugin
+    }
+
+    return comment.Plugin + "/" + comment.Rule
+}
+
+/**
+ * Maps a severity onto a SARIF level.
+ */
+func SarifLevel(severity string) string {
+    switch (severity) {
+    case reviewdata.SeverityError:
+        return "error"
+    case reviewdata.SeverityWarning:
+        return "warning"
+    }
+
+    return "note"
+}
+
+/**
+ * Describes a finding in a single line, for plain text reports.
+ */
+func DescribeFinding(finding reviewdata.Finding) string {
+    var location string = finding.Filename
+
+    if (finding.Line > 0) {
+        location += ":" + strconv.Itoa(finding.Line)
+    }
+
+    return "[" + finding.Comment.EffectiveSeverity() + "] " +
+           location + " (" + RuleId(finding.Comment) + "): " +
+           finding.Comment.Text
+}
+
+/**
+ * Generates a SARIF 2.1.0 report.
+ */
+func GenerateSarif(review *reviewdata.ReviewSummary) ([]byte, error) {
+    var run SarifRun
+
+    run.Tool.Driver.Name           = toolName
+    run.Tool.Driver.InformationUri = toolUri
+    run.Tool.Driver.Rules          = []SarifRule{}
+    run.Results                    = []SarifResult{}
+
+    rules := make(map[string]bool)
+
+    for _, finding := range review.Findings {
+        ruleId := RuleId(finding.Comment)
+
+        if (!rules[ruleId]) {
+            rules[ruleId] = true
+            run.Tool.Driver.Rules = append(run.Tool.Driver.Rules,
+                SarifRule{Id:         ruleId,
+                          Properties: map[string]string{
+                                          "plugin": finding.Comment.Plugin}})
+        }
+
+        var location SarifLocation
+        location.PhysicalLocation.ArtifactLocation.Uri = finding.Filename
+
+        if (finding.Line > 0) {
+            var numLines int = finding.Comment.NumLines
+
+            if (numLines < 1) {
+                numLines = 1
+            }
+
+            location.PhysicalLocation.Region = &SarifRegion{
+                                    StartLine: finding.Line,
+                                    EndLine:   finding.Line + numLines - 1}
+        }
+
+        run.Results = append(run.Results, SarifResult{
+            RuleId:     ruleId,
+            Level:      SarifLevel(finding.Comment.EffectiveSeverity()),
+            Message:    SarifMessage{Text: finding.Comment.Text},
+            Locations:  []SarifLocation{location},
+            Properties: map[string]interface{}{
+                            "plugin":     finding.Comment.Plugin,
+                            "raiseIssue": finding.Comment.RaiseIssue,
+                            "reviewLine": finding.Comment.Line}})
+    }
+
+    sort.Slice(run.Tool.Driver.Rules, func(i, j int) bool {
+        return run.Tool.Driver.Rules[i].Id < run.Tool.Driver.Rules[j].Id
+    })
+
+    return json.MarshalIndent(SarifLog{
+                                Schema:  "https://json.schemastore.org/" +
+                                         "sarif-2.1.0.json",
+                                Version: "2.1.0",
+                                Runs:    []SarifRun{run}},
+                              "",
+                              "  ")
+}
+
+/**
+ * Generates a plain JSON report: the review summary itself.
+ */
+func GenerateJson(review *reviewdata.ReviewSummary) ([]byte, error) {
+    return json.MarshalIndent(review, "", "  ")
+}
+
+/**
+ * Generates a JUnit XML report, with one test case per file. A file fails if
+ * any of its findings raise issues or are at least warnings; lesser findings
+ * are reported as output.
+ */
+func GenerateJunit(review *reviewdata.ReviewSummary) ([]byte, error) {
+    suite := JunitSuite{Name: toolName + " review " + review.ReviewId}
+
+    if (review.Server != "") {
+        suite.Name += " on " + review.Server
+    }
+
+    // Files can have findings without having been reviewed, if a plugin made
+    // a comment while reviewing the review request
+    var filenames []string = append([]string{}, review.Files...)
+    byFile := make(map[string][]reviewdata.Finding)
+
+    for _, finding := range review.Findings {
+        _, seen := byFile[finding.Filename]
+
+        if (!seen && !contains(filenames, finding.Filename)) {
+            filenames = append(filenames, finding.Filename)
+        }
+
+        byFile[finding.Filename] = append(byFile[finding.Filename], finding)
+    }
+
+    sort.Strings(filenames)
+
+    for _, filename := range filenames {
+        testCase := JunitCase{Name: filename, ClassName: toolName}
+
+        var failures []string
+        var output   []string
+
+        for _, finding := range byFile[filename] {
+            rank := reviewdata.SeverityRank(
+                                    finding.Comment.EffectiveSeverity())
+
+            if (finding.Comment.RaiseIssue ||
+                rank >= reviewdata.SeverityRank(reviewdata.SeverityWarning)) {
+                failures = append(failures, DescribeFinding(finding))
+            } else {
+                output = append(output, DescribeFinding(finding))
+            }
+        }
+
+        if (len(failures) > 0) {
+            testCase.Failure = &JunitFailure{
+                                Message: strconv.Itoa(len(failures)) +
+                                         " findings",
+                                Type:    "review",
+                                Text:    strings.Join(failures, "\n")}
+            suite.Failures++
+        }
+
+        testCase.SystemOut = strings.Join(output, "\n")
+
+        suite.Cases = append(suite.Cases, testCase)
+        suite.Tests++
+    }
+
+    content, err := xml.MarshalIndent(JunitSuites{Suites: []JunitSuite{suite}},
+                                      "",
+                                      "  ")
+
+    return append([]byte(xml.Header), content...), err
+}
+
+/**
+ * Whether a list of strings contains a string.
+ */
+func contains(list []string, item string) bool {
+    for _, listItem := range list {
+        if (listItem == item) {
+            return true
+        }
+    }
+
+    return false
+}
@@ -0,0 +1,198 @@
+/**
+ * Writes review findings out as reports, for CI dashboards and IDE tooling,
+ * and serves them over HTTP.
+ *
+ * A report is written, in each configured format, whenever a review is
+ * published. Reviews which aren't published get no report: the bot has no
+ * offline or dry-run mode to write them from. Anything else which produces a
+ * review summary can write one with Write.
+ */
+package report
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * The report config block.
+ */
+type Config struct {
+    Directory string   // The directory into which reports are written
+    Formats   []string // Any of "sarif", "json" and "junit"
+    Listen    string   // If set, the address on which reports are served
+}
+
+/**
+ * A report format: how to name a report, and how to generate it.
+ */
+type Format struct {
+    Extension string
+    Generate  func(*reviewdata.ReviewSummary) ([]byte, error)
+}
+
+var (
+    config Config
+
+    /**
+     * Every format that can be written. New formats are added here.
+     */
+    formats = map[string]Format{
+        "sarif": {Extension: ".sarif",     Generate: GenerateSarif},
+        "json":  {Extension: ".json",      Generate: GenerateJson},
+        "junit": {Extension: ".junit.xml", Generate: GenerateJunit},
+    }
+
+    unsafeChars = regexp.MustCompile("[^A-Za-z0-9_.-]")
+)
+
+/**
+ * Configures reports.
+ *
+ * @param rawConfig A raw json message containing report config.
+ *
+ * @retval error Error status
+ */
+func Configure(rawConfig json.RawMessage) error {
+    if (len(rawConfig) == 0) {
+        return nil
+    }
+
+    err := json.Unmarshal(rawConfig, &config)
+
+    if (err != nil) {
+        return err
+    }
+
+    for _, format := range config.Formats {
+        _, found := formats[format]
+
+        if (!found) {
+            return errors.New("Unknown report format: " + format)
+        }
+    }
+
+    if (len(config.Formats) > 0) {
+        if (config.Directory == "") {
+            return errors.New("Reports need a directory")
+        }
+
+        err = os.MkdirAll(config.Directory, 0755)
+
+        fmt.Printf("Report: Writing %v reports to %s\n",
+                   config.Formats,
+                   config.Directory)
+    }
+
+    return err
+}
+
+/**
+ * Whether any reports are to be written.
+ */
+func Enabled() bool {
+    return len(config.Formats) > 0
+}
+
+/**
+ * Builds the name, without extension, of a review's reports.
+ *
+ * @param review The review's summary.
+ *
+ * @returns The name. Safe for use as a filename.
+ */
+func BaseName(review *reviewdata.ReviewSummary) string {
+    var name string = "review-" + review.ReviewId
+
+    if (review.Server != "") {
+        name = review.Server + "-" + name
+    }
+
+    return unsafeChars.ReplaceAllString(name, "_")
+}
+
+/**
+ * Writes all configured reports for a review, replacing any previous reports
+ * for the same review.
+ *
+ * @param review The review's summary.
+ *
+ * @retval error The last error that occurred, if any.
+ */
+func Write(review *reviewdata.ReviewSummary) error {
+    var lastErr error
+
+    for _, formatName := range config.Formats {
+        format := formats[formatName]
+
+        content, err := format.Generate(review)
+
+        if (err == nil) {
+            path := filepath.Join(config.Directory,
+                                  BaseName(review) + format.Extension)
+
+            // Write then rename, so a download never sees half a report
+            err = ioutil.WriteFile(path + ".tmp", content, 0644)
+
+            if (err == nil) {
+                err = os.Rename(path + ".tmp", path)
+            }
+        }
+
+        if (err != nil) {
+            fmt.Printf("Report: Could not write %s report for review %s: %s\n",
+                       formatName,
+                       review.ReviewId,
+                       err)
+            lastErr = err
+        }
+    }
+
+    return lastErr
+}
+
+/**
+ * Writes reports whenever a review is published.
+ *
+ * @param events The review event stream.
+ */
+func Run(events <- chan reviewdata.Event) {
+    for event := range events {
+        if (event.Type == reviewdata.EventPublished && event.Review != nil) {
+            Write(event.Review)
+        }
+    }
+}
+
+/**
+ * Serves the report directory over HTTP, under /reports/, if configured to do
+ * so. Blocks.
+ */
+func Serve() {
+    if (config.Listen == "") {
+        return
+    }
+
+    // Use our own mux, so that we don't pick up handlers that plugins have
+    // registered for their own servers
+    mux := http.NewServeMux()
+    mux.Handle("/reports/",
+               http.StripPrefix("/reports/",
+                                http.FileServer(http.Dir(config.Directory))))
+
+    fmt.Printf("Report: Serving reports on %s\n", config.Listen)
+
+    err := http.ListenAndServe(config.Listen, mux)
+
+    if (err != nil) {
+        fmt.Printf("Report: Stopped serving reports: %s\n", err)
+    }
+}
@@ -0,0 +1,317 @@
+/**
+ * Tests generating reports against golden files, and writing them.
+ */
+package report
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "flag"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "rbplugindata/reviewdata"
+)
+
+var update = flag.Bool("update", false, "Rewrite the golden reports")
+
+/**
+ * A review with findings from several plugins, of every severity, including
+ * one on a file that wasn't reviewed and one without a line.
+ */
+func testReview() *reviewdata.ReviewSummary {
+    return &reviewdata.ReviewSummary{
+        Server:     "main",
+        ReviewId:   "42",
+        Summary:    "Fix the widget",
+        Link:       "http://rb/r/42/",
+        Comments:   4,
+        Issues:     3,
+        Files:      []string{"a.go", "b.go", "c.go"},
+        Severities: map[string]int{reviewdata.SeverityError:   1,
+                                   reviewdata.SeverityWarning: 2,
+                                   reviewdata.SeverityInfo:    2},
+        Findings:   []reviewdata.Finding{
+            {Filename: "a.go",
+             Line:     3,
+             Comment:  reviewdata.Comment{Line:       10,
+                                          NumLines:   2,
+                                          Text:       "Deferred in a loop",
+                                          RaiseIssue: true,
+                                          Severity:   reviewdata.SeverityError,
+                                          Rule:       "defer-in-loop",
+                                          Plugin:     "GoCheckReviewer"}},
+            {Filename: "a.go",
+             Line:     20,
+             Comment:  reviewdata.Comment{Line:     27,
+                                          Text:     "Deferred in a loop again",
+                                          Severity: reviewdata.SeverityWarning,
+                                          Rule:     "defer-in-loop",
+                                          Plugin:   "GoCheckReviewer"}},
+            {Filename: "b.go",
+             Line:     7,
+             Comment:  reviewdata.Comment{Line:       9,
+                                          Text:       "Trailing whitespace",
+                                          RaiseIssue: true,
+                                          Plugin:     "WhitespaceReviewer"}},
+            {Filename: "notes.txt",
+             Comment:  reviewdata.Comment{Text:       "Not reviewed",
+                                          RaiseIssue: true,
+                                          Severity:   reviewdata.SeverityInfo,
+                                          Plugin:     "ClassifyReviewer"}},
+            {Filename: "c.go",
+             Comment:  reviewdata.Comment{Line:   4,
+                                          Text:   "TODO: <tidy> & \"go\"",
+                                          Plugin: "TodoReviewer",
+                                          Rule:   "todo"}}}}
+}
+
+func TestGenerateGolden(t *testing.T) {
+    for name, format := range formats {
+        content, err := format.Generate(testReview())
+
+        if (err != nil) {
+            t.Errorf("Generating %s failed: %s", name, err)
+            continue
+        }
+
+        var golden string = filepath.Join("testdata", "review" +
+                                                      format.Extension)
+
+        if (*update) {
+            if err := ioutil.WriteFile(golden, content, 0644); (err != nil) {
+                t.Fatal(err)
+            }
+        }
+
+        expected, err := ioutil.ReadFile(golden)
+
+        if (err != nil) {
+            t.Fatal(err)
+        }
+
+        if (string(content) != string(expected)) {
+            t.Errorf("%s report differs from %s:\n%s", name, golden, content)
+        }
+    }
+}
+
+func TestRuleId(t *testing.T) {
+    var tests = []struct {
+        plugin string
+        rule   string
+        id     string
+    }{
+        {"TodoReviewer", "", "TodoReviewer"},
+        {"GoCheckReviewer", "defer-in-loop", "GoCheckReviewer/defer-in-loop"},
+    }
+
+    for _, test := range tests {
+        id := RuleId(reviewdata.Comment{Plugin: test.plugin, Rule: test.rule})
+
+        if (id != test.id) {
+            t.Errorf("RuleId(%q, %q) = %q; want %q",
+                     test.plugin,
+                     test.rule,
+                     id,
+                     test.id)
+        }
+    }
+}
+
+func TestGenerateSarif(t *testing.T) {
+    content, err := GenerateSarif(testReview())
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    var log SarifLog
+
+    if err := json.Unmarshal(content, &log); (err != nil) {
+        t.Fatal(err)
+    }
+
+    if (log.Version != "2.1.0" || len(log.Runs) != 1) {
+        t.Fatalf("SARIF version %q with %d runs; want 2.1.0 with one",
+                 log.Version,
+                 len(log.Runs))
+    }
+
+    // Each rule is listed once, in order
+    var rules = []string{"ClassifyReviewer",
+                         "GoCheckReviewer/defer-in-loop",
+                         "TodoReviewer/todo",
+                         "WhitespaceReviewer"}
+
+    if (len(log.Runs[0].Tool.Driver.Rules) != len(rules)) {
+        t.Fatalf("SARIF rules = %+v; want %v",
+                 log.Runs[0].Tool.Driver.Rules,
+                 rules)
+    }
+
+    for i, rule := range log.Runs[0].Tool.Driver.Rules {
+        if (rule.Id != rules[i]) {
+            t.Errorf("SARIF rule %d = %q; want %q", i, rule.Id, rules[i])
+        }
+    }
+
+    // Every finding is a result, at its severity's level
+    var results = []struct {
+        ruleId string
+        level  string
+        region *SarifRegion
+    }{
+        {"GoCheckReviewer/defer-in-loop", "error", &SarifRegion{3, 4}},
+        {"GoCheckReviewer/defer-in-loop", "warning", &SarifRegion{20, 20}},
+        {"WhitespaceReviewer", "warning", &SarifRegion{7, 7}},
+        {"ClassifyReviewer", "note", nil},
+        {"TodoReviewer/todo", "note", nil},
+    }
+
+    if (len(log.Runs[0].Results) != len(results)) {
+        t.Fatalf("SARIF has %d results; want %d",
+                 len(log.Runs[0].Results),
+                 len(results))
+    }
+
+    for i, result := range log.Runs[0].Results {
+        region := result.Locations[0].PhysicalLocation.Region
+
+        if (result.RuleId != results[i].ruleId ||
+            result.Level != results[i].level ||
+            (region == nil) != (results[i].region == nil) ||
+            (region != nil && *region != *results[i].region)) {
+            t.Errorf("SARIF result %d = %+v; want %+v",
+                     i,
+                     result,
+                     results[i])
+        }
+    }
+}
+
+func TestGenerateJunit(t *testing.T) {
+    content, err := GenerateJunit(testReview())
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    var suites JunitSuites
+
+    if err := xml.Unmarshal(content, &suites); (err != nil) {
+        t.Fatal(err)
+    }
+
+    if (len(suites.Suites) != 1) {
+        t.Fatalf("JUnit has %d suites; want one", len(suites.Suites))
+    }
+
+    // Files fail if they have findings which raise issues or are at least
+    // warnings
+    var cases = []struct {
+        name   string
+        failed bool
+        output bool
+    }{
+        {"a.go", true, false},
+        {"b.go", true, false},
+        {"c.go", false, true},
+        {"notes.txt", true, false},
+    }
+
+    suite := suites.Suites[0]
+
+    if (suite.Name != "GoReviewbot review 42 on main" ||
+        suite.Tests != len(cases) ||
+        suite.Failures != 3 ||
+        len(suite.Cases) != len(cases)) {
+        t.Fatalf("JUnit suite = %+v", suite)
+    }
+
+    for i, testCase := range suite.Cases {
+        if (testCase.Name != cases[i].name ||
+            (testCase.Failure != nil) != cases[i].failed ||
+            (testCase.SystemOut != "") != cases[i].output) {
+            t.Errorf("JUnit case %d = %+v; want %+v", i, testCase, cases[i])
+        }
+    }
+
+    if (suite.Cases[0].Failure.Message != "2 findings") {
+        t.Errorf("JUnit failure for a.go = %+v", suite.Cases[0].Failure)
+    }
+
+    // A review without findings passes every file
+    var clean *reviewdata.ReviewSummary = testReview()
+    clean.Findings = nil
+
+    content, _ = GenerateJunit(clean)
+    suites     = JunitSuites{}
+    xml.Unmarshal(content, &suites)
+
+    if (suites.Suites[0].Failures != 0 || suites.Suites[0].Tests != 3) {
+        t.Errorf("JUnit suite without findings = %+v", suites.Suites[0])
+    }
+}
+
+func TestWrite(t *testing.T) {
+    dir := t.TempDir()
+
+    err := Configure(json.RawMessage(`{"Directory": "` + dir + `",
+                                       "Formats": ["sarif", "json", "junit"]}`))
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+    defer func() { config = Config{} }()
+
+    var review *reviewdata.ReviewSummary = testReview()
+    review.Server = "rb/two"
+
+    if err := Write(review); (err != nil) {
+        t.Fatal(err)
+    }
+
+    for _, name := range []string{"rb_two-review-42.sarif",
+                                  "rb_two-review-42.json",
+                                  "rb_two-review-42.junit.xml"} {
+        if _, err := os.Stat(filepath.Join(dir, name)); (err != nil) {
+            t.Errorf("Report %s not written: %s", name, err)
+        }
+    }
+
+    files, _ := ioutil.ReadDir(dir)
+
+    if (len(files) != 3) {
+        t.Errorf("Wrote %d files; want 3", len(files))
+    }
+}
+
+func TestConfigure(t *testing.T) {
+    defer func() { config = Config{} }()
+
+    var tests = []struct {
+        config  string
+        failure bool
+    }{
+        {``, false},
+        {`{"Formats": ["sarif"]}`, true},
+        {`{"Directory": "` + t.TempDir() + `", "Formats": ["csv"]}`, true},
+        {`{"Directory": "` + t.TempDir() + `", "Formats": ["junit"]}`, false},
+    }
+
+    for _, test := range tests {
+        config = Config{}
+
+        err := Configure(json.RawMessage(test.config))
+
+        if ((err != nil) != test.failure) {
+            t.Errorf("Configure(%s) = %v; want error %t",
+                     test.config,
+                     err,
+                     test.failure)
+        }
+    }
+}
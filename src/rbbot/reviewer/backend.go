@@ -0,0 +1,82 @@
+/**
+ * Abstracts the code review system that a server talks to, so that the review
+ * pipeline doesn't care whether it is reviewing for ReviewBoard or Gerrit.
+ */
+package reviewer
+
+import (
+    "errors"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * A single file in a review's latest diff, as listed by a backend. Used to
+ * fetch the file.
+ */
+type DiffFile struct {
+    Id       int
+    Filename string // Set by backends that know it before fetching the file
+    Links    reviewdata.LinkContainer
+}
+
+/**
+ * The outcome of a review, as handed to a backend to publish.
+ */
+type Verdict struct {
+    Body      string // The top comment, in markdown
+    Footer    string // Appended after everything else. May be empty
+    Commented bool   // Whether any file comments were made
+    Notify    bool   // Whether the review's owner should be notified
+}
+
+/**
+ * A ReviewBackend is a code review system, which provides the following
+ * functions.
+ *
+ * Line comments are made against the ReviewLine numbers that the backend put
+ * into each file's diff, and are only visible once the review is published.
+ */
+type ReviewBackend interface {
+    FetchRequest(reviewId string) (reviewdata.ReviewRequest,
+                                   error) // Fetches a review request
+    ListFiles(reviewdata.ReviewRequest) ([]DiffFile,
+                                         error) // Lists the latest diff's
+                                                // changed files
+    FetchFile(DiffFile) (reviewdata.FileDiff,
+                         error) // Fetches a file's diff and content
+    StartReply(reviewdata.ReviewRequest) (string,
+                                          error) // Starts an unpublished
+                                                 // reply, returning its ID
+    PostComment(reviewId string,
+                replyId  string,
+                file     reviewdata.FileDiff,
+                comment  reviewdata.Comment) error // Adds a line comment to a
+                                                   // reply
+    Publish(reviewId string,
+            replyId  string,
+            verdict  Verdict) error // Publishes a reply
+    ResolvePrevious(reviewId string) error // Resolves the open issues that
+                                           // the bot raised in previous
+                                           // reviews
+}
+
+/**
+ * Creates the backend that a server's config asks for.
+ *
+ * @param server The server.
+ *
+ * @retval ReviewBackend The backend.
+ * @retval error         If the backend is unknown.
+ */
+func NewBackend(server *Server) (ReviewBackend, error) {
+    switch (server.Config.Backend) {
+    case "", "reviewboard":
+        return &ReviewBoardBackend{server: server}, nil
+    case "gerrit":
+        return &GerritBackend{server: server}, nil
+    }
+
+    return nil, errors.New("Server '" + server.Name + "' has unknown backend " +
+                           server.Config.Backend)
+}
@@ -0,0 +1,798 @@
+/**
+ * Tests the ReviewBoard and Gerrit backends against fake servers.
+ */
+package reviewer
+
+import (
+    "database/sql"
+    "encoding/base64"
+    "encoding/json"
+    "io/ioutil"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "rbbot/db"
+    "rbplugindata/reviewdata"
+
+    _ "github.com/mattn/go-sqlite3"
+)
+
+/**
+ * Points the database at a fresh, empty, key/value store. Skips the test if
+ * sqlite isn't available.
+ */
+func useTestDb(t *testing.T) {
+    dir, err := ioutil.TempDir("", "rbbot-test")
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    t.Cleanup(func() { os.RemoveAll(dir) })
+
+    var dbPath string = filepath.Join(dir, "db.sqlite3")
+
+    conn, err := sql.Open("sqlite3", dbPath)
+
+    if (err != nil) {
+        t.Skipf("No database: %s", err)
+    }
+    defer conn.Close()
+
+    _, err = conn.Exec("CREATE TABLE IF NOT EXISTS KVSTORE " +
+                       "(KEY TEXT UNIQUE, VALUE TEXT);")
+
+    if (err != nil) {
+        t.Skipf("No database: %s", err)
+    }
+
+    db.Configure(dbPath)
+}
+
+/**
+ * Starts a fake server, returning its URL.
+ */
+func fakeServer(t *testing.T, handler http.HandlerFunc) string {
+    fake := httptest.NewServer(handler)
+    t.Cleanup(fake.Close)
+
+    return fake.URL
+}
+
+/**
+ * Creates a ReviewBoard backend which talks to a fake server.
+ */
+func fakeReviewBoard(t       *testing.T,
+                     handler  http.HandlerFunc) (*ReviewBoardBackend, string) {
+    var server *Server = &Server{}
+    var url    string  = fakeServer(t, handler)
+
+    server.Config.RbApiUrl   = url + "/api"
+    server.Config.RbToken    = "token abc"
+    server.Config.RbUsername = "reviewbot"
+
+    return &ReviewBoardBackend{server: server}, url
+}
+
+/**
+ * Creates a Gerrit backend which talks to a fake server.
+ */
+func fakeGerrit(t *testing.T, handler http.HandlerFunc) *GerritBackend {
+    var server *Server = &Server{}
+
+    server.Config.Backend         = "gerrit"
+    server.Config.Gerrit.Url      = fakeServer(t, handler)
+    server.Config.Gerrit.Username = "reviewbot"
+    server.Config.Gerrit.Password = "secret"
+
+    return &GerritBackend{server: server}
+}
+
+/**
+ * Writes a Gerrit json response, with its XSSI prefix.
+ */
+func writeGerrit(w http.ResponseWriter, body string) {
+    w.Write([]byte(")]}'\n" + body))
+}
+
+/**
+ * Reads a multipart form field from a request to ReviewBoard.
+ */
+func formValue(t *testing.T, r *http.Request, key string) string {
+    if err := r.ParseMultipartForm(1 << 20); (err != nil) {
+        t.Error(err)
+        return ""
+    }
+
+    return r.FormValue(key)
+}
+
+func TestReviewBoardFetchRequest(t *testing.T) {
+    backend, url := fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.Header.Get("Authorization") != "token abc") {
+                w.WriteHeader(http.StatusUnauthorized)
+                return
+            }
+
+            switch (r.URL.Path) {
+            case "/api/review-requests/42/":
+                w.Write([]byte(`{"stat": "ok", "review_request": {
+                    "id": 42, "summary": "Fix it", "bugs_closed": ["7"],
+                    "links": {"latest_diff": {"href": "/diffs/2/"}}}}`))
+            default:
+                w.Write([]byte("<html>Not found</html>"))
+            }
+        })
+
+    request, err := backend.FetchRequest("42")
+
+    if (err != nil) {
+        t.Fatalf("FetchRequest failed: %s", err)
+    }
+
+    if (request.Id != 42 ||
+        request.Summary != "Fix it" ||
+        len(request.Bugs_Closed) != 1 ||
+        request.Links.Latest_Diff.Href != "/diffs/2/") {
+        t.Errorf("FetchRequest gave %+v", request)
+    }
+
+    if _, err = backend.FetchRequest("43"); (err == nil) {
+        t.Errorf("FetchRequest of a missing review from %s succeeded", url)
+    }
+}
+
+func TestReviewBoardListFiles(t *testing.T) {
+    backend, url := fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.URL.Path != "/api/review-requests/42/diffs/2/files/") {
+                w.WriteHeader(http.StatusNotFound)
+                return
+            }
+
+            w.Write([]byte(`{"stat": "ok", "files": [
+                {"id": 7, "links": {"self": {"href": "/files/7/"}}},
+                {"id": 8, "links": {"self": {"href": "/files/8/"}}}]}`))
+        })
+
+    var request reviewdata.ReviewRequest
+    request.Links.Latest_Diff.Href = url + "/api/review-requests/42/diffs/2"
+
+    files, err := backend.ListFiles(request)
+
+    if (err != nil) {
+        t.Fatalf("ListFiles failed: %s", err)
+    }
+
+    if (len(files) != 2 ||
+        files[0].Id != 7 ||
+        files[1].Links.Self.Href != "/files/8/") {
+        t.Errorf("ListFiles gave %+v", files)
+    }
+}
+
+func TestReviewBoardFetchFile(t *testing.T) {
+    var fail            bool
+    var missingOriginal bool
+
+    backend, url := fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            if (fail) {
+                w.WriteHeader(http.StatusInternalServerError)
+                w.Write([]byte("Internal error"))
+                return
+            }
+
+            switch (r.URL.Path) {
+            case "/files/7/":
+                if (strings.Contains(r.Header.Get("Accept"), "diff.data")) {
+                    w.Write([]byte(`{"stat": "ok",
+                                     "diff_data": {"chunks": [
+                        {"index": 0, "change": "replace", "lines": [
+                         [1, 1, "old", [], 1, "new", [], false]]}]}}`))
+                } else {
+                    w.Write([]byte(`{"stat": "ok",
+                                     "file": {"dest_file": "a.go",
+                        "status": "modified", "source_revision": "abc"}}`))
+                }
+            case "/files/7/patched/":
+                w.Write([]byte("new\n"))
+            case "/files/7/original/":
+                if (missingOriginal) {
+                    w.WriteHeader(http.StatusNotFound)
+                    w.Write([]byte("<html>Not found</html>"))
+                } else {
+                    w.Write([]byte("old\n"))
+                }
+            }
+        })
+
+    var diffFile DiffFile
+    diffFile.Id                       = 7
+    diffFile.Links.Self.Href          = url + "/files/7/"
+    diffFile.Links.Patched_File.Href  = url + "/files/7/patched/"
+    diffFile.Links.Original_File.Href = url + "/files/7/original/"
+
+    file, err := backend.FetchFile(diffFile)
+
+    if (err != nil) {
+        t.Fatalf("FetchFile failed: %s", err)
+    }
+
+    if (file.Id != 7 ||
+        file.Filename != "a.go" ||
+        file.Status != reviewdata.FileModified ||
+        string(file.EntireFile) != "new\n" ||
+        string(file.OriginalFile) != "old\n") {
+        t.Errorf("FetchFile gave %+v", file)
+    }
+
+    if (len(file.Diff_Data.Chunks) != 1 ||
+        file.Diff_Data.Chunks[0].Lines[0].LhText != "old" ||
+        file.Diff_Data.Chunks[0].Lines[0].RhLine != 1) {
+        t.Errorf("FetchFile gave chunks %+v", file.Diff_Data.Chunks)
+    }
+
+    // Error pages aren't mistaken for files
+    missingOriginal = true
+
+    if _, err = backend.FetchFile(diffFile); (err == nil) {
+        t.Errorf("FetchFile of a file without its original succeeded")
+    }
+
+    // Failures are returned, rather than taking the bot down
+    fail = true
+
+    if _, err = backend.FetchFile(diffFile); (err == nil) {
+        t.Errorf("FetchFile of a failing file succeeded")
+    }
+}
+
+func TestReviewBoardPostComment(t *testing.T) {
+    var got = make(map[string]string)
+
+    backend, _ := fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.Method != "POST" ||
+                r.URL.Path != "/api/review-requests/42/reviews/9/" +
+                              "diff-comments/") {
+                w.WriteHeader(http.StatusNotFound)
+                return
+            }
+
+            for _, key := range []string{"filediff_id", "first_line",
+                                         "num_lines", "text",
+                                         "issue_opened"} {
+                got[key] = formValue(t, r, key)
+            }
+
+            w.WriteHeader(http.StatusCreated)
+            w.Write([]byte(`{"stat": "ok", "diff_comment": {"id": 3}}`))
+        })
+
+    var file reviewdata.FileDiff
+    file.Id = 7
+
+    err := backend.PostComment("42",
+                               "9",
+                               file,
+                               reviewdata.Comment{Line:       3,
+                                                  NumLines:   2,
+                                                  Text:       "Hmm",
+                                                  RaiseIssue: true})
+
+    if (err != nil) {
+        t.Fatalf("PostComment failed: %s", err)
+    }
+
+    var want = map[string]string{"filediff_id":  "7",
+                                 "first_line":   "3",
+                                 "num_lines":    "2",
+                                 "text":         "Hmm",
+                                 "issue_opened": "true"}
+
+    for key, value := range want {
+        if (got[key] != value) {
+            t.Errorf("PostComment sent %s=%q, want %q", key, got[key], value)
+        }
+    }
+}
+
+func TestReviewBoardPublish(t *testing.T) {
+    var tests = []struct {
+        name    string
+        verdict Verdict
+        trivial string
+        bottom  string
+    }{
+        {"notify", Verdict{Body: "Top", Notify: true}, "", ""},
+        {"quiet", Verdict{Body: "Top"}, "true", ""},
+        {"footer", Verdict{Body: "Top", Footer: "Foot", Notify: true},
+         "", "Foot"},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var got = make(map[string]string)
+
+            backend, _ := fakeReviewBoard(t,
+                func(w http.ResponseWriter, r *http.Request) {
+                    if (r.Method != "PUT" ||
+                        r.URL.Path != "/api/review-requests/42/reviews/9/") {
+                        w.WriteHeader(http.StatusNotFound)
+                        return
+                    }
+
+                    for _, key := range []string{"public", "body_top",
+                                                 "trivial", "body_bottom"} {
+                        got[key] = formValue(t, r, key)
+                    }
+
+                    w.Write([]byte(`{"stat": "ok", "review": {"id": 9}}`))
+                })
+
+            err := backend.Publish("42", "9", test.verdict)
+
+            if (err != nil) {
+                t.Fatalf("Publish failed: %s", err)
+            }
+
+            if (got["public"] != "1" ||
+                got["body_top"] != "Top" ||
+                got["trivial"] != test.trivial ||
+                got["body_bottom"] != test.bottom) {
+                t.Errorf("Publish sent %v", got)
+            }
+        })
+    }
+}
+
+/**
+ * A ReviewBoard failure: an error status, or an ok status whose body doesn't
+ * say ok.
+ */
+type rbFailure struct {
+    name   string
+    status int
+    body   string
+}
+
+var rbFailures = []rbFailure{
+    {"forbidden", http.StatusForbidden,
+     `{"stat": "fail", "err": {"code": 101, "msg": "You don't have ` +
+     `permission for this"}}`},
+    {"server error", http.StatusInternalServerError,
+     "<html>Internal Server Error</html>"},
+    {"not ok", http.StatusOK,
+     `{"stat": "fail", "err": {"code": 208, "msg": "Invalid form data"}}`},
+    {"error page", http.StatusOK, "<html>Log in</html>"},
+}
+
+/**
+ * Creates a ReviewBoard backend whose every request fails.
+ */
+func failingReviewBoard(t       *testing.T,
+                        failure  rbFailure) *ReviewBoardBackend {
+    backend, _ := fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            w.WriteHeader(failure.status)
+            w.Write([]byte(failure.body))
+        })
+
+    return backend
+}
+
+func TestReviewBoardFailures(t *testing.T) {
+    useTestDb(t)
+
+    var file reviewdata.FileDiff
+    file.Id = 7
+
+    var request reviewdata.ReviewRequest
+    request.ReviewId = "42"
+
+    for _, failure := range rbFailures {
+        t.Run(failure.name, func(t *testing.T) {
+            backend := failingReviewBoard(t, failure)
+
+            err := backend.PostComment("42",
+                                       "9",
+                                       file,
+                                       reviewdata.Comment{Line:     3,
+                                                          NumLines: 1,
+                                                          Text:     "Hmm"})
+
+            if (err == nil) {
+                t.Errorf("PostComment succeeded")
+            }
+
+            err = backend.Publish("42", "9", Verdict{Body: "Top"})
+
+            if (err == nil) {
+                t.Errorf("Publish succeeded")
+            }
+
+            if _, err = backend.StartReply(request); (err == nil) {
+                t.Errorf("StartReply succeeded")
+            }
+
+            if _, err = backend.FetchRequest("42"); (err == nil) {
+                t.Errorf("FetchRequest succeeded")
+            }
+        })
+    }
+
+    // ReviewBoard's reason is passed on
+    backend := failingReviewBoard(t, rbFailures[0])
+    err     := backend.Publish("42", "9", Verdict{Body: "Top"})
+
+    if (err == nil ||
+        !strings.Contains(err.Error(), "403") ||
+        !strings.Contains(err.Error(), "You don't have permission")) {
+        t.Errorf("Publish gave %v", err)
+    }
+}
+
+func TestReviewBoardResolvePrevious(t *testing.T) {
+    useTestDb(t)
+
+    var backend *ReviewBoardBackend
+    var url     string
+    var open    int32 = 1
+
+    dropped := make(chan string, 10)
+
+    backend, url = fakeReviewBoard(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            switch (r.URL.Path) {
+            case "/api/review-requests/42/reviews/":
+                w.Write([]byte(`{"stat": "ok", "reviews": [
+                    {"id": 8, "links": {"user": {"title": "someone"}}},
+                    {"id": 9, "links": {"user": {"title": "reviewbot"}}}]}`))
+            case "/api/review-requests/42/reviews/9/diff-comments/":
+                // Comments are dropped until none are left open
+                if (atomic.LoadInt32(&open) == 1) {
+                    w.Write([]byte(`{"stat": "ok", "diff_comments": [
+                        {"id": 1, "issue_opened": true, "issue_status": "open",
+                         "links": {"self": {"href": "` + url + `/c/1/"}}},
+                        {"id": 2, "issue_opened": false,
+                         "links": {"self": {"href": "` + url + `/c/2/"}}}]}`))
+                } else {
+                    w.Write([]byte(`{"stat": "ok", "diff_comments": []}`))
+                }
+            case "/c/1/", "/c/2/":
+                atomic.StoreInt32(&open, 0)
+                dropped <- r.URL.Path + " " + formValue(t, r, "issue_status")
+                w.Write([]byte(`{"stat": "ok"}`))
+            case "/api/review-requests/43/reviews/":
+                w.Write([]byte("<html>Broken</html>"))
+            }
+        })
+
+    if err := backend.ResolvePrevious("42"); (err != nil) {
+        t.Fatalf("ResolvePrevious failed: %s", err)
+    }
+
+    // Comments are dropped in the background
+    select {
+    case drop := <-dropped:
+        if (drop != "/c/1/ dropped") {
+            t.Errorf("ResolvePrevious made drop %q", drop)
+        }
+    case <-time.After(5 * time.Second):
+        t.Errorf("ResolvePrevious didn't drop the open comment")
+    }
+
+    if err := backend.ResolvePrevious("43"); (err == nil) {
+        t.Errorf("ResolvePrevious with a broken reply list succeeded")
+    }
+}
+
+func TestGerritFetchRequest(t *testing.T) {
+    backend := fakeGerrit(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            user, password, _ := r.BasicAuth()
+
+            if (user != "reviewbot" || password != "secret") {
+                w.WriteHeader(http.StatusUnauthorized)
+                return
+            }
+
+            if (r.URL.Path != "/a/changes/42") {
+                w.WriteHeader(http.StatusNotFound)
+                w.Write([]byte("Not found"))
+                return
+            }
+
+            writeGerrit(w, `{"project": "bot", "branch": "master",
+                "subject": "Fix it", "_number": 42,
+                "current_revision": "abc",
+                "revisions": {"abc": {"commit": {"message":
+                    "Fix it\n\nProperly.\n\nBug: 7, 8\nChange-Id: I1\n"}}}}`)
+        })
+
+    request, err := backend.FetchRequest("42")
+
+    if (err != nil) {
+        t.Fatalf("FetchRequest failed: %s", err)
+    }
+
+    if (request.Id != 42 ||
+        request.Summary != "Fix it" ||
+        request.Description != "Properly." ||
+        len(request.Bugs_Closed) != 2 ||
+        request.Links.Repository.Title != "bot" ||
+        request.Links.Latest_Diff.Href != "/changes/42/revisions/abc") {
+        t.Errorf("FetchRequest gave %+v", request)
+    }
+
+    if _, err = backend.FetchRequest("43"); (err == nil) {
+        t.Errorf("FetchRequest of a missing change succeeded")
+    }
+}
+
+func TestGerritListFiles(t *testing.T) {
+    backend := fakeGerrit(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            writeGerrit(w, `{"/COMMIT_MSG": {}, "dir/b.go": {},
+                             "a.go": {"status": "A"}}`)
+        })
+
+    var request reviewdata.ReviewRequest
+    request.Links.Latest_Diff.Href = "/changes/42/revisions/abc"
+
+    files, err := backend.ListFiles(request)
+
+    if (err != nil) {
+        t.Fatalf("ListFiles failed: %s", err)
+    }
+
+    if (len(files) != 2 ||
+        files[0].Id != 1 ||
+        files[0].Filename != "a.go" ||
+        files[1].Id != 2 ||
+        files[1].Filename != "dir/b.go" ||
+        files[1].Links.Self.Href != "/changes/42/revisions/abc/files/" +
+                                    "dir%2Fb.go") {
+        t.Errorf("ListFiles gave %+v", files)
+    }
+}
+
+func TestGerritFetchFile(t *testing.T) {
+    encode := func(text string) []byte {
+        return []byte(base64.StdEncoding.EncodeToString([]byte(text)))
+    }
+
+    backend := fakeGerrit(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            switch (r.URL.EscapedPath() + "?" + r.URL.RawQuery) {
+            case "/a/changes/42/revisions/abc/files/a.go/diff?context=ALL":
+                writeGerrit(w, `{"change_type": "RENAMED",
+                    "meta_a": {"name": "old.go"},
+                    "content": [{"ab": ["same"]},
+                                {"a": ["old"], "b": ["new", "more"]}]}`)
+            case "/a/changes/42/revisions/abc/files/old.go/content?parent=1":
+                w.Write(encode("same\nold\n"))
+            case "/a/changes/42/revisions/abc/files/a.go/content?":
+                w.Write(encode("same\nnew\nmore\n"))
+            default:
+                w.WriteHeader(http.StatusNotFound)
+            }
+        })
+
+    var diffFile DiffFile
+    diffFile.Id              = 1
+    diffFile.Filename        = "a.go"
+    diffFile.Links.Self.Href = "/changes/42/revisions/abc/files/a.go"
+
+    file, err := backend.FetchFile(diffFile)
+
+    if (err != nil) {
+        t.Fatalf("FetchFile failed: %s", err)
+    }
+
+    if (file.Filename != "a.go" ||
+        file.Status != reviewdata.FileMoved ||
+        string(file.OriginalFile) != "same\nold\n" ||
+        string(file.EntireFile) != "same\nnew\nmore\n") {
+        t.Errorf("FetchFile gave %+v", file)
+    }
+
+    // The replacement pairs "old" with "new", and inserts "more"
+    var changes []string
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        changes = append(changes, chunk.Change)
+    }
+
+    if (strings.Join(changes, ",") != "equal,replace,insert" ||
+        file.RhLineFor(3) != 3 ||
+        file.ReviewLineForLh(2) != 2) {
+        t.Errorf("FetchFile gave chunks %+v", file.Diff_Data.Chunks)
+    }
+
+    diffFile.Links.Self.Href = "/changes/42/revisions/abc/files/b.go"
+
+    if _, err = backend.FetchFile(diffFile); (err == nil) {
+        t.Errorf("FetchFile of a missing file succeeded")
+    }
+}
+
+func TestGerritPostComment(t *testing.T) {
+    var got GerritCommentInput
+
+    backend := fakeGerrit(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.Method != "PUT" ||
+                r.URL.Path != "/a/changes/42/revisions/abc/drafts") {
+                w.WriteHeader(http.StatusNotFound)
+                return
+            }
+
+            got = GerritCommentInput{}
+            json.NewDecoder(r.Body).Decode(&got)
+            writeGerrit(w, `{}`)
+        })
+
+    var diff GerritDiff
+
+    json.Unmarshal([]byte(`{"content": [{"ab": ["one", "two"]},
+                                        {"b": ["thrée", "four", "five"]}]}`),
+                   &diff)
+
+    var file reviewdata.FileDiff
+    file.Filename         = "a.go"
+    file.Diff_Data.Chunks = GerritChunks(diff)
+
+    var tests = []struct {
+        name    string
+        comment reviewdata.Comment
+        line    int
+        span    *GerritCommentRange
+    }{
+        {"line", reviewdata.Comment{Line: 3, NumLines: 1}, 3, nil},
+        {"unsized", reviewdata.Comment{Line: 3}, 3, nil},
+        {"range", reviewdata.Comment{Line: 2, NumLines: 2}, 3,
+         &GerritCommentRange{StartLine: 2, EndLine: 3, EndCharacter: 5}},
+        {"long range", reviewdata.Comment{Line: 1, NumLines: 5}, 5,
+         &GerritCommentRange{StartLine: 1, EndLine: 5, EndCharacter: 4}},
+    }
+
+    for _, test := range tests {
+        test.comment.Text       = "Hmm"
+        test.comment.RaiseIssue = true
+
+        err := backend.PostComment("42", "abc", file, test.comment)
+
+        if (err != nil) {
+            t.Fatalf("PostComment(%s) failed: %s", test.name, err)
+        }
+
+        if (got.Path != "a.go" ||
+            got.Line != test.line ||
+            got.Message != "Hmm" ||
+            !got.Unresolved ||
+            (got.Range == nil) != (test.span == nil) ||
+            (got.Range != nil && *got.Range != *test.span)) {
+            t.Errorf("PostComment(%s) sent %+v, range %+v",
+                     test.name,
+                     got,
+                     got.Range)
+        }
+    }
+}
+
+func TestGerritPublish(t *testing.T) {
+    var tests = []struct {
+        name    string
+        label   string
+        verdict Verdict
+        notify  string
+        votes   map[string]int
+    }{
+        {"no label", "", Verdict{Body: "Top", Commented: true},
+         "NONE", nil},
+        {"issues", "Code-Review", Verdict{Body: "Top", Commented: true,
+                                          Notify: true},
+         "OWNER", map[string]int{"Code-Review": -1}},
+        {"perfect", "Code-Review", Verdict{Body: "Top"},
+         "NONE", map[string]int{"Code-Review": 1}},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            var got GerritReviewInput
+
+            backend := fakeGerrit(t,
+                func(w http.ResponseWriter, r *http.Request) {
+                    if (r.Method != "POST" ||
+                        r.URL.Path != "/a/changes/42/revisions/abc/review") {
+                        w.WriteHeader(http.StatusNotFound)
+                        return
+                    }
+
+                    json.NewDecoder(r.Body).Decode(&got)
+                    writeGerrit(w, `{}`)
+                })
+
+            backend.server.Config.Gerrit.Label       = test.label
+            backend.server.Config.Gerrit.IssueVote   = -1
+            backend.server.Config.Gerrit.PerfectVote = 1
+
+            err := backend.Publish("42", "abc", test.verdict)
+
+            if (err != nil) {
+                t.Fatalf("Publish failed: %s", err)
+            }
+
+            if (got.Message != "Top" ||
+                got.Drafts != "PUBLISH" ||
+                got.Notify != test.notify ||
+                len(got.Labels) != len(test.votes)) {
+                t.Errorf("Publish sent %+v", got)
+            }
+
+            for label, vote := range test.votes {
+                if (got.Labels[label] != vote) {
+                    t.Errorf("Publish voted %d on %s, want %d",
+                             got.Labels[label],
+                             label,
+                             vote)
+                }
+            }
+        })
+    }
+}
+
+func TestGerritResolvePrevious(t *testing.T) {
+    var got = make(map[string]GerritReviewInput)
+
+    backend := fakeGerrit(t,
+        func(w http.ResponseWriter, r *http.Request) {
+            switch (r.URL.Path) {
+            case "/a/changes/42/comments":
+                // Only c1 is ours, unresolved and unanswered
+                writeGerrit(w, `{"a.go": [
+                    {"id": "c1", "line": 3, "patch_set": 1, "unresolved": true,
+                     "author": {"username": "reviewbot"}},
+                    {"id": "c2", "line": 4, "patch_set": 1, "unresolved": true,
+                     "author": {"username": "reviewbot"}},
+                    {"id": "c3", "line": 4, "patch_set": 1, "unresolved": true,
+                     "in_reply_to": "c2", "author": {"username": "someone"}},
+                    {"id": "c4", "line": 5, "patch_set": 2, "unresolved": true,
+                     "author": {"username": "someone"}}]}`)
+            case "/a/changes/42/revisions/1/review":
+                var review GerritReviewInput
+                json.NewDecoder(r.Body).Decode(&review)
+                got[r.URL.Path] = review
+                writeGerrit(w, `{}`)
+            default:
+                w.WriteHeader(http.StatusNotFound)
+            }
+        })
+
+    if err := backend.ResolvePrevious("42"); (err != nil) {
+        t.Fatalf("ResolvePrevious failed: %s", err)
+    }
+
+    review, found := got["/a/changes/42/revisions/1/review"]
+
+    if (!found ||
+        len(got) != 1 ||
+        len(review.Comments["a.go"]) != 1 ||
+        review.Comments["a.go"][0].InReplyTo != "c1" ||
+        review.Comments["a.go"][0].Unresolved) {
+        t.Errorf("ResolvePrevious sent %+v", got)
+    }
+
+    if err := backend.ResolvePrevious("43"); (err == nil) {
+        t.Errorf("ResolvePrevious of a missing change succeeded")
+    }
+}
@@ -0,0 +1,219 @@
+/**
+ * Classifies files before they are reviewed, so that generated, vendored,
+ * binary and oversized files can be treated differently from source files.
+ */
+package reviewer
+
+import (
+    "bytes"
+    "regexp"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * What is done with each class of file.
+ */
+const (
+    PolicyReview      = "review"       /**< Run the plugins, as normal */
+    PolicySkip        = "skip"         /**< Don't review the file at all */
+    PolicyCommentOnce = "comment-once" /**< Say what the file is, once,
+                                        *   instead of running the plugins */
+)
+
+/**
+ * Classifies files for a server.
+ */
+type Classifier struct {
+    generatedContent *regexp.Regexp
+    generatedPaths   *regexp.Regexp
+    vendoredPaths    *regexp.Regexp
+    maxFileBytes     int
+    maxDiffLines     int
+    policies         map[string]string
+    comments         map[string]string
+}
+
+var (
+    // Go's convention for marking generated files
+    goGeneratedMarker = "(?m)^// Code generated .* DO NOT EDIT\\.$"
+
+    defaultVendoredPaths = []string{
+        "(^|/)vendor/",
+        "(^|/)third[-_]party/",
+        "(^|/)node_modules/",
+        "(^|/)src/[a-z0-9.\\-]+\\.[a-z]+/", // GOPATH-style, e.g. src/github.com
+    }
+
+    defaultPolicies = map[string]string{
+        reviewdata.ClassSource:    PolicyReview,
+        reviewdata.ClassGenerated: PolicyCommentOnce,
+        reviewdata.ClassVendored:  PolicySkip,
+        reviewdata.ClassBinary:    PolicySkip,
+        reviewdata.ClassOversized: PolicySkip,
+    }
+
+    defaultClassComments = map[string]string{
+        reviewdata.ClassGenerated: "This file is generated, so shouldn't be " +
+                                   "edited by hand. Change whatever it's " +
+                                   "generated from, and regenerate it",
+        reviewdata.ClassVendored:  "This file is vendored, so shouldn't be " +
+                                   "edited here. Change it upstream, and " +
+                                   "vendor the new version",
+        reviewdata.ClassBinary:    "This binary file wasn't reviewed",
+        reviewdata.ClassOversized: "This file is too large to review",
+    }
+)
+
+// Generated markers are only looked for this far into a file
+const generatedMarkerBytes = 4096
+
+/**
+ * Creates a classifier from a server's configuration.
+ *
+ * @param config The server's configuration.
+ *
+ * @returns The classifier.
+ */
+func NewClassifier(config RbConfig) *Classifier {
+    var classification = config.Classification
+    var vendoredPaths  []string = classification.VendoredPaths
+    var generated      []string = append([]string{goGeneratedMarker},
+                                         classification.GeneratedPatterns...)
+
+    classifier := &Classifier{maxFileBytes: classification.MaxFileBytes,
+                              maxDiffLines: classification.MaxDiffLines,
+                              policies:     make(map[string]string),
+                              comments:     make(map[string]string)}
+
+    classifier.generatedContent = regexp.MustCompile(
+                                        strings.Join(generated, "|"))
+
+    if (len(classification.GeneratedPaths) > 0) {
+        classifier.generatedPaths = regexp.MustCompile(
+                            strings.Join(classification.GeneratedPaths, "|"))
+    }
+
+    if (len(vendoredPaths) == 0) {
+        vendoredPaths = defaultVendoredPaths
+    }
+
+    classifier.vendoredPaths = regexp.MustCompile(
+                                        strings.Join(vendoredPaths, "|"))
+
+    for class, policy := range defaultPolicies {
+        classifier.policies[class] = policy
+    }
+
+    for class, policy := range classification.Policies {
+        classifier.policies[class] = policy
+    }
+
+    for class, comment := range defaultClassComments {
+        classifier.comments[class] = comment
+    }
+
+    for class, comment := range classification.Comments {
+        classifier.comments[class] = comment
+    }
+
+    return classifier
+}
+
+/**
+ * Classifies a file.
+ *
+ * @param file The file, fetched from the backend.
+ *
+ * @returns One of the reviewdata Class constants.
+ */
+func (c *Classifier) Classify(file reviewdata.FileDiff) string {
+    var top []byte = file.EntireFile
+
+    if (len(top) > generatedMarkerBytes) {
+        top = top[:generatedMarkerBytes]
+    }
+
+    if (file.Diff_Data.Binary || bytes.IndexByte(top, 0) >= 0) {
+        return reviewdata.ClassBinary
+    }
+
+    if (c.vendoredPaths.MatchString(file.Filename)) {
+        return reviewdata.ClassVendored
+    }
+
+    if ((c.generatedPaths != nil &&
+         c.generatedPaths.MatchString(file.Filename)) ||
+        c.generatedContent.Match(top)) {
+        return reviewdata.ClassGenerated
+    }
+
+    if (c.maxFileBytes > 0 && len(file.EntireFile) > c.maxFileBytes) {
+        return reviewdata.ClassOversized
+    }
+
+    if (c.maxDiffLines > 0) {
+        var diffLines int = 0
+
+        for _, chunk := range file.Diff_Data.Chunks {
+            if (chunk.Change != "equal") {
+                diffLines += len(chunk.Lines)
+            }
+        }
+
+        if (diffLines > c.maxDiffLines) {
+            return reviewdata.ClassOversized
+        }
+    }
+
+    return reviewdata.ClassSource
+}
+
+/**
+ * Retrieves the policy for a class of file.
+ */
+func (c *Classifier) Policy(class string) string {
+    policy, found := c.policies[class]
+
+    if (!found) {
+        return PolicyReview
+    }
+
+    return policy
+}
+
+/**
+ * Makes the single comment on a file whose class's policy is comment-once,
+ * on the file's first changed line.
+ *
+ * @param file     The file.
+ * @param comments The channel to which the comment is sent.
+ * @param wg       Done is called on this once the comment is sent.
+ */
+func (c *Classifier) CommentOnce(file      reviewdata.FileDiff,
+                                 comments  chan <- reviewdata.Comment,
+                                 wg       *sync.WaitGroup) {
+    defer wg.Done()
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        if (chunk.Change == "equal") {
+            continue
+        }
+
+        for _, line := range chunk.Lines {
+            if (line.RhLine > 0) {
+                comments <- reviewdata.Comment{
+                                Line:       line.ReviewLine,
+                                NumLines:   1,
+                                Text:       c.comments[file.Class],
+                                RaiseIssue: file.Class ==
+                                            reviewdata.ClassGenerated,
+                                Rule:       file.Class,
+                                Plugin:     "FileClassifier"}
+                return
+            }
+        }
+    }
+}
@@ -4,6 +4,7 @@
 package reviewer
 
 type RbConfig struct {
+    Backend  string /* "reviewboard" (the default) or "gerrit" */
     RbApiUrl string
     RbToken  string
     RbUsername string /* Used to drop previous comments, when configured to do
@@ -26,6 +27,32 @@ type RbConfig struct {
         File        []string
         ReviewTitle []string
     }
+    Classification struct {
+        GeneratedPatterns []string /* Regexes which mark the top of a file as
+                                    * generated, as well as Go's "Code
+                                    * generated ... DO NOT EDIT." */
+        GeneratedPaths    []string /* Regexes of generated file names */
+        VendoredPaths     []string /* Regexes of vendored file names.
+                                    * Replaces the defaults */
+        MaxFileBytes      int      /* Larger files are oversized. Zero
+                                    * doesn't limit size */
+        MaxDiffLines      int      /* Files with larger diffs are oversized.
+                                    * Zero doesn't limit size */
+        Policies          map[string]string /* Class to "skip", "review"
+                                             * or "comment-once" */
+        Comments          map[string]string /* Class to the comment made
+                                             * by comment-once */
+    }
     ConcurrentFileDownloads int
     EmailOnPerfect          bool
+    Plugins                 []string /* Canonical names of the reviewer plugins
+                                      * to run. Empty runs all of them. */
+    Gerrit struct {
+        Url         string /* e.g. https://gerrit.example.com */
+        Username    string /* Also used to resolve previous comments */
+        Password    string /* The user's HTTP password */
+        Label       string /* The label to vote on. Empty doesn't vote */
+        IssueVote   int    /* The vote given when comments are made */
+        PerfectVote int    /* The vote given when none are */
+    }
 }
@@ -0,0 +1,683 @@
+/**
+ * The Gerrit backend, which talks to Gerrit's REST API.
+ *
+ * A Gerrit change is reviewed as a review request whose ID is the change
+ * number. Its latest diff is the current patch set, and a reply is made up of
+ * draft comments on that patch set, which are published by setting a review.
+ */
+package reviewer
+
+import (
+    "bytes"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "path"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "unicode/utf8"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * Gerrit prefixes every JSON response with this, to defeat XSSI.
+ */
+var gerritMagicPrefix = []byte(")]}'")
+
+/**
+ * Matches commit message footers, e.g. "Change-Id: I0123abcd".
+ */
+var gerritFooter = regexp.MustCompile("^[A-Za-z0-9-]+: ")
+
+/**
+ * The parts of a Gerrit ChangeInfo that we use.
+ */
+type GerritChange struct {
+    Project          string
+    Branch           string
+    Subject          string
+    Updated          string
+    Number           int `json:"_number"`
+    Current_Revision string
+    Revisions        map[string]struct {
+        Commit struct {
+            Message string
+        }
+    }
+}
+
+/**
+ * The parts of a Gerrit FileInfo that we use.
+ */
+type GerritFile struct {
+    Status string
+    Binary bool
+}
+
+/**
+ * The parts of a Gerrit DiffInfo that we use. Each content entry is a run of
+ * lines which are either the same on both sides (Ab), or differ (A and/or B).
+ */
+type GerritDiff struct {
+    Change_Type string
+    Binary      bool
+    Meta_A      struct {
+        Name string // The file's name before the change
+    }
+    Content     []struct {
+        A      []string
+        B      []string
+        Ab     []string
+        Skip   int
+        Common bool // A and B differ only in whitespace
+    }
+}
+
+/**
+ * Maps Gerrit's change types onto file statuses.
+ */
+var gerritStatuses = map[string]string{
+    "ADDED":    reviewdata.FileAdded,
+    "MODIFIED": reviewdata.FileModified,
+    "REWRITE":  reviewdata.FileModified,
+    "DELETED":  reviewdata.FileDeleted,
+    "RENAMED":  reviewdata.FileMoved,
+    "COPIED":   reviewdata.FileCopied,
+}
+
+/**
+ * A comment, as read from Gerrit.
+ */
+type GerritComment struct {
+    Id          string
+    Line        int
+    Patch_Set   int
+    In_Reply_To string
+    Unresolved  bool
+    Author      struct {
+        Username string
+    }
+}
+
+/**
+ * The lines that a comment spans, as sent to Gerrit.
+ */
+type GerritCommentRange struct {
+    StartLine      int `json:"start_line"`
+    StartCharacter int `json:"start_character"`
+    EndLine        int `json:"end_line"`
+    EndCharacter   int `json:"end_character"`
+}
+
+/**
+ * A comment, as sent to Gerrit.
+ */
+type GerritCommentInput struct {
+    Path       string              `json:"path,omitempty"`
+    Line       int                 `json:"line,omitempty"`
+    Range      *GerritCommentRange `json:"range,omitempty"`
+    InReplyTo  string              `json:"in_reply_to,omitempty"`
+    Message    string              `json:"message"`
+    Unresolved bool                `json:"unresolved"`
+}
+
+/**
+ * A review, as sent to Gerrit.
+ */
+type GerritReviewInput struct {
+    Message  string                          `json:"message,omitempty"`
+    Labels   map[string]int                  `json:"labels,omitempty"`
+    Comments map[string][]GerritCommentInput `json:"comments,omitempty"`
+    Drafts   string                          `json:"drafts,omitempty"`
+    Notify   string                          `json:"notify,omitempty"`
+    Tag      string                          `json:"tag"`
+}
+
+/**
+ * Sends a request to Gerrit's authenticated REST API.
+ *
+ * @param server  The server to which the request shall be sent.
+ * @param method  The request method.
+ * @param path    The REST path, e.g. "/changes/1234".
+ * @param payload Anything to send as a json body. Nil sends no body.
+ *
+ * @retval []byte The raw response body.
+ * @retval error  The error that occurred, if the request was unsuccessful.
+ */
+func GerritSend(server  *Server,
+                method   string,
+                path     string,
+                payload  interface{}) ([]byte, error) {
+    var body io.Reader
+
+    if (payload != nil) {
+        encoded, err := json.Marshal(payload)
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        body = bytes.NewReader(encoded)
+    }
+
+    req, err := http.NewRequest(method,
+                                server.Config.Gerrit.Url + "/a" + path,
+                                body)
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    req.SetBasicAuth(server.Config.Gerrit.Username,
+                     server.Config.Gerrit.Password)
+    req.Header.Set("Accept", "application/json")
+
+    if (payload != nil) {
+        req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+    }
+
+    resp, err := (&http.Client{}).Do(req)
+
+    if (err != nil) {
+        return nil, err
+    }
+    defer resp.Body.Close()
+
+    respBody, err := ioutil.ReadAll(resp.Body)
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    if (resp.StatusCode < 200 || resp.StatusCode > 299) {
+        return nil, errors.New("Gerrit returned " + resp.Status + " for " +
+                               path + ": " +
+                               strings.TrimSpace(string(respBody)))
+    }
+
+    return respBody, nil
+}
+
+/**
+ * Sends a request to Gerrit, and decodes the json response.
+ *
+ * @param server     The server to which the request shall be sent.
+ * @param method     The request method.
+ * @param path       The REST path.
+ * @param payload    Anything to send as a json body. Nil sends no body.
+ * @param respEntity A pointer to a struct into which the response should be
+ *                   decoded. If this is nil, the response is not decoded.
+ *
+ * @retval nil   If the request was successful.
+ * @retval error The error that occurred, if the request was unsuccessful.
+ */
+func GerritRequest(server     *Server,
+                   method      string,
+                   path        string,
+                   payload     interface{},
+                   respEntity  interface{}) error {
+    body, err := GerritSend(server, method, path, payload)
+
+    if (err != nil || respEntity == nil) {
+        return err
+    }
+
+    return json.Unmarshal(bytes.TrimPrefix(body, gerritMagicPrefix),
+                          respEntity)
+}
+
+/**
+ * Converts a Gerrit diff into chunks. Gerrit comments are made against
+ * right-hand line numbers, so those are also the lines' review lines.
+ * Removed lines have no right-hand line, and can't be commented on.
+ *
+ * Like ReviewBoard, a replacement pairs its removed and added lines, and any
+ * left over go into a delete or insert chunk of their own.
+ *
+ * @param diff The diff.
+ *
+ * @returns The chunks.
+ */
+func GerritChunks(diff GerritDiff) []reviewdata.DiffChunk {
+    var chunks []reviewdata.DiffChunk
+    var lhLine int = 1
+    var rhLine int = 1
+
+    addChunk := func(change  string,
+                     lhLines []string,
+                     rhLines []string,
+                     common  bool) {
+        var chunk reviewdata.DiffChunk = reviewdata.DiffChunk{
+                                             Index:  len(chunks),
+                                             Change: change}
+
+        for i := 0; i < len(lhLines) || i < len(rhLines); i++ {
+            var line reviewdata.Line = reviewdata.Line{WhitespaceOnly: common}
+
+            if (i < len(lhLines)) {
+                line.LhLine = lhLine
+                line.LhText = lhLines[i]
+                lhLine++
+            }
+
+            if (i < len(rhLines)) {
+                line.ReviewLine = rhLine
+                line.RhLine     = rhLine
+                line.RhText     = rhLines[i]
+                rhLine++
+            }
+
+            chunk.Lines = append(chunk.Lines, line)
+        }
+
+        chunks = append(chunks, chunk)
+    }
+
+    for _, content := range diff.Content {
+        var paired int = len(content.A)
+
+        if (len(content.B) < paired) {
+            paired = len(content.B)
+        }
+
+        if (len(content.Ab) > 0) {
+            addChunk("equal", content.Ab, content.Ab, false)
+        } else if (len(content.A) > 0 || len(content.B) > 0) {
+            if (paired > 0) {
+                addChunk("replace",
+                         content.A[:paired],
+                         content.B[:paired],
+                         content.Common)
+            }
+
+            if (len(content.A) > paired) {
+                addChunk("delete", content.A[paired:], nil, content.Common)
+            }
+
+            if (len(content.B) > paired) {
+                addChunk("insert", nil, content.B[paired:], content.Common)
+            }
+        } else {
+            // Lines that Gerrit didn't send us
+            lhLine += content.Skip
+            rhLine += content.Skip
+        }
+    }
+
+    return chunks
+}
+
+/**
+ * Picks the bug IDs out of a commit message's "Bug:" footers.
+ */
+func GerritBugs(message string) []string {
+    var bugs []string
+
+    for _, line := range strings.Split(message, "\n") {
+        if (strings.HasPrefix(line, "Bug:")) {
+            for _, bug := range strings.Split(line[len("Bug:"):], ",") {
+                if (strings.TrimSpace(bug) != "") {
+                    bugs = append(bugs, strings.TrimSpace(bug))
+                }
+            }
+        }
+    }
+
+    return bugs
+}
+
+/**
+ * Picks the description out of a commit message: everything after the subject
+ * line, apart from the trailing footers.
+ */
+func GerritDescription(message string) string {
+    lines := strings.Split(strings.TrimSpace(message), "\n")[1:]
+
+    // Footers are the last paragraph, if every line in it is a "Key: value"
+    // line
+    var end int = len(lines)
+
+    for end > 0 && strings.TrimSpace(lines[end - 1]) != "" {
+        if (!gerritFooter.MatchString(lines[end - 1])) {
+            end = len(lines)
+            break
+        }
+
+        end--
+    }
+
+    return strings.TrimSpace(strings.Join(lines[:end], "\n"))
+}
+
+/**
+ * Reviews for Gerrit.
+ */
+type GerritBackend struct {
+    server *Server
+}
+
+func (b *GerritBackend) FetchRequest(
+                                reviewId string) (reviewdata.ReviewRequest,
+                                                  error) {
+    var change  GerritChange
+    var request reviewdata.ReviewRequest
+
+    err := GerritRequest(b.server,
+                         "GET",
+                         "/changes/" + reviewId +
+                         "?o=CURRENT_REVISION&o=CURRENT_COMMIT",
+                         nil,
+                         &change)
+
+    if (err != nil) {
+        return request, err
+    }
+
+    request.Id           = change.Number
+    request.Summary      = change.Subject
+    request.Branch       = change.Branch
+    request.Commit_Id    = change.Current_Revision
+    request.Last_Updated = change.Updated
+    request.Bugs_Closed  = GerritBugs(
+                    change.Revisions[change.Current_Revision].Commit.Message)
+    request.Description  = GerritDescription(
+                    change.Revisions[change.Current_Revision].Commit.Message)
+    request.Absolute_Url = b.server.Config.Gerrit.Url + "/c/" +
+                           change.Project + "/+/" +
+                           strconv.Itoa(change.Number)
+
+    request.Links.Repository.Title = change.Project
+
+    // Each patch set has its own revision, so this changes whenever a new one
+    // is uploaded
+    request.Links.Latest_Diff.Href = "/changes/" +
+                                     strconv.Itoa(change.Number) +
+                                     "/revisions/" +
+                                     change.Current_Revision
+
+    return request, nil
+}
+
+func (b *GerritBackend) ListFiles(
+                        request reviewdata.ReviewRequest) ([]DiffFile, error) {
+    var files     map[string]GerritFile
+    var diffFiles []DiffFile
+    var paths     []string
+
+    err := GerritRequest(b.server,
+                         "GET",
+                         request.Links.Latest_Diff.Href + "/files/",
+                         nil,
+                         &files)
+
+    for path := range files {
+        // Skip magic files, such as the commit message
+        if (!strings.HasPrefix(path, "/")) {
+            paths = append(paths, path)
+        }
+    }
+
+    // Gerrit has no file IDs, so number the files in a stable order
+    sort.Strings(paths)
+
+    for i, path := range paths {
+        var diffFile DiffFile
+
+        diffFile.Id              = i + 1
+        diffFile.Filename        = path
+        diffFile.Links.Self.Href = request.Links.Latest_Diff.Href +
+                                   "/files/" +
+                                   url.PathEscape(path)
+
+        diffFiles = append(diffFiles, diffFile)
+    }
+
+    return diffFiles, err
+}
+
+func (b *GerritBackend) FetchFile(
+                            diffFile DiffFile) (reviewdata.FileDiff, error) {
+    var file reviewdata.FileDiff
+    var diff GerritDiff
+
+    file.Id       = diffFile.Id
+    file.Filename = diffFile.Filename
+
+    err := GerritRequest(b.server,
+                         "GET",
+                         diffFile.Links.Self.Href + "/diff?context=ALL",
+                         nil,
+                         &diff)
+
+    if (err != nil) {
+        return file, err
+    }
+
+    file.Diff_Data.Chunks = GerritChunks(diff)
+    file.Status           = gerritStatuses[diff.Change_Type]
+    file.Diff_Data.Binary = diff.Binary
+
+    // The original is the file as it was, under its old name, in the patch
+    // set's first parent
+    if (diff.Change_Type != "ADDED") {
+        original, err := GerritSend(b.server,
+                                    "GET",
+                                    path.Dir(diffFile.Links.Self.Href) + "/" +
+                                    url.PathEscape(diff.Meta_A.Name) +
+                                    "/content?parent=1",
+                                    nil)
+
+        if (err != nil) {
+            return file, err
+        }
+
+        file.OriginalFile, err = base64.StdEncoding.DecodeString(
+                                                        string(original))
+
+        if (err != nil) {
+            return file, err
+        }
+    }
+
+    // Deleted files have no content
+    if (diff.Change_Type == "DELETED") {
+        return file, nil
+    }
+
+    content, err := GerritSend(b.server,
+                               "GET",
+                               diffFile.Links.Self.Href + "/content",
+                               nil)
+
+    if (err != nil) {
+        return file, err
+    }
+
+    file.EntireFile, err = base64.StdEncoding.DecodeString(string(content))
+
+    return file, err
+}
+
+func (b *GerritBackend) StartReply(
+                            request reviewdata.ReviewRequest) (string, error) {
+    // Drafts are made against the patch set being reviewed
+    return request.Commit_Id, nil
+}
+
+/**
+ * Works out the range of a comment that spans several lines. The range runs
+ * from the start of the first line to the end of the last.
+ *
+ * @param file    The file being commented on.
+ * @param comment The comment.
+ *
+ * @returns The range, or nil if the comment is on a single line.
+ */
+func GerritRange(file    reviewdata.FileDiff,
+                 comment reviewdata.Comment) *GerritCommentRange {
+    if (comment.NumLines <= 1) {
+        return nil
+    }
+
+    commentRange := &GerritCommentRange{
+                        StartLine: comment.Line,
+                        EndLine:   comment.Line + comment.NumLines - 1}
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        for _, line := range chunk.Lines {
+            if (line.ReviewLine == commentRange.EndLine) {
+                commentRange.EndCharacter = utf8.RuneCountInString(
+                                                                line.RhText)
+            }
+        }
+    }
+
+    return commentRange
+}
+
+func (b *GerritBackend) PostComment(reviewId string,
+                                    replyId  string,
+                                    file     reviewdata.FileDiff,
+                                    comment  reviewdata.Comment) error {
+    input := GerritCommentInput{Path:       file.Filename,
+                                Line:       comment.Line,
+                                Range:      GerritRange(file, comment),
+                                Message:    comment.Text,
+                                Unresolved: comment.RaiseIssue}
+
+    // Ranged comments are shown against their last line
+    if (input.Range != nil) {
+        input.Line = input.Range.EndLine
+    }
+
+    return GerritRequest(b.server,
+                         "PUT",
+                         "/changes/" + reviewId +
+                         "/revisions/" + replyId + "/drafts",
+                         input,
+                         nil)
+}
+
+func (b *GerritBackend) Publish(reviewId string,
+                                replyId  string,
+                                verdict  Verdict) error {
+    review := GerritReviewInput{Message: verdict.Body,
+                                Drafts:  "PUBLISH",
+                                Notify:  "NONE",
+                                Tag:     "autogenerated:reviewbot"}
+
+    if (verdict.Footer != "") {
+        review.Message += "\n\n" + verdict.Footer
+    }
+
+    if (verdict.Notify) {
+        review.Notify = "OWNER"
+    }
+
+    if (b.server.Config.Gerrit.Label != "") {
+        var vote int = b.server.Config.Gerrit.PerfectVote
+
+        if (verdict.Commented) {
+            vote = b.server.Config.Gerrit.IssueVote
+        }
+
+        review.Labels = map[string]int{b.server.Config.Gerrit.Label: vote}
+    }
+
+    return GerritRequest(b.server,
+                         "POST",
+                         "/changes/" + reviewId +
+                         "/revisions/" + replyId + "/review",
+                         review,
+                         nil)
+}
+
+/**
+ * Resolves every unresolved comment thread that the bot had the last word in,
+ * by replying to it on the patch set on which it was made.
+ *
+ * @retval nil   If every thread was resolved.
+ * @retval error The last error that occurred, if any weren't.
+ */
+func (b *GerritBackend) ResolvePrevious(reviewId string) error {
+    var comments map[string][]GerritComment
+
+    err := GerritRequest(b.server,
+                         "GET",
+                         "/changes/" + reviewId + "/comments",
+                         nil,
+                         &comments)
+
+    if (err != nil) {
+        return errors.New("Could not retrieve comments on " + reviewId + ": " +
+                          err.Error())
+    }
+
+    // Threads whose last comment has been replied to aren't ours to resolve
+    repliedTo := make(map[string]bool)
+
+    for _, fileComments := range comments {
+        for _, comment := range fileComments {
+            repliedTo[comment.In_Reply_To] = true
+        }
+    }
+
+    replies := make(map[int]map[string][]GerritCommentInput)
+
+    for path, fileComments := range comments {
+        for _, comment := range fileComments {
+            if (!comment.Unresolved ||
+                repliedTo[comment.Id] ||
+                comment.Author.Username != b.server.Config.Gerrit.Username) {
+                continue
+            }
+
+            if (replies[comment.Patch_Set] == nil) {
+                replies[comment.Patch_Set] =
+                                    make(map[string][]GerritCommentInput)
+            }
+
+            replies[comment.Patch_Set][path] = append(
+                                replies[comment.Patch_Set][path],
+                                GerritCommentInput{
+                                    Line:       comment.Line,
+                                    InReplyTo:  comment.Id,
+                                    Message:    "Superseded by a newer review",
+                                    Unresolved: false})
+        }
+    }
+
+    var resolveErr error
+
+    for patchSet, patchSetReplies := range replies {
+        err = GerritRequest(b.server,
+                            "POST",
+                            "/changes/" + reviewId +
+                            "/revisions/" + strconv.Itoa(patchSet) +
+                            "/review",
+                            GerritReviewInput{
+                                Comments: patchSetReplies,
+                                Notify:   "NONE",
+                                Tag:      "autogenerated:reviewbot"},
+                            nil)
+
+        if (err != nil) {
+            resolveErr = errors.New("Could not resolve comments on " +
+                                    reviewId + ": " + err.Error())
+        }
+    }
+
+    fmt.Printf("Resolved previous comments on %s in %d patch sets\n",
+               reviewId,
+               len(replies))
+
+    return resolveErr
+}
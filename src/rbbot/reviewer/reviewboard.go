@@ -0,0 +1,677 @@
+/**
+ * The ReviewBoard backend.
+ */
+package reviewer
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "log"
+    "mime/multipart"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    "rbbot/db"
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * All of the files in a ReviewBoard diff.
+ */
+type DiffFileContainer struct {
+    Files []DiffFile
+}
+
+/**
+ * Ancillary data about a file that we pick up.
+ */
+type ReviewFileData struct {
+    File struct {
+        Dest_File       string
+        Status          string
+        Source_Revision string // PRE-CREATION for new files
+    }
+}
+
+/**
+ * The response from publishing a review. Used to pick up the ID.
+ */
+type ReviewResponse struct {
+    Review struct {
+        Id int
+    }
+}
+
+/**
+ * Wraps the review request that we receive.
+ */
+type ReviewContainer struct {
+    Stat           string
+    Review_Request reviewdata.ReviewRequest
+}
+
+/**
+ * The status that every ReviewBoard API response carries.
+ */
+type RbStat struct {
+    Stat string
+    Err  struct {
+        Code int
+        Msg  string
+    }
+}
+
+/**
+ * A key/value pair of strings.
+ */
+type KvString struct {
+    k string
+    v string
+}
+
+/**
+ * Reads a response from ReviewBoard, rejecting any that report failure.
+ *
+ * @param resp The response.
+ * @param link The resource that was requested.
+ * @param api  Whether the response is from the API, and so must carry a "stat"
+ *             of "ok". Raw files carry no stat.
+ *
+ * @retval []byte The response body.
+ * @retval error  If the request failed, or the response couldn't be read.
+ */
+func ReadResponse(resp *http.Response, link string, api bool) ([]byte, error) {
+    body, err := ioutil.ReadAll(resp.Body)
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    var stat RbStat
+    var msg  string
+
+    // Failures usually say why
+    if (json.Unmarshal(body, &stat) == nil && stat.Err.Msg != "") {
+        msg = ": " + stat.Err.Msg
+    }
+
+    if (resp.StatusCode < 200 || resp.StatusCode > 299) {
+        return nil, errors.New("ReviewBoard returned " + resp.Status +
+                               " for " + link + msg)
+    } else if (api && stat.Stat != "ok") {
+        return nil, errors.New("ReviewBoard did not return ok for " + link +
+                               msg)
+    }
+
+    return body, nil
+}
+
+/**
+ * Retrieves an object from the ReviewBoard API, and umarshalls it into the
+ * passed struct.
+ *
+ * @param server     The server from which the entity shall be retrieved.
+ * @param link       The link from which the entity shall be retrieved.
+ * @param entity     A pointer to a struct into which the received json shall be
+ *                   unmarshsalled.
+ * @param addKvStrings Any headers that should be added to the request, on top of
+ *                   the ReviewBoard API token.
+ *
+ * @retval nil   If no error occurred. The entity struct will have been
+ *               populated.
+ * @retval error If an error occurred. The entity struct will not have been
+ *               populated.
+ */
+func GetEntity(server        *Server,
+               link          string,
+               entity        interface{},
+               addKvStrings  []KvString) error {
+    req, err := http.NewRequest("GET", link, nil)
+
+    if (err != nil) {
+        return err
+    }
+
+    req.Header.Add("Authorization", server.Config.RbToken)
+
+    for _, header := range(addKvStrings) {
+        req.Header.Add(header.k, header.v)
+    }
+
+    resp, err := (&http.Client{}).Do(req)
+
+    if (err != nil) {
+        return err
+    }
+    defer resp.Body.Close()
+
+    body, err := ReadResponse(resp, link, true)
+
+    if (err != nil) {
+        return err
+    }
+
+    return json.Unmarshal(body, entity)
+}
+
+/**
+ * Retrieves a raw entity from a review, as an array of bytes. Error statuses
+ * fail, so that error pages aren't mistaken for the entity.
+ */
+func GetRawEntity(server *Server, link string) (error, []byte) {
+    req, err := http.NewRequest("GET", link, nil)
+
+    if (err != nil) {
+        return err, nil
+    }
+
+    req.Header.Add("Authorization", server.Config.RbToken)
+
+    resp, err := (&http.Client{}).Do(req)
+
+    if (err != nil) {
+        return err, nil
+    }
+    defer resp.Body.Close()
+
+    body, err := ReadResponse(resp, link, false)
+
+    return err, body
+}
+
+/**
+ * Sends a request to ReviewBoard.
+ *
+ * @param server     The server to which the request shall be sent.
+ * @param method     The rquest method.
+ * @param link       The resource to which data shall be sent.
+ * @param args       A list of key/value pairs to be added to the request.
+ * @param respEntity A pointer to a struct into which the response should be
+ *                   decoded. If this is nil, the response is not decoded.
+ *
+ * @retval nil   If the request was successful.
+ * @retval error The error that occurred, if the request was unsuccessful, or
+ *               if ReviewBoard reported that it failed.
+ */
+func SendRequest(server    *Server,
+                 method     string,
+                 link       string,
+                 args       []KvString,
+                 respEntity interface{}) error {
+    var b bytes.Buffer
+
+    w := multipart.NewWriter(&b)
+
+    for _, pair := range(args) {
+        fw, err := w.CreateFormField(pair.k)
+
+        if err != nil {
+            return err
+        }
+
+        _, err = fw.Write([]byte(pair.v))
+
+        if (err != nil) {
+            return err
+        }
+    }
+
+    w.Close()
+
+    req, err := http.NewRequest(method, link, &b)
+
+    if (err != nil) {
+        return err
+    }
+
+    req.Header.Add("Authorization", server.Config.RbToken)
+    req.Header.Set("Content-Type", w.FormDataContentType())
+
+    resp, err := (&http.Client{}).Do(req)
+
+    if (err != nil) {
+        return err
+    }
+    defer resp.Body.Close()
+
+    body, err := ReadResponse(resp, link, true)
+
+    if (err != nil || respEntity == nil) {
+        return err
+    }
+
+    return json.Unmarshal(body, respEntity)
+}
+
+/**
+ * Drops all open comments from a single review reply.
+ *
+ * @retval nil   If the reply's comments were retrieved. Any which could not
+ *               be dropped are logged.
+ * @retval error If the reply's comments could not be retrieved.
+ */
+func DropCommentsFromReply(server   *Server,
+                           reviewId  string,
+                           replyId   string) error {
+    type DiffCommentContainer struct {
+        Diff_Comments []struct {
+            Id           int
+            Issue_Opened bool
+            Issue_Status string
+            Links        struct {
+                Self struct {
+                    Href string
+                }
+            }
+        }
+    }
+
+    var link string = server.Config.RbApiUrl +
+                      "/review-requests/" +
+                      reviewId +
+                      "/reviews/" +
+                      replyId +
+                      "/diff-comments/"
+    var diffCommentContainer DiffCommentContainer
+
+    var allDropped   bool = false
+
+    for dropAttempts := 0; dropAttempts < 10; dropAttempts++ {
+        err := GetEntity(server, link, &diffCommentContainer, []KvString{})
+
+        if (err != nil) {
+            return errors.New("Could not retrieve diff comments: " +
+                              err.Error())
+        }
+
+        var toDropList []string
+
+        for _, comment := range(diffCommentContainer.Diff_Comments) {
+            if (comment.Issue_Opened && comment.Issue_Status == "open") {
+                // This issue is still open. Close it
+                toDropList = append(toDropList, comment.Links.Self.Href)
+            }
+        }
+
+        if (len(toDropList) == 0) {
+            allDropped = true
+            break
+        } else {
+            var wg sync.WaitGroup
+            wg.Add(len(toDropList))
+            fmt.Printf("There are %d comments to drop from review %s, " +
+                       "reply %s\n",
+                       len(toDropList),
+                       reviewId,
+                       replyId)
+
+            // Drop a max of 10 comments at once
+            throttleChan := make(chan bool, 10)
+
+            // Now we have a list of all comments to drop, drop them in parallel
+            for _, toDrop := range(toDropList) {
+                go func (toDropLink string) {
+                    // Before sending the request, add to the channel. This will
+                    // block if the channel is full
+                    throttleChan <- true
+
+                    err := SendRequest(server,
+                                       "PUT",
+                                       toDropLink,
+                                       []KvString{{k: "issue_status",
+                                                   v: "dropped"}},
+                                       nil)
+                    if (err != nil) {
+                        log.Printf("Error while dropping comments: %s\n",
+                                   err)
+                    }
+
+                    // Eat a value from the throttle channel to free up a space
+                    _ = <- throttleChan
+
+                    wg.Done()
+                }(toDrop)
+            }
+            wg.Wait()
+        }
+        // Sleep 1 second so that we don't hammer the server
+        time.Sleep(time.Second)
+    }
+
+    if (allDropped) {
+        fmt.Printf("All comments are dropped from review %s\n", reviewId)
+    } else {
+        log.Printf("Failed to drop all comments from review %s\n",
+                   reviewId)
+    }
+
+    return nil
+}
+
+/**
+ * Drops the comments from a review reply in the background, logging any
+ * failure.
+ */
+func DropCommentsInBackground(server *Server, reviewId string, replyId string) {
+    go func() {
+        err := DropCommentsFromReply(server, reviewId, replyId)
+
+        if (err != nil) {
+            log.Printf("Failed to drop comments from review %s, reply %s: " +
+                       "%s\n",
+                       reviewId,
+                       replyId,
+                       err)
+        }
+    }()
+}
+
+/**
+ * Drops previous review comments made by the bot. The comments themselves are
+ * dropped in the background.
+ *
+ * @retval nil   If the bot's previous replies were found.
+ * @retval error If they could not be.
+ */
+func DropPreviousComments(server *Server, reviewId string) error {
+    // If we've logged the last reply we made, drop the comments from that
+    lastReplyId, found := db.KvGet(DbKey(server, "LastReplyId_", reviewId))
+
+    if (found) {
+        // We don't need to do this synchronously
+        DropCommentsInBackground(server, reviewId, lastReplyId)
+    } else {
+        // This review was last reviewed by a previous version of the bot.
+        // Search the entire list of replies for any that it made
+        type ReplyContainer struct {
+            Reviews []struct {
+                Id    int
+                Links struct {
+                    User struct {
+                        Title string
+                    }
+                }
+            }
+        }
+
+        var replyContainer ReplyContainer
+        var url string = server.Config.RbApiUrl +
+                            "/review-requests/" +
+                            reviewId +
+                            "/reviews/"
+
+        err := GetEntity(server, url, &replyContainer, []KvString{})
+
+        if (err != nil) {
+            return errors.New("Could not retrieve review response list: " +
+                              err.Error())
+        }
+
+        for _, reply := range(replyContainer.Reviews) {
+            if (reply.Links.User.Title == server.Config.RbUsername) {
+                // This is one of ours
+                // We don't need to do this synchronously
+                DropCommentsInBackground(server,
+                                         reviewId,
+                                         strconv.Itoa(reply.Id))
+            }
+        }
+    }
+
+    return nil
+}
+
+
+/**
+ * Retrieves diffed files for a review
+ */
+func GetDiffedFiles(server *Server, link string) (error, DiffFileContainer) {
+    var diffFiles DiffFileContainer
+    err := GetEntity(server, link + "/files/", &diffFiles, []KvString{})
+    return err, diffFiles
+}
+
+/**
+ * Retrieves a single file diff from a review's file links.
+ */
+func GetFileDiff (server *Server,
+                  links   reviewdata.LinkContainer) (error, reviewdata.FileDiff) {
+    var file     reviewdata.FileDiff
+    var fileData ReviewFileData
+
+    err := GetEntity(server,
+                    links.Self.Href,
+                    &file,
+                    []KvString{
+                        {k: "Accept",
+                         v: "application/vnd.reviewboard.org.diff.data+json"}})
+
+    if err != nil {
+        return err, file
+    }
+
+    err = GetEntity(server, links.Self.Href, &fileData, []KvString{})
+
+    if ( err != nil) {
+        return err, file
+    }
+
+    err, entireFile := GetRawEntity(server, links.Patched_File.Href)
+
+    if ( err != nil) {
+        return err, file
+    }
+
+    file.Filename   = fileData.File.Dest_File
+    file.Status     = fileData.File.Status
+    file.EntireFile = entireFile
+
+    // ReviewBoard calls new files modified
+    if (fileData.File.Source_Revision == "PRE-CREATION") {
+        file.Status = reviewdata.FileAdded
+    } else {
+        err, file.OriginalFile = GetRawEntity(server,
+                                              links.Original_File.Href)
+    }
+
+    return err, file
+}
+
+/**
+ * Creates an empty review reply, to which comments can be attached.
+ *
+ * @param server   The server on which the review lives.
+ * @param reviewId The review ID.
+ *
+ * @retval string The ID of the review reply, as a string.
+ * @retval error  Any error that occurred while creating the reply.
+ */
+func CreateReviewReply (server *Server, reviewId string) (string, error) {
+    var reviewUrl string = server.Config.RbApiUrl +
+                           "/review-requests/" +
+                           reviewId +
+                           "/reviews/"
+
+    var reviewResponse ReviewResponse
+
+    err := SendRequest(server,
+                       "POST",
+                       reviewUrl,
+                       []KvString{{k: "body_top", v: "This is a test review"}},
+                       &reviewResponse)
+
+    if (err != nil) {
+        return "", err
+    }
+
+    var reviewResponseIdString string = strconv.Itoa(reviewResponse.Review.Id)
+
+    return reviewResponseIdString, nil
+}
+
+/**
+ * Retrieves a review request by its ID.
+ *
+ * @param server   The server on which the review lives.
+ * @param reviewId The review ID
+ *
+ * @retval Any error that occurred, and the review request.
+ */
+func GetReviewRequest(server   *Server,
+                      reviewId  string) (reviewdata.ReviewRequest, error) {
+    var url string = server.Config.RbApiUrl +
+                     "/review-requests/" +
+                     reviewId +
+                     "/"
+
+    var review ReviewContainer
+
+    err := GetEntity(server, url, &review, []KvString{})
+
+    return review.Review_Request, err
+}
+
+
+/**
+ * Adds a single diff comment to a review reply.
+ *
+ * @param server   The server on which the review lives.
+ * @param reviewId The ID of the review being done.
+ * @param replyId  The ID of the existing review reply.
+ * @param fileId   The ID of the file diff being commented on.
+ * @param comment  The comment.
+ *
+ * @retval nil   If the comment was added.
+ * @retval error The error that occurred, if it wasn't.
+ */
+func SendComment(server   *Server,
+                 reviewId  string,
+                 replyId   string,
+                 fileId    int,
+                 comment   reviewdata.Comment) error {
+    var reviewCommentUrl string = server.Config.RbApiUrl +
+                                  "/review-requests/" +
+                                  reviewId +
+                                  "/reviews/" +
+                                  replyId +
+                                  "/diff-comments/"
+
+    var fileIdStr  string = strconv.Itoa(fileId)
+    var firstLine  string = strconv.Itoa(comment.Line)
+    var numLines   string = strconv.Itoa(comment.NumLines)
+    var raiseIssue string = strconv.FormatBool(comment.RaiseIssue)
+
+    return SendRequest(server,
+                       "POST",
+                       reviewCommentUrl,
+                       []KvString{{k: "filediff_id",  v: fileIdStr},
+                                  {k: "first_line",   v: firstLine},
+                                  {k: "num_lines",    v: numLines},
+                                  {k: "text",         v: comment.Text},
+                                  {k: "text_type",    v: "markdown"},
+                                  {k: "issue_opened", v: raiseIssue}},
+                       nil)
+}
+
+/**
+ * Publishes a review reply, making it public and unmodifiable.
+ *
+ * @param server   The server on which the review lives.
+ * @param reviewId The ID of the review whose reply is being published.
+ * @param replyId  The ID of the reply being published.
+ * @param verdict  What to publish.
+ *
+ * @retval nil   On success.
+ * @retval error If an error occurred while publishing.
+ */
+func PublishReply(server   *Server,
+                  reviewId  string,
+                  replyId   string,
+                  verdict   Verdict) error {
+    var kvReq []KvString
+
+    kvReq = append(kvReq,
+                   KvString{k: "public",             v: "1"},
+                   KvString{k: "body_top",           v: verdict.Body},
+                   KvString{k: "body_top_text_type", v: "markdown"})
+
+    if (!verdict.Notify) {
+        kvReq = append(kvReq, KvString{k: "trivial", v: "true"})
+    }
+
+    if (verdict.Footer != "") {
+        kvReq = append(kvReq,
+                       KvString{k: "body_bottom",           v: verdict.Footer},
+                       KvString{k: "body_bottom_text_type", v: "markdown"})
+    }
+
+    var reviewUrl string = server.Config.RbApiUrl + "/review-requests/" +
+                           reviewId +
+                           "/reviews/" +
+                           replyId +
+                           "/"
+
+    return SendRequest(server, "PUT", reviewUrl, kvReq, nil)
+}
+
+/**
+ * Reviews for ReviewBoard, through its web API.
+ */
+type ReviewBoardBackend struct {
+    server *Server
+}
+
+func (b *ReviewBoardBackend) FetchRequest(
+                                reviewId string) (reviewdata.ReviewRequest,
+                                                  error) {
+    return GetReviewRequest(b.server, reviewId)
+}
+
+func (b *ReviewBoardBackend) ListFiles(
+                        request reviewdata.ReviewRequest) ([]DiffFile, error) {
+    err, diff := GetDiffedFiles(b.server, request.Links.Latest_Diff.Href)
+    return diff.Files, err
+}
+
+func (b *ReviewBoardBackend) FetchFile(
+                            diffFile DiffFile) (reviewdata.FileDiff, error) {
+    err, fileDiff := GetFileDiff(b.server, diffFile.Links)
+    fileDiff.Id = diffFile.Id
+    return fileDiff, err
+}
+
+func (b *ReviewBoardBackend) StartReply(
+                            request reviewdata.ReviewRequest) (string, error) {
+    replyId, err := CreateReviewReply(b.server, request.ReviewId)
+
+    if (err != nil) {
+        return "", err
+    }
+
+    // Save the reply ID in case we review this again
+    db.KvPut(DbKey(b.server, "LastReplyId_", request.ReviewId), replyId)
+
+    return replyId, nil
+}
+
+func (b *ReviewBoardBackend) PostComment(reviewId string,
+                                         replyId  string,
+                                         file     reviewdata.FileDiff,
+                                         comment  reviewdata.Comment) error {
+    return SendComment(b.server, reviewId, replyId, file.Id, comment)
+}
+
+func (b *ReviewBoardBackend) Publish(reviewId string,
+                                     replyId  string,
+                                     verdict  Verdict) error {
+    return PublishReply(b.server, reviewId, replyId, verdict)
+}
+
+func (b *ReviewBoardBackend) ResolvePrevious(reviewId string) error {
+    return DropPreviousComments(b.server, reviewId)
+}
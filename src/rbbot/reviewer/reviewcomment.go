@@ -4,23 +4,24 @@ import (
     "math/rand"
 )
 
-func GenerateTopComment(seenBefore   bool,
+func GenerateTopComment(server      *Server,
+                        seenBefore   bool,
                         requester    string,
                         commented    bool,
                         extraComment string) string {
     var comment string
 
     if (seenBefore) {
-        comment = config.Comments.Top.SeenBefore[rand.Intn(
-                                len(config.Comments.Top.SeenBefore))] + "\n\n"
+        comment = server.Config.Comments.Top.SeenBefore[rand.Intn(
+                                len(server.Config.Comments.Top.SeenBefore))] + "\n\n"
     } else {
-        comment = config.Comments.Top.NewReview[rand.Intn(
-                                len(config.Comments.Top.NewReview))] + "\n\n"
+        comment = server.Config.Comments.Top.NewReview[rand.Intn(
+                                len(server.Config.Comments.Top.NewReview))] + "\n\n"
     }
 
     if (!commented) {
-        comment += config.Comments.Top.PerfectReview[rand.Intn(
-                       len(config.Comments.Top.PerfectReview))] + "\n\n"
+        comment += server.Config.Comments.Top.PerfectReview[rand.Intn(
+                       len(server.Config.Comments.Top.PerfectReview))] + "\n\n"
 
     }
 
@@ -1,75 +1,22 @@
 package reviewer
 
 import (
-        "net/http"
         "log"
         "io/ioutil"
         "fmt"
         "encoding/json"
         "sync"
         "sync/atomic"
-        "bytes"
-        "mime/multipart"
         "strconv"
-        "regexp"
         "plugin"
         "errors"
-        "strings"
         "time"
 
         "rbbot/db"
+        "rbbot/events"
         "rbplugindata/reviewdata"
 )
 
-var (
-    config                     RbConfig
-    fileExclusionRegex        *regexp.Regexp
-    fileExclusionsSet          bool
-    reviewTitleExclusionRegex *regexp.Regexp
-    reviewTitleExclusionSet    bool
-)
-
-/**
- * A single file in ReviewBoard's diffs. Used to pick up links.
- */
-type DiffFile struct {
-    Id    int
-    Links reviewdata.LinkContainer
-}
-
-/**
- * All of the files in a review.
- */
-type DiffFileContainer struct {
-    Files []DiffFile
-}
-
-/**
- * Ancillary data about a file that we pick up.
- */
-type ReviewFileData struct {
-    File struct {
-        Dest_File string
-    }
-}
-
-/**
- * The response from publishing a review. Used to pick up the ID.
- */
-type ReviewResponse struct {
-    Review struct {
-        Id int
-    }
-}
-
-/**
- * Wraps the review request that we receive.
- */
-type ReviewContainer struct {
-    Stat           string
-    Review_Request reviewdata.ReviewRequest
-}
-
 /**
  * A ReviewerPlugin is something that provides the following functions.
  */
@@ -87,6 +34,15 @@ type ReviewerPlugin interface {
                                             // interface
 }
 
+/**
+ * A ReviewerPlugin may also provide the following function, in which case it
+ * is told which directory the config file is in before it is configured, so
+ * that it can find files that its config names relative to the config file.
+ */
+type ConfigDirUser interface {
+    UseConfigDir(string)
+}
+
 /**
  * When it reviews the review, a plugin can pass back an anonymous structure
  * which we pass back in for file reviews.
@@ -97,336 +53,12 @@ type ReviewPluginPassback struct {
 }
 
 /**
- * A key/value pair of strings.
- */
-type KvString struct {
-    k string
-    v string
-}
-
-/**
- * Retrieves an object from the ReviewBoard API, and umarshalls it into the
- * passed struct.
- *
- * @param link       The link from which the entity shall be retrieved.
- * @param entity     A pointer to a struct into which the received json shall be
- *                   unmarshsalled.
- * @param addKvStrings Any headers that should be added to the request, on top of
- *                   the ReviewBoard API token.
- *
- * @retval nil   If no error occurred. The entity struct will have been
- *               populated.
- * @retval error If an error occurred. The entity struct will not have been
- *               populated.
- */
-func GetEntity(link string, entity interface{}, addKvStrings []KvString) error {
-    req, err := http.NewRequest("GET", link, nil)
-
-    req.Header.Add("Authorization", config.RbToken)
-
-    for _, header := range(addKvStrings) {
-        req.Header.Add(header.k, header.v)
-    }
-
-    resp, err := (&http.Client{}).Do(req)
-
-    if (err != nil) {
-        return err
-    }
-    defer resp.Body.Close()
-
-    body, err := ioutil.ReadAll(resp.Body)
-
-    if (err != nil) {
-        return err
-    }
-
-    err = json.Unmarshal(body, entity)
-
-    if err != nil {
-        return err
-    }
-
-    return err
-}
-
-/**
- * Retrieves a raw entity from a review, as an array of bytes.
- */
-func GetRawEntity(link string) (error, []byte) {
-    req, err := http.NewRequest("GET", link, nil)
-
-    req.Header.Add("Authorization", config.RbToken)
-
-    resp, err := (&http.Client{}).Do(req)
-
-    if (err != nil) {
-        log.Fatal(err)
-    }
-    defer resp.Body.Close()
-
-    body, err := ioutil.ReadAll(resp.Body)
-
-    if (err != nil) {
-        log.Fatal(err)
-    }
-
-    return err, body
-}
-
-/**
- * Sends a request to ReviewBoard.
- *
- * @param method     The rquest method.
- * @param link       The resource to which data shall be sent.
- * @param args       A list of key/value pairs to be added to the request.
- * @param respEntity A pointer to a struct into which the response should be
- *                   decoded. If this is nil, the response is not decoded.
- *
- * @retval nil   If the request was successful.
- * @retval error The error that occurred, if the request was unsuccessful.
- */
-func SendRequest(method     string,
-                 link       string,
-                 args       []KvString,
-                 respEntity interface{}) error {
-    var b bytes.Buffer
-
-    w := multipart.NewWriter(&b)
-
-    for _, pair := range(args) {
-        fw, err := w.CreateFormField(pair.k)
-
-        if err != nil {
-            log.Fatal(err)
-        }
-
-        _, err = fw.Write([]byte(pair.v))
-
-        if (err != nil) {
-            log.Fatal(err)
-        }
-    }
-
-    w.Close()
-
-    req, err := http.NewRequest(method, link, &b)
-
-    req.Header.Add("Authorization", config.RbToken)
-    req.Header.Set("Content-Type", w.FormDataContentType())
-
-    resp, err := (&http.Client{}).Do(req)
-
-    if (err != nil) {
-        log.Fatal(err)
-    }
-    defer resp.Body.Close()
-
-    if (respEntity != nil) {
-        body, _ := ioutil.ReadAll(resp.Body)
-
-        err = json.Unmarshal(body, respEntity)
-
-        if err != nil {
-            log.Fatal(err)
-        }
-    }
-
-    return err
-}
-
-/**
- * Drops all open comments from a single review reply.
- */
-func DropCommentsFromReply(reviewId string, replyId string) {
-    type DiffCommentContainer struct {
-        Diff_Comments []struct {
-            Id           int
-            Issue_Opened bool
-            Issue_Status string
-            Links        struct {
-                Self struct {
-                    Href string
-                }
-            }
-        }
-    }
-
-    var link string = config.RbApiUrl +
-                      "/review-requests/" +
-                      reviewId +
-                      "/reviews/" +
-                      replyId +
-                      "/diff-comments/"
-    var diffCommentContainer DiffCommentContainer
-
-    var allDropped   bool = false
-
-    for dropAttempts := 0; dropAttempts < 10; dropAttempts++ {
-        err := GetEntity(link, &diffCommentContainer, []KvString{})
-
-        if (err != nil) {
-            log.Fatal("Could not retrieve diff comments: " + err.Error())
-        }
-
-        var toDropList []string
-
-        for _, comment := range(diffCommentContainer.Diff_Comments) {
-            if (comment.Issue_Opened && comment.Issue_Status == "open") {
-                // This issue is still open. Close it
-                toDropList = append(toDropList, comment.Links.Self.Href)
-            }
-        }
-
-        if (len(toDropList) == 0) {
-            allDropped = true
-            break
-        } else {
-            var wg sync.WaitGroup
-            wg.Add(len(toDropList))
-            fmt.Printf("There are %d comments to drop from review %s, " +
-                       "reply %s\n",
-                       len(toDropList),
-                       reviewId,
-                       replyId)
-
-            // Drop a max of 10 comments at once
-            throttleChan := make(chan bool, 10)
-
-            // Now we have a list of all comments to drop, drop them in parallel
-            for _, toDrop := range(toDropList) {
-                go func (toDropLink string) {
-                    // Before sending the request, add to the channel. This will
-                    // block if the channel is full
-                    throttleChan <- true
-
-                    err = SendRequest("PUT",
-                                      toDropLink,
-                                      []KvString{{k: "issue_status",
-                                                  v: "dropped"}},
-                                      nil)
-                    if (err != nil) {
-                        log.Fatal("Error while dropping comments: " +
-                                  err.Error())
-                    }
-
-                    // Eat a value from the throttle channel to free up a space
-                    _ = <- throttleChan
-
-                    wg.Done()
-                }(toDrop)
-            }
-            wg.Wait()
-        }
-        // Sleep 1 second so that we don't hammer the server
-        time.Sleep(time.Second)
-    }
-
-    if (allDropped) {
-        fmt.Printf("All comments are dropped from review %s\n", reviewId)
-    } else {
-        log.Printf("Failed to drop all comments from review %s\n",
-                   reviewId)
-    }
-}
-
-/**
- * Drops previous review comments made by the bot. Returns when all comments are
- * dropped.
- */
-func DropPreviousComments(reviewId string) {
-    // If we've logged the last reply we made, drop the comments from that
-    lastReplyId, found := db.KvGet("LastReplyId_" + reviewId)
-
-    if (found) {
-        // We don't need to do this synchronously
-        go DropCommentsFromReply(reviewId, lastReplyId)
-    } else {
-        // This review was last reviewed by a previous version of the bot.
-        // Search the entire list of replies for any that it made
-        type ReplyContainer struct {
-            Reviews []struct {
-                Id    int
-                Links struct {
-                    User struct {
-                        Title string
-                    }
-                }
-            }
-        }
-
-        var replyContainer ReplyContainer
-        var url string = config.RbApiUrl +
-                            "/review-requests/" +
-                            reviewId +
-                            "/reviews/"
-
-        err := GetEntity(url, &replyContainer, []KvString{})
-
-        if (err != nil) {
-            log.Fatal("Could not retrieve review response list: " + err.Error())
-        }
-
-        for _, reply := range(replyContainer.Reviews) {
-            if (reply.Links.User.Title == config.RbUsername) {
-                // This is one of ours
-                // We don't need to do this synchronously
-                go DropCommentsFromReply(reviewId, strconv.Itoa(reply.Id))
-            }
-        }
-    }
-}
-
-
-/**
- * Retrieves diffed files for a review
- */
-func GetDiffedFiles(link string) (error, DiffFileContainer) {
-    var diffFiles DiffFileContainer
-    err := GetEntity(link + "/files/", &diffFiles, []KvString{})
-    return err, diffFiles
-}
-
-/**
- * Retrieves a single file diff from a review's file links.
- */
-func GetFileDiff (links reviewdata.LinkContainer) (error, reviewdata.FileDiff) {
-    var file     reviewdata.FileDiff
-    var fileData ReviewFileData
-
-    err := GetEntity(links.Self.Href,
-                    &file,
-                    []KvString{
-                        {k: "Accept",
-                         v: "application/vnd.reviewboard.org.diff.data+json"}})
-
-    if err != nil {
-        log.Fatal(err)
-    }
-
-    err = GetEntity(links.Self.Href, &fileData, []KvString{})
-
-    if ( err != nil) {
-        log.Fatal(err)
-    }
-
-    err, entireFile := GetRawEntity(links.Patched_File.Href)
-
-    if ( err != nil) {
-        log.Fatal(err)
-    }
-
-    file.Filename   = fileData.File.Dest_File
-    file.EntireFile = entireFile
-
-    return err, file
-}
-
-/**
- * Manages and collates comments.
+ * Manages and collates comments. Every comment is also added, as it was
+ * received, to a list of raw comments.
  */
 func ManageComments(inChan <- chan reviewdata.Comment,
                     outComments *reviewdata.CommentedFile,
+                    rawComments *[]reviewdata.Comment,
                     wg          *sync.WaitGroup) {
     for {
         comment, ok := <- inChan
@@ -438,6 +70,8 @@ func ManageComments(inChan <- chan reviewdata.Comment,
             break
         }
 
+        *rawComments = append(*rawComments, comment)
+
         // TODO: HACK - set 0-length comments to 1
         if (comment.NumLines == 0) {
             comment.NumLines = 1
@@ -475,13 +109,62 @@ func ManageComments(inChan <- chan reviewdata.Comment,
     }
 }
 
+/**
+ * Runs a single checker plugin on a file, and publishes an event once the
+ * plugin reports that it is done. The plugin's comments are marked as its own
+ * on their way to the comment manager.
+ */
+func RunChecker(server      *Server,
+                file         reviewdata.FileDiff,
+                reviewIdStr  string,
+                passback     ReviewPluginPassback,
+                comments     chan <- reviewdata.Comment,
+                wg          *sync.WaitGroup) {
+    timer := time.Now()
+
+    var pluginWg    sync.WaitGroup
+    var forwarderWg sync.WaitGroup
+    var pluginName  string = passback.Plugin.CanonicalName()
+
+    pluginComments := make(chan reviewdata.Comment)
+
+    pluginWg.Add(1)
+    forwarderWg.Add(1)
+
+    go func() {
+        for comment := range pluginComments {
+            comment.Plugin = pluginName
+            comments <- comment
+        }
+        forwarderWg.Done()
+    }()
+
+    passback.Plugin.Check(file, passback.Passback, pluginComments, &pluginWg)
+
+    pluginWg.Wait()
+
+    close(pluginComments)
+    forwarderWg.Wait()
+
+    events.Publish(reviewdata.EventPluginFinished,
+                   server.Name,
+                   reviewIdStr,
+                   map[string]string{"plugin":   pluginName,
+                                     "file":     file.Filename,
+                                     "duration": time.Since(timer).String()})
+
+    wg.Done()
+}
+
 /**
  * Runs all of the checkers on a single file, and collates comments.
  */
-func CheckFileAndComment(file            reviewdata.FileDiff,
+func CheckFileAndComment(server         *Server,
+                         file            reviewdata.FileDiff,
                          reviewIdStr     string,
                          responseIdStr   string,
                          commentCount   *int32,
+                         findings       *ReviewFindings,
                          wg             *sync.WaitGroup,
                          reviewPlugins  []ReviewPluginPassback) {
     timer := time.Now()
@@ -495,22 +178,31 @@ func CheckFileAndComment(file            reviewdata.FileDiff,
 
     var checkerGroup  sync.WaitGroup
     var commentedFile reviewdata.CommentedFile
+    var rawComments   []reviewdata.Comment
 
     comments := make(chan reviewdata.Comment)
 
     commentedFile.FileId   = file.Id
     commentedFile.Comments = make(map[int][]*reviewdata.Comment)
 
-    checkerGroup.Add(numCheckers)
-
-    go ManageComments(comments, &commentedFile, &commentMgrWg)
+    go ManageComments(comments, &commentedFile, &rawComments, &commentMgrWg)
 
-    //Run the checkers
-    for i := 0; i < numCheckers; i++ {
-        go reviewPlugins[i].Plugin.Check(file,
-                                         reviewPlugins[i].Passback,
-                                         comments,
-                                         &checkerGroup)
+    if (server.Classifier.Policy(file.Class) == PolicyCommentOnce) {
+        // Say what the file is, rather than have every plugin comment on it
+        checkerGroup.Add(1)
+        go server.Classifier.CommentOnce(file, comments, &checkerGroup)
+    } else {
+        //Run the checkers
+        checkerGroup.Add(numCheckers)
+
+        for i := 0; i < numCheckers; i++ {
+            go RunChecker(server,
+                          file,
+                          reviewIdStr,
+                          reviewPlugins[i],
+                          comments,
+                          &checkerGroup)
+        }
     }
 
     // Wait for them all to complete
@@ -523,6 +215,8 @@ func CheckFileAndComment(file            reviewdata.FileDiff,
 
     commentMgrWg.Wait()
 
+    AddFindings(findings, file, rawComments)
+
     fmt.Printf("Running checkers took: %s\n", time.Since(timer))
 
     // If there are comments on the file, add them to the review
@@ -534,12 +228,12 @@ func CheckFileAndComment(file            reviewdata.FileDiff,
                                          int32(len(commentedFile.Comments)))
 
         // The number of comments we're allowed to make is:
-        allowedComments := config.Comments.MaxComments -
+        allowedComments := server.Config.Comments.MaxComments -
                            (int(totalComments) -
                             len(commentedFile.Comments))
 
         log.Printf("We are allowed %d max comments on %d\n",
-                   config.Comments.MaxComments,
+                   server.Config.Comments.MaxComments,
                    commentedFile.FileId)
         log.Printf("We have left %d comments on %d\n",
                    (int(totalComments) - len(commentedFile.Comments)),
@@ -550,8 +244,10 @@ func CheckFileAndComment(file            reviewdata.FileDiff,
 
         // If we've some comment budget left, send the all of the comments
         if (allowedComments > 0) {
-            SendFileComments(reviewIdStr,
+            SendFileComments(server,
+                             reviewIdStr,
                              responseIdStr,
+                             file,
                              commentedFile,
                              allowedComments)
         }
@@ -562,22 +258,42 @@ func CheckFileAndComment(file            reviewdata.FileDiff,
 
 /**
  * Runs all of the checker plugins, and submits comments to the review. Returns
- * the number of comments made, and a general review comment.
+ * the number of comments made, a general review comment, and every finding
+ * (most severe first).
  */
-func RunCheckersAndComment(reviewIdStr    string,
+func RunCheckersAndComment(server        *Server,
+                           reviewIdStr    string,
                            responseIdStr  string,
                            reviewRequest  reviewdata.ReviewRequest,
-                           files         *[]reviewdata.FileDiff,
-                           reviewPlugins  []ReviewerPlugin) (int, string) {
+                           files         *[]reviewdata.FileDiff) (
+                                                    int,
+                                                    string,
+                                                    []reviewdata.Finding) {
+    var reviewPlugins []ReviewerPlugin = server.Plugins
     var fileCheckWaitGroup sync.WaitGroup
     var commentsMade       int32 = 0
+    var findings           ReviewFindings
 
     fileCheckWaitGroup.Add(len(*files))
 
     var reviewCommentChan chan string = make(chan string, len(reviewPlugins))
+    var reviewComments    []string
+    var commentsCollected = make(chan bool)
+
+    // Plugins may push any number of review comments, so collect them as they
+    // come, rather than relying on the channel's buffer
+    go func() {
+        for comment := range reviewCommentChan {
+            reviewComments = append(reviewComments, comment)
+        }
+
+        commentsCollected <- true
+    }()
 
     var pluginPassbacks []ReviewPluginPassback
 
+    reviewRequest.Files = *files
+
     for i := 0; i < len(reviewPlugins); i++ {
         // Check the review synchronously, allowing the plugin to pass back
         // something that we give to its file checks
@@ -588,11 +304,16 @@ func RunCheckersAndComment(reviewIdStr    string,
         pluginPassbacks = append(pluginPassbacks, passback)
     }
 
+    close(reviewCommentChan)
+    <-commentsCollected
+
     for i := 0; i < len(*files); i++ {
-        go CheckFileAndComment((*files)[i],
+        go CheckFileAndComment(server,
+                               (*files)[i],
                                reviewIdStr,
                                responseIdStr,
                                &commentsMade,
+                               &findings,
                                &fileCheckWaitGroup,
                                pluginPassbacks)
     }
@@ -602,63 +323,41 @@ func RunCheckersAndComment(reviewIdStr    string,
 
     var generalComment string = ""
 
-    for i := 0; i < len(reviewCommentChan); i++ {
-        generalComment += <-reviewCommentChan + "\n"
+    for _, comment := range reviewComments {
+        generalComment += comment + "\n"
     }
 
     commentsGenerated := int(atomic.LoadInt32(&commentsMade))
 
-    if (commentsGenerated > config.Comments.MaxComments) {
-        generalComment += "\n" + config.Comments.MaxCommentComment + "\n"
+    if (commentsGenerated > server.Config.Comments.MaxComments) {
+        generalComment += "\n" + server.Config.Comments.MaxCommentComment +
+                          "\n"
     }
 
-    return commentsGenerated, generalComment
-}
+    SortFindings(findings.Findings)
 
-/**
- * Creates an empty review reply, to which comments can be attached.
- *
- * @param reviewId The review ID.
- *
- * @retval string The ID of the review reply, as a string.
- */
-func CreateReviewReply (reviewId string) string {
-    var reviewUrl string = config.RbApiUrl +
-                           "/review-requests/" +
-                           reviewId +
-                           "/reviews/"
-
-    var reviewResponse ReviewResponse
-
-    err := SendRequest("POST",
-                       reviewUrl,
-                       []KvString{{k: "body_top", v: "This is a test review"}},
-                       &reviewResponse)
-
-    if (err != nil) {
-        log.Fatal(err)
-    }
-
-    var reviewResponseIdString string = strconv.Itoa(reviewResponse.Review.Id)
-
-    return reviewResponseIdString
+    return commentsGenerated, generalComment, findings.Findings
 }
 
 /**
  * Sends all comments for a single file, adding them to an existing review
- * response.
+ * reply.
  *
- * @param reviewId               The ID of the review being done.
- * @param reviewResponseIdString The ID of the existing review response.
- * @param comments               A CommentedFile containing all of the comments
- *                               for the file.
- * @param allowedComments        The maximum number of comments we are allowed
- *                               to send.
+ * @param server          The server on which the review lives.
+ * @param reviewId        The ID of the review being done.
+ * @param replyId         The ID of the existing review reply.
+ * @param file            The file being commented on.
+ * @param comments        A CommentedFile containing all of the comments for
+ *                        the file.
+ * @param allowedComments The maximum number of comments we are allowed to
+ *                        send.
  */
-func SendFileComments(reviewId               string,
-                      reviewResponseIdString string,
-                      comments               reviewdata.CommentedFile,
-                      allowedComments        int) {
+func SendFileComments(server          *Server,
+                      reviewId         string,
+                      replyId          string,
+                      file             reviewdata.FileDiff,
+                      comments         reviewdata.CommentedFile,
+                      allowedComments  int) {
 
     var commentsMade int = 0
 
@@ -672,123 +371,119 @@ func SendFileComments(reviewId               string,
                     break
                 }
 
-                var commentsId  string = strconv.Itoa(comments.FileId)
-                var commentLine string = strconv.Itoa(line)
-                var numLines    string = strconv.Itoa(comment.NumLines)
-                var raiseIssue  string
+                comment.Line = line
 
-                if (comment.RaiseIssue) {
-                    raiseIssue = "true"
-                } else {
-                    raiseIssue = "false"
+                err := server.Backend.PostComment(reviewId,
+                                                  replyId,
+                                                  file,
+                                                  *comment)
+
+                if (err != nil) {
+                    fmt.Printf("Could not comment on %s: %s\n",
+                               file.Filename,
+                               err)
+                    continue
                 }
 
-                var reviewCommentUrl string = config.RbApiUrl +
-                                              "/review-requests/" +
-                                              reviewId +
-                                              "/reviews/" +
-                                              reviewResponseIdString +
-                                              "/diff-comments/"
-
-                SendRequest("POST",
-                            reviewCommentUrl,
-                            []KvString{{k: "filediff_id",  v: commentsId},
-                                       {k: "first_line",   v: commentLine},
-                                       {k: "num_lines",    v: numLines},
-                                       {k: "text",         v: comment.Text},
-                                       {k: "text_type",    v: "markdown"},
-                                       {k: "issue_opened", v: raiseIssue}},
-                            nil)
                 commentsMade++
+
+                events.Publish(reviewdata.EventCommentPosted,
+                               server.Name,
+                               reviewId,
+                               map[string]string{
+                                   "file":      strconv.Itoa(comments.FileId),
+                                   "line":      strconv.Itoa(line),
+                                   "num_lines": strconv.Itoa(comment.NumLines),
+                                   "issue":     strconv.FormatBool(
+                                                        comment.RaiseIssue)})
             }
         }
     }
 }
 
 /**
- * Publishes a review response, making it public and unmodifiable.
+ * Publishes a review reply, making it public and unmodifiable.
  *
- * @param reviewId      The ID of the review whose response is being published.
- * @param responseIdStr The ID of the response being published.
- * @param requester     The name of the entity that requested the review.
- * @param commented     Whether any checkers made comments.
- * @param extraComment  A comment from any checkers which did not relate to
- *                      files.
- * @param seenBefore    Whether we've seen this review before.
+ * @param server       The server on which the review lives.
+ * @param reviewId     The ID of the review whose reply is being published.
+ * @param replyId      The ID of the reply being published.
+ * @param requester    The name of the entity that requested the review.
+ * @param commented    Whether any checkers made comments.
+ * @param extraComment A comment from any checkers which did not relate to
+ *                     files.
+ * @param seenBefore   Whether we've seen this review before.
  *
  * @retval nil   On success.
  * @retval error If an error occurred while publishing.
  */
-func PublishReview(reviewId      string,
-                   responseIdStr string,
+func PublishReview(server       *Server,
+                   reviewId      string,
+                   replyId       string,
                    requester     string,
                    commented     bool,
                    extraComment  string,
                    seenBefore    bool) error {
+    var verdict Verdict
 
-    var topComment string = GenerateTopComment(seenBefore,
-                                               requester,
-                                               commented,
-                                               extraComment)
-
-    var kvReq []KvString
+    verdict.Body = GenerateTopComment(server,
+                                      seenBefore,
+                                      requester,
+                                      commented,
+                                      extraComment)
+    verdict.Commented = commented
+    verdict.Notify    = (server.Config.EmailOnPerfect || commented)
 
-    kvReq = append(kvReq,
-                   KvString{k: "public",             v: "1"},
-                   KvString{k: "body_top",           v: topComment},
-                   KvString{k: "body_top_text_type", v: "markdown"})
-
-    if (!config.EmailOnPerfect && !commented) {
-        kvReq = append(kvReq, KvString{k: "trivial", v: "true"})
+    if (!seenBefore) {
+        verdict.Footer = server.Config.Comments.Bottom.NewReview
     }
 
-    if (!seenBefore && config.Comments.Bottom.NewReview != "") {
-        var bottomComment string = config.Comments.Bottom.NewReview
-        kvReq = append(kvReq,
-                       KvString{k: "body_bottom",           v: bottomComment},
-                       KvString{k: "body_bottom_text_type", v: "markdown"})
-    }
-
-    var reviewUrl string = config.RbApiUrl + "/review-requests/" +
-                           reviewId +
-                           "/reviews/" +
-                           responseIdStr +
-                           "/"
-
-    err := SendRequest("PUT",
-                       reviewUrl,
-                       kvReq,
-                       nil)
-    return err
+    return server.Backend.Publish(reviewId, replyId, verdict)
 }
 
 /**
- * Retrieves a review request by its ID.
- *
- * @param reviewId The review ID
+ * Performs a review.
  *
- * @retval Any error that occurred, and the review request.
+ * @param incomingReq The incoming review request.
  */
-func GetReviewRequest(reviewId string) (reviewdata.ReviewRequest, error) {
-    var url string = config.RbApiUrl + "/review-requests/" + reviewId + "/"
+func DoReview(incomingReq reviewdata.ReviewRequest) {
+    reviewId := incomingReq.ReviewId
+    fmt.Println("Received review request for: " + reviewId)
 
-    var review ReviewContainer
+    server, found := GetServer(incomingReq.Server)
 
-    err := GetEntity(url, &review, []KvString{})
+    if (!found) {
+        fmt.Printf("Review %s is for unknown server '%s'\n",
+                   reviewId,
+                   incomingReq.Server)
 
-    return review.Review_Request, err
-}
+        events.Publish(reviewdata.EventFailed,
+                       incomingReq.Server,
+                       reviewId,
+                       map[string]string{"reason": "unknown server"})
 
-/**
- * Performs a review.
- *
- * @param incomingReq   The incoming review request.
- * @param reviewPlugins A list of plugins that should be run against the review.
- */
-func DoReview(incomingReq   reviewdata.ReviewRequest,
-              reviewPlugins []ReviewerPlugin) {
-    reviewId := incomingReq.ReviewId
-    fmt.Println("Received review request for: " + reviewId)
+        incomingReq.ResultChan <- reviewdata.ReviewResult{}
+        return
+    }
+
+    // A stale-only request is a re-review, which is only done if we last
+    // reviewed with a different set of plugins
+    if (incomingReq.StaleOnly) {
+        if reason := StaleReason(server, reviewId); (reason != "") {
+            fmt.Printf("Not re-reviewing %s: %s\n", reviewId, reason)
+
+            events.Publish(reviewdata.EventSkipped,
+                           server.Name,
+                           reviewId,
+                           map[string]string{"reason": reason})
+
+            incomingReq.ResultChan <- reviewdata.ReviewResult{}
+            return
+        }
+
+        incomingReq.Force = true
+    }
+
+    events.Publish(reviewdata.EventStarted, server.Name, reviewId, nil)
 
     timer := time.Now()
     totalTime := time.Now()
@@ -799,20 +494,27 @@ func DoReview(incomingReq   reviewdata.ReviewRequest,
 
     // If we've not already filled in the request, do that
     if (incomingReq.Id == 0) {
-        populatedRequest, err = GetReviewRequest(reviewId)
+        populatedRequest, err = server.Backend.FetchRequest(reviewId)
 
         populatedRequest.ResultChan = incomingReq.ResultChan
         populatedRequest.Force      = incomingReq.Force
+        populatedRequest.Server     = incomingReq.Server
+        populatedRequest.ReviewId   = incomingReq.ReviewId
 
         if (err != nil) {
             // Something went wrong loading the review
             fmt.Println("Failed to process review")
             fmt.Println(err)
+
+            events.Publish(reviewdata.EventFailed,
+                           server.Name,
+                           reviewId,
+                           map[string]string{"reason": err.Error()})
         }
     }
 
     // Check if we've seen this diff before
-    lastSeenDiff, found := db.KvGet("RLD" + reviewId)
+    lastSeenDiff, found := db.KvGet(DbKey(server, "RLD", reviewId))
 
     if (populatedRequest.Id != 0) {
         if (found &&
@@ -820,59 +522,101 @@ func DoReview(incomingReq   reviewdata.ReviewRequest,
             lastSeenDiff == populatedRequest.Links.Latest_Diff.Href) {
             // We've already reviewed this before, ignore
             fmt.Println("Ignoring already-seen diff for review " + reviewId)
-        } else if (reviewTitleExclusionSet &&
+
+            events.Publish(reviewdata.EventSkipped,
+                           server.Name,
+                           reviewId,
+                           map[string]string{"reason": "already-seen"})
+        } else if (server.reviewTitleExclusionSet &&
                    populatedRequest.Force == false &&
-                   reviewTitleExclusionRegex.MatchString(
+                   server.reviewTitleExclusionRegex.MatchString(
                                                         populatedRequest.Summary)) {
             // We've excluded this review by title
             fmt.Println("Ignoring review by title: " + populatedRequest.Summary)
+
+            events.Publish(reviewdata.EventSkipped,
+                           server.Name,
+                           reviewId,
+                           map[string]string{"reason": "title-excluded"})
         } else {
             // If we found a latest diff URL, we've seen this review before
             populatedRequest.SeenBefore = found
 
             // Store the fact that we've now seen this diff
-            db.KvPut("RLD" + reviewId, populatedRequest.Links.Latest_Diff.Href)
+            db.KvPut(DbKey(server, "RLD", reviewId),
+                     populatedRequest.Links.Latest_Diff.Href)
 
             // If configured to do so, drop all of our previous comments
-            if (config.Comments.DropPreviousComments &&
+            if (server.Config.Comments.DropPreviousComments &&
                 populatedRequest.SeenBefore) {
 
                 timer = time.Now()
-                DropPreviousComments(reviewId)
+
+                // Previous comments are best-effort; review regardless
+                err = server.Backend.ResolvePrevious(reviewId)
+
+                if (err != nil) {
+                    fmt.Printf("Could not drop previous comments on %s: %s\n",
+                               reviewId,
+                               err)
+                }
+
                 fmt.Printf("Dropping previous comments took %s\n",
                            time.Since(timer))
                 timer = time.Now()
             }
 
             // Pick up the review's diffs
-            err, diff := GetDiffedFiles(populatedRequest.Links.Latest_Diff.Href)
+            diff, err := server.Backend.ListFiles(populatedRequest)
 
             var diffFiles    []reviewdata.FileDiff
+            var skippedFiles []reviewdata.FileDiff
 
             if (err != nil) {
                 // Can't retrieve any files, skip this review
                 fmt.Printf("Could not find any files: %s\n", err)
+
+                events.Publish(reviewdata.EventFailed,
+                               server.Name,
+                               reviewId,
+                               map[string]string{"reason": err.Error()})
             } else {
                 // We retrieve the files in parallel (up to x at a time), and
                 // need to mutex the list addition because slice appending is
                 // not goroutine-safe
                 var fileWaiter    sync.WaitGroup
                 var fileListMutex sync.Mutex
-                throttleChan := make(chan bool, config.ConcurrentFileDownloads)
-                fileWaiter.Add(len(diff.Files))
+                throttleChan := make(chan bool,
+                                     server.Config.ConcurrentFileDownloads)
+                fileWaiter.Add(len(diff))
 
-                for _, passToFunc := range diff.Files {
+                for _, passToFunc := range diff {
                     go func (diffFile DiffFile) {
                         // Before retrieving the file, add to the channel. This
                         // will block if the channel is full
                         throttleChan <- true
 
-                        _, fileDiff := GetFileDiff(diffFile.Links)
+                        fileDiff, err := server.Backend.FetchFile(diffFile)
+
+                        if (err != nil) {
+                            fmt.Printf("Could not retrieve file %d: %s\n",
+                                       diffFile.Id,
+                                       err)
+                        } else if (!server.fileExclusionsSet ||
+                                   !server.fileExclusionRegex.MatchString(
+                                                    fileDiff.Filename)) {
+                            fileDiff.Class =
+                                    server.Classifier.Classify(fileDiff)
 
-                        if (!fileExclusionRegex.MatchString(fileDiff.Filename)) {
-                            fileDiff.Id = diffFile.Id
+                            // Files skipped by their class aren't reviewed,
+                            // but plugins may still want to know about them
                             fileListMutex.Lock()
-                                diffFiles   = append(diffFiles, fileDiff)
+                            if (server.Classifier.Policy(fileDiff.Class) !=
+                                                    PolicySkip) {
+                                diffFiles    = append(diffFiles, fileDiff)
+                            } else {
+                                skippedFiles = append(skippedFiles, fileDiff)
+                            }
                             fileListMutex.Unlock()
                         }
                         fileWaiter.Done()
@@ -889,41 +633,92 @@ func DoReview(incomingReq   reviewdata.ReviewRequest,
                 fmt.Printf("Retrieving the review took %s\n", time.Since(timer))
                 timer = time.Now()
 
+                events.Publish(reviewdata.EventFilesFetched,
+                               server.Name,
+                               reviewId,
+                               map[string]string{
+                                   "files":    strconv.Itoa(len(diffFiles)),
+                                   "excluded": strconv.Itoa(len(diff) -
+                                                            len(diffFiles))})
+
                 // Create the review reply before processing anything, so we can populate it
                 // with comments in parallel
-                var responseIdStr = CreateReviewReply(reviewId)
-
-                // Save the reply ID in case we review this again
-                db.KvPut("LastReplyId_" + reviewId, responseIdStr)
-
-                fmt.Printf("Making the reply took %s\n", time.Since(timer))
-                timer = time.Now()
-
-                // Comment on the files
-                commentsMade, extraComment := RunCheckersAndComment(reviewId,
-                                                                    responseIdStr,
-                                                                    populatedRequest,
-                                                                    &diffFiles,
-                                                                    reviewPlugins)
-                fmt.Printf("Commenting took %s\n", time.Since(timer))
-                timer = time.Now()
+                responseIdStr, err := server.Backend.StartReply(
+                                                            populatedRequest)
+
+                if (err != nil) {
+                    fmt.Printf("Could not start a reply to %s: %s\n",
+                               reviewId,
+                               err)
+
+                    events.Publish(reviewdata.EventFailed,
+                                   server.Name,
+                                   reviewId,
+                                   map[string]string{"reason": err.Error()})
+                } else {
+                    fmt.Printf("Making the reply took %s\n", time.Since(timer))
+                    timer = time.Now()
+
+                    // Comment on the files
+                    var extraComment string
+                    var findings     []reviewdata.Finding
+
+                    populatedRequest.SkippedFiles = skippedFiles
+
+                    commentsMade, extraComment, findings =
+                                    RunCheckersAndComment(server,
+                                                          reviewId,
+                                                          responseIdStr,
+                                                          populatedRequest,
+                                                          &diffFiles)
+                    fmt.Printf("Commenting took %s\n", time.Since(timer))
+                    timer = time.Now()
+
+                    err = PublishReview(server,
+                                        reviewId,
+                                        responseIdStr,
+                                        populatedRequest.Requester,
+                                        (commentsMade > 0),
+                                        extraComment,
+                                        populatedRequest.SeenBefore)
 
-                PublishReview(reviewId,
-                              responseIdStr,
-                              populatedRequest.Requester,
-                              (commentsMade > 0),
-                              extraComment,
-                              populatedRequest.SeenBefore)
+                    if (err != nil) {
+                        events.Publish(reviewdata.EventFailed,
+                                       server.Name,
+                                       reviewId,
+                                       map[string]string{
+                                           "reason": err.Error()})
+                    } else {
+                        // Remember which plugins this was reviewed with, so
+                        // that re-reviews can tell if it's stale
+                        db.KvPut(DbKey(server, "RLF", reviewId),
+                                 server.Fingerprint)
+
+                        events.PublishEvent(reviewdata.Event{
+                            Type:     reviewdata.EventPublished,
+                            Server:   server.Name,
+                            ReviewId: reviewId,
+                            Details:  map[string]string{
+                                          "reply":    responseIdStr,
+                                          "comments": strconv.Itoa(
+                                                            commentsMade)},
+                            Review:   SummariseReview(server,
+                                                      populatedRequest,
+                                                      commentsMade,
+                                                      diffFiles,
+                                                      findings)})
+                    }
 
-                fmt.Printf("Publishing took %s\n", time.Since(timer))
-                timer = time.Now()
+                    fmt.Printf("Publishing took %s\n", time.Since(timer))
+                    timer = time.Now()
 
-                // Also store some fun stats
-                db.KvIncr("reviewsDone", 1)
-                db.KvIncr("commentsMade", commentsMade)
+                    // Also store some fun stats
+                    db.KvIncr("reviewsDone", 1)
+                    db.KvIncr("commentsMade", commentsMade)
 
-                fmt.Printf("Databasing took %s\n", time.Since(timer))
-                timer = time.Now()
+                    fmt.Printf("Databasing took %s\n", time.Since(timer))
+                    timer = time.Now()
+                }
             }
         }
     } else {
@@ -944,10 +739,12 @@ func DoReview(incomingReq   reviewdata.ReviewRequest,
  *
  * @param pluginDir The directory from which requester plugins should be
  *                  loaded.
+ * @param configDir The directory that the config file is in.
  * @param pConfig   A raw json message containing config which plugins will
  *                  decode.
  */
 func LoadReviewerPlugins(pluginDir string,
+                         configDir string,
                          pConfig json.RawMessage) ([]ReviewerPlugin, error) {
     var plugins []ReviewerPlugin
 
@@ -984,7 +781,12 @@ func LoadReviewerPlugins(pluginDir string,
                 break
             }
 
-            // Configure the plugin
+            // Configure the plugin, telling it where its config came from
+            // if it wants to know
+            if user, ok := reviewPlugin.(ConfigDirUser); (ok) {
+                user.UseConfigDir(configDir)
+            }
+
             reviewer.Configure(pConfig)
 
             // Add the plugin to out list
@@ -1002,47 +804,16 @@ func LoadReviewerPlugins(pluginDir string,
     return plugins, err
 }
 
-/**
- * Configures the reviewer, given its config block.
- *
- * @param config A raw json message containing config.
- *
- * @retval error Error status
- */
-func Configure(rawConfig json.RawMessage) error {
-    err := json.Unmarshal(rawConfig, &config)
-
-    // Build the file exclusion regex
-    if (len(config.ExclusionRegexes.File) > 0) {
-        fileExclusionRegex = regexp.MustCompile(
-                                strings.Join(
-                                    config.ExclusionRegexes.File,
-                                    "|"))
-        fileExclusionsSet = true
-    } else {
-        fileExclusionsSet = false
-    }
-
-    // Build the review title exclusion regex
-    if (len(config.ExclusionRegexes.ReviewTitle) > 0) {
-        reviewTitleExclusionRegex = regexp.MustCompile(
-                            strings.Join(config.ExclusionRegexes.ReviewTitle,
-                                         "|"))
-        reviewTitleExclusionSet = true
-    } else {
-        reviewTitleExclusionSet = false
-    }
-
-    return err
-}
-
 /**
  * Runs the reviewer. Blocks on the reviewReqs channel, handling reviews as they
  * come in.
  *
  * @param pluginPath            The path to the directory in which reviewer
  *                              plugins shall be found.
- * @param rawConfig             A json-encoded struct containing reviewer
+ * @param configDir             The directory that the config file is in.
+ * @param rawConfig             A json-encoded struct containing the default
+ *                              server's configuration.
+ * @param rawServers            A json-encoded map of server name to server
  *                              configuration.
  * @param reviewPluginRawConfig A json-encoded struct which is passed to
  *                              plugins, and from which they configure.
@@ -1050,25 +821,39 @@ func Configure(rawConfig json.RawMessage) error {
  *                              received.
  */
 func Go(pluginPath            string,
+        configDir             string,
         rawConfig             json.RawMessage,
+        rawServers            json.RawMessage,
         reviewPluginRawConfig json.RawMessage,
         reviewReqs            <-chan reviewdata.ReviewRequest) {
 
-    err := Configure(rawConfig)
-
-    fmt.Printf("Reviewer config: %+v\n", config)
+    plugins, err := LoadReviewerPlugins(pluginPath,
+                                        configDir,
+                                        reviewPluginRawConfig)
 
     if (err != nil) {
         log.Fatal(err)
     }
 
-    plugins, err := LoadReviewerPlugins(pluginPath, reviewPluginRawConfig)
+    err = ConfigureServers(rawConfig,
+                           rawServers,
+                           plugins,
+                           reviewPluginRawConfig)
 
     if (err != nil) {
         log.Fatal(err)
     }
 
     for {
-        go DoReview(<-reviewReqs, plugins)
+        reviewReq := <-reviewReqs
+
+        events.Publish(reviewdata.EventQueued,
+                       reviewReq.Server,
+                       reviewReq.ReviewId,
+                       map[string]string{
+                           "requester": reviewReq.Requester,
+                           "force":     strconv.FormatBool(reviewReq.Force)})
+
+        go DoReview(reviewReq)
     }
 }
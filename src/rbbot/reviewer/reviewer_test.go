@@ -0,0 +1,78 @@
+/**
+ * Tests running plugins over a review.
+ */
+package reviewer
+
+import (
+    "encoding/json"
+    "sync"
+    "testing"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * A plugin which makes a fixed set of review comments.
+ */
+type chattyPlugin struct {
+    comments []string
+}
+
+func (p chattyPlugin) Version() (int, int, int) {
+    return 0, 0, 0
+}
+
+func (p chattyPlugin) CanonicalName() string {
+    return "ChattyPlugin"
+}
+
+func (p chattyPlugin) Configure(rawConfig json.RawMessage) {
+}
+
+func (p chattyPlugin) Check(file        reviewdata.FileDiff,
+                            passback    interface{},
+                            commentChan chan <- reviewdata.Comment,
+                            wg          *sync.WaitGroup) {
+    wg.Done()
+}
+
+func (p chattyPlugin) CheckReview(review      reviewdata.ReviewRequest,
+                                  commentChan chan <- string) interface{} {
+    for _, comment := range p.comments {
+        commentChan <- comment
+    }
+
+    return nil
+}
+
+func TestRunCheckersAndCommentCollectsEveryReviewComment(t *testing.T) {
+    var server *Server = &Server{}
+    var files  []reviewdata.FileDiff
+
+    server.Config.Comments.MaxComments = 10
+    server.Plugins = []ReviewerPlugin{
+        chattyPlugin{comments: []string{"One", "Two", "Three"}},
+        chattyPlugin{comments: []string{"Four"}}}
+
+    done := make(chan string)
+
+    go func() {
+        _, generalComment, _ := RunCheckersAndComment(
+                                                server,
+                                                "42",
+                                                "9",
+                                                reviewdata.ReviewRequest{},
+                                                &files)
+        done <- generalComment
+    }()
+
+    select {
+    case generalComment := <-done:
+        if (generalComment != "One\nTwo\nThree\nFour\n") {
+            t.Errorf("Review comment was %q", generalComment)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("Running the plugins deadlocked")
+    }
+}
@@ -0,0 +1,353 @@
+/**
+ * Manages the code review servers that the bot reviews for.
+ */
+package reviewer
+
+import (
+    "bytes"
+    "crypto/sha1"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "regexp"
+    "sort"
+    "strings"
+
+    "rbbot/db"
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * A single code review server, along with everything needed to review for it.
+ */
+type Server struct {
+    Name        string           /**< The server's name. Empty for the
+                                  *   default server */
+    Config      RbConfig         /**< The server's configuration */
+    Backend     ReviewBackend    /**< The code review system the server
+                                  *   talks to */
+    Plugins     []ReviewerPlugin /**< The plugins run against this server's
+                                  *   reviews */
+    Fingerprint string           /**< Identifies the plugins, their versions
+                                  *   and their config. Changes whenever any
+                                  *   of those do */
+    Classifier  *Classifier      /**< Classifies the server's files */
+
+    fileExclusionRegex        *regexp.Regexp
+    fileExclusionsSet          bool
+    reviewTitleExclusionRegex *regexp.Regexp
+    reviewTitleExclusionSet    bool
+}
+
+var (
+    servers = make(map[string]*Server)
+
+    // Closed once the servers have been configured
+    serversReady = make(chan struct{})
+)
+
+/**
+ * Creates a server, building its exclusion regexes and picking out its plugins.
+ *
+ * @param name       The server's name.
+ * @param config     The server's configuration.
+ * @param allPlugins Every plugin that has been loaded.
+ *
+ * @retval *Server The server.
+ * @retval error   If a configured plugin was not loaded.
+ */
+func NewServer(name       string,
+               config     RbConfig,
+               allPlugins []ReviewerPlugin) (*Server, error) {
+    var err error
+
+    server := &Server{Name: name, Config: config}
+
+    server.Backend, err = NewBackend(server)
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    // Build the file exclusion regex
+    if (len(config.ExclusionRegexes.File) > 0) {
+        server.fileExclusionRegex = regexp.MustCompile(
+                                        strings.Join(
+                                            config.ExclusionRegexes.File,
+                                            "|"))
+        server.fileExclusionsSet = true
+    }
+
+    // Build the review title exclusion regex
+    if (len(config.ExclusionRegexes.ReviewTitle) > 0) {
+        server.reviewTitleExclusionRegex = regexp.MustCompile(
+                            strings.Join(config.ExclusionRegexes.ReviewTitle,
+                                         "|"))
+        server.reviewTitleExclusionSet = true
+    }
+
+    server.Classifier = NewClassifier(config)
+
+    // An empty plugin list means that the server runs everything
+    if (len(config.Plugins) == 0) {
+        server.Plugins = allPlugins
+    } else {
+        for _, pluginName := range config.Plugins {
+            var found bool = false
+
+            for _, plugin := range allPlugins {
+                if (plugin.CanonicalName() == pluginName) {
+                    server.Plugins = append(server.Plugins, plugin)
+                    found = true
+                }
+            }
+
+            if (!found) {
+                err = errors.New("Server '" + name + "' uses plugin " +
+                                 pluginName + ", which is not loaded")
+            }
+        }
+    }
+
+    return server, err
+}
+
+/**
+ * Builds a fingerprint of a set of plugins, their versions and their config.
+ *
+ * @param plugins      The plugins.
+ * @param pluginConfig The raw json config given to the plugins.
+ *
+ * @returns A hex-encoded hash which changes whenever a plugin is added,
+ *          removed or upgraded, or its config changes.
+ */
+func PluginFingerprint(plugins      []ReviewerPlugin,
+                       pluginConfig json.RawMessage) string {
+    var versions []string
+
+    for _, plugin := range plugins {
+        major, minor, micro := plugin.Version()
+        versions = append(versions, fmt.Sprintf("%s@%d.%d.%d",
+                                                plugin.CanonicalName(),
+                                                major,
+                                                minor,
+                                                micro))
+    }
+
+    sort.Strings(versions)
+
+    // Whitespace changes to the config don't count
+    var compactConfig bytes.Buffer
+    json.Compact(&compactConfig, pluginConfig)
+
+    hash := sha1.Sum([]byte(strings.Join(versions, ",") + "\n" +
+                            compactConfig.String()))
+
+    return hex.EncodeToString(hash[:])
+}
+
+/**
+ * Configures all servers.
+ *
+ * The default server is configured by the top-level reviewBoard block, and is
+ * only created if that block names a ReviewBoard API URL or a Gerrit URL.
+ * Named servers take the default block as their base, and override whatever
+ * they specify.
+ *
+ * @param rawConfig    A raw json message containing the default server's
+ *                     config.
+ * @param rawServers   A raw json message containing a map of server name to
+ *                     server config.
+ * @param plugins      Every plugin that has been loaded.
+ * @param pluginConfig The raw json config given to the plugins.
+ *
+ * @retval error Error status
+ */
+func ConfigureServers(rawConfig    json.RawMessage,
+                      rawServers   json.RawMessage,
+                      plugins      []ReviewerPlugin,
+                      pluginConfig json.RawMessage) error {
+    var defaultConfig RbConfig
+    var namedConfigs  map[string]json.RawMessage
+
+    if (len(rawConfig) > 0) {
+        err := json.Unmarshal(rawConfig, &defaultConfig)
+
+        if (err != nil) {
+            return err
+        }
+    }
+
+    if (len(rawServers) > 0) {
+        err := json.Unmarshal(rawServers, &namedConfigs)
+
+        if (err != nil) {
+            return err
+        }
+    }
+
+    if (defaultConfig.RbApiUrl != "" || defaultConfig.Gerrit.Url != "") {
+        server, err := NewServer("", defaultConfig, plugins)
+
+        if (err != nil) {
+            return err
+        }
+
+        servers[""] = server
+    }
+
+    for name, rawServer := range namedConfigs {
+        var serverConfig RbConfig
+
+        // Decoding the default config afresh, then the server's on top of it,
+        // leaves any unset fields at their defaults. Copying defaultConfig
+        // instead would share its slices and maps with every server
+        if (len(rawConfig) > 0) {
+            err := json.Unmarshal(rawConfig, &serverConfig)
+
+            if (err != nil) {
+                return err
+            }
+        }
+
+        err := json.Unmarshal(rawServer, &serverConfig)
+
+        if (err != nil) {
+            return err
+        }
+
+        server, err := NewServer(name, serverConfig, plugins)
+
+        if (err != nil) {
+            return err
+        }
+
+        servers[name] = server
+    }
+
+    if (len(servers) == 0) {
+        return errors.New("No review servers are configured")
+    }
+
+    defer close(serversReady)
+
+    for name, server := range servers {
+        server.Fingerprint = PluginFingerprint(server.Plugins, pluginConfig)
+
+        var url string = server.Config.RbApiUrl
+
+        if (server.Config.Backend == "gerrit") {
+            url = server.Config.Gerrit.Url
+        }
+
+        fmt.Printf("Server '%s': %s with %d plugins (fingerprint %s)\n",
+                   name,
+                   url,
+                   len(server.Plugins),
+                   server.Fingerprint)
+    }
+
+    return nil
+}
+
+/**
+ * Retrieves a server by name.
+ *
+ * @param name The server's name. Empty for the default server.
+ *
+ * @returns The server, and whether it was found.
+ */
+func GetServer(name string) (*Server, bool) {
+    server, found := servers[name]
+    return server, found
+}
+
+/**
+ * Describes a server to requester plugins, waiting until the servers have been
+ * configured if need be.
+ *
+ * @param name The server's name. Empty for the default server.
+ *
+ * @returns The server's profile, and whether it was found.
+ */
+func ServerProfile(name string) (reviewdata.ServerProfile, bool) {
+    <-serversReady
+
+    server, found := GetServer(name)
+
+    if (!found) {
+        return reviewdata.ServerProfile{}, false
+    }
+
+    profile := reviewdata.ServerProfile{Name:    server.Name,
+                                        Backend: "reviewboard",
+                                        ApiUrl:  server.Config.RbApiUrl,
+                                        Token:   server.Config.RbToken}
+
+    if (server.Config.Backend == "gerrit") {
+        profile.Backend = "gerrit"
+        profile.ApiUrl  = server.Config.Gerrit.Url
+        profile.Token   = ""
+    }
+
+    return profile, true
+}
+
+/**
+ * Works out why a stale-only re-review isn't needed.
+ *
+ * @param server   The server on which the review lives.
+ * @param reviewId The review's ID.
+ *
+ * @returns "not-reviewed", "up-to-date", or "" if the review is stale.
+ */
+func StaleReason(server *Server, reviewId string) string {
+    _, reviewed := db.KvGet(DbKey(server, "RLD", reviewId))
+    lastFingerprint, _ := db.KvGet(DbKey(server, "RLF", reviewId))
+
+    if (!reviewed) {
+        return "not-reviewed"
+    } else if (lastFingerprint == server.Fingerprint) {
+        return "up-to-date"
+    }
+
+    return ""
+}
+
+/**
+ * Works out whether a review was last made with a different set of plugins,
+ * plugin versions or plugin config, so that a stale-only re-review would be
+ * made.
+ *
+ * @param serverName The name of the server on which the review lives.
+ * @param reviewId   The review's ID.
+ *
+ * @returns Whether the review is stale.
+ */
+func IsStale(serverName string, reviewId string) bool {
+    <-serversReady
+
+    server, found := GetServer(serverName)
+
+    return found && StaleReason(server, reviewId) == ""
+}
+
+/**
+ * Builds a database key that is unique to a server, so that review IDs from
+ * different servers cannot collide. The default server uses un-namespaced
+ * keys, for compatibility with databases written before servers had names.
+ *
+ * @param server   The server.
+ * @param prefix   The key prefix, e.g. "RLD".
+ * @param reviewId The review ID.
+ *
+ * @returns The key.
+ */
+func DbKey(server *Server, prefix string, reviewId string) string {
+    if (server.Name == "") {
+        return prefix + reviewId
+    }
+
+    return prefix + server.Name + "/" + reviewId
+}
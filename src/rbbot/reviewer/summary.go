@@ -0,0 +1,114 @@
+/**
+ * Collects findings, and summarises finished reviews.
+ */
+package reviewer
+
+import (
+    "sort"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * Every finding made during a review. Files are checked in parallel, so
+ * additions are mutexed.
+ */
+type ReviewFindings struct {
+    mutex    sync.Mutex
+    Findings []reviewdata.Finding
+}
+
+/**
+ * Adds the comments made on a single file to a review's findings.
+ *
+ * @param findings The review's findings.
+ * @param file     The file on which the comments were made.
+ * @param comments The comments.
+ */
+func AddFindings(findings *ReviewFindings,
+                 file      reviewdata.FileDiff,
+                 comments  []reviewdata.Comment) {
+    findings.mutex.Lock()
+    defer findings.mutex.Unlock()
+
+    for _, comment := range comments {
+        findings.Findings = append(findings.Findings,
+                                   reviewdata.Finding{
+                                       Filename: file.Filename,
+                                       Line:     file.RhLineFor(comment.Line),
+                                       Comment:  comment})
+    }
+}
+
+/**
+ * Sorts findings such that the most severe come first. Findings of equal
+ * severity are sorted by file, then line.
+ */
+func SortFindings(findings []reviewdata.Finding) {
+    sort.SliceStable(findings, func(i, j int) bool {
+        iRank := reviewdata.SeverityRank(
+                                    findings[i].Comment.EffectiveSeverity())
+        jRank := reviewdata.SeverityRank(
+                                    findings[j].Comment.EffectiveSeverity())
+
+        if (iRank != jRank) {
+            return iRank > jRank
+        } else if (findings[i].Filename != findings[j].Filename) {
+            return findings[i].Filename < findings[j].Filename
+        }
+
+        return findings[i].Comment.Line < findings[j].Comment.Line
+    })
+}
+
+/**
+ * Summarises a finished review.
+ *
+ * @param server       The server on which the review lives.
+ * @param request      The review request.
+ * @param commentsMade The number of comments posted.
+ * @param files        Every file that was reviewed.
+ * @param findings     Every finding, most severe first.
+ *
+ * @returns The summary.
+ */
+func SummariseReview(server       *Server,
+                     request       reviewdata.ReviewRequest,
+                     commentsMade  int,
+                     files         []reviewdata.FileDiff,
+                     findings      []reviewdata.Finding) *reviewdata.ReviewSummary {
+    summary := &reviewdata.ReviewSummary{
+                        Server:     server.Name,
+                        ReviewId:   request.ReviewId,
+                        Summary:    request.Summary,
+                        Link:       request.Absolute_Url,
+                        Comments:   commentsMade,
+                        Severities: make(map[string]int),
+                        Findings:   findings}
+
+    // Older ReviewBoards don't tell us where the review lives, but it's
+    // usually next to the API
+    if (summary.Link == "") {
+        summary.Link = strings.TrimSuffix(
+                            strings.TrimSuffix(server.Config.RbApiUrl, "/"),
+                            "/api") + "/r/" + request.ReviewId + "/"
+    }
+
+    for _, file := range files {
+        summary.Files = append(summary.Files, file.Filename)
+    }
+
+    sort.Strings(summary.Files)
+
+    for _, finding := range findings {
+        summary.Severities[finding.Comment.EffectiveSeverity()]++
+
+        if (finding.Comment.RaiseIssue) {
+            summary.Issues++
+        }
+    }
+
+    return summary
+}
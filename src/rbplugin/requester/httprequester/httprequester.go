@@ -21,6 +21,7 @@ type Requester struct {
  */
 type Payload struct {
     Secret   string
+    Server   string
     ReviewId int
     Force    bool
 }
@@ -65,6 +66,7 @@ func (p Requester) Run(reviewRequests chan <- reviewdata.ReviewRequest) {
                             var reviewReq reviewdata.ReviewRequest
 
                             reviewReq.Force = payload.Force
+                            reviewReq.Server = payload.Server
                             reviewReq.ReviewId = strconv.Itoa(payload.ReviewId)
                             reviewReq.ResultChan = make(
                                                 chan reviewdata.ReviewResult,
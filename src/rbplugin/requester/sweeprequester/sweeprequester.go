@@ -0,0 +1,435 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "strconv"
+    "strings"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * A server whose open review requests should be swept.
+ */
+type SweepServer struct {
+    Server string // The bot's name for the server. Empty for the default
+    Query  string // Extra query parameters, e.g. "to-groups=mygroup"
+}
+
+type Config struct {
+    SweepRequester struct {
+        Schedule          string // Cron-style: minute hour dom month dow
+        RequestsPerMinute int    // Limits both API requests and enqueues of
+                                 // stale review requests
+        PageSize          int
+        Servers           []SweepServer
+    }
+}
+
+/**
+ * A page of review requests, as listed by ReviewBoard.
+ */
+type ReviewRequestPage struct {
+    Stat            string
+    Total_Results   int
+    Review_Requests []reviewdata.ReviewRequest
+    Links           struct {
+        Next reviewdata.Link
+    }
+}
+
+/**
+ * A parsed cron-style schedule. Each field holds the values at which the
+ * schedule fires.
+ */
+type Schedule struct {
+    Minute     map[int]bool
+    Hour       map[int]bool
+    DayOfMonth map[int]bool
+    Month      map[int]bool
+    DayOfWeek  map[int]bool
+
+    anyDayOfMonth bool
+    anyDayOfWeek  bool
+}
+
+var (
+    config Config
+
+    // Provided by the bot, to look up its servers and check for staleness
+    serverProfile func(string) (reviewdata.ServerProfile, bool)
+    isStale       func(string, string) bool
+
+    // The most days in each month, in leap years
+    daysInMonth = [12]int{31, 29, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Requester struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Requester) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Requester) CanonicalName() string {
+    return "SweepRequester"
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Requester) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.SweepRequester.RequestsPerMinute <= 0) {
+        config.SweepRequester.RequestsPerMinute = 30
+    }
+
+    if (config.SweepRequester.PageSize <= 0) {
+        config.SweepRequester.PageSize = 25
+    }
+}
+
+/**
+ * Takes the bot's functions for looking up its servers, and for checking
+ * whether a review is stale.
+ */
+func (p Requester) UseServers(
+        profile func(string) (reviewdata.ServerProfile, bool),
+        stale   func(string, string) bool) {
+    serverProfile = profile
+    isStale       = stale
+}
+
+/**
+ * Parses a single cron field, e.g. "*", "5", "1-5", "*\/15" or "0,30".
+ *
+ * @param field The field.
+ * @param min   The smallest value the field may take.
+ * @param max   The largest value the field may take.
+ *
+ * @returns The set of values at which the field matches, and any error.
+ */
+func ParseField(field string, min int, max int) (map[int]bool, error) {
+    values := make(map[int]bool)
+
+    for _, part := range strings.Split(field, ",") {
+        var step int = 1
+        var err  error
+
+        // Pick off any step
+        if (strings.Contains(part, "/")) {
+            stepParts := strings.SplitN(part, "/", 2)
+            part = stepParts[0]
+            step, err = strconv.Atoi(stepParts[1])
+
+            if (err != nil || step <= 0) {
+                return nil, errors.New("Invalid step in " + field)
+            }
+        }
+
+        // Work out the range that the part covers
+        var low  int = min
+        var high int = max
+
+        if (part != "*") {
+            bounds := strings.SplitN(part, "-", 2)
+
+            low, err = strconv.Atoi(bounds[0])
+            if (err != nil) {
+                return nil, errors.New("Invalid value in " + field)
+            }
+
+            high = low
+
+            if (len(bounds) == 2) {
+                high, err = strconv.Atoi(bounds[1])
+                if (err != nil) {
+                    return nil, errors.New("Invalid range in " + field)
+                }
+            }
+        }
+
+        if (low < min || high > max || low > high) {
+            return nil, errors.New("Out of range value in " + field)
+        }
+
+        for value := low; value <= high; value += step {
+            values[value] = true
+        }
+    }
+
+    return values, nil
+}
+
+/**
+ * Parses a five-field cron-style schedule.
+ *
+ * @param schedule The schedule, e.g. "0 2 * * 1-5".
+ *
+ * @returns The parsed schedule, and any error.
+ */
+func ParseSchedule(schedule string) (Schedule, error) {
+    var parsed Schedule
+    var err    error
+
+    fields := strings.Fields(schedule)
+
+    if (len(fields) != 5) {
+        return parsed, errors.New("Schedule must have five fields: " + schedule)
+    }
+
+    // The bounds of each field, in order
+    var bounds = [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+    var values   [5]map[int]bool
+
+    for i, field := range fields {
+        values[i], err = ParseField(field, bounds[i][0], bounds[i][1])
+
+        if (err != nil) {
+            return parsed, err
+        }
+    }
+
+    parsed.Minute     = values[0]
+    parsed.Hour       = values[1]
+    parsed.DayOfMonth = values[2]
+    parsed.Month      = values[3]
+    parsed.DayOfWeek  = values[4]
+
+    parsed.anyDayOfMonth = (fields[2] == "*")
+    parsed.anyDayOfWeek  = (fields[4] == "*")
+
+    // Only restricting the day of the month can rule out every day, e.g.
+    // "0 0 30 2 *"
+    if (!parsed.anyDayOfMonth && parsed.anyDayOfWeek) {
+        var possible bool
+
+        for month := range parsed.Month {
+            for day := range parsed.DayOfMonth {
+                possible = possible || day <= daysInMonth[month - 1]
+            }
+        }
+
+        if (!possible) {
+            return parsed, errors.New("Schedule never fires: " + schedule)
+        }
+    }
+
+    return parsed, nil
+}
+
+/**
+ * Finds the next time, strictly after the passed time, at which a schedule
+ * fires.
+ *
+ * @param schedule The schedule.
+ * @param after    The time after which to look.
+ *
+ * @returns The next time at which the schedule fires, and whether it fires
+ *          within four years.
+ */
+func NextRun(schedule Schedule, after time.Time) (time.Time, bool) {
+    next := after.Truncate(time.Minute).Add(time.Minute)
+
+    // Every schedule fires at least once every four years
+    for i := 0; i < 4 * 366 * 24 * 60; i++ {
+        // As with cron, if both days are restricted then either may match
+        var dayMatch bool
+        domMatch := schedule.DayOfMonth[next.Day()]
+        dowMatch := schedule.DayOfWeek[int(next.Weekday())]
+
+        if (schedule.anyDayOfMonth || schedule.anyDayOfWeek) {
+            dayMatch = domMatch && dowMatch
+        } else {
+            dayMatch = domMatch || dowMatch
+        }
+
+        if (dayMatch &&
+            schedule.Month[int(next.Month())] &&
+            schedule.Hour[next.Hour()] &&
+            schedule.Minute[next.Minute()]) {
+            return next, true
+        }
+
+        next = next.Add(time.Minute)
+    }
+
+    return next, false
+}
+
+/**
+ * Retrieves a page of review requests from ReviewBoard.
+ *
+ * @param token The server's API token.
+ * @param link  The page's URL.
+ *
+ * @returns The page, and any error.
+ */
+func GetPage(token string, link string) (ReviewRequestPage, error) {
+    var page ReviewRequestPage
+
+    req, err := http.NewRequest("GET", link, nil)
+
+    if (err != nil) {
+        return page, err
+    }
+
+    req.Header.Add("Authorization", token)
+
+    resp, err := (&http.Client{}).Do(req)
+
+    if (err != nil) {
+        return page, err
+    }
+    defer resp.Body.Close()
+
+    body, err := ioutil.ReadAll(resp.Body)
+
+    if (err != nil) {
+        return page, err
+    }
+
+    if (resp.StatusCode != http.StatusOK) {
+        return page, errors.New("Listing review requests returned " +
+                                resp.Status)
+    }
+
+    err = json.Unmarshal(body, &page)
+
+    return page, err
+}
+
+/**
+ * Sweeps a single server, enqueueing a stale-only re-review of every open
+ * review request which was last reviewed with a different set of plugins,
+ * plugin versions or plugin config. Those which aren't stale are skipped
+ * without being enqueued, so don't count against the limiter.
+ *
+ * @param server         The server to sweep.
+ * @param reviewRequests The channel into which review requests are pushed.
+ * @param limiter        Ticks whenever we are allowed to do something.
+ */
+func Sweep(server          SweepServer,
+           reviewRequests  chan <- reviewdata.ReviewRequest,
+           limiter        <- chan time.Time) {
+    var enqueued int = 0
+    var skipped  int = 0
+
+    profile, found := serverProfile(server.Server)
+
+    if (!found) {
+        fmt.Printf("Sweep of server '%s' failed: No such server\n",
+                   server.Server)
+        return
+    } else if (profile.Backend != "reviewboard") {
+        fmt.Printf("Sweep of server '%s' skipped: Only ReviewBoard servers " +
+                   "can be swept\n",
+                   server.Server)
+        return
+    }
+
+    var link string = profile.ApiUrl +
+                      "/review-requests/?status=pending&max-results=" +
+                      strconv.Itoa(config.SweepRequester.PageSize)
+
+    if (server.Query != "") {
+        link += "&" + server.Query
+    }
+
+    for link != "" {
+        <-limiter
+
+        page, err := GetPage(profile.Token, link)
+
+        if (err != nil) {
+            fmt.Printf("Sweep of server '%s' failed: %s\n", server.Server, err)
+            break
+        }
+
+        for _, request := range page.Review_Requests {
+            request.ReviewId = strconv.Itoa(request.Id)
+
+            if (!isStale(server.Server, request.ReviewId)) {
+                skipped++
+                continue
+            }
+
+            <-limiter
+
+            // The listing gives us the whole review request, so the bot
+            // doesn't need to retrieve it again
+            request.Server     = server.Server
+            request.Requester  = "SweepRequester"
+            request.StaleOnly  = true
+            request.ResultChan = make(chan reviewdata.ReviewResult, 1)
+
+            reviewRequests <- request
+            enqueued++
+        }
+
+        link = page.Links.Next.Href
+    }
+
+    fmt.Printf("Sweep of server '%s' enqueued %d review requests, and " +
+               "skipped %d which are up to date\n",
+               server.Server,
+               enqueued,
+               skipped)
+}
+
+/**
+ * Runs the plugin.
+ */
+func (p Requester) Run(reviewRequests chan <- reviewdata.ReviewRequest) {
+    schedule, err := ParseSchedule(config.SweepRequester.Schedule)
+
+    if (err != nil) {
+        fmt.Printf("SweepRequester not running: %s\n", err)
+        return
+    }
+
+    if (serverProfile == nil || isStale == nil) {
+        fmt.Printf("SweepRequester not running: The bot did not provide its " +
+                   "servers\n")
+        return
+    }
+
+    limiter := time.Tick(time.Minute /
+                    time.Duration(config.SweepRequester.RequestsPerMinute))
+
+    for {
+        next, found := NextRun(schedule, time.Now())
+
+        if (!found) {
+            fmt.Printf("SweepRequester stopping: %s never fires\n",
+                       config.SweepRequester.Schedule)
+            return
+        }
+
+        fmt.Printf("Next sweep at %s\n", next)
+        time.Sleep(next.Sub(time.Now()))
+
+        for _, server := range config.SweepRequester.Servers {
+            Sweep(server, reviewRequests, limiter)
+        }
+    }
+}
+
+// Export our plugin as a ReviewRequester for main to pick up
+var ReviewRequester Requester
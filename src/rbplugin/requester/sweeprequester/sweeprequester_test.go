@@ -0,0 +1,193 @@
+/**
+ * Tests parsing cron-style schedules, and finding when they next fire.
+ */
+package main
+
+import (
+    "sort"
+    "testing"
+    "time"
+)
+
+/**
+ * Lists the values in a parsed field, in order.
+ */
+func fieldValues(field map[int]bool) []int {
+    var values []int
+
+    for value := range field {
+        values = append(values, value)
+    }
+
+    sort.Ints(values)
+
+    return values
+}
+
+func TestParseField(t *testing.T) {
+    var tests = []struct {
+        field   string
+        min     int
+        max     int
+        values  []int
+        failure bool
+    }{
+        {"*", 0, 6, []int{0, 1, 2, 3, 4, 5, 6}, false},
+        {"5", 0, 59, []int{5}, false},
+        {"1-5", 0, 6, []int{1, 2, 3, 4, 5}, false},
+        {"*/15", 0, 59, []int{0, 15, 30, 45}, false},
+        {"10-20/5", 0, 59, []int{10, 15, 20}, false},
+        {"0,30", 0, 59, []int{0, 30}, false},
+        {"1-3,5,20-40/10", 0, 59, []int{1, 2, 3, 5, 20, 30, 40}, false},
+        {"*/7", 1, 31, []int{1, 8, 15, 22, 29}, false},
+        {"0", 1, 31, nil, true},
+        {"60", 0, 59, nil, true},
+        {"5-1", 0, 59, nil, true},
+        {"*/0", 0, 59, nil, true},
+        {"*/x", 0, 59, nil, true},
+        {"a-b", 0, 59, nil, true},
+        {"1-b", 0, 59, nil, true},
+        {"", 0, 59, nil, true},
+    }
+
+    for _, test := range tests {
+        field, err := ParseField(test.field, test.min, test.max)
+        values     := fieldValues(field)
+
+        if ((err != nil) != test.failure ||
+            len(values) != len(test.values)) {
+            t.Errorf("ParseField(%q, %d, %d) = %v, %v; want %v, error %t",
+                     test.field,
+                     test.min,
+                     test.max,
+                     values,
+                     err,
+                     test.values,
+                     test.failure)
+            continue
+        }
+
+        for i := range values {
+            if (values[i] != test.values[i]) {
+                t.Errorf("ParseField(%q, %d, %d) = %v; want %v",
+                         test.field,
+                         test.min,
+                         test.max,
+                         values,
+                         test.values)
+                break
+            }
+        }
+    }
+}
+
+func TestParseSchedule(t *testing.T) {
+    var tests = []struct {
+        schedule string
+        failure  bool
+    }{
+        {"0 2 * * 1-5", false},
+        {"*/15 * * * *", false},
+        {"0 0 1,15 * 0", false},
+        {"0 2 * *", true},
+        {"0 2 * * * *", true},
+        {"0 24 * * *", true},
+        {"0 0 0 * *", true},
+        {"0 0 * 13 *", true},
+        {"0 0 * * 7", true},
+        // Days that no month has
+        {"0 0 30 2 *", true},
+        {"0 0 31 4,6,9,11 *", true},
+        {"0 0 30,31 2 *", true},
+        // ... unless another month, or the day of the week, allows it
+        {"0 0 31 2,3 *", false},
+        {"0 0 30 2 1", false},
+        {"0 0 29 2 *", false},
+    }
+
+    for _, test := range tests {
+        _, err := ParseSchedule(test.schedule)
+
+        if ((err != nil) != test.failure) {
+            t.Errorf("ParseSchedule(%q) = %v; want error %t",
+                     test.schedule,
+                     err,
+                     test.failure)
+        }
+    }
+}
+
+func TestNextRun(t *testing.T) {
+    // A Wednesday
+    var after = time.Date(2024, time.January, 10, 12, 34, 56, 0, time.UTC)
+
+    var tests = []struct {
+        schedule string
+        next     time.Time
+    }{
+        // Every minute fires strictly after the passed time
+        {"* * * * *", time.Date(2024, 1, 10, 12, 35, 0, 0, time.UTC)},
+        // Steps
+        {"*/15 * * * *", time.Date(2024, 1, 10, 12, 45, 0, 0, time.UTC)},
+        {"0 */6 * * *", time.Date(2024, 1, 10, 18, 0, 0, 0, time.UTC)},
+        // Lists, wrapping into the next hour and day
+        {"10,20 * * * *", time.Date(2024, 1, 10, 13, 10, 0, 0, time.UTC)},
+        {"0 2,4 * * *", time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC)},
+        // Ranges of weekdays, from a Wednesday
+        {"0 2 * * 1-5", time.Date(2024, 1, 11, 2, 0, 0, 0, time.UTC)},
+        {"0 2 * * 6-6", time.Date(2024, 1, 13, 2, 0, 0, 0, time.UTC)},
+        // Sunday
+        {"30 9 * * 0", time.Date(2024, 1, 14, 9, 30, 0, 0, time.UTC)},
+        // Day of the month, wrapping into the next month
+        {"0 0 5 * *", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+        // Day of the month in a later month, and a leap day
+        {"0 0 1 3 *", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+        {"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+        // Both days restricted: either may match, so the 15th (a Monday)
+        // loses to Friday the 12th
+        {"0 0 15 * 5", time.Date(2024, 1, 12, 0, 0, 0, 0, time.UTC)},
+        // ... and the 11th beats Saturday the 13th
+        {"0 0 11 * 6", time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC)},
+        // Only one day restricted: that day must match
+        {"0 0 13 * *", time.Date(2024, 1, 13, 0, 0, 0, 0, time.UTC)},
+        {"0 0 * 9 5", time.Date(2024, 9, 6, 0, 0, 0, 0, time.UTC)},
+    }
+
+    for _, test := range tests {
+        schedule, err := ParseSchedule(test.schedule)
+
+        if (err != nil) {
+            t.Errorf("ParseSchedule(%q) = %v", test.schedule, err)
+            continue
+        }
+
+        next, found := NextRun(schedule, after)
+
+        if (!found || !next.Equal(test.next)) {
+            t.Errorf("NextRun(%q, %v) = %v; want %v",
+                     test.schedule,
+                     after,
+                     next,
+                     test.next)
+        }
+    }
+}
+
+func TestNextRunNever(t *testing.T) {
+    // Parsing rejects this, so build it by hand
+    var schedule = Schedule{Minute:        map[int]bool{0: true},
+                            Hour:          map[int]bool{0: true},
+                            DayOfMonth:    map[int]bool{30: true},
+                            Month:         map[int]bool{2: true},
+                            DayOfWeek:     map[int]bool{0: true, 1: true,
+                                                        2: true, 3: true,
+                                                        4: true, 5: true,
+                                                        6: true},
+                            anyDayOfWeek:  true}
+
+    var after = time.Date(2024, time.January, 10, 12, 34, 56, 0, time.UTC)
+
+    if next, found := NextRun(schedule, after); (found) {
+        t.Errorf("NextRun(0 0 30 2 *, %v) = %v; want none", after, next)
+    }
+}
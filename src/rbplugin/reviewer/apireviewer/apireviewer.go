@@ -0,0 +1,663 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "go/types"
+    "path"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+
+    "rbplugindata/goast"
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    ApiReviewer struct {
+        Heading        string   // Starts the plugin's part of the review body
+        PublicPackages []string // Regexes of the package directories which
+                                // are public API
+        PublicSeverity string   // Of breaking changes to public packages
+        Severity       string   // Of breaking changes to other packages
+        Exclude        []string // Regexes of files not to check
+    }
+}
+
+/**
+ * A member of an exported struct or interface: a field or a method.
+ */
+type Member struct {
+    Signature string
+    Line      int
+}
+
+/**
+ * An exported declaration.
+ */
+type Decl struct {
+    Kind      string            // "function", "method", "struct",
+                                // "interface", "type", "variable" or
+                                // "constant"
+    Signature string            // What must stay the same for callers
+    Members   map[string]Member // A struct's exported fields, or an
+                                // interface's methods
+    File      string
+    Line      int
+}
+
+/**
+ * A change to a package's exported API.
+ */
+type Change struct {
+    Text     string
+    Breaking bool
+    File     string // The file that the change is commented on
+    Line     int    // The line in the file
+    Original bool   // Whether Line is in the original file
+}
+
+/**
+ * The changes to a package's exported API.
+ */
+type PackageChanges struct {
+    Package string
+    Public  bool
+    Changes []Change
+}
+
+var (
+    config Config
+
+    excludeRegex *regexp.Regexp
+    publicRegex  *regexp.Regexp
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "ApiReviewer"
+}
+
+/**
+ * Lists the types in a parameter or result list. Names are left out, as
+ * renaming a parameter doesn't change anything for callers.
+ */
+func FieldTypes(fields *ast.FieldList) string {
+    var fieldTypes []string
+
+    if (fields == nil) {
+        return ""
+    }
+
+    for _, field := range fields.List {
+        var count int = len(field.Names)
+
+        if (count == 0) {
+            count = 1
+        }
+
+        for i := 0; i < count; i++ {
+            fieldTypes = append(fieldTypes, types.ExprString(field.Type))
+        }
+    }
+
+    return strings.Join(fieldTypes, ", ")
+}
+
+/**
+ * Describes a function's signature, e.g. "func(string, int) (bool, error)".
+ */
+func Signature(funcType *ast.FuncType) string {
+    var signature string = "func"
+
+    if (funcType.TypeParams != nil) {
+        signature += "[" + FieldTypes(funcType.TypeParams) + "]"
+    }
+
+    signature += "(" + FieldTypes(funcType.Params) + ")"
+
+    if (funcType.Results != nil && len(funcType.Results.List) > 0) {
+        results := FieldTypes(funcType.Results)
+
+        if (len(funcType.Results.List) == 1 &&
+            len(funcType.Results.List[0].Names) <= 1) {
+            signature += " " + results
+        } else {
+            signature += " (" + results + ")"
+        }
+    }
+
+    return signature
+}
+
+/**
+ * Finds the exported members of a struct or interface.
+ */
+func Members(fileSet *token.FileSet, fields *ast.FieldList) map[string]Member {
+    members := make(map[string]Member)
+
+    for _, field := range fields.List {
+        var line int = fileSet.Position(field.Pos()).Line
+        var signature string
+
+        if funcType, ok := field.Type.(*ast.FuncType); ok {
+            signature = Signature(funcType)
+        } else {
+            signature = types.ExprString(field.Type)
+        }
+
+        // Embedded fields are named after their type
+        if (len(field.Names) == 0) {
+            name := strings.TrimPrefix(types.ExprString(field.Type), "*")
+
+            if (strings.Contains(name, ".")) {
+                name = name[strings.LastIndex(name, ".") + 1:]
+            }
+
+            if (ast.IsExported(name)) {
+                members[name] = Member{Signature: signature, Line: line}
+            }
+        }
+
+        for _, name := range field.Names {
+            if (name.IsExported()) {
+                members[name.Name] = Member{Signature: signature, Line: line}
+            }
+        }
+    }
+
+    return members
+}
+
+/**
+ * Finds the exported declarations in a Go source file.
+ *
+ * @param filename The file's name.
+ * @param source   The file's content. An empty file has no declarations.
+ * @param decls    The map, of declaration key to declaration, to add to.
+ *
+ * @returns The file's package name, and whether the file could be parsed.
+ */
+func Declarations(filename string,
+                  source   []byte,
+                  decls    map[string]Decl) (string, bool) {
+    if (len(source) == 0) {
+        return "", true
+    }
+
+    fileSet := token.NewFileSet()
+
+    parsed, err := parser.ParseFile(fileSet, filename, source, 0)
+
+    if (err != nil) {
+        return "", false
+    }
+
+    add := func(key string, decl Decl, pos token.Pos) {
+        decl.File = filename
+        decl.Line = fileSet.Position(pos).Line
+        decls[key] = decl
+    }
+
+    for _, decl := range parsed.Decls {
+        switch d := decl.(type) {
+        case *ast.FuncDecl:
+            if (!d.Name.IsExported()) {
+                continue
+            }
+
+            if (d.Recv == nil || len(d.Recv.List) == 0) {
+                add(d.Name.Name,
+                    Decl{Kind: "function", Signature: Signature(d.Type)},
+                    d.Pos())
+                continue
+            }
+
+            receiver := goast.ReceiverName(d.Recv.List[0].Type)
+
+            if (!ast.IsExported(strings.TrimPrefix(receiver, "*"))) {
+                continue
+            }
+
+            // A method's key leaves out whether its receiver is a pointer, so
+            // that changing it is a change rather than a removal
+            add(strings.TrimPrefix(receiver, "*") + "." + d.Name.Name,
+                Decl{Kind:      "method",
+                     Signature: "(" + receiver + ") " + Signature(d.Type)},
+                d.Pos())
+        case *ast.GenDecl:
+            for _, spec := range d.Specs {
+                switch s := spec.(type) {
+                case *ast.TypeSpec:
+                    if (!s.Name.IsExported()) {
+                        continue
+                    }
+
+                    switch t := s.Type.(type) {
+                    case *ast.StructType:
+                        add(s.Name.Name,
+                            Decl{Kind:      "struct",
+                                 Signature: "struct",
+                                 Members:   Members(fileSet, t.Fields)},
+                            s.Pos())
+                    case *ast.InterfaceType:
+                        add(s.Name.Name,
+                            Decl{Kind:      "interface",
+                                 Signature: "interface",
+                                 Members:   Members(fileSet, t.Methods)},
+                            s.Pos())
+                    default:
+                        var signature string = types.ExprString(s.Type)
+
+                        if (s.Assign.IsValid()) {
+                            signature = "= " + signature
+                        }
+
+                        add(s.Name.Name,
+                            Decl{Kind: "type", Signature: signature},
+                            s.Pos())
+                    }
+                case *ast.ValueSpec:
+                    var kind      string = "variable"
+                    var signature string
+
+                    if (d.Tok == token.CONST) {
+                        kind = "constant"
+                    }
+
+                    if (s.Type != nil) {
+                        signature = types.ExprString(s.Type)
+                    }
+
+                    for _, name := range s.Names {
+                        if (name.IsExported()) {
+                            add(name.Name,
+                                Decl{Kind: kind, Signature: signature},
+                                name.Pos())
+                        }
+                    }
+                }
+            }
+        }
+    }
+
+    return parsed.Name.Name, true
+}
+
+/**
+ * Compares the members of a struct or interface.
+ */
+func CompareMembers(key      string,
+                    original Decl,
+                    current  Decl) []Change {
+    var changes []Change
+    var names   []string
+    var noun    string = "field"
+
+    if (current.Kind == "interface") {
+        noun = "method"
+    }
+
+    for name := range original.Members {
+        names = append(names, name)
+    }
+
+    for name := range current.Members {
+        if _, found := original.Members[name]; !found {
+            names = append(names, name)
+        }
+    }
+
+    sort.Strings(names)
+
+    for _, name := range names {
+        before, wasThere := original.Members[name]
+        after,  isThere  := current.Members[name]
+
+        switch {
+        case !isThere:
+            changes = append(changes,
+                             Change{Text:     "Removed " + noun + " `" +
+                                              key + "." + name + "`",
+                                    Breaking: true,
+                                    File:     original.File,
+                                    Line:     before.Line,
+                                    Original: true})
+        case !wasThere:
+            // Implementations of an interface won't have the new method
+            changes = append(changes,
+                             Change{Text:     "Added " + noun + " `" + key +
+                                              "." + name + "`",
+                                    Breaking: current.Kind == "interface",
+                                    File:     current.File,
+                                    Line:     after.Line})
+        case before.Signature != after.Signature:
+            changes = append(changes,
+                             Change{Text:     "Changed " + noun + " `" + key +
+                                              "." + name + "` from `" +
+                                              before.Signature + "` to `" +
+                                              after.Signature + "`",
+                                    Breaking: true,
+                                    File:     current.File,
+                                    Line:     after.Line})
+        }
+    }
+
+    return changes
+}
+
+/**
+ * Compares a package's exported declarations, before and after a change.
+ *
+ * @returns The changes, in order of declaration key.
+ */
+func Compare(original map[string]Decl, current map[string]Decl) []Change {
+    var changes []Change
+    var keys    []string
+
+    for key := range original {
+        keys = append(keys, key)
+    }
+
+    for key := range current {
+        if _, found := original[key]; !found {
+            keys = append(keys, key)
+        }
+    }
+
+    sort.Strings(keys)
+
+    for _, key := range keys {
+        before, wasThere := original[key]
+        after,  isThere  := current[key]
+
+        switch {
+        case !isThere:
+            changes = append(changes,
+                             Change{Text:     "Removed " + before.Kind +
+                                              " `" + key + "`",
+                                    Breaking: true,
+                                    File:     before.File,
+                                    Line:     before.Line,
+                                    Original: true})
+        case !wasThere:
+            changes = append(changes,
+                             Change{Text:     "Added " + after.Kind + " `" +
+                                              key + "`",
+                                    File:     after.File,
+                                    Line:     after.Line})
+        case before.Kind != after.Kind || before.Signature != after.Signature:
+            var from string = before.Signature
+            var to   string = after.Signature
+
+            if (before.Kind != after.Kind) {
+                from = before.Kind
+                to   = after.Kind
+            }
+
+            // A constant or variable whose type is inferred has none to show
+            if (from == "" || to == "") {
+                continue
+            }
+
+            changes = append(changes,
+                             Change{Text:     "Changed " + after.Kind + " `" +
+                                              key + "` from `" + from +
+                                              "` to `" + to + "`",
+                                    Breaking: true,
+                                    File:     after.File,
+                                    Line:     after.Line})
+        case after.Members != nil:
+            changes = append(changes, CompareMembers(key, before, after)...)
+        }
+    }
+
+    return changes
+}
+
+/**
+ * Works out whether a file's API should be checked.
+ */
+func Checkable(file reviewdata.FileDiff) bool {
+    if (!strings.HasSuffix(file.Filename, ".go") ||
+        strings.HasSuffix(file.Filename, "_test.go") ||
+        file.Class == reviewdata.ClassGenerated ||
+        (excludeRegex != nil && excludeRegex.MatchString(file.Filename))) {
+        return false
+    }
+
+    // Without the original, everything would look new
+    return file.Status == reviewdata.FileAdded || len(file.OriginalFile) > 0
+}
+
+/**
+ * Retrieves the severity of breaking changes to a package.
+ */
+func Severity(public bool) string {
+    if (public) {
+        return config.ApiReviewer.PublicSeverity
+    }
+
+    return config.ApiReviewer.Severity
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Breaking changes to the file's package, found by CheckReview, are
+ * commented on where they are made.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    packages, ok := passback.([]PackageChanges)
+
+    if (!ok) {
+        return
+    }
+
+    // Right-hand line to review line
+    lines, _ := file.ReviewLines()
+
+    for _, changes := range packages {
+        for _, change := range changes.Changes {
+            if (!change.Breaking || change.File != file.Filename) {
+                continue
+            }
+
+            var line int
+
+            if (change.Original) {
+                line = file.ReviewLineForLh(change.Line)
+            } else {
+                line = lines[change.Line]
+            }
+
+            if (line == 0) {
+                continue
+            }
+
+            commentChan <- reviewdata.Comment{
+                               Line:       line,
+                               NumLines:   1,
+                               Text:       "This breaks the exported API of " +
+                                           "`" + changes.Package + "`: " +
+                                           change.Text,
+                               RaiseIssue: true,
+                               Severity:   Severity(changes.Public),
+                               Rule:       "api-break"}
+        }
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Each Go package that the review touches has its exported declarations
+ * compared, before and after. Comparing whole packages means that moving a
+ * declaration between files isn't a change. All changes are summarised in the
+ * review body, and the changes are passed back for Check to comment on.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    var packages []PackageChanges
+    var dirs     []string
+
+    originals := make(map[string]map[string]Decl)
+    currents  := make(map[string]map[string]Decl)
+    mains     := make(map[string]bool)
+
+    for _, file := range review.Files {
+        if (!Checkable(file)) {
+            continue
+        }
+
+        dir := path.Dir(file.Filename)
+
+        if (originals[dir] == nil) {
+            originals[dir] = make(map[string]Decl)
+            currents[dir]  = make(map[string]Decl)
+            dirs           = append(dirs, dir)
+        }
+
+        // A file that can't be parsed, before or after, is left out entirely
+        original := make(map[string]Decl)
+        current  := make(map[string]Decl)
+
+        originalName, originalOk := Declarations(file.Filename,
+                                                 file.OriginalFile,
+                                                 original)
+        currentName,  currentOk  := Declarations(file.Filename,
+                                                 file.EntireFile,
+                                                 current)
+
+        if (!originalOk || !currentOk) {
+            continue
+        }
+
+        // Commands have no API
+        if (originalName == "main" || currentName == "main") {
+            mains[dir] = true
+        }
+
+        for key, decl := range original {
+            originals[dir][key] = decl
+        }
+
+        for key, decl := range current {
+            currents[dir][key] = decl
+        }
+    }
+
+    sort.Strings(dirs)
+
+    for _, dir := range dirs {
+        if (mains[dir]) {
+            continue
+        }
+
+        changes := Compare(originals[dir], currents[dir])
+
+        if (len(changes) > 0) {
+            packages = append(packages,
+                              PackageChanges{
+                                  Package: dir,
+                                  Public:  publicRegex != nil &&
+                                           publicRegex.MatchString(dir),
+                                  Changes: changes})
+        }
+    }
+
+    if (len(packages) == 0) {
+        return nil
+    }
+
+    var summary string = config.ApiReviewer.Heading + "\n"
+
+    for _, changes := range packages {
+        summary += "\n`" + changes.Package + "`"
+
+        if (changes.Public) {
+            summary += " (public API)"
+        }
+
+        summary += "\n\n"
+
+        for _, change := range changes.Changes {
+            if (change.Breaking) {
+                summary += "- **Breaking:** " + change.Text + "\n"
+            } else {
+                summary += "- " + change.Text + "\n"
+            }
+        }
+    }
+
+    commentChan <- summary
+
+    return packages
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.ApiReviewer.Heading == "") {
+        config.ApiReviewer.Heading = "**API changes**"
+    }
+
+    if (config.ApiReviewer.PublicSeverity == "") {
+        config.ApiReviewer.PublicSeverity = reviewdata.SeverityError
+    }
+
+    if (config.ApiReviewer.Severity == "") {
+        config.ApiReviewer.Severity = reviewdata.SeverityWarning
+    }
+
+    var err error
+
+    if (len(config.ApiReviewer.Exclude) > 0) {
+        excludeRegex, err = regexp.Compile(
+                        strings.Join(config.ApiReviewer.Exclude, "|"))
+
+        if (err != nil) {
+            fmt.Printf("ApiReviewer: Bad exclusion: %s\n", err)
+        }
+    }
+
+    if (len(config.ApiReviewer.PublicPackages) > 0) {
+        publicRegex, err = regexp.Compile(
+                        strings.Join(config.ApiReviewer.PublicPackages, "|"))
+
+        if (err != nil) {
+            fmt.Printf("ApiReviewer: Bad public package: %s\n", err)
+        }
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
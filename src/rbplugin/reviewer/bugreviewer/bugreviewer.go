@@ -0,0 +1,377 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "net/http"
+    "net/url"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * Which review requests must reference a bug. Both regexes must match, and an
+ * empty regex matches anything.
+ */
+type Requirement struct {
+    Repository string
+    Branch     string
+}
+
+/**
+ * An HTTP bug tracker, against which bugs are resolved.
+ */
+type Tracker struct {
+    Url            string   // The bug's URL. "{bug}" is replaced with the
+                            // bug's ID
+    Authorization  string   // Optional. Sent as the Authorization header
+    StatusField    string   // Where the bug's status is in the tracker's
+                            // JSON, e.g. "fields.status.name"
+    ClosedStatuses []string // Statuses of bugs which are closed
+    TimeoutSec     int
+}
+
+type Config struct {
+    BugReviewer struct {
+        Heading    string        // Starts the plugin's part of the review body
+        RequireFor []Requirement // Review requests that must reference a bug
+        Patterns   []string      // What a bug ID looks like, e.g. "PROJ-\\d+"
+        Tracker    Tracker
+    }
+}
+
+var (
+    config Config
+
+    requirements []struct {
+        repository *regexp.Regexp
+        branch     *regexp.Regexp
+    }
+    patterns     []*regexp.Regexp
+    mentionRegex *regexp.Regexp // Finds bug IDs in text
+
+    client = &http.Client{}
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "BugReviewer"
+}
+
+/**
+ * Works out whether a review request must reference a bug.
+ */
+func BugRequired(review reviewdata.ReviewRequest) bool {
+    for _, requirement := range requirements {
+        if ((requirement.repository == nil ||
+             requirement.repository.MatchString(
+                                        review.Links.Repository.Title)) &&
+            (requirement.branch == nil ||
+             requirement.branch.MatchString(review.Branch))) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Works out whether a bug ID matches one of the configured patterns. Anything
+ * does if none are configured.
+ */
+func ValidBug(bug string) bool {
+    if (len(patterns) == 0) {
+        return true
+    }
+
+    for _, pattern := range patterns {
+        if (pattern.MatchString(bug)) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Looks up a bug's status in the bug tracker.
+ *
+ * @param bug The bug's ID.
+ *
+ * @returns The bug's status, whether the bug exists, and any error. A bug
+ *          which the tracker doesn't know about is not an error.
+ */
+func BugStatus(bug string) (string, bool, error) {
+    var tracker Tracker = config.BugReviewer.Tracker
+
+    req, err := http.NewRequest("GET",
+                                strings.Replace(tracker.Url,
+                                                "{bug}",
+                                                url.PathEscape(bug),
+                                                -1),
+                                nil)
+
+    if (err != nil) {
+        return "", false, err
+    }
+
+    req.Header.Set("Accept", "application/json")
+
+    if (tracker.Authorization != "") {
+        req.Header.Set("Authorization", tracker.Authorization)
+    }
+
+    resp, err := client.Do(req)
+
+    if (err != nil) {
+        return "", false, err
+    }
+
+    defer resp.Body.Close()
+
+    if (resp.StatusCode == http.StatusNotFound) {
+        return "", false, nil
+    } else if (resp.StatusCode != http.StatusOK) {
+        return "", false, fmt.Errorf("Bug tracker returned %s", resp.Status)
+    }
+
+    body, err := ioutil.ReadAll(resp.Body)
+
+    if (err != nil) {
+        return "", false, err
+    }
+
+    var value interface{}
+
+    err = json.Unmarshal(body, &value)
+
+    if (err != nil) {
+        return "", false, err
+    }
+
+    // Walk down to the status field
+    for _, key := range strings.Split(tracker.StatusField, ".") {
+        object, isObject := value.(map[string]interface{})
+
+        if (!isObject) {
+            return "", true, fmt.Errorf("Bug tracker's response has no %s",
+                                        tracker.StatusField)
+        }
+
+        value = object[key]
+    }
+
+    status, isString := value.(string)
+
+    if (!isString) {
+        return "", true, fmt.Errorf("Bug tracker's response has no %s",
+                                    tracker.StatusField)
+    }
+
+    return status, true, nil
+}
+
+/**
+ * Checks a bug against the bug tracker.
+ *
+ * @returns A problem with the bug, or an empty string if there isn't one.
+ */
+func CheckTracker(bug string) string {
+    status, exists, err := BugStatus(bug)
+
+    if (err != nil) {
+        // Not the review request's fault, so not worth commenting on
+        fmt.Printf("BugReviewer: Could not look up %s: %s\n", bug, err)
+        return ""
+    }
+
+    if (!exists) {
+        return "Bug " + bug + " doesn't exist"
+    }
+
+    for _, closed := range config.BugReviewer.Tracker.ClosedStatuses {
+        if (strings.EqualFold(status, closed)) {
+            return "Bug " + bug + " is already closed (" + status + ")"
+        }
+    }
+
+    return ""
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    (*wg).Done()
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Problems with the review request's bug references are added to the review
+ * body.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    var problems []string
+
+    closed := make(map[string]bool)
+
+    for _, bug := range review.Bugs_Closed {
+        bug = strings.TrimSpace(bug)
+
+        if (bug != "") {
+            closed[bug] = true
+        }
+    }
+
+    if (len(closed) == 0 && BugRequired(review)) {
+        problems = append(problems,
+                          "This review request must reference a bug")
+    }
+
+    for _, bug := range review.Bugs_Closed {
+        bug = strings.TrimSpace(bug)
+
+        if (bug == "") {
+            continue
+        }
+
+        if (!ValidBug(bug)) {
+            problems = append(problems,
+                              "\"" + bug + "\" doesn't look like a bug ID " +
+                              "(expected `" +
+                              strings.Join(config.BugReviewer.Patterns,
+                                           "` or `") +
+                              "`)")
+        } else if (config.BugReviewer.Tracker.Url != "") {
+            problem := CheckTracker(bug)
+
+            if (problem != "") {
+                problems = append(problems, problem)
+            }
+        }
+    }
+
+    if (mentionRegex != nil) {
+        mentioned := make(map[string]bool)
+
+        for _, bug := range mentionRegex.FindAllString(review.Summary, -1) {
+            if (!closed[bug] && !mentioned[bug]) {
+                problems = append(problems,
+                                  "The summary mentions " + bug + ", but " +
+                                  "it isn't in the bugs closed")
+            }
+
+            mentioned[bug] = true
+        }
+    }
+
+    if (len(problems) > 0) {
+        commentChan <- config.BugReviewer.Heading + "\n\n- " +
+                       strings.Join(problems, "\n- ") + "\n"
+    }
+
+    return nil
+}
+
+/**
+ * Compiles a regex from the config, logging it if it's bad.
+ *
+ * @returns The regex, which is nil if the expression is empty, and whether
+ *          the expression was good.
+ */
+func compile(expression string) (*regexp.Regexp, bool) {
+    if (expression == "") {
+        return nil, true
+    }
+
+    compiled, err := regexp.Compile(expression)
+
+    if (err != nil) {
+        fmt.Printf("BugReviewer: Bad regex %s: %s\n", expression, err)
+        return nil, false
+    }
+
+    return compiled, true
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.BugReviewer.Heading == "") {
+        config.BugReviewer.Heading = "**Bugs**"
+    }
+
+    if (config.BugReviewer.Tracker.StatusField == "") {
+        config.BugReviewer.Tracker.StatusField = "status"
+    }
+
+    if (config.BugReviewer.Tracker.TimeoutSec <= 0) {
+        config.BugReviewer.Tracker.TimeoutSec = 10
+    }
+
+    client.Timeout = time.Duration(config.BugReviewer.Tracker.TimeoutSec) *
+                     time.Second
+
+    for _, requirement := range config.BugReviewer.RequireFor {
+        repository, repositoryOk := compile(requirement.Repository)
+        branch, branchOk         := compile(requirement.Branch)
+
+        // A bad requirement would otherwise match everything
+        if (repositoryOk && branchOk) {
+            requirements = append(requirements, struct {
+                repository *regexp.Regexp
+                branch     *regexp.Regexp
+            }{repository, branch})
+        }
+    }
+
+    var alternatives []string
+
+    for _, pattern := range config.BugReviewer.Patterns {
+        // Bugs closed must match a pattern in full
+        compiled, ok := compile("^(?:" + pattern + ")$")
+
+        if (ok) {
+            patterns = append(patterns, compiled)
+            alternatives = append(alternatives, "(?:" + pattern + ")")
+        }
+    }
+
+    if (len(alternatives) > 0) {
+        mentionRegex, _ = compile("\\b(?:" +
+                                  strings.Join(alternatives, "|") +
+                                  ")\\b")
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
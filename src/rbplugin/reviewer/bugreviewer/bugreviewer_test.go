@@ -0,0 +1,106 @@
+/**
+ * Tests looking bugs up in a fake bug tracker.
+ */
+package main
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "testing"
+)
+
+/**
+ * Points the plugin at a fake bug tracker.
+ */
+func fakeTracker(t *testing.T) {
+    tracker := httptest.NewServer(http.HandlerFunc(
+        func(w http.ResponseWriter, r *http.Request) {
+            if (r.Header.Get("Authorization") != "Bearer abc") {
+                w.WriteHeader(http.StatusUnauthorized)
+                return
+            }
+
+            switch (strings.TrimPrefix(r.URL.Path, "/bugs/")) {
+            case "PROJ-1":
+                w.Write([]byte(`{"fields": {"status": {"name": "Open"}}}`))
+            case "PROJ-2":
+                w.Write([]byte(`{"fields": {"status": {"name": "DONE"}}}`))
+            case "PROJ-4":
+                w.Write([]byte(`{"fields": {"status": `))
+            case "PROJ-5":
+                w.Write([]byte(`{"fields": {"resolution": "Fixed"}}`))
+            case "PROJ-6":
+                w.WriteHeader(http.StatusInternalServerError)
+            default:
+                w.WriteHeader(http.StatusNotFound)
+            }
+        }))
+    t.Cleanup(tracker.Close)
+
+    config.BugReviewer.Tracker = Tracker{
+                                   Url:            tracker.URL + "/bugs/{bug}",
+                                   Authorization:  "Bearer abc",
+                                   StatusField:    "fields.status.name",
+                                   ClosedStatuses: []string{"Done", "Closed"}}
+}
+
+func TestBugStatus(t *testing.T) {
+    fakeTracker(t)
+
+    var tests = []struct {
+        bug     string
+        status  string
+        exists  bool
+        failure bool
+    }{
+        {"PROJ-1", "Open", true, false},
+        {"PROJ-2", "DONE", true, false},
+        {"PROJ-3", "", false, false},
+        {"PROJ-4", "", false, true},
+        {"PROJ-5", "", true, true},
+        {"PROJ-6", "", false, true},
+    }
+
+    for _, test := range tests {
+        status, exists, err := BugStatus(test.bug)
+
+        if (status != test.status ||
+            exists != test.exists ||
+            (err != nil) != test.failure) {
+            t.Errorf("BugStatus(%s) = %q, %t, %v; want %q, %t, error %t",
+                     test.bug,
+                     status,
+                     exists,
+                     err,
+                     test.status,
+                     test.exists,
+                     test.failure)
+        }
+    }
+}
+
+func TestCheckTracker(t *testing.T) {
+    fakeTracker(t)
+
+    var tests = []struct {
+        bug     string
+        problem string
+    }{
+        {"PROJ-1", ""},
+        {"PROJ-2", "Bug PROJ-2 is already closed (DONE)"},
+        {"PROJ-3", "Bug PROJ-3 doesn't exist"},
+        // The tracker's problems aren't the review request's
+        {"PROJ-4", ""},
+        {"PROJ-6", ""},
+    }
+
+    for _, test := range tests {
+        if problem := CheckTracker(test.bug); (problem != test.problem) {
+            t.Errorf("CheckTracker(%s) = %q, want %q",
+                     test.bug,
+                     problem,
+                     test.problem)
+        }
+    }
+}
@@ -0,0 +1,379 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "strings"
+    "sync"
+
+    "rbplugindata/goast"
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    ComplexityReviewer struct {
+        MaxComplexity int // Cyclomatic complexity
+        MaxNesting    int // Depth of nested blocks
+        MaxParams     int
+        MaxLines      int // The function's length, from its signature to its
+                          // closing brace
+        RaiseIssue    bool
+        Severity      string
+    }
+}
+
+/**
+ * How big and convoluted a function is.
+ */
+type Metrics struct {
+    Complexity int
+    Nesting    int
+    Params     int
+    Lines      int
+}
+
+/**
+ * A function, as found in a file.
+ */
+type Function struct {
+    Key       string // Identifies the function within its package, e.g.
+                     // "(*Server).Start"
+    StartLine int
+    EndLine   int
+    Metrics   Metrics
+}
+
+/**
+ * Works out how deeply blocks nest, as it walks a function's body.
+ */
+type nestingVisitor struct {
+    depth    int
+    maxDepth *int
+}
+
+var (
+    config Config
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "ComplexityReviewer"
+}
+
+/**
+ * Visits a node, going a level deeper for nodes that open a nested block.
+ */
+func (v nestingVisitor) Visit(node ast.Node) ast.Visitor {
+    var deeper nestingVisitor = nestingVisitor{depth:    v.depth + 1,
+                                               maxDepth: v.maxDepth}
+
+    switch n := node.(type) {
+    case *ast.IfStmt:
+        if (deeper.depth > *v.maxDepth) {
+            *v.maxDepth = deeper.depth
+        }
+
+        if (n.Init != nil) {
+            ast.Walk(deeper, n.Init)
+        }
+
+        ast.Walk(deeper, n.Cond)
+        ast.Walk(deeper, n.Body)
+
+        // An else-if chain doesn't nest any deeper
+        if elseIf, ok := n.Else.(*ast.IfStmt); ok {
+            ast.Walk(v, elseIf)
+        } else if (n.Else != nil) {
+            ast.Walk(deeper, n.Else)
+        }
+
+        return nil
+    case *ast.ForStmt, *ast.RangeStmt, *ast.SwitchStmt, *ast.TypeSwitchStmt,
+         *ast.SelectStmt, *ast.FuncLit:
+        if (deeper.depth > *v.maxDepth) {
+            *v.maxDepth = deeper.depth
+        }
+
+        return deeper
+    }
+
+    return v
+}
+
+/**
+ * Works out a function's cyclomatic complexity: one, plus one for each branch.
+ */
+func Complexity(body *ast.BlockStmt) int {
+    var complexity int = 1
+
+    ast.Inspect(body, func(node ast.Node) bool {
+        switch n := node.(type) {
+        case *ast.IfStmt, *ast.ForStmt, *ast.RangeStmt:
+            complexity++
+        case *ast.CaseClause:
+            // A default case isn't a branch of its own
+            if (n.List != nil) {
+                complexity++
+            }
+        case *ast.CommClause:
+            if (n.Comm != nil) {
+                complexity++
+            }
+        case *ast.BinaryExpr:
+            if (n.Op == token.LAND || n.Op == token.LOR) {
+                complexity++
+            }
+        }
+
+        return true
+    })
+
+    return complexity
+}
+
+/**
+ * Finds the functions in a Go source file, and measures them.
+ *
+ * @param filename The file's name.
+ * @param source   The file's content.
+ *
+ * @returns The functions, and whether the file could be parsed.
+ */
+func Functions(filename string, source []byte) ([]Function, bool) {
+    var functions []Function
+
+    fileSet := token.NewFileSet()
+
+    parsed, err := parser.ParseFile(fileSet, filename, source, 0)
+
+    if (err != nil) {
+        return nil, false
+    }
+
+    for _, decl := range parsed.Decls {
+        funcDecl, ok := decl.(*ast.FuncDecl)
+
+        if (!ok || funcDecl.Body == nil) {
+            continue
+        }
+
+        var function Function
+        var nesting  int = 0
+
+        function.Key = funcDecl.Name.Name
+
+        if (funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0) {
+            receiver := goast.ReceiverName(funcDecl.Recv.List[0].Type)
+
+            function.Key = "(" + receiver + ")." + function.Key
+        }
+
+        function.StartLine = fileSet.Position(funcDecl.Pos()).Line
+        function.EndLine   = fileSet.Position(funcDecl.End()).Line
+
+        ast.Walk(nestingVisitor{depth: 0, maxDepth: &nesting}, funcDecl.Body)
+
+        function.Metrics.Complexity = Complexity(funcDecl.Body)
+        function.Metrics.Nesting    = nesting
+        function.Metrics.Lines      = function.EndLine -
+                                      function.StartLine + 1
+
+        // Unnamed parameters count once each
+        for _, field := range funcDecl.Type.Params.List {
+            if (len(field.Names) == 0) {
+                function.Metrics.Params++
+            } else {
+                function.Metrics.Params += len(field.Names)
+            }
+        }
+
+        functions = append(functions, function)
+    }
+
+    return functions, true
+}
+
+/**
+ * Describes a metric that is over its limit, if it got worse.
+ *
+ * @param name     The metric's name.
+ * @param value    The metric, now.
+ * @param original The metric before the change, or -1 if not known.
+ * @param limit    The metric's limit. Zero isn't checked.
+ *
+ * @returns The description, or an empty string if there's nothing to say.
+ */
+func Describe(name string, value int, original int, limit int) string {
+    if (limit <= 0 || value <= limit || (original >= 0 && value <= original)) {
+        return ""
+    }
+
+    if (original >= 0) {
+        return fmt.Sprintf("%s went from %d to %d (the limit is %d)",
+                           name,
+                           original,
+                           value,
+                           limit)
+    }
+
+    return fmt.Sprintf("%s is %d (the limit is %d)", name, value, limit)
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Functions that overlap a change and are over a limit are commented on, on
+ * their signature line, unless the change didn't make them any worse.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!strings.HasSuffix(file.Filename, ".go") ||
+        file.Class == reviewdata.ClassGenerated) {
+        return
+    }
+
+    functions, ok := Functions(file.Filename, file.EntireFile)
+
+    if (!ok) {
+        return
+    }
+
+    originals := make(map[string]Metrics)
+
+    if (len(file.OriginalFile) > 0) {
+        originalFunctions, _ := Functions(file.Filename, file.OriginalFile)
+
+        for _, function := range originalFunctions {
+            originals[function.Key] = function.Metrics
+        }
+    }
+
+    // Right-hand line to review line, for every line and for changed lines
+    all, changed := file.ReviewLines()
+
+    limits := config.ComplexityReviewer
+
+    for _, function := range functions {
+        var firstChanged int = 0
+
+        for rhLine := function.StartLine; rhLine <= function.EndLine; rhLine++ {
+            if _, found := changed[rhLine]; found {
+                firstChanged = rhLine
+                break
+            }
+        }
+
+        if (firstChanged == 0) {
+            continue
+        }
+
+        original, known := originals[function.Key]
+
+        if (!known) {
+            original = Metrics{-1, -1, -1, -1}
+        }
+
+        var problems []string
+
+        for _, problem := range []string{
+                Describe("Cyclomatic complexity",
+                         function.Metrics.Complexity,
+                         original.Complexity,
+                         limits.MaxComplexity),
+                Describe("Nesting depth",
+                         function.Metrics.Nesting,
+                         original.Nesting,
+                         limits.MaxNesting),
+                Describe("Parameter count",
+                         function.Metrics.Params,
+                         original.Params,
+                         limits.MaxParams),
+                Describe("Length",
+                         function.Metrics.Lines,
+                         original.Lines,
+                         limits.MaxLines)} {
+            if (problem != "") {
+                problems = append(problems, problem)
+            }
+        }
+
+        if (len(problems) == 0) {
+            continue
+        }
+
+        // Comment on the signature if it's in the diff
+        line, found := all[function.StartLine]
+
+        if (!found) {
+            line = changed[firstChanged]
+        }
+
+        commentChan <- reviewdata.Comment{
+                           Line:       line,
+                           NumLines:   1,
+                           Text:       "This function is getting too big " +
+                                       "or convoluted. Consider splitting " +
+                                       "it up.\n\n- " +
+                                       strings.Join(problems, "\n- "),
+                           RaiseIssue: limits.RaiseIssue,
+                           Severity:   limits.Severity,
+                           Rule:       "complexity"}
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.ComplexityReviewer.MaxComplexity == 0) {
+        config.ComplexityReviewer.MaxComplexity = 15
+    }
+
+    if (config.ComplexityReviewer.MaxNesting == 0) {
+        config.ComplexityReviewer.MaxNesting = 4
+    }
+
+    if (config.ComplexityReviewer.MaxParams == 0) {
+        config.ComplexityReviewer.MaxParams = 6
+    }
+
+    if (config.ComplexityReviewer.MaxLines == 0) {
+        config.ComplexityReviewer.MaxLines = 80
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
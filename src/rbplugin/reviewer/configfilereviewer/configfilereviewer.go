@@ -0,0 +1,2398 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "io/ioutil"
+    "math"
+    "path"
+    "path/filepath"
+    "reflect"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "unicode/utf8"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * The kinds of node, which match json schema's types.
+ */
+const (
+    nodeObject = iota
+    nodeArray
+    nodeString
+    nodeNumber
+    nodeBool
+    nodeNull
+)
+
+/**
+ * Validates files whose names match a glob against a json schema.
+ */
+type SchemaPath struct {
+    Path   string // e.g. "/config.json" or "deploy/**/*.yaml"
+    Schema string // The schema's file, relative to the config file
+}
+
+type Config struct {
+    ConfigFileReviewer struct {
+        Schemas       []SchemaPath
+        CommentedJson []string          // Globs of json files that may have
+                                        // comments and trailing commas
+        Disabled      []string          // Rules not to check
+        Severities    map[string]string // By rule
+        RaiseIssue    bool              // Syntax errors always raise issues
+    }
+}
+
+/**
+ * A parsed value, from any of the formats that have them.
+ */
+type Node struct {
+    Kind   int
+    Value  interface{}      // string, float64, bool or nil, for scalars
+    Keys   []string         // An object's keys, in order
+    Fields map[string]*Node
+    Lines  map[string]int   // The line of each key
+    Items  []*Node
+    Line   int
+}
+
+/**
+ * A problem with a file.
+ */
+type Problem struct {
+    Rule    string
+    Line    int
+    Text    string
+    Related int    // For duplicate keys, the line of the first one
+}
+
+/**
+ * A syntax error, at the line that the parser found it on.
+ */
+type SyntaxError struct {
+    Line  int
+    Text  string
+    AtEnd bool   // Whether more text might have fixed it
+}
+
+func (e SyntaxError) Error() string {
+    return fmt.Sprintf("Line %d: %s", e.Line, e.Text)
+}
+
+/**
+ * Parses text a character at a time, keeping track of the line.
+ */
+type parser struct {
+    source   string
+    pos      int
+    line     int
+    comments bool      // Whether json may have comments and trailing commas
+    problems []Problem // Duplicate keys, which don't stop parsing
+}
+
+/**
+ * A line of yaml.
+ */
+type yamlLine struct {
+    Number int
+    Indent int
+    Text   string // Without indentation or comments
+    Raw    string
+}
+
+/**
+ * Parses yaml a line at a time, working out structure from indentation.
+ */
+type yamlParser struct {
+    lines    []yamlLine
+    i        int
+    problems []Problem
+}
+
+/**
+ * A schema, and the files that it applies to.
+ */
+type compiledSchema struct {
+    Path   *regexp.Regexp
+    Schema interface{}
+}
+
+var (
+    config Config
+
+    disabled = make(map[string]bool)
+
+    schemas       []compiledSchema
+    commentedJson []*regexp.Regexp
+
+    // Relative schemas are found relative to the config file
+    configDir = "."
+
+    defaultSeverities = map[string]string{
+        "syntax":        reviewdata.SeverityError,
+        "duplicate-key": reviewdata.SeverityWarning,
+        "schema":        reviewdata.SeverityError,
+    }
+
+    defaultCommentedJson = []string{"tsconfig*.json", "jsconfig*.json",
+                                    ".vscode/**"}
+
+    kindNames = map[int]string{
+        nodeObject: "an object",
+        nodeArray:  "an array",
+        nodeString: "a string",
+        nodeNumber: "a number",
+        nodeBool:   "a boolean",
+        nodeNull:   "null",
+    }
+
+    typeNames = map[string]string{
+        "object":  "an object",
+        "array":   "an array",
+        "string":  "a string",
+        "number":  "a number",
+        "integer": "an integer",
+        "boolean": "a boolean",
+        "null":    "null",
+    }
+
+    jsonNumberRegex = regexp.MustCompile(
+                        "^-?(0|[1-9][0-9]*)(\\.[0-9]+)?([eE][+-]?[0-9]+)?")
+
+    yamlNullRegex  = regexp.MustCompile("^(~|null|Null|NULL)$")
+    yamlBoolRegex  = regexp.MustCompile("^(true|True|TRUE|false|False|FALSE)$")
+    yamlIntRegex   = regexp.MustCompile("^[-+]?(0|[1-9][0-9]*)$")
+    yamlFloatRegex = regexp.MustCompile(
+                        "^[-+]?(\\.[0-9]+|[0-9]+(\\.[0-9]*)?)" +
+                        "([eE][-+]?[0-9]+)?$")
+    yamlBlockRegex = regexp.MustCompile("^[|>][0-9+-]*$")
+
+    tomlBareKeyRegex = regexp.MustCompile("^[A-Za-z0-9_-]+")
+    tomlIntRegex     = regexp.MustCompile(
+                        "^([-+]?(0|[1-9](_?[0-9])*)|" +
+                        "0x[0-9A-Fa-f](_?[0-9A-Fa-f])*|" +
+                        "0o[0-7](_?[0-7])*|0b[01](_?[01])*)$")
+    tomlFloatRegex   = regexp.MustCompile(
+                        "^([-+]?(0|[1-9](_?[0-9])*)(\\.[0-9](_?[0-9])*)?" +
+                        "([eE][-+]?[0-9](_?[0-9])*)?|[-+]?(inf|nan))$")
+    tomlDateRegex    = regexp.MustCompile(
+                        "^([0-9]{4}-[0-9]{2}-[0-9]{2}" +
+                        "([Tt ][0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]+)?" +
+                        "([Zz]|[-+][0-9]{2}:[0-9]{2})?)?|" +
+                        "[0-9]{2}:[0-9]{2}:[0-9]{2}(\\.[0-9]+)?)$")
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "ConfigFileReviewer"
+}
+
+/**
+ * Turns a glob into a regex. A glob without a "/" matches the file's name in
+ * any directory, and one starting with "/" matches from the top of the
+ * repository. "**" matches any number of directories.
+ */
+func Glob(glob string) (*regexp.Regexp, error) {
+    var expression string = "^"
+
+    if (strings.HasPrefix(glob, "/")) {
+        glob = glob[1:]
+    } else if (!strings.Contains(strings.TrimSuffix(glob, "/"), "/")) {
+        expression = "(^|/)"
+    }
+
+    for i := 0; (i < len(glob)); i++ {
+        switch {
+        case (strings.HasPrefix(glob[i:], "**/")):
+            expression += "(.*/)?"
+            i += 2
+        case (strings.HasPrefix(glob[i:], "**")):
+            expression += ".*"
+            i++
+        case (glob[i] == '*'):
+            expression += "[^/]*"
+        case (glob[i] == '?'):
+            expression += "[^/]"
+        default:
+            expression += regexp.QuoteMeta(glob[i:i + 1])
+        }
+    }
+
+    return regexp.Compile(expression + "$")
+}
+
+/**
+ * Works out a file's format from its extension.
+ *
+ * @returns "json", "yaml", "toml", "xml" or "" for other files.
+ */
+func Format(filename string) string {
+    switch (strings.ToLower(path.Ext(filename))) {
+    case ".json":
+        return "json"
+    case ".yaml", ".yml":
+        return "yaml"
+    case ".toml":
+        return "toml"
+    case ".xml":
+        return "xml"
+    }
+
+    return ""
+}
+
+/**
+ * Makes an empty object.
+ */
+func newObject(line int) *Node {
+    return &Node{Kind:   nodeObject,
+                 Fields: make(map[string]*Node),
+                 Lines:  make(map[string]int),
+                 Line:   line}
+}
+
+/**
+ * Sets an object's key, noting if it's already set. The last value wins.
+ */
+func setKey(node     *Node,
+            key      string,
+            line     int,
+            value    *Node,
+            problems *[]Problem) {
+    if _, found := node.Fields[key]; (found) {
+        *problems = append(*problems,
+                           Problem{"duplicate-key", line,
+                                   "`" + key + "` is set more than once, " +
+                                   "and only one of the values is used",
+                                   node.Lines[key]})
+    } else {
+        node.Keys = append(node.Keys, key)
+    }
+
+    node.Fields[key] = value
+    node.Lines[key]  = line
+}
+
+/**
+ * Returns the next character, or 0 at the end.
+ */
+func (p *parser) peek() byte {
+    if (p.pos >= len(p.source)) {
+        return 0
+    }
+
+    return p.source[p.pos]
+}
+
+/**
+ * Moves on by some characters.
+ */
+func (p *parser) advance(count int) {
+    for ; (count > 0 && p.pos < len(p.source)); count-- {
+        if (p.source[p.pos] == '\n') {
+            p.line++
+        }
+
+        p.pos++
+    }
+}
+
+/**
+ * Describes the next character, for an error.
+ */
+func (p *parser) describe() string {
+    switch (p.peek()) {
+    case 0:
+        return "the end of the file"
+    case '\n':
+        return "the end of the line"
+    case '`':
+        return "a backtick"
+    }
+
+    r, _ := utf8.DecodeRuneInString(p.source[p.pos:])
+
+    return "`" + string(r) + "`"
+}
+
+/**
+ * Makes a syntax error at the current line.
+ */
+func (p *parser) fail(format string, args ...interface{}) error {
+    return SyntaxError{p.line, fmt.Sprintf(format, args...),
+                       p.pos >= len(p.source)}
+}
+
+/**
+ * Skips json whitespace, and comments where they're allowed.
+ */
+func (p *parser) jsonSpace() error {
+    for (p.pos < len(p.source)) {
+        var rest string = p.source[p.pos:]
+
+        switch {
+        case (strings.IndexByte(" \t\r\n", rest[0]) >= 0):
+            p.advance(1)
+        case (p.comments && strings.HasPrefix(rest, "//")):
+            for (p.peek() != 0 && p.peek() != '\n') {
+                p.advance(1)
+            }
+        case (p.comments && strings.HasPrefix(rest, "/*")):
+            end := strings.Index(rest[2:], "*/")
+
+            if (end < 0) {
+                return p.fail("The comment isn't closed")
+            }
+
+            p.advance(end + 4)
+        default:
+            return nil
+        }
+    }
+
+    return nil
+}
+
+/**
+ * Parses a quoted json string.
+ */
+func (p *parser) jsonString() (string, error) {
+    var value strings.Builder
+    var line  int = p.line
+
+    escapes := map[byte]string{'"': "\"", '\\': "\\", '/': "/", 'b': "\b",
+                               'f': "\f", 'n': "\n", 'r': "\r", 't': "\t"}
+
+    p.advance(1)
+
+    for {
+        var c byte = p.peek()
+
+        switch {
+        case (c == 0):
+            return "", SyntaxError{line, "The string isn't closed", true}
+        case (c == '"'):
+            p.advance(1)
+            return value.String(), nil
+        case (c < 0x20):
+            return "", p.fail("Strings can't have control characters or " +
+                              "line breaks in; use an escape such as `\\n`")
+        case (c == '\\'):
+            p.advance(1)
+
+            var escape byte = p.peek()
+
+            if (escape == 'u') {
+                code, err := strconv.ParseUint(
+                                p.source[p.pos + 1:
+                                         smaller(p.pos + 5, len(p.source))],
+                                16, 16)
+
+                if (err != nil) {
+                    return "", p.fail("`\\u` must be followed by four hex " +
+                                      "digits")
+                }
+
+                value.WriteRune(rune(code))
+                p.advance(5)
+            } else if (escapes[escape] != "") {
+                value.WriteString(escapes[escape])
+                p.advance(1)
+            } else {
+                return "", p.fail("%s can't be escaped", p.describe())
+            }
+        default:
+            value.WriteByte(c)
+            p.advance(1)
+        }
+    }
+}
+
+/**
+ * Parses any json value.
+ */
+func (p *parser) jsonValue() (*Node, error) {
+    if err := p.jsonSpace(); (err != nil) {
+        return nil, err
+    }
+
+    var line int    = p.line
+    var rest string = p.source[p.pos:]
+
+    switch {
+    case (p.peek() == '{'):
+        return p.jsonObject()
+    case (p.peek() == '['):
+        return p.jsonArray()
+    case (p.peek() == '"'):
+        value, err := p.jsonString()
+
+        return &Node{Kind: nodeString, Value: value, Line: line}, err
+    case (jsonNumberRegex.MatchString(rest)):
+        number := jsonNumberRegex.FindString(rest)
+        value, _ := strconv.ParseFloat(number, 64)
+
+        p.advance(len(number))
+
+        return &Node{Kind: nodeNumber, Value: value, Line: line}, nil
+    case (strings.HasPrefix(rest, "true")):
+        p.advance(4)
+        return &Node{Kind: nodeBool, Value: true, Line: line}, nil
+    case (strings.HasPrefix(rest, "false")):
+        p.advance(5)
+        return &Node{Kind: nodeBool, Value: false, Line: line}, nil
+    case (strings.HasPrefix(rest, "null")):
+        p.advance(4)
+        return &Node{Kind: nodeNull, Line: line}, nil
+    case (p.peek() == '\''):
+        return nil, p.fail("Strings must be in double quotes")
+    }
+
+    return nil, p.fail("Expected a value, but found %s", p.describe())
+}
+
+/**
+ * Parses a json object.
+ */
+func (p *parser) jsonObject() (*Node, error) {
+    var node *Node = newObject(p.line)
+
+    p.advance(1)
+
+    for {
+        if err := p.jsonSpace(); (err != nil) {
+            return nil, err
+        }
+
+        if (p.peek() == '}' && (len(node.Keys) == 0 || p.comments)) {
+            p.advance(1)
+            return node, nil
+        }
+
+        if (p.peek() == '}') {
+            return nil, p.fail("Trailing commas aren't allowed in json")
+        }
+
+        if (p.peek() != '"') {
+            return nil, p.fail("Expected a key in double quotes, but found %s",
+                               p.describe())
+        }
+
+        var line int = p.line
+
+        key, err := p.jsonString()
+
+        if (err == nil) {
+            err = p.jsonSpace()
+        }
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        if (p.peek() != ':') {
+            return nil, p.fail("Expected `:` after `%s`, but found %s", key,
+                               p.describe())
+        }
+
+        p.advance(1)
+
+        value, err := p.jsonValue()
+
+        if (err == nil) {
+            err = p.jsonSpace()
+        }
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        setKey(node, key, line, value, &p.problems)
+
+        switch (p.peek()) {
+        case ',':
+            p.advance(1)
+        case '}':
+            p.advance(1)
+            return node, nil
+        default:
+            return nil, p.fail("Expected `,` or `}`, but found %s",
+                               p.describe())
+        }
+    }
+}
+
+/**
+ * Parses a json array.
+ */
+func (p *parser) jsonArray() (*Node, error) {
+    var node *Node = &Node{Kind: nodeArray, Line: p.line}
+
+    p.advance(1)
+
+    for {
+        if err := p.jsonSpace(); (err != nil) {
+            return nil, err
+        }
+
+        if (p.peek() == ']' && (len(node.Items) == 0 || p.comments)) {
+            p.advance(1)
+            return node, nil
+        }
+
+        if (p.peek() == ']') {
+            return nil, p.fail("Trailing commas aren't allowed in json")
+        }
+
+        value, err := p.jsonValue()
+
+        if (err == nil) {
+            err = p.jsonSpace()
+        }
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        node.Items = append(node.Items, value)
+
+        switch (p.peek()) {
+        case ',':
+            p.advance(1)
+        case ']':
+            p.advance(1)
+            return node, nil
+        default:
+            return nil, p.fail("Expected `,` or `]`, but found %s",
+                               p.describe())
+        }
+    }
+}
+
+/**
+ * Parses a json document.
+ *
+ * @param comments Whether to allow comments and trailing commas, as
+ *                 tsconfig.json and the like do.
+ */
+func ParseJson(source string, comments bool) ([]*Node, []Problem, error) {
+    var p parser = parser{source: source, line: 1, comments: comments}
+
+    node, err := p.jsonValue()
+
+    if (err == nil) {
+        err = p.jsonSpace()
+    }
+
+    if (err == nil && p.pos < len(p.source)) {
+        err = p.fail("Expected the end of the file, but found %s",
+                     p.describe())
+    }
+
+    return []*Node{node}, p.problems, err
+}
+
+/**
+ * Works out what a plain yaml scalar is.
+ */
+func yamlScalar(text string, line int) *Node {
+    switch {
+    case (text == "" || yamlNullRegex.MatchString(text)):
+        return &Node{Kind: nodeNull, Line: line}
+    case (yamlBoolRegex.MatchString(text)):
+        return &Node{Kind:  nodeBool,
+                     Value: strings.ToLower(text) == "true",
+                     Line:  line}
+    case (yamlIntRegex.MatchString(text) || yamlFloatRegex.MatchString(text)):
+        value, _ := strconv.ParseFloat(text, 64)
+
+        return &Node{Kind: nodeNumber, Value: value, Line: line}
+    }
+
+    return &Node{Kind: nodeString, Value: text, Line: line}
+}
+
+/**
+ * Parses a quoted yaml string.
+ */
+func (p *parser) yamlQuoted() (string, error) {
+    var value strings.Builder
+    var quote byte = p.peek()
+    var line  int  = p.line
+
+    escapes := map[byte]string{'"': "\"", '\\': "\\", '/': "/", 'b': "\b",
+                               'f': "\f", 'n': "\n", 'r': "\r", 't': "\t",
+                               '0': "\x00", ' ': " ", 'e': "\x1b"}
+
+    p.advance(1)
+
+    for {
+        var c byte = p.peek()
+
+        switch {
+        case (c == 0):
+            return "", SyntaxError{line, "The string isn't closed", true}
+        case (c == quote && quote == '\'' &&
+              strings.HasPrefix(p.source[p.pos:], "''")):
+            value.WriteByte('\'')
+            p.advance(2)
+        case (c == quote):
+            p.advance(1)
+            return value.String(), nil
+        case (c == '\n'):
+            // Line breaks in quoted strings fold into spaces
+            value.WriteByte(' ')
+            p.advance(1)
+
+            for (p.peek() == ' ' || p.peek() == '\t') {
+                p.advance(1)
+            }
+        case (c == '\\' && quote == '"'):
+            p.advance(1)
+
+            var escape byte = p.peek()
+
+            if (escapes[escape] != "") {
+                value.WriteString(escapes[escape])
+                p.advance(1)
+            } else if (escape == 'x' || escape == 'u' || escape == 'U') {
+                var digits int = map[byte]int{'x': 2, 'u': 4, 'U': 8}[escape]
+
+                code, err := strconv.ParseUint(
+                                p.source[p.pos + 1:
+                                         smaller(p.pos + 1 + digits,
+                                             len(p.source))],
+                                16, 32)
+
+                if (err != nil) {
+                    return "", p.fail("`\\%c` must be followed by %d hex " +
+                                      "digits", escape, digits)
+                }
+
+                value.WriteRune(rune(code))
+                p.advance(digits + 1)
+            } else {
+                return "", p.fail("%s can't be escaped", p.describe())
+            }
+        default:
+            value.WriteByte(c)
+            p.advance(1)
+        }
+    }
+}
+
+/**
+ * Parses a yaml flow value, such as `[a, {b: c}]`.
+ */
+func (p *parser) yamlFlow() (*Node, error) {
+    for (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n') {
+        p.advance(1)
+    }
+
+    var line int = p.line
+
+    switch (p.peek()) {
+    case '[', '{':
+        var node    *Node
+        var opening byte = p.peek()
+        var closing byte = map[byte]byte{'[': ']', '{': '}'}[opening]
+
+        if (opening == '{') {
+            node = newObject(line)
+        } else {
+            node = &Node{Kind: nodeArray, Line: line}
+        }
+
+        p.advance(1)
+
+        for {
+            for (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n') {
+                p.advance(1)
+            }
+
+            if (p.peek() == 0) {
+                return nil, SyntaxError{line,
+                                        fmt.Sprintf("The `%c` isn't closed",
+                                                    opening),
+                                        true}
+            }
+
+            if (p.peek() == closing) {
+                p.advance(1)
+                return node, nil
+            }
+
+            var keyLine int = p.line
+
+            value, err := p.yamlFlow()
+
+            if (err != nil) {
+                return nil, err
+            }
+
+            for (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n') {
+                p.advance(1)
+            }
+
+            if (opening == '{') {
+                var key string = fmt.Sprint(value.Value)
+
+                if (value.Kind == nodeObject || value.Kind == nodeArray) {
+                    return nil, SyntaxError{keyLine,
+                                            "Keys must be scalars", false}
+                }
+
+                value = &Node{Kind: nodeNull, Line: keyLine}
+
+                if (p.peek() == ':') {
+                    p.advance(1)
+
+                    if value, err = p.yamlFlow(); (err != nil) {
+                        return nil, err
+                    }
+                }
+
+                setKey(node, key, keyLine, value, &p.problems)
+            } else if (p.peek() == ':') {
+                // A key and value in a sequence is a mapping of just them
+                var pair *Node  = newObject(keyLine)
+                var key  string = fmt.Sprint(value.Value)
+
+                if (value.Kind == nodeObject || value.Kind == nodeArray) {
+                    return nil, SyntaxError{keyLine,
+                                            "Keys must be scalars", false}
+                }
+
+                p.advance(1)
+
+                item, err := p.yamlFlow()
+
+                if (err != nil) {
+                    return nil, err
+                }
+
+                setKey(pair, key, keyLine, item, &p.problems)
+
+                node.Items = append(node.Items, pair)
+            } else {
+                node.Items = append(node.Items, value)
+            }
+
+            for (p.peek() == ' ' || p.peek() == '\t' || p.peek() == '\n') {
+                p.advance(1)
+            }
+
+            if (p.peek() == ',') {
+                p.advance(1)
+            } else if (p.peek() != closing && p.peek() != 0) {
+                return nil, p.fail("Expected `,` or `%c`, but found %s",
+                                   closing, p.describe())
+            }
+        }
+    case '"', '\'':
+        value, err := p.yamlQuoted()
+
+        return &Node{Kind: nodeString, Value: value, Line: line}, err
+    }
+
+    var start int = p.pos
+
+    // Plain scalars end at flow indicators, and at ": " for keys
+    for (p.peek() != 0 && strings.IndexByte(",[]{}\n", p.peek()) < 0 &&
+         !(p.peek() == ':' &&
+           (p.pos + 1 >= len(p.source) ||
+            strings.IndexByte(" ,]}\n", p.source[p.pos + 1]) >= 0))) {
+        p.advance(1)
+    }
+
+    return yamlScalar(strings.TrimSpace(p.source[start:p.pos]), line), nil
+}
+
+/**
+ * Removes a comment from a line of yaml, leaving any # in quotes.
+ */
+func yamlStripComment(text string) string {
+    var quote byte
+
+    for i := 0; (i < len(text)); i++ {
+        var c    byte = text[i]
+        var prev byte = ' '
+
+        if (i > 0) {
+            prev = text[i - 1]
+        }
+
+        switch {
+        case (quote != 0):
+            if (c == '\\' && quote == '"') {
+                i++
+            } else if (c == quote) {
+                quote = 0
+            }
+        case ((c == '"' || c == '\'') && strings.IndexByte(" [{,:", prev) >= 0):
+            quote = c
+        case (c == '#' && (prev == ' ' || prev == '\t' || i == 0)):
+            return strings.TrimRight(text[:i], " \t")
+        }
+    }
+
+    return strings.TrimRight(text, " \t")
+}
+
+/**
+ * Works out whether a line of yaml is a list item.
+ */
+func yamlIsItem(text string) bool {
+    return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+/**
+ * Works out whether a line of yaml starts or ends a document.
+ */
+func yamlIsMarker(line yamlLine) bool {
+    return line.Indent == 0 &&
+           (line.Text == "---" || line.Text == "..." ||
+            strings.HasPrefix(line.Text, "--- "))
+}
+
+/**
+ * Splits a line of yaml into a key and its value.
+ *
+ * @returns The key, the rest of the line, and whether the line is a key at
+ *          all.
+ */
+func yamlSplitKey(text string, line int) (string, string, bool, error) {
+    var key  string
+    var rest string
+
+    if (text == "" || strings.IndexByte("[{?|>*&!", text[0]) >= 0) {
+        return "", "", false, nil
+    }
+
+    if (text[0] == '"' || text[0] == '\'') {
+        var p parser = parser{source: text, line: line}
+
+        quoted, err := p.yamlQuoted()
+
+        if (err != nil) {
+            return "", "", false, err
+        }
+
+        rest = strings.TrimLeft(text[p.pos:], " ")
+
+        if (rest != ":" && !strings.HasPrefix(rest, ": ")) {
+            return "", "", false, nil
+        }
+
+        return quoted, strings.TrimSpace(rest[1:]), true, nil
+    }
+
+    index := strings.Index(text, ": ")
+
+    if (index < 0 && strings.HasSuffix(text, ":")) {
+        index = len(text) - 1
+    }
+
+    if (index < 0) {
+        return "", "", false, nil
+    }
+
+    key  = strings.TrimSpace(text[:index])
+    rest = strings.TrimSpace(text[index + 1:])
+
+    return key, rest, true, nil
+}
+
+/**
+ * Returns whether there are lines left, skipping blank ones.
+ */
+func (p *yamlParser) more() bool {
+    for (p.i < len(p.lines) && p.lines[p.i].Text == "") {
+        p.i++
+    }
+
+    return p.i < len(p.lines)
+}
+
+/**
+ * Returns the current line, which must be indented with spaces.
+ */
+func (p *yamlParser) current() (yamlLine, error) {
+    var line yamlLine = p.lines[p.i]
+
+    if (strings.HasPrefix(line.Text, "\t")) {
+        return line, SyntaxError{line.Number,
+                                 "Tabs can't be used to indent yaml", false}
+    }
+
+    return line, nil
+}
+
+/**
+ * Parses the block starting at the current line.
+ */
+func (p *yamlParser) block(parent int) (*Node, error) {
+    line, err := p.current()
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    if (yamlIsItem(line.Text)) {
+        return p.sequence(line.Indent)
+    }
+
+    _, _, isKey, err := yamlSplitKey(line.Text, line.Number)
+
+    if (err != nil) {
+        return nil, err
+    }
+
+    if (isKey) {
+        return p.mapping(line.Indent)
+    }
+
+    p.i++
+
+    return p.inline(parent, line.Text, line.Number)
+}
+
+/**
+ * Parses a block sequence.
+ */
+func (p *yamlParser) sequence(indent int) (*Node, error) {
+    var node *Node = &Node{Kind: nodeArray, Line: p.lines[p.i].Number}
+
+    for (p.more()) {
+        line, err := p.current()
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        if (line.Indent != indent || !yamlIsItem(line.Text) ||
+            yamlIsMarker(line)) {
+            break
+        }
+
+        var item *Node
+        var rest string = strings.TrimLeft(line.Text[1:], " ")
+
+        if (rest == "") {
+            p.i++
+
+            if (p.more() && p.lines[p.i].Indent > indent) {
+                item, err = p.block(indent)
+            } else {
+                item = &Node{Kind: nodeNull, Line: line.Number}
+            }
+        } else {
+            // The rest of the line is a block, indented to where it starts
+            p.lines[p.i].Indent += len(line.Text) - len(rest)
+            p.lines[p.i].Text    = rest
+
+            item, err = p.block(indent)
+        }
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        node.Items = append(node.Items, item)
+    }
+
+    return node, nil
+}
+
+/**
+ * Parses a block mapping.
+ */
+func (p *yamlParser) mapping(indent int) (*Node, error) {
+    var node *Node = newObject(p.lines[p.i].Number)
+
+    for (p.more()) {
+        line, err := p.current()
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        if (line.Indent < indent || yamlIsMarker(line)) {
+            break
+        }
+
+        if (line.Indent > indent) {
+            return nil, SyntaxError{line.Number,
+                                    "The indentation doesn't match the " +
+                                    "lines before", false}
+        }
+
+        if (yamlIsItem(line.Text)) {
+            return nil, SyntaxError{line.Number,
+                                    "Expected `key: value`, but found a " +
+                                    "list item", false}
+        }
+
+        key, rest, isKey, err := yamlSplitKey(line.Text, line.Number)
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        if (!isKey) {
+            return nil, SyntaxError{line.Number,
+                                    "Expected `key: value`", false}
+        }
+
+        p.i++
+
+        value, err := p.inline(indent, rest, line.Number)
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        // "<<" merges in another mapping, so may be given more than once
+        if (key == "<<") {
+            continue
+        }
+
+        setKey(node, key, line.Number, value, &p.problems)
+    }
+
+    return node, nil
+}
+
+/**
+ * Parses the value after a key or list item, which may carry on over the
+ * lines that are indented more than its parent.
+ *
+ * @param parent The indentation of the key or list item.
+ */
+func (p *yamlParser) inline(parent int, text string, line int) (*Node, error) {
+    // Anchors and tags don't change whether the value is valid
+    for (text != "" && (text[0] == '&' || text[0] == '!')) {
+        if space := strings.IndexByte(text, ' '); (space >= 0) {
+            text = strings.TrimLeft(text[space:], " ")
+        } else {
+            text = ""
+        }
+    }
+
+    continues := func() bool {
+        return p.more() && p.lines[p.i].Indent > parent &&
+               !yamlIsMarker(p.lines[p.i])
+    }
+
+    // A flow value's closing bracket may line up with its key
+    closes := func() bool {
+        return p.more() && p.lines[p.i].Indent == parent &&
+               strings.IndexByte("]}", p.lines[p.i].Text[0]) >= 0
+    }
+
+    switch {
+    case (text == ""):
+        if (p.more() && !yamlIsMarker(p.lines[p.i]) &&
+            (p.lines[p.i].Indent > parent ||
+                         (p.lines[p.i].Indent == parent &&
+                          yamlIsItem(p.lines[p.i].Text)))) {
+            return p.block(parent)
+        }
+
+        return &Node{Kind: nodeNull, Line: line}, nil
+    case (yamlBlockRegex.MatchString(text)):
+        var lines []string
+        var indent int = -1
+
+        // Block scalars keep everything, including blank lines and #
+        for ; (p.i < len(p.lines)); p.i++ {
+            var next yamlLine = p.lines[p.i]
+
+            if (strings.TrimSpace(next.Raw) == "") {
+                lines = append(lines, "")
+                continue
+            }
+
+            if (next.Indent <= parent || yamlIsMarker(next)) {
+                break
+            }
+
+            if (indent < 0) {
+                indent = next.Indent
+            }
+
+            lines = append(lines, next.Raw[smaller(indent, next.Indent):])
+        }
+
+        return &Node{Kind:  nodeString,
+                     Value: strings.Join(lines, "\n"),
+                     Line:  line}, nil
+    case (text[0] == '[' || text[0] == '{' || text[0] == '"' ||
+          text[0] == '\''):
+        // Flow values and quoted strings can carry on over several lines
+        for {
+            var flow parser = parser{source: text, line: line}
+
+            node, err := flow.yamlFlow()
+
+            if (err == nil) {
+                rest := strings.TrimSpace(flow.source[flow.pos:])
+
+                if (rest != "") {
+                    return nil, flow.fail("Expected the end of the value, " +
+                                          "but found `%s`", rest)
+                }
+
+                p.problems = append(p.problems, flow.problems...)
+
+                return node, nil
+            }
+
+            if (!err.(SyntaxError).AtEnd || !(continues() || closes())) {
+                return nil, err
+            }
+
+            text += "\n" + p.lines[p.i].Text
+            p.i++
+        }
+    case (text[0] == '*'):
+        return &Node{Kind: nodeString, Value: text, Line: line}, nil
+    case (strings.Contains(text, ": ") || strings.HasSuffix(text, ":")):
+        return nil, SyntaxError{line,
+                                "A value can't be a mapping on the same " +
+                                "line as its key", false}
+    }
+
+    // Plain scalars fold over lines
+    for (continues()) {
+        next, err := p.current()
+
+        if (err != nil) {
+            return nil, err
+        }
+
+        if (strings.Contains(next.Text, ": ") ||
+            strings.HasSuffix(next.Text, ":")) {
+            return nil, SyntaxError{next.Number,
+                                    "The indentation doesn't match the " +
+                                    "lines before", false}
+        }
+
+        text += " " + next.Text
+        p.i++
+    }
+
+    return yamlScalar(text, line), nil
+}
+
+/**
+ * Parses a yaml stream.
+ *
+ * @returns A node for each document.
+ */
+func ParseYaml(source string) ([]*Node, []Problem, error) {
+    var p     yamlParser
+    var nodes []*Node
+
+    for i, raw := range strings.Split(source, "\n") {
+        raw = strings.TrimRight(raw, "\r")
+
+        trimmed := strings.TrimLeft(raw, " ")
+
+        p.lines = append(p.lines,
+                         yamlLine{Number: i + 1,
+                                  Indent: len(raw) - len(trimmed),
+                                  Text:   yamlStripComment(trimmed),
+                                  Raw:    raw})
+    }
+
+    for (p.more()) {
+        line, err := p.current()
+
+        if (err != nil) {
+            return nodes, p.problems, err
+        }
+
+        switch {
+        case (strings.HasPrefix(line.Text, "%") || line.Text == "..." ||
+              line.Text == "---"):
+            p.i++
+            continue
+        case (yamlIsMarker(line)):
+            p.i++
+
+            node, err := p.inline(-1, strings.TrimSpace(line.Text[4:]),
+                                  line.Number)
+
+            if (err != nil) {
+                return nodes, p.problems, err
+            }
+
+            nodes = append(nodes, node)
+            continue
+        }
+
+        node, err := p.block(-1)
+
+        if (err != nil) {
+            return nodes, p.problems, err
+        }
+
+        nodes = append(nodes, node)
+
+        // Anything left that isn't a new document is out of place
+        if (p.more() && !yamlIsMarker(p.lines[p.i])) {
+            return nodes, p.problems,
+                   SyntaxError{p.lines[p.i].Number,
+                               "The indentation doesn't match the lines " +
+                               "before", false}
+        }
+    }
+
+    return nodes, p.problems, nil
+}
+
+/**
+ * Skips toml spaces and tabs, and optionally a comment.
+ */
+func (p *parser) tomlSpace(comments bool) {
+    for (p.peek() == ' ' || p.peek() == '\t') {
+        p.advance(1)
+    }
+
+    if (comments && p.peek() == '#') {
+        for (p.peek() != 0 && p.peek() != '\n') {
+            p.advance(1)
+        }
+    }
+}
+
+/**
+ * Checks that nothing but a comment is left on the line, and moves past it.
+ */
+func (p *parser) tomlEndLine() error {
+    p.tomlSpace(true)
+
+    if (p.peek() == '\r') {
+        p.advance(1)
+    }
+
+    if (p.peek() != 0 && p.peek() != '\n') {
+        return p.fail("Expected the end of the line, but found %s",
+                      p.describe())
+    }
+
+    p.advance(1)
+
+    return nil
+}
+
+/**
+ * Parses a toml key, which may be dotted.
+ */
+func (p *parser) tomlKey() ([]string, error) {
+    var keys []string
+
+    for {
+        p.tomlSpace(false)
+
+        switch {
+        case (p.peek() == '"'):
+            key, err := p.tomlBasic("\"")
+
+            if (err != nil) {
+                return nil, err
+            }
+
+            keys = append(keys, key)
+        case (p.peek() == '\''):
+            end := strings.IndexAny(p.source[p.pos + 1:], "'\n")
+
+            if (end < 0 || p.source[p.pos + 1 + end] != '\'') {
+                return nil, p.fail("The key's quote isn't closed")
+            }
+
+            keys = append(keys, p.source[p.pos + 1:p.pos + 1 + end])
+            p.advance(end + 2)
+        default:
+            key := tomlBareKeyRegex.FindString(p.source[p.pos:])
+
+            if (key == "") {
+                return nil, p.fail("Expected a key, but found %s",
+                                   p.describe())
+            }
+
+            keys = append(keys, key)
+            p.advance(len(key))
+        }
+
+        p.tomlSpace(false)
+
+        if (p.peek() != '.') {
+            return keys, nil
+        }
+
+        p.advance(1)
+    }
+}
+
+/**
+ * Parses a toml basic string, in which backslashes start escapes.
+ *
+ * @param quote The string's quotes: one double quote, or three for a string
+ *              which may carry on over lines.
+ */
+func (p *parser) tomlBasic(quote string) (string, error) {
+    var value strings.Builder
+    var line  int = p.line
+
+    escapes := map[byte]string{'"': "\"", '\\': "\\", 'b': "\b",
+                               'f': "\f", 'n': "\n", 'r': "\r", 't': "\t",
+                               'e': "\x1b"}
+
+    p.advance(len(quote))
+
+    // A line break straight after the opening quotes isn't part of the string
+    if (len(quote) == 3 && strings.HasPrefix(p.source[p.pos:], "\r\n")) {
+        p.advance(2)
+    } else if (len(quote) == 3 && p.peek() == '\n') {
+        p.advance(1)
+    }
+
+    for {
+        var c    byte   = p.peek()
+        var rest string = p.source[p.pos:]
+
+        switch {
+        case (c == 0):
+            return "", SyntaxError{line, "The string isn't closed", true}
+        case (strings.HasPrefix(rest, quote)):
+            var extra int = 0
+
+            // Up to two quotes may come straight before the closing ones
+            for (len(quote) == 3 && extra < 2 &&
+                 strings.HasPrefix(rest[extra + 1:], quote)) {
+                extra++
+            }
+
+            value.WriteString(rest[:extra])
+            p.advance(extra + len(quote))
+
+            return value.String(), nil
+        case ((c == '\n' || c == '\r') && len(quote) == 1):
+            return "", p.fail("Strings can't have line breaks in; use " +
+                              "`\\n`, or a multi-line string")
+        case ((c < 0x20 && c != '\t' && c != '\n' && c != '\r') ||
+              c == 0x7f):
+            return "", p.fail("Strings can't have control characters in; " +
+                              "use an escape such as `\\u0000`")
+        case (c == '\\'):
+            p.advance(1)
+
+            var escape  byte = p.peek()
+            var lineEnd int  = strings.IndexByte(p.source[p.pos:], '\n')
+
+            if (escapes[escape] != "") {
+                value.WriteString(escapes[escape])
+                p.advance(1)
+            } else if (escape == 'u' || escape == 'U') {
+                var digits int = map[byte]int{'u': 4, 'U': 8}[escape]
+
+                code, err := strconv.ParseUint(
+                                p.source[p.pos + 1:
+                                         smaller(p.pos + 1 + digits,
+                                             len(p.source))],
+                                16, 32)
+
+                if (err != nil) {
+                    return "", p.fail("`\\%c` must be followed by %d hex " +
+                                      "digits", escape, digits)
+                }
+
+                if (!utf8.ValidRune(rune(code))) {
+                    return "", p.fail("`\\%c` must be followed by a " +
+                                      "Unicode character's code", escape)
+                }
+
+                value.WriteRune(rune(code))
+                p.advance(digits + 1)
+            } else if (len(quote) == 3 && lineEnd >= 0 &&
+                       strings.Trim(p.source[p.pos:p.pos + lineEnd],
+                                    " \t\r") == "") {
+                // A backslash at the end of a line joins it to the next
+                for (strings.IndexByte(" \t\r\n", p.peek()) >= 0) {
+                    p.advance(1)
+                }
+            } else {
+                return "", p.fail("%s can't be escaped", p.describe())
+            }
+        default:
+            value.WriteByte(c)
+            p.advance(1)
+        }
+    }
+}
+
+/**
+ * Parses a toml string, of any of the four kinds.
+ */
+func (p *parser) tomlString() (string, error) {
+    var line  int    = p.line
+    var rest  string = p.source[p.pos:]
+    var quote string = rest[:1]
+
+    if (strings.HasPrefix(rest, "\"\"\"") || strings.HasPrefix(rest, "'''")) {
+        quote = rest[:3]
+    }
+
+    if (quote == "\"" || quote == "\"\"\"") {
+        return p.tomlBasic(quote)
+    }
+
+    if (quote == "'") {
+        end := strings.IndexAny(rest[1:], "'\n")
+
+        if (end < 0 || rest[1 + end] != '\'') {
+            return "", SyntaxError{line, "The string isn't closed",
+                                   end < 0}
+        }
+
+        p.advance(end + 2)
+
+        return rest[1:1 + end], nil
+    }
+
+    end := strings.Index(rest[3:], quote)
+
+    if (end < 0) {
+        return "", SyntaxError{line, "The string isn't closed", true}
+    }
+
+    // Up to two quotes may come straight before the closing ones
+    for (3 + end + 3 < len(rest) && rest[3 + end + 3] == quote[0] &&
+         end < len(rest)) {
+        end++
+    }
+
+    p.advance(end + 6)
+
+    return strings.TrimPrefix(rest[3:3 + end], "\n"), nil
+}
+
+/**
+ * Parses a toml value.
+ */
+func (p *parser) tomlValue() (*Node, error) {
+    var line int = p.line
+
+    switch (p.peek()) {
+    case '"', '\'':
+        value, err := p.tomlString()
+
+        return &Node{Kind: nodeString, Value: value, Line: line}, err
+    case '[':
+        var node *Node = &Node{Kind: nodeArray, Line: line}
+
+        p.advance(1)
+
+        for {
+            // Arrays may go over lines, with comments in
+            for (strings.IndexByte(" \t\r\n#", p.peek()) >= 0 &&
+                 p.peek() != 0) {
+                p.tomlSpace(true)
+
+                if (p.peek() == '\n' || p.peek() == '\r') {
+                    p.advance(1)
+                }
+            }
+
+            if (p.peek() == ']') {
+                p.advance(1)
+                return node, nil
+            }
+
+            if (p.peek() == 0) {
+                return nil, SyntaxError{line, "The `[` isn't closed", true}
+            }
+
+            value, err := p.tomlValue()
+
+            if (err != nil) {
+                return nil, err
+            }
+
+            node.Items = append(node.Items, value)
+
+            for (strings.IndexByte(" \t\r\n#", p.peek()) >= 0 &&
+                 p.peek() != 0) {
+                p.tomlSpace(true)
+
+                if (p.peek() == '\n' || p.peek() == '\r') {
+                    p.advance(1)
+                }
+            }
+
+            if (p.peek() == ',') {
+                p.advance(1)
+            } else if (p.peek() != ']') {
+                return nil, p.fail("Expected `,` or `]`, but found %s",
+                                   p.describe())
+            }
+        }
+    case '{':
+        var node *Node = newObject(line)
+
+        p.advance(1)
+        p.tomlSpace(false)
+
+        if (p.peek() == '}') {
+            p.advance(1)
+            return node, nil
+        }
+
+        for {
+            if err := p.tomlKeyValue(node, make(map[*Node]bool));
+               (err != nil) {
+                return nil, err
+            }
+
+            p.tomlSpace(false)
+
+            switch (p.peek()) {
+            case ',':
+                p.advance(1)
+            case '}':
+                p.advance(1)
+                return node, nil
+            default:
+                return nil, p.fail("Expected `,` or `}` on the same line, " +
+                                   "but found %s", p.describe())
+            }
+        }
+    }
+
+    var start int = p.pos
+
+    for (p.peek() != 0 && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0) {
+        p.advance(1)
+    }
+
+    // A date and time may be split by a space
+    if (p.peek() == ' ' && p.pos + 1 < len(p.source) &&
+        p.source[p.pos + 1] >= '0' && p.source[p.pos + 1] <= '9' &&
+        tomlDateRegex.MatchString(p.source[start:p.pos] + "T00:00:00")) {
+        p.advance(1)
+
+        for (p.peek() != 0 && strings.IndexByte(" \t\r\n,]}#", p.peek()) < 0) {
+            p.advance(1)
+        }
+    }
+
+    var text string = p.source[start:p.pos]
+
+    switch {
+    case (text == "true" || text == "false"):
+        return &Node{Kind: nodeBool, Value: text == "true", Line: line}, nil
+    case (tomlIntRegex.MatchString(text) || tomlFloatRegex.MatchString(text)):
+        value, _ := strconv.ParseFloat(strings.Replace(text, "_", "", -1), 64)
+
+        if (strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0o") ||
+            strings.HasPrefix(text, "0b")) {
+            integer, _ := strconv.ParseInt(strings.Replace(text, "_", "", -1),
+                                           0, 64)
+            value = float64(integer)
+        }
+
+        return &Node{Kind: nodeNumber, Value: value, Line: line}, nil
+    case (tomlDateRegex.MatchString(text)):
+        return &Node{Kind: nodeString, Value: text, Line: line}, nil
+    case (text == ""):
+        return nil, p.fail("Expected a value, but found %s", p.describe())
+    }
+
+    return nil, SyntaxError{line,
+                            "`" + text + "` isn't a valid value. Strings " +
+                            "must be quoted", false}
+}
+
+/**
+ * Parses `key = value` into a table.
+ *
+ * @param table    The table.
+ * @param explicit Tables defined by headers, which dotted keys can't add to.
+ */
+func (p *parser) tomlKeyValue(table *Node, explicit map[*Node]bool) error {
+    var line int = p.line
+
+    keys, err := p.tomlKey()
+
+    if (err != nil) {
+        return err
+    }
+
+    if (p.peek() != '=') {
+        return p.fail("Expected `=` after `%s`, but found %s",
+                      strings.Join(keys, "."), p.describe())
+    }
+
+    p.advance(1)
+    p.tomlSpace(false)
+
+    value, err := p.tomlValue()
+
+    if (err != nil) {
+        return err
+    }
+
+    for i, key := range keys[:len(keys) - 1] {
+        next, found := table.Fields[key]
+
+        if (!found) {
+            next = newObject(line)
+            setKey(table, key, line, next, &p.problems)
+        } else if (next.Kind != nodeObject || explicit[next]) {
+            return SyntaxError{line,
+                               "`" + strings.Join(keys[:i + 1], ".") +
+                               "` is already set, so can't have keys " +
+                               "added to it", false}
+        }
+
+        table = next
+    }
+
+    setKey(table, keys[len(keys) - 1], line, value, &p.problems)
+
+    return nil
+}
+
+/**
+ * Parses a toml document.
+ */
+func ParseToml(source string) ([]*Node, []Problem, error) {
+    var p parser = parser{source: source, line: 1}
+
+    root     := newObject(1)
+    table    := root
+    explicit := make(map[*Node]bool)
+
+    for (p.peek() != 0) {
+        p.tomlSpace(true)
+
+        switch (p.peek()) {
+        case 0:
+            continue
+        case '\r', '\n':
+            p.advance(1)
+            continue
+        case '[':
+            var line  int  = p.line
+            var array bool = strings.HasPrefix(p.source[p.pos:], "[[")
+
+            if (array) {
+                p.advance(2)
+            } else {
+                p.advance(1)
+            }
+
+            keys, err := p.tomlKey()
+
+            if (err != nil) {
+                return []*Node{root}, p.problems, err
+            }
+
+            if (array && strings.HasPrefix(p.source[p.pos:], "]]")) {
+                p.advance(2)
+            } else if (!array && p.peek() == ']') {
+                p.advance(1)
+            } else {
+                return []*Node{root}, p.problems,
+                       p.fail("Expected the table's `]`, but found %s",
+                              p.describe())
+            }
+
+            var name string = strings.Join(keys, ".")
+
+            table = root
+
+            for i, key := range keys {
+                next, found := table.Fields[key]
+                last := i == len(keys) - 1
+
+                switch {
+                case (last && array && !found):
+                    next = &Node{Kind: nodeArray, Line: line}
+                    setKey(table, key, line, next, &p.problems)
+                    fallthrough
+                case (last && array && next.Kind == nodeArray):
+                    item := newObject(line)
+                    next.Items = append(next.Items, item)
+                    next = item
+                case (!found):
+                    next = newObject(line)
+                    table.Fields[key] = next
+                    table.Lines[key]  = line
+                    table.Keys        = append(table.Keys, key)
+                case (next.Kind == nodeArray && len(next.Items) > 0 &&
+                      next.Items[0].Kind == nodeObject && !last):
+                    next = next.Items[len(next.Items) - 1]
+                case (last && explicit[next]):
+                    p.problems = append(p.problems,
+                                        Problem{"duplicate-key", line,
+                                                "The `[" + name + "]` " +
+                                                "table is defined more " +
+                                                "than once",
+                                                next.Line})
+                case (next.Kind != nodeObject):
+                    return []*Node{root}, p.problems,
+                           SyntaxError{line,
+                                       "`" + strings.Join(keys[:i + 1], ".") +
+                                       "` is already set to a value, so " +
+                                       "can't be a table", false}
+                }
+
+                table = next
+            }
+
+            if (!array) {
+                explicit[table] = true
+                table.Line = line
+            }
+        default:
+            if err := p.tomlKeyValue(table, explicit); (err != nil) {
+                return []*Node{root}, p.problems, err
+            }
+        }
+
+        if err := p.tomlEndLine(); (err != nil) {
+            return []*Node{root}, p.problems, err
+        }
+    }
+
+    return []*Node{root}, p.problems, nil
+}
+
+/**
+ * Returns the smaller of two ints.
+ */
+func smaller(a int, b int) int {
+    if (a < b) {
+        return a
+    }
+
+    return b
+}
+
+/**
+ * Works out which line an offset into some text is on.
+ */
+func lineAt(source string, offset int64) int {
+    return strings.Count(source[:smaller(int(offset), len(source))], "\n") + 1
+}
+
+/**
+ * Parses an xml document, which has nothing for a schema to check.
+ */
+func ParseXml(source string) ([]*Node, []Problem, error) {
+    var problems []Problem
+
+    decoder := xml.NewDecoder(strings.NewReader(source))
+
+    for {
+        token, err := decoder.Token()
+
+        if (err == io.EOF) {
+            return nil, problems, nil
+        }
+
+        if (err != nil) {
+            if syntaxError, ok := err.(*xml.SyntaxError); (ok) {
+                return nil, problems,
+                       SyntaxError{syntaxError.Line,
+                                   strings.ToUpper(syntaxError.Msg[:1]) +
+                                   syntaxError.Msg[1:], false}
+            }
+
+            return nil, problems,
+                   SyntaxError{lineAt(source, decoder.InputOffset()),
+                               err.Error(), false}
+        }
+
+        if start, ok := token.(xml.StartElement); (ok) {
+            seen := make(map[xml.Name]bool)
+            line := lineAt(source, decoder.InputOffset())
+
+            for _, attribute := range start.Attr {
+                if (seen[attribute.Name]) {
+                    problems = append(problems,
+                                      Problem{"duplicate-key", line,
+                                              "The `" +
+                                              attribute.Name.Local +
+                                              "` attribute is set more " +
+                                              "than once on `<" +
+                                              start.Name.Local + ">`",
+                                              line})
+                }
+
+                seen[attribute.Name] = true
+            }
+        }
+    }
+}
+
+/**
+ * Turns a node back into what encoding/json would have given, to compare
+ * with values from a schema.
+ */
+func Plain(node *Node) interface{} {
+    switch (node.Kind) {
+    case nodeObject:
+        object := make(map[string]interface{})
+
+        for _, key := range node.Keys {
+            object[key] = Plain(node.Fields[key])
+        }
+
+        return object
+    case nodeArray:
+        array := make([]interface{}, 0)
+
+        for _, item := range node.Items {
+            array = append(array, Plain(item))
+        }
+
+        return array
+    }
+
+    return node.Value
+}
+
+/**
+ * Names a node's place in a document, for a problem.
+ */
+func describePath(path string) string {
+    if (path == "") {
+        return "The document"
+    }
+
+    return "`" + path + "`"
+}
+
+/**
+ * Works out whether a node is of a json schema type.
+ */
+func isType(node *Node, schemaType string) bool {
+    switch (schemaType) {
+    case "object":
+        return node.Kind == nodeObject
+    case "array":
+        return node.Kind == nodeArray
+    case "string":
+        return node.Kind == nodeString
+    case "number":
+        return node.Kind == nodeNumber
+    case "integer":
+        return node.Kind == nodeNumber &&
+               node.Value.(float64) == math.Trunc(node.Value.(float64))
+    case "boolean":
+        return node.Kind == nodeBool
+    case "null":
+        return node.Kind == nodeNull
+    }
+
+    return true
+}
+
+/**
+ * Finds the schema that a local $ref, such as "#/definitions/RbConfig",
+ * points to.
+ */
+func resolve(root interface{}, ref string) interface{} {
+    var current interface{} = root
+
+    if (!strings.HasPrefix(ref, "#")) {
+        return nil
+    }
+
+    for _, part := range strings.Split(strings.TrimPrefix(ref[1:], "/"), "/") {
+        if (part == "") {
+            continue
+        }
+
+        part = strings.NewReplacer("~1", "/", "~0", "~").Replace(part)
+
+        object, ok := current.(map[string]interface{})
+
+        if (!ok) {
+            return nil
+        }
+
+        current = object[part]
+    }
+
+    return current
+}
+
+/**
+ * Validates a node against a json schema. Only the commonly used keywords
+ * are understood; others are ignored.
+ *
+ * @param node     The node.
+ * @param schema   The schema, decoded by encoding/json.
+ * @param root     The whole schema, to resolve $refs against.
+ * @param path     Where the node is in the document.
+ * @param line     The line to report problems with the node on.
+ * @param problems The problems found.
+ */
+func Validate(node     *Node,
+              schema   interface{},
+              root     interface{},
+              path     string,
+              line     int,
+              problems *[]Problem) {
+    var where string = describePath(path)
+
+    add := func(text string, at int) {
+        *problems = append(*problems, Problem{"schema", at, text, 0})
+    }
+
+    rules, ok := schema.(map[string]interface{})
+
+    if (!ok) {
+        if (schema == false) {
+            add(where + " isn't allowed", line)
+        }
+
+        return
+    }
+
+    if ref, ok := rules["$ref"].(string); (ok) {
+        if resolved := resolve(root, ref); (resolved != nil) {
+            Validate(node, resolved, root, path, line, problems)
+        }
+    }
+
+    if types, found := rules["type"]; (found) {
+        var names   []string
+        var matched bool
+
+        if name, ok := types.(string); (ok) {
+            types = []interface{}{name}
+        }
+
+        if list, ok := types.([]interface{}); (ok) {
+            for _, schemaType := range list {
+                name, _ := schemaType.(string)
+                names   = append(names, typeNames[name])
+                matched = matched || isType(node, name)
+            }
+        }
+
+        if (!matched) {
+            add(where + " should be " + strings.Join(names, " or ") +
+                ", not " + kindNames[node.Kind], line)
+            return
+        }
+    }
+
+    if enum, ok := rules["enum"].([]interface{}); (ok) {
+        var allowed []string
+        var matched bool
+
+        for _, value := range enum {
+            encoded, _ := json.Marshal(value)
+
+            allowed = append(allowed, "`" + string(encoded) + "`")
+            matched = matched || reflect.DeepEqual(value, Plain(node))
+        }
+
+        if (!matched) {
+            add(where + " should be one of " + strings.Join(allowed, ", "),
+                line)
+        }
+    }
+
+    if all, ok := rules["allOf"].([]interface{}); (ok) {
+        for _, option := range all {
+            Validate(node, option, root, path, line, problems)
+        }
+    }
+
+    for _, keyword := range []string{"anyOf", "oneOf"} {
+        options, ok := rules[keyword].([]interface{})
+
+        if (!ok) {
+            continue
+        }
+
+        var matches int = 0
+
+        for _, option := range options {
+            var found []Problem
+
+            Validate(node, option, root, path, line, &found)
+
+            if (len(found) == 0) {
+                matches++
+            }
+        }
+
+        if (matches == 0) {
+            add(where + " doesn't match any of the forms that it can take",
+                line)
+        } else if (matches > 1 && keyword == "oneOf") {
+            add(where + " matches more than one of the forms that it can " +
+                "take", line)
+        }
+    }
+
+    switch (node.Kind) {
+    case nodeObject:
+        properties, _ := rules["properties"].(map[string]interface{})
+        patterns, _   := rules["patternProperties"].(map[string]interface{})
+
+        for _, key := range node.Keys {
+            var child   string = strings.TrimPrefix(path + "." + key, ".")
+            var matched bool
+
+            if property, found := properties[key]; (found) {
+                Validate(node.Fields[key], property, root, child,
+                         node.Lines[key], problems)
+                matched = true
+            }
+
+            for pattern, property := range patterns {
+                if regex, err := regexp.Compile(pattern);
+                   (err == nil && regex.MatchString(key)) {
+                    Validate(node.Fields[key], property, root, child,
+                             node.Lines[key], problems)
+                    matched = true
+                }
+            }
+
+            if additional, found := rules["additionalProperties"];
+               (found && !matched) {
+                if (additional == false) {
+                    add("`" + child + "` isn't a known setting",
+                        node.Lines[key])
+                } else {
+                    Validate(node.Fields[key], additional, root, child,
+                             node.Lines[key], problems)
+                }
+            }
+        }
+
+        required, _ := rules["required"].([]interface{})
+
+        for _, key := range required {
+            name, _ := key.(string)
+
+            if _, found := node.Fields[name]; (!found) {
+                add(where + " is missing `" + name + "`", line)
+            }
+        }
+    case nodeArray:
+        if items, found := rules["items"]; (found) {
+            for i, item := range node.Items {
+                Validate(item, items, root, fmt.Sprintf("%s[%d]", path, i),
+                         item.Line, problems)
+            }
+        }
+
+        if minimum, ok := rules["minItems"].(float64);
+           (ok && float64(len(node.Items)) < minimum) {
+            add(fmt.Sprintf("%s should have at least %g items", where,
+                            minimum), line)
+        }
+
+        if maximum, ok := rules["maxItems"].(float64);
+           (ok && float64(len(node.Items)) > maximum) {
+            add(fmt.Sprintf("%s should have at most %g items", where,
+                            maximum), line)
+        }
+    case nodeString:
+        var length int = utf8.RuneCountInString(node.Value.(string))
+
+        if minimum, ok := rules["minLength"].(float64);
+           (ok && float64(length) < minimum) {
+            add(fmt.Sprintf("%s should be at least %g characters long",
+                            where, minimum), line)
+        }
+
+        if maximum, ok := rules["maxLength"].(float64);
+           (ok && float64(length) > maximum) {
+            add(fmt.Sprintf("%s should be at most %g characters long",
+                            where, maximum), line)
+        }
+
+        if pattern, ok := rules["pattern"].(string); (ok) {
+            if regex, err := regexp.Compile(pattern);
+               (err == nil && !regex.MatchString(node.Value.(string))) {
+                add(where + " should match `" + pattern + "`", line)
+            }
+        }
+    case nodeNumber:
+        var value float64 = node.Value.(float64)
+
+        if minimum, ok := rules["minimum"].(float64); (ok && value < minimum) {
+            add(fmt.Sprintf("%s should be at least %g", where, minimum),
+                line)
+        }
+
+        if maximum, ok := rules["maximum"].(float64); (ok && value > maximum) {
+            add(fmt.Sprintf("%s should be at most %g", where, maximum),
+                line)
+        }
+    }
+}
+
+/**
+ * Finds the problems with a config file.
+ *
+ * @param filename The file's name, to pick its format and schema.
+ * @param source   The file's contents.
+ */
+func Problems(filename string, source string) []Problem {
+    var nodes    []*Node
+    var problems []Problem
+    var err      error
+    var name     string = strings.TrimPrefix(filename, "/")
+
+    switch (Format(filename)) {
+    case "json":
+        var comments bool = false
+
+        for _, glob := range commentedJson {
+            comments = comments || glob.MatchString(name)
+        }
+
+        nodes, problems, err = ParseJson(source, comments)
+    case "yaml":
+        nodes, problems, err = ParseYaml(source)
+    case "toml":
+        nodes, problems, err = ParseToml(source)
+    case "xml":
+        nodes, problems, err = ParseXml(source)
+    }
+
+    if (err != nil) {
+        syntaxError := err.(SyntaxError)
+
+        return append(problems,
+                      Problem{"syntax", syntaxError.Line, syntaxError.Text, 0})
+    }
+
+    for _, schema := range schemas {
+        if (!schema.Path.MatchString(name)) {
+            continue
+        }
+
+        for _, node := range nodes {
+            Validate(node, schema.Schema, schema.Schema, "", node.Line,
+                     &problems)
+        }
+    }
+
+    return problems
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Problems that the change brings in are commented on, at their line if it's
+ * in the diff, or otherwise at the first changed line.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (Format(file.Filename) == "" || file.Status == reviewdata.FileDeleted) {
+        return
+    }
+
+    problems := Problems(file.Filename, string(file.EntireFile))
+
+    if (len(problems) == 0) {
+        return
+    }
+
+    // Problems that were there before the change aren't its fault
+    original := make(map[string]bool)
+
+    if (file.Status != reviewdata.FileAdded) {
+        for _, problem := range Problems(file.Filename,
+                                         string(file.OriginalFile)) {
+            original[problem.Rule + "\n" + problem.Text] = true
+        }
+    }
+
+    // Right-hand line to review line, for every line and for changed lines
+    all, changed := file.ReviewLines()
+
+    // The review line of the first changed line
+    var firstChanged int = 0
+    var firstRhLine  int = 0
+
+    for rhLine, reviewLine := range changed {
+        if (firstRhLine == 0 || rhLine < firstRhLine) {
+            firstRhLine  = rhLine
+            firstChanged = reviewLine
+        }
+    }
+
+    for _, problem := range problems {
+        if (disabled[problem.Rule]) {
+            continue
+        }
+
+        // Duplicate keys are only new if one of them is
+        _, lineChanged    := changed[problem.Line]
+        _, relatedChanged := changed[problem.Related]
+
+        if (problem.Rule == "duplicate-key" && !lineChanged &&
+            !relatedChanged) {
+            continue
+        }
+
+        if (problem.Rule != "duplicate-key" &&
+            original[problem.Rule + "\n" + problem.Text]) {
+            continue
+        }
+
+        var text string = problem.Text
+
+        reviewLine, found := all[problem.Line]
+
+        if (!found) {
+            if (firstChanged == 0) {
+                continue
+            }
+
+            reviewLine = firstChanged
+            text       = fmt.Sprintf("Line %d: %s", problem.Line, text)
+        }
+
+        commentChan <- reviewdata.Comment{
+                           Line:       reviewLine,
+                           NumLines:   1,
+                           Text:       text,
+                           RaiseIssue: config.ConfigFileReviewer.RaiseIssue ||
+                                       problem.Rule == "syntax",
+                           Severity:   config.ConfigFileReviewer.
+                                           Severities[problem.Rule],
+                           Rule:       problem.Rule}
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Takes the directory that the config file is in.
+ */
+func (p Reviewer) UseConfigDir(dir string) {
+    configDir = dir
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    var settings = &config.ConfigFileReviewer
+
+    if (settings.Severities == nil) {
+        settings.Severities = make(map[string]string)
+    }
+
+    for rule, severity := range defaultSeverities {
+        if (settings.Severities[rule] == "") {
+            settings.Severities[rule] = severity
+        }
+    }
+
+    for _, rule := range settings.Disabled {
+        disabled[rule] = true
+    }
+
+    if (settings.CommentedJson == nil) {
+        settings.CommentedJson = defaultCommentedJson
+    }
+
+    for _, glob := range settings.CommentedJson {
+        regex, err := Glob(glob)
+
+        if (err != nil) {
+            fmt.Printf("ConfigFileReviewer: Bad glob: %s\n", err)
+            continue
+        }
+
+        commentedJson = append(commentedJson, regex)
+    }
+
+    for _, schemaPath := range settings.Schemas {
+        var schema interface{}
+
+        regex, err := Glob(schemaPath.Path)
+
+        if (err != nil) {
+            fmt.Printf("ConfigFileReviewer: Bad glob: %s\n", err)
+            continue
+        }
+
+        schemaFile := schemaPath.Schema
+
+        if (!filepath.IsAbs(schemaFile)) {
+            schemaFile = filepath.Join(configDir, schemaFile)
+        }
+
+        raw, err := ioutil.ReadFile(schemaFile)
+
+        if (err == nil) {
+            err = json.Unmarshal(raw, &schema)
+        }
+
+        if (err != nil) {
+            fmt.Printf("ConfigFileReviewer: Could not load schema from " +
+                       "%s: %s\n",
+                       schemaFile,
+                       err)
+            continue
+        }
+
+        schemas = append(schemas, compiledSchema{regex, schema})
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,268 @@
+/**
+ * Tests parsing config files, and validating them against schemas.
+ */
+package main
+
+import (
+    "encoding/json"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+/**
+ * A config file, and what it should parse to.
+ */
+type parseTest struct {
+    source string
+    plain  string // The documents as json, one per line
+    err    string // Part of the syntax error, if there should be one
+}
+
+/**
+ * Runs a parser over some config files.
+ */
+func testParser(t     *testing.T,
+                name  string,
+                parse func(string) ([]*Node, []Problem, error),
+                tests []parseTest) {
+    for _, test := range tests {
+        nodes, _, err := parse(test.source)
+
+        if (test.err != "") {
+            if (err == nil || !strings.Contains(err.Error(), test.err)) {
+                t.Errorf("%s(%q) failed with %v, want %q",
+                         name,
+                         test.source,
+                         err,
+                         test.err)
+            }
+
+            continue
+        }
+
+        if (err != nil) {
+            t.Errorf("%s(%q) failed: %s", name, test.source, err)
+            continue
+        }
+
+        var documents []string
+
+        for _, node := range nodes {
+            encoded, _ := json.Marshal(Plain(node))
+            documents = append(documents, string(encoded))
+        }
+
+        if (strings.Join(documents, "\n") != test.plain) {
+            t.Errorf("%s(%q) = %s, want %s",
+                     name,
+                     test.source,
+                     strings.Join(documents, "\n"),
+                     test.plain)
+        }
+    }
+}
+
+func TestParseJson(t *testing.T) {
+    var tests = []parseTest{
+        {`{"a": 1, "b": [true, null, "c"]}`,
+         `{"a":1,"b":[true,null,"c"]}`, ""},
+        {`"\u00e9\n"`, `"é\n"`, ""},
+        {`{"a": 1,}`, "", "Trailing commas aren't allowed"},
+        {`{"a": 1 // One` + "\n}", "", "Expected"},
+        {`{"a": "b`, "", "The string isn't closed"},
+        {`{'a': 1}`, "", "Expected"},
+    }
+
+    testParser(t, "ParseJson",
+               func(source string) ([]*Node, []Problem, error) {
+                   return ParseJson(source, false)
+               },
+               tests)
+}
+
+func TestParseCommentedJson(t *testing.T) {
+    var tests = []parseTest{
+        {"{\n    // One\n    \"a\": 1, /* Two */\n}", `{"a":1}`, ""},
+        {`[1, 2,]`, `[1,2]`, ""},
+        {`{"a": 1 /* One`, "", "The comment isn't closed"},
+    }
+
+    testParser(t, "ParseJson",
+               func(source string) ([]*Node, []Problem, error) {
+                   return ParseJson(source, true)
+               },
+               tests)
+}
+
+func TestParseYaml(t *testing.T) {
+    var tests = []parseTest{
+        {"a: 1\nb:\n  - c\n  - d: e\n    f: g\n",
+         `{"a":1,"b":["c",{"d":"e","f":"g"}]}`, ""},
+        {"a: yes\nb: ~\nc: 1.5\nd: 'it''s'\n",
+         `{"a":"yes","b":null,"c":1.5,"d":"it's"}`, ""},
+        {`a: "\U0001F600 \x41"`, `{"a":"😀 A"}`, ""},
+        {"a: |\n  One\n  # Two\nb: 1\n", `{"a":"One\n# Two","b":1}`, ""},
+        {"a: 1 # One\n# Two\n", `{"a":1}`, ""},
+        {"a: 1\n---\nb: 2\n", "{\"a\":1}\n{\"b\":2}", ""},
+        {"on:\n  schedule: [cron: \"40 1 * * *\"]\n",
+         `{"on":{"schedule":[{"cron":"40 1 * * *"}]}}`, ""},
+        {"a: [b: 1, c, {d: 2}]", `{"a":[{"b":1},"c",{"d":2}]}`, ""},
+        {"a: {b: [1, 2], c: d}", `{"a":{"b":[1,2],"c":"d"}}`, ""},
+        {"branches: [\n  main,    # The default\n  # Releases\n" +
+         "  release,\n]\n",
+         `{"branches":["main","release"]}`, ""},
+        {"a: {\n  b: 1,  # One\n  c: 2\n  }\n", `{"a":{"b":1,"c":2}}`, ""},
+        {"- a\n- [b,\n   c]\n", `["a",["b","c"]]`, ""},
+        {"a: [b, c\nd: 1\n", "", "The `[` isn't closed"},
+        {"a: [b c: d]", `{"a":[{"b c":"d"}]}`, ""},
+        {"a: [b, [c] d]", "", "Expected `,` or `]`"},
+        {"a: b: c", "", "A value can't be a mapping"},
+        {"a:\n\t- b", "", "Tabs can't be used"},
+        {"a: 1\n  b: 2", "", "The indentation doesn't match"},
+        {"a: \"b", "", "The string isn't closed"},
+    }
+
+    testParser(t, "ParseYaml", ParseYaml, tests)
+}
+
+func TestParseToml(t *testing.T) {
+    var tests = []parseTest{
+        {"a = 1\nb = 'c'\n\n[d]\ne = [true, 1.5]\n",
+         `{"a":1,"b":"c","d":{"e":[true,1.5]}}`, ""},
+        {`a = "\U0001F600 \u00e9\ttab"`, `{"a":"😀 é\ttab"}`, ""},
+        {"a = \"b\tc\"", `{"a":"b\tc"}`, ""},
+        {`"quoted \u0041" = 1`, `{"quoted A":1}`, ""},
+        {"a = \"\"\"\nOne \\\n    Two\\n\"\"\"\"\"",
+         `{"a":"One Two\n\"\""}`, ""},
+        {"a = '''\nC:\\path\n'''", `{"a":"C:\\path\n"}`, ""},
+        {"a = { b = 1, c.d = 2 }\n[[e]]\nf = 1\n[[e]]\n",
+         `{"a":{"b":1,"c":{"d":2}},"e":[{"f":1},{}]}`, ""},
+        {`a = "\/"`, "", "can't be escaped"},
+        {`a = "\UD800DC00"`, "", "Unicode character"},
+        {`a = "\u12"`, "", "must be followed by 4 hex digits"},
+        {"a = \"b\nc\"", "", "line breaks"},
+        {`a = "b`, "", "The string isn't closed"},
+        {"a = 1 b = 2", "", "Expected the end of the line"},
+    }
+
+    testParser(t, "ParseToml", ParseToml, tests)
+}
+
+func TestValidate(t *testing.T) {
+    schema := `{
+        "type": "object",
+        "properties": {
+            "name":  {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+            "port":  {"type": "integer", "minimum": 1, "maximum": 65535},
+            "mode":  {"enum": ["fast", "slow"]},
+            "tags":  {"type": "array", "items": {"type": "string"},
+                      "maxItems": 2},
+            "owner": {"$ref": "#/definitions/Owner"},
+            "id":    {"oneOf": [{"type": "string"}, {"type": "number"}]}
+        },
+        "required": ["name"],
+        "additionalProperties": false,
+        "definitions": {
+            "Owner": {"type": "object", "required": ["email"]}
+        }
+    }`
+
+    var tests = []struct {
+        document string
+        problems []string
+    }{
+        {`{"name": "bot", "port": 80, "mode": "fast", "tags": ["a"],
+           "owner": {"email": "a@b"}, "id": 1}`, nil},
+        {`{"port": 80}`, []string{"The document is missing `name`"}},
+        {`{"name": "Bot"}`, []string{"`name` should match `^[a-z]+$`"}},
+        {`{"name": ""}`,
+         []string{"`name` should be at least 1 characters long",
+                  "`name` should match `^[a-z]+$`"}},
+        {`{"name": "bot", "port": 8.5}`,
+         []string{"`port` should be an integer, not a number"}},
+        {`{"name": "bot", "port": 0}`, []string{"`port` should be at least 1"}},
+        {`{"name": "bot", "mode": "medium"}`,
+         []string{"`mode` should be one of `\"fast\"`, `\"slow\"`"}},
+        {`{"name": "bot", "tags": ["a", 1, "c"]}`,
+         []string{"`tags[1]` should be a string, not a number",
+                  "`tags` should have at most 2 items"}},
+        {`{"name": "bot", "owner": {}}`,
+         []string{"`owner` is missing `email`"}},
+        {`{"name": "bot", "id": true}`,
+         []string{"`id` doesn't match any of the forms that it can take"}},
+        {`{"name": "bot", "colour": "red"}`,
+         []string{"`colour` isn't a known setting"}},
+        {`[]`, []string{"The document should be an object, not an array"}},
+    }
+
+    var root interface{}
+
+    if err := json.Unmarshal([]byte(schema), &root); (err != nil) {
+        t.Fatal(err)
+    }
+
+    for _, test := range tests {
+        var problems []Problem
+        var texts    []string
+
+        nodes, _, err := ParseJson(test.document, false)
+
+        if (err != nil) {
+            t.Fatalf("ParseJson(%q) failed: %s", test.document, err)
+        }
+
+        Validate(nodes[0], root, root, "", 1, &problems)
+
+        for _, problem := range problems {
+            texts = append(texts, problem.Text)
+        }
+
+        if (strings.Join(texts, "\n") != strings.Join(test.problems, "\n")) {
+            t.Errorf("Validate(%s) found %q, want %q",
+                     test.document,
+                     texts,
+                     test.problems)
+        }
+    }
+}
+
+func TestConfigureSchemas(t *testing.T) {
+    dir := t.TempDir()
+
+    err := os.Mkdir(filepath.Join(dir, "schemas"), 0755)
+
+    if (err == nil) {
+        err = ioutil.WriteFile(filepath.Join(dir, "schemas", "a.json"),
+                               []byte(`{"type": "object"}`),
+                               0644)
+    }
+
+    if (err != nil) {
+        t.Fatal(err)
+    }
+
+    defer func() {
+        config    = Config{}
+        schemas   = nil
+        configDir = "."
+    }()
+
+    absolute, _ := json.Marshal(filepath.Join(dir, "schemas", "a.json"))
+
+    // Relative schemas are found in the config file's directory, not the
+    // working directory
+    ReviewerPlugin.UseConfigDir(dir)
+    ReviewerPlugin.Configure(json.RawMessage(`{"ConfigFileReviewer": {
+        "Schemas": [
+            {"Path": "/a.json", "Schema": "schemas/a.json"},
+            {"Path": "/b.json", "Schema": "./schemas/a.json"},
+            {"Path": "/c.json", "Schema": ` + string(absolute) + `},
+            {"Path": "/d.json", "Schema": "schemas/missing.json"}]}}`))
+
+    if (len(schemas) != 3) {
+        t.Errorf("Loaded %d schemas from %s; want 3", len(schemas), dir)
+    }
+}
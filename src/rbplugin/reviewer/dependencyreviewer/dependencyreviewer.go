@@ -0,0 +1,633 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io/ioutil"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    DependencyReviewer struct {
+        Heading         string   // Starts the plugin's part of the review
+                                 // body
+        Denylist        []string // Regexes of packages which may not be
+                                 // used, matched against "name" and
+                                 // "ecosystem:name", e.g. "^npm:left-pad$"
+        Allowlist       []string // If given, regexes of the only packages
+                                 // which may be used
+        LicenseFile     string   // A json map of package to license. Keys
+                                 // may be "name", "ecosystem:name", and
+                                 // either with "@version". Relative to the
+                                 // config file. The licenses aren't
+                                 // checked without it
+        AllowedLicenses []string // If given, the only licenses allowed
+        DeniedLicenses  []string
+        Severity        string
+    }
+}
+
+/**
+ * A dependency, as declared in a manifest.
+ */
+type Dependency struct {
+    Ecosystem string // "go", "npm", "pypi" or "vendored"
+    Name      string
+    Version   string
+    Line      int    // Where it's declared. Zero if not known
+}
+
+/**
+ * A change to a manifest's dependencies.
+ */
+type Change struct {
+    Dependency Dependency
+    Text       string   // e.g. "Upgraded `x` from 1.0 to 1.1"
+    Problems   []string // Why the dependency isn't allowed
+}
+
+/**
+ * The changes to a manifest's, or vendored tree's, dependencies.
+ */
+type Manifest struct {
+    Filename string
+    Changes  []Change
+}
+
+var (
+    config Config
+
+    denylist  *regexp.Regexp
+    allowlist *regexp.Regexp
+    licenses  = make(map[string]string)
+    configDir = "."
+
+    allowedLicenses = make(map[string]bool)
+    deniedLicenses  = make(map[string]bool)
+
+    requirementsRegex = regexp.MustCompile("(^|/)requirements[^/]*\\.txt$")
+
+    // GOPATH-style and vendor/ trees, e.g. src/github.com/jdkato/prose/...
+    vendoredRegex = regexp.MustCompile(
+                        "(?:^|/)(?:src|vendor)/([a-z0-9.\\-]+\\.[a-z]+/" +
+                        "[^/]+/[^/]+)/")
+
+    versionNumberRegex = regexp.MustCompile("[0-9]+")
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "DependencyReviewer"
+}
+
+/**
+ * Parses a go.mod file's requirements.
+ */
+func ParseGoMod(source string) map[string]Dependency {
+    var inBlock bool = false
+
+    dependencies := make(map[string]Dependency)
+
+    for i, line := range strings.Split(source, "\n") {
+        if comment := strings.Index(line, "//"); (comment >= 0) {
+            line = line[:comment]
+        }
+
+        fields := strings.Fields(line)
+
+        switch {
+        case len(fields) == 0:
+            continue
+        case inBlock && fields[0] == ")":
+            inBlock = false
+            continue
+        case fields[0] == "require" && len(fields) > 1 && fields[1] == "(":
+            inBlock = true
+            continue
+        case fields[0] == "require":
+            fields = fields[1:]
+        case !inBlock:
+            continue
+        }
+
+        if (len(fields) >= 2) {
+            dependencies[fields[0]] = Dependency{Ecosystem: "go",
+                                                 Name:      fields[0],
+                                                 Version:   fields[1],
+                                                 Line:      i + 1}
+        }
+    }
+
+    return dependencies
+}
+
+/**
+ * Parses a package.json file's dependencies, of all kinds.
+ */
+func ParsePackageJson(source string) map[string]Dependency {
+    var manifest struct {
+        Dependencies         map[string]string
+        DevDependencies      map[string]string
+        PeerDependencies     map[string]string
+        OptionalDependencies map[string]string
+    }
+
+    dependencies := make(map[string]Dependency)
+
+    if (json.Unmarshal([]byte(source), &manifest) != nil) {
+        return dependencies
+    }
+
+    lines := strings.Split(source, "\n")
+
+    for _, kind := range []map[string]string{manifest.Dependencies,
+                                             manifest.DevDependencies,
+                                             manifest.PeerDependencies,
+                                             manifest.OptionalDependencies} {
+        for name, version := range kind {
+            var dependency = Dependency{Ecosystem: "npm",
+                                        Name:      name,
+                                        Version:   version}
+
+            // The json doesn't say where it came from, so look for it
+            for i, line := range lines {
+                if (strings.Contains(line, "\"" + name + "\"") &&
+                    strings.Contains(line, ":")) {
+                    dependency.Line = i + 1
+                    break
+                }
+            }
+
+            dependencies[name] = dependency
+        }
+    }
+
+    return dependencies
+}
+
+/**
+ * Parses a pip requirements file. Names are normalised, as pip does.
+ */
+func ParseRequirements(source string) map[string]Dependency {
+    dependencies := make(map[string]Dependency)
+
+    for i, line := range strings.Split(source, "\n") {
+        if comment := strings.Index(line, "#"); (comment >= 0) {
+            line = line[:comment]
+        }
+
+        line = strings.TrimSpace(line)
+
+        // Options, such as -r other.txt or -e ., aren't packages
+        if (line == "" || strings.HasPrefix(line, "-")) {
+            continue
+        }
+
+        var name    string = line
+        var version string
+
+        if end := strings.IndexAny(line, "=<>!~[;@ "); (end >= 0) {
+            name    = line[:end]
+            version = strings.TrimSpace(line[end:])
+        }
+
+        name = strings.ToLower(strings.NewReplacer("_", "-",
+                                                   ".", "-").Replace(name))
+
+        dependencies[name] = Dependency{Ecosystem: "pypi",
+                                        Name:      name,
+                                        Version:   version,
+                                        Line:      i + 1}
+    }
+
+    return dependencies
+}
+
+/**
+ * Picks the parser for a manifest.
+ *
+ * @returns The parser, or nil if the file isn't a manifest.
+ */
+func Parser(filename string) func(string) map[string]Dependency {
+    switch {
+    case path.Base(filename) == "go.mod":
+        return ParseGoMod
+    case path.Base(filename) == "package.json":
+        return ParsePackageJson
+    case requirementsRegex.MatchString(filename):
+        return ParseRequirements
+    }
+
+    return nil
+}
+
+/**
+ * Compares two versions, by their numbers.
+ *
+ * @returns Less than zero if a is older, more than zero if it's newer, and
+ *          zero if the numbers are the same.
+ */
+func CompareVersions(a string, b string) int {
+    aNumbers := versionNumberRegex.FindAllString(a, -1)
+    bNumbers := versionNumberRegex.FindAllString(b, -1)
+
+    for i := 0; i < len(aNumbers) && i < len(bNumbers); i++ {
+        aNumber, _ := strconv.Atoi(aNumbers[i])
+        bNumber, _ := strconv.Atoi(bNumbers[i])
+
+        if (aNumber != bNumber) {
+            return aNumber - bNumber
+        }
+    }
+
+    return len(aNumbers) - len(bNumbers)
+}
+
+/**
+ * Looks up a dependency's license in the license file.
+ *
+ * @returns The license, or an empty string if it isn't known.
+ */
+func License(dependency Dependency) string {
+    var qualified string = dependency.Ecosystem + ":" + dependency.Name
+
+    for _, key := range []string{qualified + "@" + dependency.Version,
+                                 dependency.Name + "@" + dependency.Version,
+                                 qualified,
+                                 dependency.Name} {
+        if license, found := licenses[key]; found {
+            return license
+        }
+    }
+
+    return ""
+}
+
+/**
+ * Works out why a dependency isn't allowed.
+ *
+ * @returns The reasons, if any.
+ */
+func Problems(dependency Dependency) []string {
+    var problems  []string
+    var qualified string = dependency.Ecosystem + ":" + dependency.Name
+
+    matches := func(regex *regexp.Regexp) bool {
+        return regex.MatchString(dependency.Name) ||
+               regex.MatchString(qualified)
+    }
+
+    if (denylist != nil && matches(denylist)) {
+        problems = append(problems, "is on the denylist")
+    }
+
+    if (allowlist != nil && !matches(allowlist)) {
+        problems = append(problems, "isn't on the allowlist")
+    }
+
+    license := License(dependency)
+
+    switch {
+    case license != "" && deniedLicenses[strings.ToLower(license)]:
+        problems = append(problems, "is licensed under " + license +
+                                    ", which isn't allowed")
+    case license != "" && len(allowedLicenses) > 0 &&
+         !allowedLicenses[strings.ToLower(license)]:
+        problems = append(problems, "is licensed under " + license +
+                                    ", which isn't on the allowed list")
+    case license == "" && len(allowedLicenses) > 0:
+        problems = append(problems, "has no license information")
+    }
+
+    return problems
+}
+
+/**
+ * Compares a manifest's dependencies, before and after a change. Added and
+ * changed dependencies are checked against the policy.
+ *
+ * @returns The changes, in order of dependency name.
+ */
+func Compare(original map[string]Dependency,
+             current  map[string]Dependency) []Change {
+    var changes []Change
+    var names   []string
+
+    for name := range original {
+        names = append(names, name)
+    }
+
+    for name := range current {
+        if _, found := original[name]; !found {
+            names = append(names, name)
+        }
+    }
+
+    sort.Strings(names)
+
+    for _, name := range names {
+        before, wasThere := original[name]
+        after,  isThere  := current[name]
+
+        var change Change = Change{Dependency: after}
+
+        switch {
+        case !isThere:
+            change.Dependency = before
+            change.Text       = "Removed `" + name + "`"
+        case !wasThere:
+            change.Text = "Added `" + name + "`"
+
+            if (after.Version != "") {
+                change.Text += " " + after.Version
+            }
+        case before.Version != after.Version:
+            var verb string = "Changed"
+
+            if (CompareVersions(after.Version, before.Version) > 0) {
+                verb = "Upgraded"
+            } else if (CompareVersions(after.Version, before.Version) < 0) {
+                verb = "Downgraded"
+            }
+
+            change.Text = verb + " `" + name + "` from " + before.Version +
+                          " to " + after.Version
+        case after.Ecosystem == "vendored":
+            // A vendored tree whose files changed
+            change.Text = "Updated `" + name + "`"
+        default:
+            continue
+        }
+
+        if (isThere) {
+            if license := License(after); (license != "") {
+                change.Text += " (" + license + ")"
+            }
+
+            change.Problems = Problems(after)
+        }
+
+        changes = append(changes, change)
+    }
+
+    return changes
+}
+
+/**
+ * Works out which vendored trees a review adds, removes or updates, from the
+ * status of their files.
+ *
+ * @returns The changes, or nil if no vendored trees changed.
+ */
+func VendoredChanges(files []reviewdata.FileDiff) []Change {
+    original := make(map[string]Dependency)
+    current  := make(map[string]Dependency)
+    statuses := make(map[string]map[string]bool)
+
+    for _, file := range files {
+        match := vendoredRegex.FindStringSubmatch(file.Filename)
+
+        if (match == nil) {
+            continue
+        }
+
+        if (statuses[match[1]] == nil) {
+            statuses[match[1]] = make(map[string]bool)
+        }
+
+        statuses[match[1]][file.Status] = true
+    }
+
+    for name, status := range statuses {
+        var dependency = Dependency{Ecosystem: "vendored", Name: name}
+
+        // A tree with only added files is new, and one with only deleted
+        // files is gone
+        if (!status[reviewdata.FileAdded] || len(status) > 1) {
+            original[name] = dependency
+        }
+
+        if (!status[reviewdata.FileDeleted] || len(status) > 1) {
+            current[name] = dependency
+        }
+    }
+
+    return Compare(original, current)
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Added or changed dependencies that the policy doesn't allow raise issues on
+ * the lines that declare them.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    manifests, ok := passback.([]Manifest)
+
+    if (!ok) {
+        return
+    }
+
+    // Right-hand line to review line
+    lines, _ := file.ReviewLines()
+
+    for _, manifest := range manifests {
+        if (manifest.Filename != file.Filename) {
+            continue
+        }
+
+        for _, change := range manifest.Changes {
+            line, found := lines[change.Dependency.Line]
+
+            if (len(change.Problems) == 0 || !found) {
+                continue
+            }
+
+            commentChan <- reviewdata.Comment{
+                               Line:       line,
+                               NumLines:   1,
+                               Text:       "`" + change.Dependency.Name +
+                                           "` " +
+                                           strings.Join(change.Problems,
+                                                        ", and ") +
+                                           ". Check the dependency policy " +
+                                           "before depending on it",
+                               RaiseIssue: true,
+                               Severity:   config.DependencyReviewer.Severity,
+                               Rule:       "dependency-policy"}
+        }
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Every manifest, and vendored tree, that the review changes has its
+ * dependencies listed in the review body. Vendored trees are usually skipped
+ * by the review, so their policy problems are only mentioned there.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    var manifests []Manifest
+
+    for _, file := range review.Files {
+        parse := Parser(file.Filename)
+
+        // Without the original, everything would look new
+        if (parse == nil || (file.Status != reviewdata.FileAdded &&
+                             len(file.OriginalFile) == 0)) {
+            continue
+        }
+
+        changes := Compare(parse(string(file.OriginalFile)),
+                           parse(string(file.EntireFile)))
+
+        if (len(changes) > 0) {
+            manifests = append(manifests, Manifest{Filename: file.Filename,
+                                                   Changes:  changes})
+        }
+    }
+
+    vendored := VendoredChanges(append(append([]reviewdata.FileDiff{},
+                                              review.Files...),
+                                       review.SkippedFiles...))
+
+    if (len(vendored) > 0) {
+        manifests = append(manifests, Manifest{Filename: "Vendored",
+                                               Changes:  vendored})
+    }
+
+    if (len(manifests) == 0) {
+        return nil
+    }
+
+    var summary string = config.DependencyReviewer.Heading + "\n"
+
+    for _, manifest := range manifests {
+        summary += "\n`" + manifest.Filename + "`\n\n"
+
+        for _, change := range manifest.Changes {
+            if (len(change.Problems) > 0) {
+                summary += "- **Not allowed:** " + change.Text + ", which " +
+                           strings.Join(change.Problems, ", and ") + "\n"
+            } else {
+                summary += "- " + change.Text + "\n"
+            }
+        }
+    }
+
+    commentChan <- summary
+
+    return manifests
+}
+
+/**
+ * Takes the directory that the config file is in.
+ */
+func (p Reviewer) UseConfigDir(dir string) {
+    configDir = dir
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    var settings = &config.DependencyReviewer
+    var err      error
+
+    if (settings.Heading == "") {
+        settings.Heading = "**Dependencies**"
+    }
+
+    if (settings.Severity == "") {
+        settings.Severity = reviewdata.SeverityError
+    }
+
+    if (len(settings.Denylist) > 0) {
+        denylist, err = regexp.Compile(strings.Join(settings.Denylist, "|"))
+
+        if (err != nil) {
+            fmt.Printf("DependencyReviewer: Bad denylist: %s\n", err)
+        }
+    }
+
+    if (len(settings.Allowlist) > 0) {
+        allowlist, err = regexp.Compile(strings.Join(settings.Allowlist, "|"))
+
+        if (err != nil) {
+            fmt.Printf("DependencyReviewer: Bad allowlist: %s\n", err)
+        }
+    }
+
+    if (settings.LicenseFile == "") {
+        if (len(settings.AllowedLicenses) > 0 ||
+            len(settings.DeniedLicenses) > 0) {
+            fmt.Printf("DependencyReviewer: No license file, so licenses " +
+                       "won't be checked\n")
+        }
+
+        return
+    }
+
+    licenseFile := settings.LicenseFile
+
+    if (!filepath.IsAbs(licenseFile)) {
+        licenseFile = filepath.Join(configDir, licenseFile)
+    }
+
+    raw, err := ioutil.ReadFile(licenseFile)
+
+    if (err == nil) {
+        err = json.Unmarshal(raw, &licenses)
+    }
+
+    if (err != nil) {
+        fmt.Printf("DependencyReviewer: Could not load licenses from %s, so " +
+                   "licenses won't be checked: %s\n",
+                   licenseFile,
+                   err)
+        return
+    }
+
+    for _, license := range settings.AllowedLicenses {
+        allowedLicenses[strings.ToLower(license)] = true
+    }
+
+    for _, license := range settings.DeniedLicenses {
+        deniedLicenses[strings.ToLower(license)] = true
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
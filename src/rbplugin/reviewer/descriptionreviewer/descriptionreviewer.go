@@ -0,0 +1,193 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strings"
+    "sync"
+
+    "github.com/jdkato/prose/summarize"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * What a section of a review request must contain. Zero values aren't
+ * checked.
+ */
+type Section struct {
+    Required         bool     // Whether the section may be empty
+    MinWords         int
+    MinSentences     int
+    MinReadingEase   float64  // Flesch reading ease. Lower is harder to read
+    RequiredKeywords []string // Words that must all appear, in any case and
+                              // with any ending
+}
+
+type Config struct {
+    DescriptionReviewer struct {
+        Heading     string // Starts the plugin's part of the review body
+        Summary     Section
+        Description Section
+        TestingDone Section
+    }
+}
+
+var (
+    config Config
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "DescriptionReviewer"
+}
+
+/**
+ * Checks a single section of a review request.
+ *
+ * @param name    The section's name, as shown to the user.
+ * @param text    The section's text.
+ * @param section What the section must contain.
+ *
+ * @returns A list of problems with the section.
+ */
+func CheckSection(name string, text string, section Section) []string {
+    var problems []string
+
+    text = strings.TrimSpace(text)
+
+    if (text == "") {
+        if (section.Required) {
+            problems = append(problems, name + " is missing")
+        }
+
+        return problems
+    }
+
+    document := summarize.NewDocument(text)
+
+    if (int(document.NumWords) < section.MinWords) {
+        problems = append(problems,
+                          fmt.Sprintf("%s has %d words; at least %d are " +
+                                      "expected",
+                                      name,
+                                      int(document.NumWords),
+                                      section.MinWords))
+    }
+
+    if (int(document.NumSentences) < section.MinSentences) {
+        problems = append(problems,
+                          fmt.Sprintf("%s has %d sentences; at least %d are " +
+                                      "expected",
+                                      name,
+                                      int(document.NumSentences),
+                                      section.MinSentences))
+    }
+
+    // Reading ease is meaningless without any sentences
+    if (section.MinReadingEase != 0 && document.NumSentences > 0) {
+        readingEase := document.FleschReadingEase()
+
+        if (readingEase < section.MinReadingEase) {
+            problems = append(problems,
+                              fmt.Sprintf("%s is hard to read (Flesch " +
+                                          "reading ease %.0f; at least %.0f " +
+                                          "is expected)",
+                                          name,
+                                          readingEase,
+                                          section.MinReadingEase))
+        }
+    }
+
+    var missing []string
+
+    for _, keyword := range section.RequiredKeywords {
+        // Keywords match at the start of words, so "test" matches "tested"
+        keywordRegex := regexp.MustCompile("(?i)\\b" +
+                                           regexp.QuoteMeta(keyword))
+
+        if (!keywordRegex.MatchString(text)) {
+            missing = append(missing, "\"" + keyword + "\"")
+        }
+    }
+
+    if (len(missing) > 0) {
+        problems = append(problems,
+                          name + " doesn't mention " +
+                          strings.Join(missing, ", "))
+    }
+
+    return problems
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    (*wg).Done()
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Problems with the review request's summary, description and testing done
+ * are added to the review body.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    var problems []string
+
+    problems = append(problems,
+                      CheckSection("The summary",
+                                   review.Summary,
+                                   config.DescriptionReviewer.Summary)...)
+    problems = append(problems,
+                      CheckSection("The description",
+                                   review.Description,
+                                   config.DescriptionReviewer.Description)...)
+    problems = append(problems,
+                      CheckSection("Testing Done",
+                                   review.Testing_Done,
+                                   config.DescriptionReviewer.TestingDone)...)
+
+    if (len(problems) > 0) {
+        commentChan <- config.DescriptionReviewer.Heading + "\n\n- " +
+                       strings.Join(problems, "\n- ") + "\n"
+    }
+
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.DescriptionReviewer.Heading == "") {
+        config.DescriptionReviewer.Heading = "**Review request description**"
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,492 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "path"
+    "regexp"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    DockerfileReviewer struct {
+        Disabled   []string          // Rules not to check
+        Severities map[string]string // By rule
+        RaiseIssue bool              // Secrets always raise issues
+    }
+}
+
+/**
+ * A Dockerfile instruction, with its continuation lines joined up.
+ */
+type Instruction struct {
+    Keyword   string // Upper-cased, e.g. "RUN"
+    Args      string
+    StartLine int
+    EndLine   int
+}
+
+/**
+ * A problem with an instruction.
+ */
+type Problem struct {
+    Rule string
+    Text string
+}
+
+var (
+    config Config
+
+    disabled = make(map[string]bool)
+
+    defaultSeverities = map[string]string{
+        "unpinned-image":      reviewdata.SeverityWarning,
+        "apt-get-install":     reviewdata.SeverityWarning,
+        "add-instead-of-copy": reviewdata.SeverityInfo,
+        "user-root":           reviewdata.SeverityWarning,
+        "secret-in-env":       reviewdata.SeverityError,
+        "missing-healthcheck": reviewdata.SeverityInfo,
+    }
+
+    escapeDirectiveRegex = regexp.MustCompile("(?i)^#\\s*escape\\s*=\\s*(.)")
+
+    secretNameRegex = regexp.MustCompile(
+                        "(?i)(passw(or)?d|secret|token|api_?key|private_?key|" +
+                        "credential|access_?key)")
+
+    archiveRegex = regexp.MustCompile(
+                        "(?i)\\.(tar|tar\\.gz|tgz|tar\\.bz2|tbz2?|tar\\.xz|" +
+                        "txz)$")
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "DockerfileReviewer"
+}
+
+/**
+ * Works out whether a file is a Dockerfile.
+ */
+func IsDockerfile(filename string) bool {
+    var base string = strings.ToLower(path.Base(filename))
+
+    return base == "dockerfile" || strings.HasPrefix(base, "dockerfile.") ||
+           strings.HasSuffix(base, ".dockerfile")
+}
+
+/**
+ * Parses a Dockerfile into instructions. Lines ending in the escape character
+ * continue onto the next, skipping any comments in between.
+ */
+func Parse(source string) []Instruction {
+    var instructions []Instruction
+    var current      *Instruction
+    var escape       string = "\\"
+    var directives   bool   = true
+
+    for i, line := range strings.Split(source, "\n") {
+        var trimmed string = strings.TrimSpace(line)
+
+        // Parser directives may only come first
+        if (directives && strings.HasPrefix(trimmed, "#")) {
+            if match := escapeDirectiveRegex.FindStringSubmatch(trimmed);
+               (match != nil) {
+                escape = match[1]
+            }
+
+            continue
+        }
+
+        directives = false
+
+        if (trimmed == "" || strings.HasPrefix(trimmed, "#")) {
+            continue
+        }
+
+        var continues bool = strings.HasSuffix(trimmed, escape)
+
+        if (continues) {
+            trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, escape))
+        }
+
+        if (current == nil) {
+            fields := strings.SplitN(trimmed, " ", 2)
+
+            current = &Instruction{Keyword:   strings.ToUpper(fields[0]),
+                                   StartLine: i + 1}
+
+            if (len(fields) > 1) {
+                trimmed = strings.TrimSpace(fields[1])
+            } else {
+                trimmed = ""
+            }
+        }
+
+        if (current.Args != "" && trimmed != "") {
+            current.Args += " "
+        }
+
+        current.Args   += trimmed
+        current.EndLine = i + 1
+
+        if (!continues) {
+            instructions = append(instructions, *current)
+            current = nil
+        }
+    }
+
+    if (current != nil) {
+        instructions = append(instructions, *current)
+    }
+
+    return instructions
+}
+
+/**
+ * Splits an instruction's arguments, which may be in json form, leaving out
+ * flags such as --chown=user.
+ */
+func Arguments(args string) []string {
+    var arguments []string
+    var all       []string
+
+    if (strings.HasPrefix(args, "[") &&
+        json.Unmarshal([]byte(args), &all) == nil) {
+        return all
+    }
+
+    for _, argument := range strings.Fields(args) {
+        if (!strings.HasPrefix(argument, "--")) {
+            arguments = append(arguments, argument)
+        }
+    }
+
+    return arguments
+}
+
+/**
+ * Checks a FROM instruction's image.
+ *
+ * @param instruction The instruction.
+ * @param stages      The names of earlier build stages, which may be built
+ *                    from without a tag.
+ */
+func CheckFrom(instruction Instruction, stages map[string]bool) []Problem {
+    arguments := Arguments(instruction.Args)
+
+    if (len(arguments) == 0) {
+        return nil
+    }
+
+    var image string = arguments[0]
+    var name  string = image[strings.LastIndex(image, "/") + 1:]
+
+    // Earlier stages and digests are pinned already, and build arguments
+    // can't be checked until build time
+    if (strings.ToLower(image) == "scratch" || stages[strings.ToLower(image)] ||
+        strings.Contains(image, "$") || strings.Contains(image, "@sha256:")) {
+        return nil
+    }
+
+    if (!strings.Contains(name, ":")) {
+        return []Problem{{"unpinned-image",
+                          "`" + image + "` has no tag, so gets whatever " +
+                          "`latest` is at build time. Pin it to a version, " +
+                          "or a digest"}}
+    }
+
+    if (strings.HasSuffix(name, ":latest")) {
+        return []Problem{{"unpinned-image",
+                          "`" + image + "` changes whenever a new version " +
+                          "is released. Pin it to a version, or a digest"}}
+    }
+
+    return nil
+}
+
+/**
+ * Checks a RUN instruction's apt-get installs.
+ */
+func CheckRun(instruction Instruction) []Problem {
+    var problems []Problem
+
+    if (!strings.Contains(instruction.Args, "apt-get install") &&
+        !strings.Contains(instruction.Args, "apt-get -y install")) {
+        return nil
+    }
+
+    if (!strings.Contains(instruction.Args, "--no-install-recommends")) {
+        problems = append(problems,
+                          Problem{"apt-get-install",
+                                  "`apt-get install` without " +
+                                  "`--no-install-recommends` installs " +
+                                  "packages that the image doesn't need"})
+    }
+
+    if (!strings.Contains(instruction.Args, "/var/lib/apt/lists")) {
+        problems = append(problems,
+                          Problem{"apt-get-install",
+                                  "The apt cache is left in the image. " +
+                                  "Finish the same `RUN` with " +
+                                  "`rm -rf /var/lib/apt/lists/*`"})
+    }
+
+    return problems
+}
+
+/**
+ * Checks that an ADD instruction needs to be one, rather than a COPY.
+ */
+func CheckAdd(instruction Instruction) []Problem {
+    arguments := Arguments(instruction.Args)
+
+    if (len(arguments) < 2) {
+        return nil
+    }
+
+    // The last argument is the destination
+    for _, source := range arguments[:len(arguments) - 1] {
+        if (strings.Contains(source, "://") ||
+            archiveRegex.MatchString(source)) {
+            return nil
+        }
+    }
+
+    return []Problem{{"add-instead-of-copy",
+                      "Use `COPY`, as nothing here needs `ADD`'s URL " +
+                      "fetching or archive extraction"}}
+}
+
+/**
+ * Checks an ENV or ARG instruction for secrets, which are kept in the image's
+ * history for anyone to read.
+ */
+func CheckSecrets(instruction Instruction) []Problem {
+    var names []string
+
+    arguments := strings.Fields(instruction.Args)
+
+    // "ENV KEY value" sets a single variable; otherwise, each is KEY=value
+    if (instruction.Keyword == "ENV" && len(arguments) > 1 &&
+        !strings.Contains(arguments[0], "=")) {
+        arguments = arguments[:1]
+    }
+
+    for _, argument := range arguments {
+        name := strings.SplitN(argument, "=", 2)[0]
+
+        if (name != "" && secretNameRegex.MatchString(name)) {
+            names = append(names, "`" + name + "`")
+        }
+    }
+
+    if (len(names) == 0) {
+        return nil
+    }
+
+    return []Problem{{"secret-in-env",
+                      strings.Join(names, " and ") + " looks like a " +
+                      "secret. " + instruction.Keyword + " values are kept " +
+                      "in the image, for anyone who pulls it to read. Use " +
+                      "a build secret (`RUN --mount=type=secret`), or pass " +
+                      "it in at run time"}}
+}
+
+/**
+ * Checks a Dockerfile.
+ *
+ * @returns The problems, by the index of the instruction that they're on,
+ *          leaving out disabled rules.
+ */
+func CheckDockerfile(instructions []Instruction) map[int][]Problem {
+    var lastUser   int  = -1
+    var lastExpose int  = -1
+    var healthy    bool = false
+
+    problems := make(map[int][]Problem)
+    stages   := make(map[string]bool)
+
+    add := func(index int, found []Problem) {
+        for _, problem := range found {
+            if (!disabled[problem.Rule]) {
+                problems[index] = append(problems[index], problem)
+            }
+        }
+    }
+
+    for i, instruction := range instructions {
+        switch (instruction.Keyword) {
+        case "FROM":
+            add(i, CheckFrom(instruction, stages))
+
+            arguments := Arguments(instruction.Args)
+
+            if (len(arguments) == 3 && strings.ToUpper(arguments[1]) == "AS") {
+                stages[strings.ToLower(arguments[2])] = true
+            }
+
+            // Only the final stage's user, ports and health check matter
+            lastUser   = -1
+            lastExpose = -1
+            healthy    = false
+        case "RUN":
+            add(i, CheckRun(instruction))
+        case "ADD":
+            add(i, CheckAdd(instruction))
+        case "ENV", "ARG":
+            add(i, CheckSecrets(instruction))
+        case "USER":
+            lastUser = i
+        case "EXPOSE":
+            lastExpose = i
+        case "HEALTHCHECK":
+            healthy = true
+        }
+    }
+
+    if (lastUser >= 0) {
+        user := strings.SplitN(instructions[lastUser].Args, ":", 2)[0]
+
+        if (user == "root" || user == "0") {
+            add(lastUser,
+                []Problem{{"user-root",
+                           "The image runs as root. Switch to an " +
+                           "unprivileged user once everything that needs " +
+                           "root has been done"}})
+        }
+    }
+
+    // An image that exposes a port is a service, so should say if it's up
+    if (lastExpose >= 0 && !healthy) {
+        add(lastExpose,
+            []Problem{{"missing-healthcheck",
+                       "This image runs a service, but has no " +
+                       "`HEALTHCHECK`, so nothing can tell if it stops " +
+                       "responding"}})
+    }
+
+    return problems
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Instructions that the change touches are checked. A multi-line
+ * instruction's comment covers the whole instruction, if the diff does.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!IsDockerfile(file.Filename) || file.Status == reviewdata.FileDeleted) {
+        return
+    }
+
+    // Right-hand line to review line, for every line and for changed lines
+    all, changed := file.ReviewLines()
+
+    instructions := Parse(string(file.EntireFile))
+
+    for index, problems := range CheckDockerfile(instructions) {
+        var instruction Instruction = instructions[index]
+        var touched     bool        = false
+
+        for rhLine := instruction.StartLine; rhLine <= instruction.EndLine;
+            rhLine++ {
+            if _, found := changed[rhLine]; found {
+                touched = true
+            }
+        }
+
+        first, firstFound := all[instruction.StartLine]
+        last,  lastFound  := all[instruction.EndLine]
+
+        if (!touched || !firstFound) {
+            continue
+        }
+
+        var comment = reviewdata.Comment{Line:       first,
+                                         NumLines:   1,
+                                         RaiseIssue: config.DockerfileReviewer.
+                                                         RaiseIssue,
+                                         Severity:   reviewdata.SeverityInfo,
+                                         Rule:       problems[0].Rule}
+
+        if (lastFound && last >= first) {
+            comment.NumLines = last - first + 1
+        }
+
+        var texts []string
+
+        for _, problem := range problems {
+            texts = append(texts, problem.Text)
+
+            severity := config.DockerfileReviewer.Severities[problem.Rule]
+
+            if (reviewdata.SeverityRank(severity) >
+                reviewdata.SeverityRank(comment.Severity)) {
+                comment.Severity = severity
+            }
+
+            if (problem.Rule == "secret-in-env") {
+                comment.RaiseIssue = true
+            }
+        }
+
+        comment.Text = strings.Join(texts, "\n\n")
+
+        commentChan <- comment
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.DockerfileReviewer.Severities == nil) {
+        config.DockerfileReviewer.Severities = make(map[string]string)
+    }
+
+    for rule, severity := range defaultSeverities {
+        if (config.DockerfileReviewer.Severities[rule] == "") {
+            config.DockerfileReviewer.Severities[rule] = severity
+        }
+    }
+
+    for _, rule := range config.DockerfileReviewer.Disabled {
+        disabled[rule] = true
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
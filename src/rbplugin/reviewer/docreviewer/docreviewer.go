@@ -0,0 +1,328 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "regexp"
+    "strings"
+    "sync"
+
+    "rbplugindata/goast"
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    DocReviewer struct {
+        CheckTests   bool     // Whether _test.go files are checked
+        NameOptional bool     // If set, any doc comment will do
+        Exclude      []string // Regexes of files not to check
+        RaiseIssue   bool
+        Severity     string
+    }
+}
+
+/**
+ * An exported identifier, as declared in a file.
+ */
+type Identifier struct {
+    Key  string         // Identifies the identifier within its package, e.g.
+                        // "(*Server).Start"
+    Name string
+    Kind string         // "function", "method", "type" or "constant"
+    Pos  token.Pos
+    Doc  *ast.CommentGroup
+    Lax  bool           // Whether the doc needn't start with the name, as it
+                        // is shared by a group of constants
+}
+
+var (
+    config Config
+
+    excludeRegex *regexp.Regexp
+
+    // A type's comment may start with an article
+    articles = []string{"", "A ", "An ", "The "}
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "DocReviewer"
+}
+
+/**
+ * Finds the exported identifiers declared in a Go source file. Methods are only
+ * included if their receiver type is exported too.
+ *
+ * @param fileSet  The file set to parse into.
+ * @param filename The file's name.
+ * @param source   The file's content.
+ *
+ * @returns The identifiers, and whether the file could be parsed.
+ */
+func Exported(fileSet  *token.FileSet,
+              filename string,
+              source   []byte) ([]Identifier, bool) {
+    var identifiers []Identifier
+
+    parsed, err := parser.ParseFile(fileSet,
+                                    filename,
+                                    source,
+                                    parser.ParseComments)
+
+    if (err != nil) {
+        return nil, false
+    }
+
+    for _, decl := range parsed.Decls {
+        switch d := decl.(type) {
+        case *ast.FuncDecl:
+            if (!d.Name.IsExported()) {
+                continue
+            }
+
+            var identifier = Identifier{Key:  d.Name.Name,
+                                        Name: d.Name.Name,
+                                        Kind: "function",
+                                        Pos:  d.Pos(),
+                                        Doc:  d.Doc}
+
+            if (d.Recv != nil && len(d.Recv.List) > 0) {
+                receiver := goast.ReceiverName(d.Recv.List[0].Type)
+
+                if (!ast.IsExported(strings.TrimPrefix(receiver, "*"))) {
+                    continue
+                }
+
+                identifier.Key  = "(" + receiver + ")." + d.Name.Name
+                identifier.Kind = "method"
+            }
+
+            identifiers = append(identifiers, identifier)
+        case *ast.GenDecl:
+            if (d.Tok != token.TYPE && d.Tok != token.CONST) {
+                continue
+            }
+
+            for _, spec := range d.Specs {
+                switch s := spec.(type) {
+                case *ast.TypeSpec:
+                    if (!s.Name.IsExported()) {
+                        continue
+                    }
+
+                    var doc *ast.CommentGroup = s.Doc
+
+                    // An ungrouped type's comment belongs to the declaration
+                    if (doc == nil && !d.Lparen.IsValid()) {
+                        doc = d.Doc
+                    }
+
+                    identifiers = append(identifiers,
+                                         Identifier{Key:  s.Name.Name,
+                                                    Name: s.Name.Name,
+                                                    Kind: "type",
+                                                    Pos:  s.Pos(),
+                                                    Doc:  doc})
+                case *ast.ValueSpec:
+                    for _, name := range s.Names {
+                        if (!name.IsExported()) {
+                            continue
+                        }
+
+                        var identifier = Identifier{Key:  name.Name,
+                                                    Name: name.Name,
+                                                    Kind: "constant",
+                                                    Pos:  name.Pos(),
+                                                    Doc:  s.Doc}
+
+                        if (identifier.Doc == nil) {
+                            identifier.Doc = d.Doc
+                            identifier.Lax = d.Lparen.IsValid()
+                        }
+
+                        identifiers = append(identifiers, identifier)
+                    }
+                }
+            }
+        }
+    }
+
+    return identifiers, true
+}
+
+/**
+ * Retrieves a doc comment's text, without the leading asterisks that block
+ * comments such as this one have.
+ */
+func DocText(doc *ast.CommentGroup) string {
+    var lines []string
+
+    for _, line := range strings.Split(doc.Text(), "\n") {
+        line = strings.TrimLeft(line, " \t*")
+
+        if (line != "" || len(lines) > 0) {
+            lines = append(lines, line)
+        }
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+/**
+ * Works out what is wrong with an identifier's doc comment.
+ *
+ * @returns The problem, or an empty string if there isn't one.
+ */
+func DocProblem(identifier Identifier) string {
+    var text string
+
+    if (identifier.Doc != nil) {
+        text = DocText(identifier.Doc)
+    }
+
+    if (strings.TrimSpace(text) == "") {
+        return "Exported " + identifier.Kind + " `" + identifier.Key +
+               "` has no doc comment"
+    }
+
+    if (identifier.Lax || config.DocReviewer.NameOptional) {
+        return ""
+    }
+
+    for _, article := range articles {
+        if (article != "" && identifier.Kind != "type") {
+            continue
+        }
+
+        // The name must be a whole word
+        rest := strings.TrimPrefix(text, article + identifier.Name)
+
+        if (rest != text &&
+            (rest == "" || strings.ContainsAny(rest[:1], " \n\t.,"))) {
+            return ""
+        }
+    }
+
+    return "The doc comment for exported " + identifier.Kind + " `" +
+           identifier.Key + "` should start with `" + identifier.Name + "`"
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Only identifiers that the change introduces, or renames, are checked, so
+ * existing undocumented identifiers aren't commented on.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!strings.HasSuffix(file.Filename, ".go") ||
+        file.Status == reviewdata.FileDeleted ||
+        file.Class == reviewdata.ClassGenerated ||
+        (!config.DocReviewer.CheckTests &&
+         strings.HasSuffix(file.Filename, "_test.go")) ||
+        (excludeRegex != nil && excludeRegex.MatchString(file.Filename))) {
+        return
+    }
+
+    fileSet := token.NewFileSet()
+
+    identifiers, ok := Exported(fileSet, file.Filename, file.EntireFile)
+
+    if (!ok) {
+        return
+    }
+
+    original := make(map[string]bool)
+
+    if (len(file.OriginalFile) > 0) {
+        originalIdentifiers, _ := Exported(token.NewFileSet(),
+                                           file.Filename,
+                                           file.OriginalFile)
+
+        for _, identifier := range originalIdentifiers {
+            original[identifier.Key] = true
+        }
+    }
+
+    // Right-hand line to review line, for changed lines. An identifier that
+    // is new, or renamed, has a changed declaration line
+    _, lines := file.ReviewLines()
+
+    for _, identifier := range identifiers {
+        if (original[identifier.Key]) {
+            continue
+        }
+
+        line, found := lines[fileSet.Position(identifier.Pos).Line]
+
+        if (!found) {
+            continue
+        }
+
+        problem := DocProblem(identifier)
+
+        if (problem == "") {
+            continue
+        }
+
+        commentChan <- reviewdata.Comment{
+                           Line:       line,
+                           NumLines:   1,
+                           Text:       problem,
+                           RaiseIssue: config.DocReviewer.RaiseIssue,
+                           Severity:   config.DocReviewer.Severity,
+                           Rule:       "exported-doc"}
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (len(config.DocReviewer.Exclude) > 0) {
+        var err error
+
+        excludeRegex, err = regexp.Compile(
+                        strings.Join(config.DocReviewer.Exclude, "|"))
+
+        if (err != nil) {
+            fmt.Printf("DocReviewer: Bad exclusion: %s\n", err)
+        }
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
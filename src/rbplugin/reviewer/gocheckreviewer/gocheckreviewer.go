@@ -0,0 +1,578 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "go/ast"
+    "go/importer"
+    "go/parser"
+    "go/token"
+    "go/types"
+    "path"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * The checks.
+ */
+const (
+    checkIgnoredError = "ignored-error"
+    checkDeferInLoop  = "defer-in-loop"
+    checkErrShadow    = "err-shadow"
+    checkWgAdd        = "wg-add-in-goroutine"
+)
+
+/**
+ * How a check's comments are made.
+ */
+type CheckConfig struct {
+    Disabled   bool
+    Rule       string // Defaults to the check's name
+    Severity   string
+    RaiseIssue bool
+}
+
+type Config struct {
+    GoCheckReviewer struct {
+        Checks            map[string]CheckConfig // By check name
+        IgnoredErrorFuncs []string // Functions whose errors may be ignored,
+                                   // by prefix of their full name, e.g.
+                                   // "fmt.Print" or "(*bytes.Buffer).Write".
+                                   // Replaces the defaults
+        Importer          string   // How imports are type-checked: "gc"
+                                   // (the default), "source" or "none"
+    }
+}
+
+/**
+ * A problem found by a check.
+ */
+type Problem struct {
+    Check string
+    Pos   token.Pos
+    Text  string
+}
+
+var (
+    config Config
+
+    errorType = types.Universe.Lookup("error").Type()
+
+    defaultIgnoredErrorFuncs = []string{
+        "fmt.Print",
+        "fmt.Fprint",
+        "(*bytes.Buffer).Write",
+        "(*strings.Builder).Write",
+        "(hash.Hash).Write",
+    }
+
+    texts = map[string]string{
+        checkIgnoredError: "This error is ignored. Handle it, or assign it " +
+                           "to _ with a comment saying why it's safe to " +
+                           "ignore",
+        checkDeferInLoop:  "This defer is in a loop, so it won't run until " +
+                           "the function returns. Move the loop's body into " +
+                           "a function, or release the resource explicitly",
+        checkErrShadow:    "This err shadows another err, which is read " +
+                           "after the if, so it won't see this error",
+        checkWgAdd:        "This WaitGroup.Add is in the goroutine it " +
+                           "counts, so Wait may return before it runs. Call " +
+                           "Add before starting the goroutine",
+    }
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "GoCheckReviewer"
+}
+
+/**
+ * Type-checks a package's files, as far as possible. Type errors, such as
+ * unresolvable imports, leave gaps in the information rather than failing.
+ *
+ * @returns The type information.
+ */
+func TypeCheck(fileSet *token.FileSet, files []*ast.File) *types.Info {
+    info := &types.Info{Types:  make(map[ast.Expr]types.TypeAndValue),
+                        Defs:   make(map[*ast.Ident]types.Object),
+                        Uses:   make(map[*ast.Ident]types.Object),
+                        Scopes: make(map[ast.Node]*types.Scope)}
+
+    conf := types.Config{Error: func(err error) {}}
+
+    switch (config.GoCheckReviewer.Importer) {
+    case "source":
+        conf.Importer = importer.ForCompiler(fileSet, "source", nil)
+    case "none":
+    default:
+        conf.Importer = importer.Default()
+    }
+
+    conf.Check(files[0].Name.Name, fileSet, files, info)
+
+    return info
+}
+
+/**
+ * Finds the full name of the function that a call calls, e.g. "fmt.Println".
+ *
+ * @returns The name, or an empty string if it isn't known.
+ */
+func CalleeName(info *types.Info, call *ast.CallExpr) string {
+    var ident *ast.Ident
+
+    switch fun := call.Fun.(type) {
+    case *ast.Ident:
+        ident = fun
+    case *ast.SelectorExpr:
+        ident = fun.Sel
+    }
+
+    if (ident == nil) {
+        return ""
+    }
+
+    if function, ok := info.Uses[ident].(*types.Func); ok {
+        return function.FullName()
+    }
+
+    return ""
+}
+
+/**
+ * Works out whether the error that a call returns may be ignored.
+ */
+func ErrorIgnorable(info *types.Info, call *ast.CallExpr) bool {
+    var name string = CalleeName(info, call)
+
+    for _, prefix := range config.GoCheckReviewer.IgnoredErrorFuncs {
+        if (name != "" && strings.HasPrefix(name, prefix)) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Lists the types that a call returns.
+ *
+ * @returns The types, or nil if they aren't known.
+ */
+func Results(info *types.Info, call *ast.CallExpr) []types.Type {
+    typeAndValue, found := info.Types[call]
+
+    if (!found || typeAndValue.Type == nil) {
+        return nil
+    }
+
+    if tuple, ok := typeAndValue.Type.(*types.Tuple); ok {
+        var results []types.Type
+
+        for i := 0; i < tuple.Len(); i++ {
+            results = append(results, tuple.At(i).Type())
+        }
+
+        return results
+    }
+
+    return []types.Type{typeAndValue.Type}
+}
+
+/**
+ * Finds errors which are dropped, either by calling a function as a statement
+ * or by assigning its error to _ without a comment saying why.
+ */
+func CheckIgnoredErrors(fileSet *token.FileSet,
+                        file    *ast.File,
+                        info    *types.Info) []Problem {
+    var problems []Problem
+
+    comments := ast.NewCommentMap(fileSet, file, file.Comments)
+
+    ast.Inspect(file, func(node ast.Node) bool {
+        switch n := node.(type) {
+        case *ast.ExprStmt:
+            call, ok := n.X.(*ast.CallExpr)
+
+            if (!ok || ErrorIgnorable(info, call)) {
+                return true
+            }
+
+            for _, result := range Results(info, call) {
+                if (types.Identical(result, errorType)) {
+                    problems = append(problems,
+                                      Problem{Check: checkIgnoredError,
+                                              Pos:   n.Pos()})
+                    break
+                }
+            }
+        case *ast.AssignStmt:
+            if (len(n.Rhs) != 1) {
+                return true
+            }
+
+            call, ok := n.Rhs[0].(*ast.CallExpr)
+
+            if (!ok || ErrorIgnorable(info, call)) {
+                return true
+            }
+
+            results := Results(info, call)
+
+            if (len(results) != len(n.Lhs) || len(comments[n]) > 0) {
+                return true
+            }
+
+            for i, lhs := range n.Lhs {
+                ident, isIdent := lhs.(*ast.Ident)
+
+                if (isIdent && ident.Name == "_" &&
+                    types.Identical(results[i], errorType)) {
+                    problems = append(problems,
+                                      Problem{Check: checkIgnoredError,
+                                              Pos:   n.Pos()})
+                }
+            }
+        }
+
+        return true
+    })
+
+    return problems
+}
+
+/**
+ * Finds defers in loops. A function literal in a loop starts afresh, as its
+ * defers run when it returns.
+ */
+func CheckDeferInLoop(node ast.Node, inLoop bool) []Problem {
+    var problems []Problem
+
+    ast.Inspect(node, func(child ast.Node) bool {
+        if (child == node) {
+            return true
+        }
+
+        switch n := child.(type) {
+        case *ast.FuncLit:
+            problems = append(problems, CheckDeferInLoop(n.Body, false)...)
+            return false
+        case *ast.ForStmt:
+            problems = append(problems, CheckDeferInLoop(n.Body, true)...)
+            return false
+        case *ast.RangeStmt:
+            problems = append(problems, CheckDeferInLoop(n.Body, true)...)
+            return false
+        case *ast.DeferStmt:
+            if (inLoop) {
+                problems = append(problems, Problem{Check: checkDeferInLoop,
+                                                    Pos:   n.Pos()})
+            }
+        }
+
+        return true
+    })
+
+    return problems
+}
+
+/**
+ * Finds errs declared in if statements, or their blocks, which shadow an err
+ * declared earlier in the same function, when that err is read after the if.
+ *
+ * An outer err which is only assigned after the if, e.g. by the next call
+ * that can fail, doesn't lose anything, so isn't reported.
+ */
+func CheckErrShadow(file *ast.File, info *types.Info) []Problem {
+    var problems []Problem
+
+    ifScopes := make(map[*types.Scope]*ast.IfStmt)
+    assigned := make(map[*ast.Ident]bool)
+
+    ast.Inspect(file, func(node ast.Node) bool {
+        switch n := node.(type) {
+        case *ast.IfStmt:
+            // Else ifs are visited later, so are given the outer if
+            for _, scopeNode := range []ast.Node{n, n.Body, n.Else} {
+                if (scopeNode != nil && info.Scopes[scopeNode] != nil &&
+                    ifScopes[info.Scopes[scopeNode]] == nil) {
+                    ifScopes[info.Scopes[scopeNode]] = n
+                }
+            }
+        case *ast.AssignStmt:
+            if (n.Tok == token.ASSIGN || n.Tok == token.DEFINE) {
+                for _, lhs := range n.Lhs {
+                    if ident, ok := lhs.(*ast.Ident); ok {
+                        assigned[ident] = true
+                    }
+                }
+            }
+        }
+
+        return true
+    })
+
+    for ident, object := range info.Defs {
+        if (ident.Name != "err" || object == nil ||
+            ifScopes[object.Parent()] == nil ||
+            object.Parent().Parent() == nil) {
+            continue
+        }
+
+        scope, outer := object.Parent().Parent().LookupParent("err",
+                                                              ident.Pos())
+
+        // Package-level errs, and the universe, don't count
+        if (outer == nil || scope == types.Universe ||
+            scope == object.Pkg().Scope() ||
+            ident.Pos() < file.Pos() || ident.Pos() >= file.End()) {
+            continue
+        }
+
+        // The outer err's next use after the if must read it
+        var ifEnd token.Pos = ifScopes[object.Parent()].End()
+        var next  *ast.Ident
+
+        for use, usedObject := range info.Uses {
+            if (usedObject == outer && use.Pos() >= ifEnd &&
+                (next == nil || use.Pos() < next.Pos())) {
+                next = use
+            }
+        }
+
+        if (next != nil && !assigned[next]) {
+            problems = append(problems, Problem{Check: checkErrShadow,
+                                                Pos:   ident.Pos()})
+        }
+    }
+
+    return problems
+}
+
+/**
+ * Works out whether an expression is a sync.WaitGroup. Without type
+ * information, its name has to look like one.
+ */
+func IsWaitGroup(info *types.Info, expr ast.Expr) bool {
+    typeAndValue, found := info.Types[expr]
+
+    if (found && typeAndValue.Type != nil) {
+        var exprType types.Type = typeAndValue.Type
+
+        if pointer, ok := exprType.(*types.Pointer); ok {
+            exprType = pointer.Elem()
+        }
+
+        return exprType.String() == "sync.WaitGroup"
+    }
+
+    var name string
+
+    switch e := expr.(type) {
+    case *ast.Ident:
+        name = e.Name
+    case *ast.SelectorExpr:
+        name = e.Sel.Name
+    case *ast.StarExpr:
+        return IsWaitGroup(info, e.X)
+    case *ast.ParenExpr:
+        return IsWaitGroup(info, e.X)
+    }
+
+    name = strings.ToLower(name)
+
+    return strings.HasSuffix(name, "wg") ||
+           strings.HasSuffix(name, "waitgroup")
+}
+
+/**
+ * Finds WaitGroup.Adds inside the goroutines that they count.
+ */
+func CheckWgAdd(file *ast.File, info *types.Info) []Problem {
+    var problems []Problem
+
+    ast.Inspect(file, func(node ast.Node) bool {
+        goStmt, ok := node.(*ast.GoStmt)
+
+        if (!ok) {
+            return true
+        }
+
+        funcLit, ok := goStmt.Call.Fun.(*ast.FuncLit)
+
+        if (!ok) {
+            return true
+        }
+
+        ast.Inspect(funcLit.Body, func(child ast.Node) bool {
+            call, ok := child.(*ast.CallExpr)
+
+            if (!ok) {
+                return true
+            }
+
+            selector, ok := call.Fun.(*ast.SelectorExpr)
+
+            if (ok && selector.Sel.Name == "Add" &&
+                IsWaitGroup(info, selector.X)) {
+                problems = append(problems, Problem{Check: checkWgAdd,
+                                                    Pos:   call.Pos()})
+            }
+
+            return true
+        })
+
+        return true
+    })
+
+    return problems
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * The passback holds the review's Go files, by directory, so that a file can
+ * be type-checked along with the rest of its package that's in the review.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!strings.HasSuffix(file.Filename, ".go") ||
+        file.Class == reviewdata.ClassGenerated) {
+        return
+    }
+
+    _, changed := file.ReviewLines()
+
+    if (len(changed) == 0) {
+        return
+    }
+
+    fileSet := token.NewFileSet()
+
+    parsed, err := parser.ParseFile(fileSet,
+                                    file.Filename,
+                                    file.EntireFile,
+                                    parser.ParseComments)
+
+    if (err != nil) {
+        return
+    }
+
+    // Add whatever else of the package is in the review
+    var files []*ast.File = []*ast.File{parsed}
+
+    packageFiles, _ := passback.(map[string][]reviewdata.FileDiff)
+
+    for _, other := range packageFiles[path.Dir(file.Filename)] {
+        if (other.Filename == file.Filename) {
+            continue
+        }
+
+        otherParsed, err := parser.ParseFile(fileSet,
+                                             other.Filename,
+                                             other.EntireFile,
+                                             0)
+
+        if (err == nil && otherParsed.Name.Name == parsed.Name.Name) {
+            files = append(files, otherParsed)
+        }
+    }
+
+    info := TypeCheck(fileSet, files)
+
+    var problems []Problem
+
+    problems = append(problems, CheckIgnoredErrors(fileSet, parsed, info)...)
+    problems = append(problems, CheckDeferInLoop(parsed, false)...)
+    problems = append(problems, CheckErrShadow(parsed, info)...)
+    problems = append(problems, CheckWgAdd(parsed, info)...)
+
+    for _, problem := range problems {
+        check := config.GoCheckReviewer.Checks[problem.Check]
+
+        reviewLine, found := changed[fileSet.Position(problem.Pos).Line]
+
+        if (check.Disabled || !found) {
+            continue
+        }
+
+        commentChan <- reviewdata.Comment{Line:       reviewLine,
+                                          NumLines:   1,
+                                          Text:       texts[problem.Check],
+                                          RaiseIssue: check.RaiseIssue,
+                                          Severity:   check.Severity,
+                                          Rule:       check.Rule}
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Passes back the review's Go files, by directory, for type checking.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    packageFiles := make(map[string][]reviewdata.FileDiff)
+
+    for _, file := range review.Files {
+        if (strings.HasSuffix(file.Filename, ".go") &&
+            len(file.EntireFile) > 0) {
+            directory := path.Dir(file.Filename)
+
+            packageFiles[directory] = append(packageFiles[directory], file)
+        }
+    }
+
+    return packageFiles
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.GoCheckReviewer.IgnoredErrorFuncs == nil) {
+        config.GoCheckReviewer.IgnoredErrorFuncs = defaultIgnoredErrorFuncs
+    }
+
+    if (config.GoCheckReviewer.Checks == nil) {
+        config.GoCheckReviewer.Checks = make(map[string]CheckConfig)
+    }
+
+    for check := range texts {
+        checkConfig := config.GoCheckReviewer.Checks[check]
+
+        if (checkConfig.Rule == "") {
+            checkConfig.Rule = check
+        }
+
+        config.GoCheckReviewer.Checks[check] = checkConfig
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,97 @@
+/**
+ * Tests finding errs that shadow another err which is still needed.
+ */
+package main
+
+import (
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "testing"
+)
+
+func TestCheckErrShadow(t *testing.T) {
+    config.GoCheckReviewer.Importer = "none"
+    defer func() { config = Config{} }()
+
+    var tests = []struct {
+        name   string
+        body   string
+        shadow bool
+    }{
+        {"read after the if", `
+            err := f()
+            if err == nil {
+                err := f()
+                _ = err
+            }
+            return err`, true},
+        {"declared in the if", `
+            var err error
+            if err := f(); err != nil {
+                return nil
+            }
+            return err`, true},
+        {"in an else if", `
+            err := f()
+            if err != nil {
+            } else if err := f(); err != nil {
+            }
+            if err != nil {
+            }
+            return nil`, true},
+        {"not used after the if", `
+            err := f()
+            if err == nil {
+                err := f()
+                return err
+            }
+            return nil`, false},
+        {"assigned after the if", `
+            err := f()
+            if err == nil {
+                err := f()
+                _ = err
+            }
+            err = f()
+            return err`, false},
+        {"declared again after the if", `
+            err := f()
+            if err == nil {
+                err := f()
+                _ = err
+            }
+            x, err := 1, f()
+            _ = x
+            return err`, false},
+        {"not shadowing", `
+            if err := f(); err != nil {
+                return err
+            }
+            return nil`, false},
+    }
+
+    for _, test := range tests {
+        var source string = "package p\n" +
+                            "func f() error { return nil }\n" +
+                            "func g() error {" + test.body + "\n}\n"
+
+        fileSet := token.NewFileSet()
+
+        file, err := parser.ParseFile(fileSet, "p.go", source, 0)
+
+        if (err != nil) {
+            t.Errorf("Parsing %q failed: %s", test.name, err)
+            continue
+        }
+
+        problems := CheckErrShadow(file, TypeCheck(fileSet, []*ast.File{file}))
+
+        if ((len(problems) > 0) != test.shadow || len(problems) > 1) {
+            t.Errorf("CheckErrShadow(%s) = %+v; want shadowing %t",
+                     test.name,
+                     problems,
+                     test.shadow)
+        }
+    }
+}
@@ -0,0 +1,498 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "go/build"
+    "go/format"
+    "go/parser"
+    "go/scanner"
+    "go/token"
+    "strconv"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    GofmtReviewer struct {
+        LocalPrefix     string // Imports with this prefix are grouped last,
+                               // as goimports -local does
+        RaiseIssue      bool
+        Severity        string
+        MaxSnippetLines int
+    }
+}
+
+/**
+ * A run of lines in the original file which formatting changes.
+ */
+type Hunk struct {
+    Start    int      // The first original line, counting from 0
+    End      int      // One past the last original line
+    NewLines []string // The formatted lines that replace them
+}
+
+/**
+ * The groups that goimports sorts imports into, in order.
+ */
+const (
+    importStandard = iota
+    importThirdParty
+    importLocal
+)
+
+var (
+    config Config
+
+    importClassNames = []string{"standard library", "third-party", "local"}
+
+    // Whether each import path seen so far is in the standard library
+    standardPaths = make(map[string]bool)
+    standardMutex sync.Mutex
+)
+
+// Larger diffs are reported as a single comment, rather than worked out line
+// by line
+const maxEdits = 2000
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "GofmtReviewer"
+}
+
+/**
+ * Diffs two lists of lines, using Myers' algorithm to find the shortest edit
+ * script.
+ *
+ * @param a The original lines.
+ * @param b The new lines.
+ *
+ * @returns The hunks in which the lists differ, and whether the diff was
+ *          worked out. Diffs of more than maxEdits edits are not.
+ */
+func DiffLines(a []string, b []string) ([]Hunk, bool) {
+    var n int = len(a)
+    var m int = len(b)
+
+    // v[k] holds the furthest x reached on diagonal k. Each round's v is kept,
+    // trimmed to the diagonals that the next round could have used
+    v     := make(map[int]int)
+    trace := []map[int]int{}
+    found := false
+
+    for d := 0; d <= n + m && d <= maxEdits && !found; d++ {
+        saved := make(map[int]int, 2 * d + 3)
+        for k := -d - 1; k <= d + 1; k++ {
+            saved[k] = v[k]
+        }
+        trace = append(trace, saved)
+
+        for k := -d; k <= d && !found; k += 2 {
+            var x int
+
+            if (k == -d || (k != d && v[k - 1] < v[k + 1])) {
+                x = v[k + 1]
+            } else {
+                x = v[k - 1] + 1
+            }
+
+            y := x - k
+
+            for x < n && y < m && a[x] == b[y] {
+                x++
+                y++
+            }
+
+            v[k] = x
+
+            found = (x >= n && y >= m)
+        }
+    }
+
+    if (!found) {
+        return nil, false
+    }
+
+    // Walk back through the rounds, collecting edits in reverse
+    type edit struct {
+        delete bool
+        x      int
+        y      int
+    }
+
+    var edits []edit
+    var x int = n
+    var y int = m
+
+    for d := len(trace) - 1; d > 0; d-- {
+        saved := trace[d]
+        k     := x - y
+
+        var prevK int
+
+        if (k == -d || (k != d && saved[k - 1] < saved[k + 1])) {
+            prevK = k + 1
+        } else {
+            prevK = k - 1
+        }
+
+        prevX := saved[prevK]
+        prevY := prevX - prevK
+
+        for x > prevX && y > prevY {
+            x--
+            y--
+        }
+
+        if (x == prevX) {
+            edits = append(edits, edit{delete: false, x: prevX, y: prevY})
+        } else {
+            edits = append(edits, edit{delete: true, x: prevX, y: prevY})
+        }
+
+        x = prevX
+        y = prevY
+    }
+
+    // Group adjacent edits into hunks
+    var hunks []Hunk
+
+    for i := len(edits) - 1; i >= 0; i-- {
+        e := edits[i]
+
+        var last *Hunk
+
+        if (len(hunks) > 0) {
+            last = &hunks[len(hunks) - 1]
+        }
+
+        if (last == nil || e.x > last.End) {
+            hunks = append(hunks, Hunk{Start: e.x, End: e.x})
+            last  = &hunks[len(hunks) - 1]
+        }
+
+        if (e.delete) {
+            last.End = e.x + 1
+        } else {
+            last.NewLines = append(last.NewLines, b[e.y])
+        }
+    }
+
+    return hunks, true
+}
+
+/**
+ * Builds a markdown snippet of some Go code, cut down to a maximum length.
+ */
+func Snippet(lines []string) string {
+    var maxLines int = config.GofmtReviewer.MaxSnippetLines
+    var cut      bool
+
+    if (len(lines) > maxLines) {
+        lines = lines[:maxLines]
+        cut   = true
+    }
+
+    snippet := "```go\n" + strings.Join(lines, "\n") + "\n```"
+
+    if (cut) {
+        snippet += "\n(and more)"
+    }
+
+    return snippet
+}
+
+/**
+ * Builds a comment with this plugin's issue settings.
+ */
+func NewComment(rule string, text string) reviewdata.Comment {
+    return reviewdata.Comment{Text:       text,
+                              RaiseIssue: config.GofmtReviewer.RaiseIssue,
+                              Severity:   config.GofmtReviewer.Severity,
+                              Rule:       rule}
+}
+
+/**
+ * Places a comment on a range of lines, starting at the first of them that
+ * was changed.
+ *
+ * @param comment The comment.
+ * @param first   The first right-hand line in the range.
+ * @param last    The last right-hand line in the range.
+ * @param all     Every line in the diff.
+ * @param changed The changed lines in the diff.
+ *
+ * @returns Whether any of the lines were changed. If none were, the comment
+ *          shouldn't be made.
+ */
+func PlaceComment(comment *reviewdata.Comment,
+                  first    int,
+                  last     int,
+                  all      map[int]int,
+                  changed  map[int]int) bool {
+    for first <= last {
+        _, found := changed[first]
+
+        if (found) {
+            break
+        }
+
+        first++
+    }
+
+    if (first > last) {
+        return false
+    }
+
+    comment.Line     = changed[first]
+    comment.NumLines = 1
+
+    for ; last > first; last-- {
+        reviewLine, found := all[last]
+
+        if (found) {
+            comment.NumLines = reviewLine - comment.Line + 1
+            break
+        }
+    }
+
+    return true
+}
+
+/**
+ * Works out whether an import path is in the standard library, by finding it
+ * in GOROOT. GOPATH-style paths, e.g. "myproject/db", have no dot either, so
+ * the path alone doesn't tell us.
+ */
+func IsStandard(path string) bool {
+    standardMutex.Lock()
+    defer standardMutex.Unlock()
+
+    standard, found := standardPaths[path]
+
+    if (!found) {
+        pkg, err := build.Import(path, "", build.FindOnly)
+
+        standard = (path == "C" || (err == nil && pkg.Goroot))
+        standardPaths[path] = standard
+    }
+
+    return standard
+}
+
+/**
+ * Classifies an import path into its goimports group.
+ */
+func ImportClass(path string) int {
+    if (config.GofmtReviewer.LocalPrefix != "" &&
+        strings.HasPrefix(path, config.GofmtReviewer.LocalPrefix)) {
+        return importLocal
+    }
+
+    if (IsStandard(path)) {
+        return importStandard
+    }
+
+    return importThirdParty
+}
+
+/**
+ * Checks that imports are grouped as goimports would group them: standard
+ * library, then third-party, then local imports, each group separated by a
+ * blank line.
+ */
+func CheckImports(source      []byte,
+                  all          map[int]int,
+                  changed      map[int]int,
+                  commentChan  chan <- reviewdata.Comment) {
+    fileSet := token.NewFileSet()
+
+    parsed, err := parser.ParseFile(fileSet, "", source, parser.ImportsOnly)
+
+    if (err != nil) {
+        return
+    }
+
+    var blockClass int = -1
+    var lastClass  int = -1
+    var lastLine   int = 0
+
+    for _, spec := range parsed.Imports {
+        path, err := strconv.Unquote(spec.Path.Value)
+
+        if (err != nil) {
+            continue
+        }
+
+        var line  int = fileSet.Position(spec.Pos()).Line
+        var class int = ImportClass(path)
+        var text  string
+
+        if (line > lastLine + 1) {
+            // A blank line, or a new import declaration, starts a group
+            if (class < lastClass) {
+                text = "This " + importClassNames[class] + " import " +
+                       "should be grouped before " +
+                       importClassNames[lastClass] + " imports"
+            }
+
+            blockClass = class
+        } else if (class != blockClass) {
+            text = "This " + importClassNames[class] + " import should be " +
+                   "in its own group, separate from " +
+                   importClassNames[blockClass] + " imports"
+        }
+
+        if (class > lastClass) {
+            lastClass = class
+        }
+
+        lastLine = fileSet.Position(spec.End()).Line
+
+        if (text != "") {
+            comment := NewComment("imports", text)
+
+            if (PlaceComment(&comment, line, line, all, changed)) {
+                commentChan <- comment
+            }
+        }
+    }
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!strings.HasSuffix(file.Filename, ".go") ||
+        len(file.EntireFile) == 0) {
+        return
+    }
+
+    all, changed := file.ReviewLines()
+
+    if (len(changed) == 0) {
+        return
+    }
+
+    // File-wide problems are reported on the first changed line
+    var firstChanged int = -1
+
+    for rhLine := range changed {
+        if (firstChanged < 0 || rhLine < firstChanged) {
+            firstChanged = rhLine
+        }
+    }
+
+    formatted, err := format.Source(file.EntireFile)
+
+    if (err != nil) {
+        // Report the first error once, on its line if that was changed
+        var errLine int = firstChanged
+
+        if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+            err = list[0]
+
+            _, found := changed[list[0].Pos.Line]
+
+            if (found) {
+                errLine = list[0].Pos.Line
+            }
+        }
+
+        comment := NewComment("parse", "This file could not be parsed, so " +
+                                       "could not be checked for formatting: " +
+                                       err.Error())
+
+        PlaceComment(&comment, errLine, errLine, all, changed)
+        commentChan <- comment
+        return
+    }
+
+    original := strings.Split(string(file.EntireFile), "\n")
+    hunks, ok := DiffLines(original,
+                           strings.Split(string(formatted), "\n"))
+
+    if (!ok) {
+        comment := NewComment("format", "This file isn't gofmt-formatted")
+
+        PlaceComment(&comment, firstChanged, firstChanged, all, changed)
+        commentChan <- comment
+    }
+
+    for _, hunk := range hunks {
+        var text  string
+        var first int = hunk.Start + 1
+        var last  int = hunk.End
+
+        if (hunk.Start == hunk.End) {
+            // Only lines were added, after the line before, or at the top of
+            // the file
+            first = hunk.Start
+            last  = hunk.Start
+
+            if (hunk.Start == 0) {
+                first = 1
+                last  = 1
+            }
+        }
+
+        if (len(hunk.NewLines) == 0) {
+            text = "gofmt would remove this"
+        } else {
+            text = "This isn't gofmt-formatted. It should be:\n\n" +
+                   Snippet(hunk.NewLines)
+        }
+
+        comment := NewComment("format", text)
+
+        if (PlaceComment(&comment, first, last, all, changed)) {
+            commentChan <- comment
+        }
+    }
+
+    CheckImports(file.EntireFile, all, changed, commentChan)
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.GofmtReviewer.MaxSnippetLines <= 0) {
+        config.GofmtReviewer.MaxSnippetLines = 20
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,350 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "path"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * How comments are written in a language. Line starts every line of a
+ * comment; Start and End, if set, go on lines of their own around it.
+ */
+type CommentStyle struct {
+    Start string
+    Line  string
+    End   string
+}
+
+type Config struct {
+    LicenseReviewer struct {
+        Headers       []string     // Approved headers, without comment
+                                   // syntax. "{year}" and "{holder}" are
+                                   // placeholders
+        Holders       []string     // Approved copyright holders. The first
+                                   // is suggested. Any holder is accepted if
+                                   // this is empty
+        Lines         int          // How far into a file the header may
+                                   // start and end
+        CheckModified bool         // Whether modified files must also have a
+                                   // header. Their year isn't checked
+        Exclude       []string     // Regexes of files not to check
+        Styles        map[string]CommentStyle // By file name or extension.
+                                              // Added to the defaults. Files
+                                              // without a style aren't
+                                              // checked
+        Severity      string
+    }
+}
+
+var (
+    config Config
+
+    headerRegexes []*regexp.Regexp
+    excludeRegex  *regexp.Regexp
+
+    // A year, or the last year of a range or list, e.g. "2015-2018"
+    yearPattern = "(?:\\d{4}\\s*[-,]\\s*)?(\\d{4})"
+
+    // Comment syntax, which is stripped before headers are matched
+    commentSyntax = regexp.MustCompile("^\\s*(?:/\\*+|\\*+/|\\*|//+|#+|--|;+" +
+                                       "|<!--|-->|\"\"\"|''')?|" +
+                                       "\\s*(?:\\*+/|-->|\"\"\"|''')?\\s*$")
+
+    whitespace = regexp.MustCompile("\\s+")
+
+    cStyle      = CommentStyle{Start: "/*", Line: " * ", End: " */"}
+    slashStyle  = CommentStyle{Line: "// "}
+    hashStyle   = CommentStyle{Line: "# "}
+    dashStyle   = CommentStyle{Line: "-- "}
+    markupStyle = CommentStyle{Start: "<!--", Line: "  ", End: "-->"}
+
+    styles = map[string]CommentStyle{
+        ".c": cStyle, ".h": cStyle, ".css": cStyle,
+
+        ".go": slashStyle, ".cc": slashStyle, ".cpp": slashStyle,
+        ".hpp": slashStyle, ".java": slashStyle, ".js": slashStyle,
+        ".ts": slashStyle, ".cs": slashStyle, ".rs": slashStyle,
+        ".kt": slashStyle, ".scala": slashStyle, ".swift": slashStyle,
+        ".proto": slashStyle,
+
+        ".py": hashStyle, ".sh": hashStyle, ".bash": hashStyle,
+        ".rb": hashStyle, ".pl": hashStyle, ".cmake": hashStyle,
+        ".yaml": hashStyle, ".yml": hashStyle, ".toml": hashStyle,
+        "Makefile": hashStyle, "Dockerfile": hashStyle,
+
+        ".sql": dashStyle, ".lua": dashStyle, ".hs": dashStyle,
+
+        ".html": markupStyle, ".xml": markupStyle,
+    }
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "LicenseReviewer"
+}
+
+/**
+ * Finds the comment style for a file, by its name and then its extension.
+ */
+func StyleFor(filename string) (CommentStyle, bool) {
+    var base string = path.Base(filename)
+
+    style, found := styles[base]
+
+    if (!found) {
+        style, found = styles[path.Ext(base)]
+    }
+
+    return style, found
+}
+
+/**
+ * Strips the comment syntax from the top of a file, leaving its words
+ * separated by single spaces.
+ */
+func HeaderText(lines []string) string {
+    var text []string
+
+    for _, line := range lines {
+        line = commentSyntax.ReplaceAllString(line, "")
+
+        if (strings.TrimSpace(line) != "") {
+            text = append(text, strings.TrimSpace(line))
+        }
+    }
+
+    return whitespace.ReplaceAllString(strings.Join(text, " "), " ")
+}
+
+/**
+ * Turns a header template into a regex which matches its text, as left by
+ * HeaderText.
+ */
+func HeaderRegex(header string) (*regexp.Regexp, error) {
+    var holderPattern string = ".+?"
+
+    if (len(config.LicenseReviewer.Holders) > 0) {
+        var holders []string
+
+        for _, holder := range config.LicenseReviewer.Holders {
+            holders = append(holders, regexp.QuoteMeta(holder))
+        }
+
+        holderPattern = "(?:" + strings.Join(holders, "|") + ")"
+    }
+
+    pattern := regexp.QuoteMeta(HeaderText(strings.Split(header, "\n")))
+    pattern  = strings.Replace(pattern, " ", "\\s+", -1)
+    pattern  = strings.Replace(pattern, "\\{year\\}", yearPattern, -1)
+    pattern  = strings.Replace(pattern, "\\{holder\\}", holderPattern, -1)
+
+    return regexp.Compile("(?i)" + pattern)
+}
+
+/**
+ * Writes out the first approved header for the current year, in a comment
+ * style.
+ */
+func Header(style CommentStyle) string {
+    var holder string = "COPYRIGHT HOLDER"
+    var lines  []string
+
+    if (len(config.LicenseReviewer.Holders) > 0) {
+        holder = config.LicenseReviewer.Holders[0]
+    }
+
+    header := strings.Replace(config.LicenseReviewer.Headers[0],
+                              "{year}",
+                              strconv.Itoa(time.Now().Year()),
+                              -1)
+    header  = strings.Replace(header, "{holder}", holder, -1)
+
+    if (style.Start != "") {
+        lines = append(lines, style.Start)
+    }
+
+    for _, line := range strings.Split(strings.TrimSpace(header), "\n") {
+        lines = append(lines, strings.TrimRight(style.Line + line, " "))
+    }
+
+    if (style.End != "") {
+        lines = append(lines, style.End)
+    }
+
+    return strings.Join(lines, "\n")
+}
+
+/**
+ * Finds the review line of the first line of a file, or the first line in its
+ * diff if that isn't there.
+ */
+func FirstLine(file reviewdata.FileDiff) int {
+    var first int = 0
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        for _, line := range chunk.Lines {
+            if (line.RhLine == 1) {
+                return line.ReviewLine
+            } else if (first == 0 && line.RhLine > 0) {
+                first = line.ReviewLine
+            }
+        }
+    }
+
+    return first
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    var isNew bool = (file.Status == reviewdata.FileAdded)
+
+    if (len(headerRegexes) == 0 ||
+        file.Status == reviewdata.FileDeleted ||
+        (!isNew && !config.LicenseReviewer.CheckModified) ||
+        (excludeRegex != nil && excludeRegex.MatchString(file.Filename))) {
+        return
+    }
+
+    style, found := StyleFor(file.Filename)
+
+    if (!found) {
+        return
+    }
+
+    lines := strings.SplitN(string(file.EntireFile),
+                            "\n",
+                            config.LicenseReviewer.Lines + 1)
+
+    if (len(lines) > config.LicenseReviewer.Lines) {
+        lines = lines[:config.LicenseReviewer.Lines]
+    }
+
+    var text    string = HeaderText(lines)
+    var year    string = strconv.Itoa(time.Now().Year())
+    var oldYear string
+    var problem string
+    var rule    string
+
+    for _, headerRegex := range headerRegexes {
+        match := headerRegex.FindStringSubmatch(text)
+
+        if (match == nil) {
+            continue
+        }
+
+        // Only new files need the current year
+        if (!isNew || len(match) < 2 || match[1] == year) {
+            return
+        }
+
+        oldYear = match[1]
+    }
+
+    if (oldYear != "") {
+        problem = "This new file's copyright year is " + oldYear +
+                  ", but should be " + year
+        rule    = "year"
+    } else if (strings.Contains(strings.ToLower(text), "copyright") ||
+               strings.Contains(strings.ToLower(text), "license")) {
+        problem = "This file's license header doesn't match an approved " +
+                  "header"
+        rule    = "malformed"
+    } else {
+        problem = "This file has no license header"
+        rule    = "missing"
+    }
+
+    var line int = FirstLine(file)
+
+    if (line == 0) {
+        return
+    }
+
+    commentChan <- reviewdata.Comment{
+                       Line:       line,
+                       NumLines:   1,
+                       Text:       problem + ". It should start with:\n\n" +
+                                   "```\n" + Header(style) + "\n```",
+                       RaiseIssue: true,
+                       Severity:   config.LicenseReviewer.Severity,
+                       Rule:       rule}
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.LicenseReviewer.Lines <= 0) {
+        config.LicenseReviewer.Lines = 20
+    }
+
+    for name, style := range config.LicenseReviewer.Styles {
+        styles[name] = style
+    }
+
+    for _, header := range config.LicenseReviewer.Headers {
+        headerRegex, err := HeaderRegex(header)
+
+        if (err != nil) {
+            fmt.Printf("LicenseReviewer: Bad header %s: %s\n", header, err)
+            continue
+        }
+
+        headerRegexes = append(headerRegexes, headerRegex)
+    }
+
+    if (len(config.LicenseReviewer.Exclude) > 0) {
+        var err error
+
+        excludeRegex, err = regexp.Compile(
+                        strings.Join(config.LicenseReviewer.Exclude, "|"))
+
+        if (err != nil) {
+            fmt.Printf("LicenseReviewer: Bad exclusion: %s\n", err)
+        }
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
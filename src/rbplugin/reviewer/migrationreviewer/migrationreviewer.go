@@ -0,0 +1,850 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strings"
+    "sync"
+    "unicode"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * The SQL dialects that rules know about.
+ */
+const (
+    dialectGeneric  = "generic"
+    dialectSqlite   = "sqlite"
+    dialectPostgres = "postgresql"
+    dialectMysql    = "mysql"
+)
+
+/**
+ * The ways of making a CREATE idempotent.
+ */
+const (
+    ifNotExists = "IF NOT EXISTS"
+    orReplace   = "OR REPLACE"
+)
+
+/**
+ * The kinds of token.
+ */
+const (
+    tokenWord   = iota // A keyword or unquoted identifier
+    tokenQuoted        // A quoted identifier
+    tokenString
+    tokenNumber
+    tokenSymbol
+)
+
+/**
+ * Picks the dialect of the files whose names match a regex.
+ */
+type DialectPath struct {
+    Path    string
+    Dialect string
+}
+
+type Config struct {
+    MigrationReviewer struct {
+        Paths       []string          // Regexes of migration directories,
+                                      // whose files are checked whatever
+                                      // their extension. .sql files always are
+        Dialect     string            // The default dialect
+        Dialects    []DialectPath     // The first match wins
+        LargeTables []string          // Tables that mustn't be locked
+        Disabled    []string          // Rules not to check
+        Severities  map[string]string // By rule
+        RaiseIssue  bool              // Errors always raise issues
+    }
+}
+
+/**
+ * A token of SQL.
+ */
+type Token struct {
+    Kind  int
+    Text  string // Upper-cased, for words
+    Raw   string
+    Line  int
+    Depth int    // How deeply it is nested in parentheses
+}
+
+/**
+ * A problem with a statement.
+ */
+type Problem struct {
+    Rule string
+    Text string
+}
+
+var (
+    config Config
+
+    migrationRegex *regexp.Regexp
+    dialectRegexes []*regexp.Regexp
+    largeTables    = make(map[string]bool)
+    disabled       = make(map[string]bool)
+
+    defaultSeverities = map[string]string{
+        "drop-table":               reviewdata.SeverityError,
+        "drop-column":              reviewdata.SeverityError,
+        "table-lock":               reviewdata.SeverityWarning,
+        "not-null-without-default": reviewdata.SeverityError,
+        "missing-where":            reviewdata.SeverityError,
+        "non-idempotent-create":    reviewdata.SeverityWarning,
+    }
+
+    // Words which follow DROP in an ALTER TABLE without naming a column
+    notColumns = map[string]bool{
+        "CONSTRAINT": true, "INDEX": true, "KEY": true, "PRIMARY": true,
+        "FOREIGN": true, "DEFAULT": true, "NOT": true, "CHECK": true,
+        "PARTITION": true, "IDENTITY": true, "EXPRESSION": true,
+    }
+
+    // Words which follow ADD in an ALTER TABLE without adding a column
+    notAddedColumns = map[string]bool{
+        "CONSTRAINT": true, "INDEX": true, "KEY": true, "PRIMARY": true,
+        "FOREIGN": true, "UNIQUE": true, "CHECK": true, "PARTITION": true,
+        "FULLTEXT": true, "SPATIAL": true,
+    }
+
+    // How CREATE can make each kind of object idempotently, by dialect
+    idempotentCreates = map[string]map[string]string{
+        dialectGeneric:  {"TABLE":    ifNotExists,
+                          "INDEX":    ifNotExists,
+                          "VIEW":     ifNotExists,
+                          "SEQUENCE": ifNotExists,
+                          "SCHEMA":   ifNotExists},
+        dialectSqlite:   {"TABLE":    ifNotExists,
+                          "INDEX":    ifNotExists,
+                          "VIEW":     ifNotExists,
+                          "TRIGGER":  ifNotExists},
+        dialectPostgres: {"TABLE":    ifNotExists,
+                          "INDEX":    ifNotExists,
+                          "SEQUENCE": ifNotExists,
+                          "SCHEMA":   ifNotExists,
+                          "VIEW":     orReplace,
+                          "TRIGGER":  orReplace,
+                          "FUNCTION": orReplace},
+        dialectMysql:    {"TABLE":    ifNotExists,
+                          "SCHEMA":   ifNotExists,
+                          "DATABASE": ifNotExists,
+                          "TRIGGER":  ifNotExists,
+                          "VIEW":     orReplace},
+    }
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "MigrationReviewer"
+}
+
+/**
+ * Splits SQL into statements of tokens, leaving out comments. A trigger's
+ * BEGIN ... END block is part of its statement.
+ */
+func Statements(source string) [][]Token {
+    var statements [][]Token
+    var statement  []Token
+    var line       int = 1
+    var depth      int = 0
+    var blocks     int = 0
+
+    runes := []rune(source)
+
+    // Adds the token from start to end, which may span lines
+    add := func(kind int, start int, end int) {
+        var raw  string = string(runes[start:end])
+        var text string = raw
+
+        if (kind == tokenWord) {
+            text = strings.ToUpper(raw)
+
+            switch {
+            case text == "BEGIN" && Find(statement, "TRIGGER") >= 0:
+                blocks++
+            case text == "CASE" && blocks > 0:
+                blocks++
+            case text == "END" && blocks > 0:
+                blocks--
+            }
+        }
+
+        if (raw == ")") {
+            depth--
+        }
+
+        statement = append(statement, Token{Kind:  kind,
+                                            Text:  text,
+                                            Raw:   raw,
+                                            Line:  line,
+                                            Depth: depth})
+
+        if (raw == "(") {
+            depth++
+        }
+
+        line += strings.Count(raw, "\n")
+    }
+
+    for i := 0; i < len(runes); i++ {
+        char := runes[i]
+
+        switch {
+        case char == '\n':
+            line++
+        case unicode.IsSpace(char):
+        case char == '-' && i + 1 < len(runes) && runes[i + 1] == '-':
+            for i < len(runes) && runes[i] != '\n' {
+                i++
+            }
+
+            i--
+        case char == '/' && i + 1 < len(runes) && runes[i + 1] == '*':
+            for i += 2; i < len(runes) && !(runes[i - 1] == '*' &&
+                                             runes[i] == '/'); i++ {
+                if (runes[i] == '\n') {
+                    line++
+                }
+            }
+        case char == '\'' || char == '"' || char == '`' || char == '[':
+            var start   int  = i
+            var closing rune = char
+            var kind    int  = tokenQuoted
+
+            if (char == '[') {
+                closing = ']'
+            } else if (char == '\'') {
+                kind = tokenString
+            }
+
+            // A doubled quote is an escaped one
+            for i++; i < len(runes); i++ {
+                if (runes[i] != closing) {
+                    continue
+                }
+
+                if (closing != ']' && i + 1 < len(runes) &&
+                    runes[i + 1] == closing) {
+                    i++
+                    continue
+                }
+
+                break
+            }
+
+            if (i >= len(runes)) {
+                i = len(runes) - 1
+            }
+
+            add(kind, start, i + 1)
+        case char == '$':
+            // A PostgreSQL dollar-quoted string, e.g. $$ ... $$ or $body$
+            var start int = i
+            var end   int = i + 1
+
+            for end < len(runes) && (runes[end] == '_' ||
+                                     unicode.IsLetter(runes[end]) ||
+                                     unicode.IsDigit(runes[end])) {
+                end++
+            }
+
+            if (end >= len(runes) || runes[end] != '$') {
+                add(tokenSymbol, i, i + 1)
+                continue
+            }
+
+            tag  := string(runes[start:end + 1])
+            rest := string(runes[end + 1:])
+
+            if closing := strings.Index(rest, tag); (closing >= 0) {
+                i = end + len([]rune(rest[:closing + len(tag)]))
+            } else {
+                i = len(runes) - 1
+            }
+
+            add(tokenString, start, i + 1)
+        case unicode.IsDigit(char):
+            var start int = i
+
+            for i + 1 < len(runes) && (unicode.IsDigit(runes[i + 1]) ||
+                                       runes[i + 1] == '.') {
+                i++
+            }
+
+            add(tokenNumber, start, i + 1)
+        case char == '_' || unicode.IsLetter(char):
+            var start int = i
+
+            for i + 1 < len(runes) && (runes[i + 1] == '_' ||
+                                       runes[i + 1] == '$' ||
+                                       unicode.IsLetter(runes[i + 1]) ||
+                                       unicode.IsDigit(runes[i + 1])) {
+                i++
+            }
+
+            add(tokenWord, start, i + 1)
+        case char == ';' && blocks == 0:
+            if (len(statement) > 0) {
+                statements = append(statements, statement)
+            }
+
+            statement = nil
+            depth     = 0
+        default:
+            add(tokenSymbol, i, i + 1)
+        }
+    }
+
+    if (len(statement) > 0) {
+        statements = append(statements, statement)
+    }
+
+    return statements
+}
+
+/**
+ * Finds a sequence of words outside parentheses, e.g. "IF", "NOT", "EXISTS".
+ *
+ * @returns The index of the sequence's first token, or -1 if it isn't found.
+ */
+func Find(tokens []Token, words ...string) int {
+    for i := 0; i + len(words) <= len(tokens); i++ {
+        var found bool = true
+
+        for j, word := range words {
+            token := tokens[i + j]
+
+            if (token.Depth != 0 || token.Kind != tokenWord ||
+                token.Text != word) {
+                found = false
+                break
+            }
+        }
+
+        if (found) {
+            return i
+        }
+    }
+
+    return -1
+}
+
+/**
+ * Retrieves the text of a token, or an empty string past the end.
+ */
+func TextAt(tokens []Token, i int) string {
+    if (i < 0 || i >= len(tokens)) {
+        return ""
+    }
+
+    return tokens[i].Text
+}
+
+/**
+ * Skips a sequence of words, if it is at a position.
+ *
+ * @returns The index just after the sequence, or the position if the sequence
+ *          isn't there.
+ */
+func Skip(tokens []Token, i int, words ...string) int {
+    for j, word := range words {
+        if (TextAt(tokens, i + j) != word) {
+            return i
+        }
+    }
+
+    return i + len(words)
+}
+
+/**
+ * Names the object at a position, e.g. "schema.table".
+ *
+ * @returns The name, and the index just after it.
+ */
+func Name(tokens []Token, i int) (string, int) {
+    var name string
+
+    for i < len(tokens) {
+        if (tokens[i].Kind != tokenWord && tokens[i].Kind != tokenQuoted) {
+            break
+        }
+
+        name += strings.Trim(tokens[i].Raw, "\"`[]")
+        i++
+
+        if (TextAt(tokens, i) != ".") {
+            break
+        }
+
+        name += "."
+        i++
+    }
+
+    return name, i
+}
+
+/**
+ * Works out whether a table is one of the configured large tables, with or
+ * without its schema.
+ */
+func IsLarge(table string) bool {
+    table = strings.ToLower(table)
+
+    return largeTables[table] ||
+           largeTables[table[strings.LastIndex(table, ".") + 1:]]
+}
+
+/**
+ * Splits a statement's tokens at its top-level commas.
+ */
+func Clauses(tokens []Token) [][]Token {
+    var clauses [][]Token
+    var start   int = 0
+
+    for i, token := range tokens {
+        if (token.Depth == 0 && token.Raw == ",") {
+            clauses = append(clauses, tokens[start:i])
+            start = i + 1
+        }
+    }
+
+    return append(clauses, tokens[start:])
+}
+
+/**
+ * Works out how, if at all, an ALTER TABLE or CREATE INDEX locks a large table
+ * in a dialect.
+ *
+ * @returns Advice on avoiding the lock, or an empty string if there isn't one.
+ */
+func LockAdvice(tokens []Token, dialect string, index bool) string {
+    switch (dialect) {
+    case dialectPostgres:
+        if (index) {
+            if (Find(tokens, "CONCURRENTLY") >= 0) {
+                return ""
+            }
+
+            return "Use `CREATE INDEX CONCURRENTLY`"
+        }
+
+        if (Find(tokens, "TYPE") >= 0 ||
+            Find(tokens, "SET", "NOT", "NULL") >= 0) {
+            return "Changing a column's type, or making it NOT NULL, " +
+                   "rewrites or scans the table. Consider adding a new " +
+                   "column, or a NOT VALID check constraint"
+        }
+
+        if ((Find(tokens, "ADD", "CONSTRAINT") >= 0 ||
+             Find(tokens, "ADD", "FOREIGN") >= 0 ||
+             Find(tokens, "ADD", "CHECK") >= 0) &&
+            Find(tokens, "NOT", "VALID") < 0) {
+            return "Add the constraint `NOT VALID`, then `VALIDATE` it " +
+                   "separately"
+        }
+
+        if (Find(tokens, "ADD", "PRIMARY") >= 0 ||
+            Find(tokens, "ADD", "UNIQUE") >= 0) {
+            return "Build the index `CONCURRENTLY` first, then add the " +
+                   "constraint `USING INDEX`"
+        }
+
+        return ""
+    case dialectMysql:
+        var algorithm int = Find(tokens, "ALGORITHM")
+        var lock      int = Find(tokens, "LOCK")
+
+        if (algorithm >= 0 && TextAt(tokens, algorithm + 2) == "INSTANT") {
+            return ""
+        }
+
+        if (algorithm >= 0 && TextAt(tokens, algorithm + 2) == "INPLACE" &&
+            lock >= 0 && TextAt(tokens, lock + 2) == "NONE") {
+            return ""
+        }
+
+        return "Use `ALGORITHM=INSTANT`, or `ALGORITHM=INPLACE, LOCK=NONE`, " +
+               "or an online schema change tool"
+    case dialectSqlite:
+        // Renaming, and adding columns, only change the schema
+        if (!index && (Find(tokens, "RENAME") >= 0 ||
+                       Find(tokens, "ADD") >= 0)) {
+            return ""
+        }
+
+        return "SQLite locks the whole database while this runs"
+    }
+
+    return "Check how long this takes on a copy of production data"
+}
+
+/**
+ * Checks an ALTER TABLE statement.
+ */
+func CheckAlter(tokens []Token, dialect string) []Problem {
+    var problems []Problem
+
+    table, end := Name(tokens, Skip(tokens,
+                                    Skip(tokens, 2, "IF", "EXISTS"),
+                                    "ONLY"))
+
+    for _, clause := range Clauses(tokens[end:]) {
+        if (len(clause) == 0) {
+            continue
+        }
+
+        var drop int = Find(clause, "DROP")
+        var add  int = Find(clause, "ADD")
+
+        if (drop >= 0 && !notColumns[TextAt(clause, drop + 1)]) {
+            column, _ := Name(clause,
+                              Skip(clause,
+                                   Skip(clause, drop + 1, "COLUMN"),
+                                   "IF", "EXISTS"))
+
+            problems = append(problems,
+                              Problem{"drop-column",
+                                      "This drops column `" + column +
+                                      "` from `" + table + "`, losing its " +
+                                      "data. Make sure nothing still uses " +
+                                      "it, including the code that is " +
+                                      "deployed while this runs"})
+        }
+
+        if (add == 0 && !notAddedColumns[TextAt(clause, 1)]) {
+            column, _ := Name(clause,
+                              Skip(clause,
+                                   Skip(clause, 1, "COLUMN"),
+                                   "IF", "NOT", "EXISTS"))
+
+            if (Find(clause, "NOT", "NULL") >= 0 &&
+                Find(clause, "DEFAULT") < 0 &&
+                Find(clause, "GENERATED") < 0 &&
+                Find(clause, "AS") < 0) {
+                problems = append(problems,
+                                  Problem{"not-null-without-default",
+                                          "Column `" + column + "` is " +
+                                          "added NOT NULL without a " +
+                                          "DEFAULT, which fails if `" +
+                                          table + "` has any rows"})
+            }
+        }
+    }
+
+    if advice := LockAdvice(tokens, dialect, false); (IsLarge(table) &&
+                                                      advice != "") {
+        problems = append(problems,
+                          Problem{"table-lock",
+                                  "`" + table + "` is a large table, and " +
+                                  "this may lock it while it runs. " +
+                                  advice})
+    }
+
+    return problems
+}
+
+/**
+ * Checks a CREATE statement.
+ */
+func CheckCreate(tokens []Token, dialect string) []Problem {
+    var problems []Problem
+    var i        int  = 1
+    var replace  bool = false
+
+    if (TextAt(tokens, i) == "OR" && TextAt(tokens, i + 1) == "REPLACE") {
+        replace = true
+        i += 2
+    }
+
+    // Modifiers, up to the kind of object
+    for i < len(tokens) && tokens[i].Kind == tokenWord {
+        switch (tokens[i].Text) {
+        case "UNIQUE", "TEMP", "TEMPORARY", "MATERIALIZED", "UNLOGGED",
+             "GLOBAL", "LOCAL", "FULLTEXT", "SPATIAL", "VIRTUAL":
+            i++
+            continue
+        }
+
+        break
+    }
+
+    var object string = TextAt(tokens, i)
+
+    if (object == "INDEX") {
+        on := Find(tokens, "ON")
+
+        if (on >= 0) {
+            table, _ := Name(tokens, on + 1)
+            advice   := LockAdvice(tokens, dialect, true)
+
+            if (IsLarge(table) && advice != "") {
+                problems = append(problems,
+                                  Problem{"table-lock",
+                                          "`" + table + "` is a large " +
+                                          "table, and building this index " +
+                                          "may lock it. " + advice})
+            }
+        }
+    }
+
+    var fix     string = idempotentCreates[dialect][object]
+    var example string = "CREATE " + object + " " + fix
+
+    if (fix == orReplace) {
+        example = "CREATE " + fix + " " + object
+    }
+
+    if (replace || fix == "" || Find(tokens, "TEMP") >= 0 ||
+        Find(tokens, "TEMPORARY") >= 0 ||
+        Find(tokens, "IF", "NOT", "EXISTS") >= 0) {
+        return problems
+    }
+
+    return append(problems,
+                  Problem{"non-idempotent-create",
+                          "This fails if the " + strings.ToLower(object) +
+                          " already exists, so the migration can't be " +
+                          "re-run. Use `" + example + "`"})
+}
+
+/**
+ * Checks an UPDATE or DELETE statement, after any WITH clause.
+ */
+func CheckWrite(tokens []Token) []Problem {
+    if (Find(tokens, "WHERE") >= 0) {
+        return nil
+    }
+
+    table, _ := Name(tokens, Skip(tokens, Skip(tokens, 1, "FROM"), "ONLY"))
+
+    return []Problem{{"missing-where",
+                      "This " + tokens[0].Text + " has no WHERE clause, so " +
+                      "it affects every row of `" + table + "`. If that's " +
+                      "intended, say so with `WHERE 1 = 1`"}}
+}
+
+/**
+ * Checks a statement.
+ *
+ * @param tokens  The statement's tokens.
+ * @param dialect The dialect that it's written in.
+ *
+ * @returns The problems with it, leaving out disabled rules.
+ */
+func CheckStatement(tokens []Token, dialect string) []Problem {
+    var problems []Problem
+
+    // Skip a common table expression, to get to what it's used for
+    if (tokens[0].Text == "WITH") {
+        for i, token := range tokens {
+            if (token.Depth == 0 && token.Kind == tokenWord &&
+                (token.Text == "UPDATE" || token.Text == "DELETE" ||
+                 token.Text == "INSERT" || token.Text == "SELECT")) {
+                tokens = tokens[i:]
+                break
+            }
+        }
+    }
+
+    switch {
+    case tokens[0].Text == "DROP" && TextAt(tokens, 1) == "TABLE":
+        table, _ := Name(tokens, Skip(tokens, 2, "IF", "EXISTS"))
+
+        problems = append(problems,
+                          Problem{"drop-table",
+                                  "This drops table `" + table + "`, " +
+                                  "losing its data. Make sure nothing " +
+                                  "still uses it, and that it's backed up"})
+    case tokens[0].Text == "ALTER" && TextAt(tokens, 1) == "TABLE":
+        problems = CheckAlter(tokens, dialect)
+    case tokens[0].Text == "CREATE":
+        problems = CheckCreate(tokens, dialect)
+    case tokens[0].Text == "UPDATE" || tokens[0].Text == "DELETE":
+        problems = CheckWrite(tokens)
+    }
+
+    var enabled []Problem
+
+    for _, problem := range problems {
+        if (!disabled[problem.Rule]) {
+            enabled = append(enabled, problem)
+        }
+    }
+
+    return enabled
+}
+
+/**
+ * Picks the dialect of a file.
+ */
+func Dialect(filename string) string {
+    for i, regex := range dialectRegexes {
+        if (regex != nil && regex.MatchString(filename)) {
+            return config.MigrationReviewer.Dialects[i].Dialect
+        }
+    }
+
+    return config.MigrationReviewer.Dialect
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Each changed statement is checked, and commented on at its first changed
+ * line.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (file.Status == reviewdata.FileDeleted ||
+        (!strings.HasSuffix(strings.ToLower(file.Filename), ".sql") &&
+         (migrationRegex == nil ||
+          !migrationRegex.MatchString(file.Filename)))) {
+        return
+    }
+
+    // Right-hand line to review line, for changed lines
+    _, changed := file.ReviewLines()
+
+    var dialect string = Dialect(file.Filename)
+
+    for _, statement := range Statements(string(file.EntireFile)) {
+        var line int = 0
+        var last int = statement[len(statement) - 1].Line
+
+        for rhLine := statement[0].Line; rhLine <= last; rhLine++ {
+            if reviewLine, found := changed[rhLine]; found {
+                line = reviewLine
+                break
+            }
+        }
+
+        if (line == 0) {
+            continue
+        }
+
+        problems := CheckStatement(statement, dialect)
+
+        if (len(problems) == 0) {
+            continue
+        }
+
+        var texts    []string
+        var severity string = reviewdata.SeverityInfo
+
+        for _, problem := range problems {
+            texts = append(texts, problem.Text)
+
+            ruleSeverity := config.MigrationReviewer.Severities[problem.Rule]
+
+            if (reviewdata.SeverityRank(ruleSeverity) >
+                reviewdata.SeverityRank(severity)) {
+                severity = ruleSeverity
+            }
+        }
+
+        commentChan <- reviewdata.Comment{
+                           Line:       line,
+                           NumLines:   1,
+                           Text:       strings.Join(texts, "\n\n"),
+                           RaiseIssue: config.MigrationReviewer.RaiseIssue ||
+                                           severity ==
+                                               reviewdata.SeverityError,
+                           Severity:   severity,
+                           Rule:       problems[0].Rule}
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (len(config.MigrationReviewer.Paths) == 0) {
+        config.MigrationReviewer.Paths = []string{"(^|/)migrations?/"}
+    }
+
+    if (config.MigrationReviewer.Dialect == "") {
+        config.MigrationReviewer.Dialect = dialectGeneric
+    }
+
+    if (config.MigrationReviewer.Severities == nil) {
+        config.MigrationReviewer.Severities = make(map[string]string)
+    }
+
+    for rule, severity := range defaultSeverities {
+        if (config.MigrationReviewer.Severities[rule] == "") {
+            config.MigrationReviewer.Severities[rule] = severity
+        }
+    }
+
+    var err error
+
+    migrationRegex, err = regexp.Compile(
+                        strings.Join(config.MigrationReviewer.Paths, "|"))
+
+    if (err != nil) {
+        fmt.Printf("MigrationReviewer: Bad migration path: %s\n", err)
+    }
+
+    for _, dialectPath := range config.MigrationReviewer.Dialects {
+        regex, err := regexp.Compile(dialectPath.Path)
+
+        if (err != nil) {
+            fmt.Printf("MigrationReviewer: Bad dialect path %s: %s\n",
+                       dialectPath.Path,
+                       err)
+        }
+
+        if (idempotentCreates[dialectPath.Dialect] == nil) {
+            fmt.Printf("MigrationReviewer: Unknown dialect %s\n",
+                       dialectPath.Dialect)
+        }
+
+        dialectRegexes = append(dialectRegexes, regex)
+    }
+
+    for _, table := range config.MigrationReviewer.LargeTables {
+        largeTables[strings.ToLower(table)] = true
+    }
+
+    for _, rule := range config.MigrationReviewer.Disabled {
+        disabled[rule] = true
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
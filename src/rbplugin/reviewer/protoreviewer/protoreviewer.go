@@ -0,0 +1,856 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "unicode"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    ProtoReviewer struct {
+        ExperimentalOnly  bool     // If set, breaking changes are only allowed
+                                   // in experimental files
+        ExperimentalPaths []string // Regexes of experimental files
+        Severity          string
+    }
+}
+
+/**
+ * A token in a .proto file.
+ */
+type Token struct {
+    Text string
+    Line int
+}
+
+/**
+ * A message's field.
+ */
+type Field struct {
+    Name   string
+    Type   string // Including "repeated", if it is
+    Number int
+    Line   int
+}
+
+/**
+ * Field numbers, or enum values, and names which may not be used.
+ */
+type Reserved struct {
+    Ranges [][2]int
+    Names  map[string]bool
+}
+
+/**
+ * A message, and its fields.
+ */
+type Message struct {
+    Fields   []Field
+    Reserved Reserved
+}
+
+/**
+ * An enum value.
+ */
+type Value struct {
+    Number int
+    Line   int
+}
+
+/**
+ * An enum, and its values.
+ */
+type Enum struct {
+    Values   map[string]Value
+    Reserved Reserved
+}
+
+/**
+ * An RPC method.
+ */
+type Rpc struct {
+    Signature string // e.g. "(stream Request) returns (Response)"
+    Line      int
+}
+
+/**
+ * The parts of a .proto file that matter for compatibility. Messages and enums
+ * are keyed by their name within the file, e.g. "Outer.Inner"; RPC methods by
+ * service and method, e.g. "Search.Query".
+ */
+type Schema struct {
+    Messages map[string]*Message
+    Enums    map[string]*Enum
+    Rpcs     map[string]Rpc
+}
+
+/**
+ * An incompatible change.
+ */
+type Problem struct {
+    Rule     string
+    Text     string
+    Line     int  // The line in the file
+    Original bool // Whether Line is in the original file
+}
+
+/**
+ * Parses a .proto file's tokens.
+ */
+type protoParser struct {
+    tokens []Token
+    pos    int
+    schema *Schema
+}
+
+var (
+    config Config
+
+    experimentalRegex *regexp.Regexp
+
+    // The largest field number, for "reserved 10 to max"
+    maxFieldNumber = 536870911
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "ProtoReviewer"
+}
+
+/**
+ * Splits a .proto file into tokens, leaving out comments. Strings are single
+ * tokens, quotes and all.
+ */
+func Tokenize(source string) []Token {
+    var tokens []Token
+    var line   int = 1
+
+    runes := []rune(source)
+
+    for i := 0; i < len(runes); i++ {
+        char := runes[i]
+
+        switch {
+        case char == '\n':
+            line++
+        case unicode.IsSpace(char):
+        case char == '/' && i + 1 < len(runes) && runes[i + 1] == '/':
+            for i < len(runes) && runes[i] != '\n' {
+                i++
+            }
+
+            i--
+        case char == '/' && i + 1 < len(runes) && runes[i + 1] == '*':
+            for i += 2; i < len(runes) && !(runes[i - 1] == '*' &&
+                                             runes[i] == '/'); i++ {
+                if (runes[i] == '\n') {
+                    line++
+                }
+            }
+        case char == '"' || char == '\'':
+            var start int = i
+
+            for i++; i < len(runes) && runes[i] != char; i++ {
+                if (runes[i] == '\\') {
+                    i++
+                }
+            }
+
+            if (i >= len(runes)) {
+                i = len(runes) - 1
+            }
+
+            tokens = append(tokens, Token{string(runes[start:i + 1]), line})
+        case char == '_' || char == '.' || unicode.IsLetter(char) ||
+             unicode.IsDigit(char):
+            var start int = i
+
+            for i + 1 < len(runes) && (runes[i + 1] == '_' ||
+                                       runes[i + 1] == '.' ||
+                                       unicode.IsLetter(runes[i + 1]) ||
+                                       unicode.IsDigit(runes[i + 1])) {
+                i++
+            }
+
+            tokens = append(tokens, Token{string(runes[start:i + 1]), line})
+        default:
+            tokens = append(tokens, Token{string(char), line})
+        }
+    }
+
+    return tokens
+}
+
+/**
+ * Retrieves the current token, without moving past it. Empty at the end of the
+ * file.
+ */
+func (p *protoParser) peek() Token {
+    if (p.pos >= len(p.tokens)) {
+        return Token{}
+    }
+
+    return p.tokens[p.pos]
+}
+
+/**
+ * Retrieves the current token, and moves past it.
+ */
+func (p *protoParser) next() Token {
+    token := p.peek()
+    p.pos++
+
+    return token
+}
+
+/**
+ * Skips a statement, up to and including its semicolon, or its block if it has
+ * one. Braces within brackets are option values, not blocks.
+ */
+func (p *protoParser) skipStatement() {
+    var depth    int = 0
+    var brackets int = 0
+
+    for p.pos < len(p.tokens) {
+        switch (p.next().Text) {
+        case ";":
+            if (depth == 0) {
+                return
+            }
+        case "[":
+            brackets++
+        case "]":
+            brackets--
+        case "{":
+            depth++
+        case "}":
+            depth--
+
+            if (depth <= 0 && brackets <= 0) {
+                return
+            }
+        }
+    }
+}
+
+/**
+ * Parses a number, which may be negative or hexadecimal.
+ *
+ * @returns The number, and whether it could be parsed.
+ */
+func (p *protoParser) number() (int, bool) {
+    var text string = p.next().Text
+
+    if (text == "-") {
+        text = "-" + p.next().Text
+    }
+
+    number, err := strconv.ParseInt(text, 0, 64)
+
+    return int(number), err == nil
+}
+
+/**
+ * Parses a reserved statement, after its "reserved" keyword.
+ */
+func (p *protoParser) reserved(reserved *Reserved) {
+    for p.pos < len(p.tokens) && p.peek().Text != ";" {
+        token := p.peek()
+
+        if (strings.HasPrefix(token.Text, "\"") ||
+            strings.HasPrefix(token.Text, "'")) {
+            reserved.Names[strings.Trim(p.next().Text, "\"'")] = true
+            continue
+        }
+
+        if (token.Text == ",") {
+            p.next()
+            continue
+        }
+
+        from, ok := p.number()
+
+        if (!ok) {
+            continue
+        }
+
+        var to int = from
+
+        if (p.peek().Text == "to") {
+            p.next()
+
+            if (p.peek().Text == "max") {
+                p.next()
+                to = maxFieldNumber
+            } else {
+                to, _ = p.number()
+            }
+        }
+
+        reserved.Ranges = append(reserved.Ranges, [2]int{from, to})
+    }
+
+    p.next()
+}
+
+/**
+ * Parses a field, from its label or type to its semicolon.
+ */
+func (p *protoParser) field(message *Message) {
+    var fieldType string
+    var line      int = p.peek().Line
+
+    if (p.peek().Text == "repeated") {
+        fieldType = "repeated "
+        p.next()
+    } else if (p.peek().Text == "optional" || p.peek().Text == "required") {
+        p.next()
+    }
+
+    if (p.peek().Text == "map") {
+        // map<key, value>
+        for p.pos < len(p.tokens) && p.peek().Text != ">" {
+            fieldType += p.next().Text
+        }
+
+        fieldType += p.next().Text
+        fieldType = strings.Replace(fieldType, ",", ", ", 1)
+    } else {
+        fieldType += p.next().Text
+    }
+
+    name := p.next().Text
+
+    if (p.next().Text != "=") {
+        p.skipStatement()
+        return
+    }
+
+    number, ok := p.number()
+
+    if (ok) {
+        message.Fields = append(message.Fields, Field{Name:   name,
+                                                      Type:   fieldType,
+                                                      Number: number,
+                                                      Line:   line})
+    }
+
+    p.skipStatement()
+}
+
+/**
+ * Parses a message, after its "message" keyword.
+ */
+func (p *protoParser) message(prefix string) {
+    var name    string   = prefix + p.next().Text
+    var message *Message = &Message{Reserved: Reserved{
+                                        Names: make(map[string]bool)}}
+
+    p.schema.Messages[name] = message
+
+    if (p.next().Text != "{") {
+        return
+    }
+
+    p.body(name, message)
+}
+
+/**
+ * Parses the body of a message or oneof, after its opening brace, up to and
+ * including its closing brace. A oneof's fields belong to its message.
+ */
+func (p *protoParser) body(name string, message *Message) {
+    for p.pos < len(p.tokens) {
+        switch (p.peek().Text) {
+        case "}":
+            p.next()
+            return
+        case ";":
+            p.next()
+        case "message":
+            p.next()
+            p.message(name + ".")
+        case "enum":
+            p.next()
+            p.enum(name + ".")
+        case "oneof":
+            p.next()
+            p.next()
+
+            if (p.next().Text == "{") {
+                p.body(name, message)
+            }
+        case "reserved":
+            p.next()
+            p.reserved(&message.Reserved)
+        case "option", "extensions", "extend", "group":
+            p.skipStatement()
+        default:
+            p.field(message)
+        }
+    }
+}
+
+/**
+ * Parses an enum, after its "enum" keyword.
+ */
+func (p *protoParser) enum(prefix string) {
+    var name string = prefix + p.next().Text
+    var enum *Enum  = &Enum{Values:   make(map[string]Value),
+                            Reserved: Reserved{Names: make(map[string]bool)}}
+
+    p.schema.Enums[name] = enum
+
+    if (p.next().Text != "{") {
+        return
+    }
+
+    for p.pos < len(p.tokens) {
+        switch (p.peek().Text) {
+        case "}":
+            p.next()
+            return
+        case ";":
+            p.next()
+        case "reserved":
+            p.next()
+            p.reserved(&enum.Reserved)
+        case "option":
+            p.skipStatement()
+        default:
+            token := p.next()
+
+            if (p.next().Text != "=") {
+                p.skipStatement()
+                continue
+            }
+
+            number, ok := p.number()
+
+            if (ok) {
+                enum.Values[token.Text] = Value{Number: number,
+                                                Line:   token.Line}
+            }
+
+            p.skipStatement()
+        }
+    }
+}
+
+/**
+ * Parses a service, after its "service" keyword.
+ */
+func (p *protoParser) service() {
+    var name string = p.next().Text
+
+    if (p.next().Text != "{") {
+        return
+    }
+
+    for p.pos < len(p.tokens) {
+        switch (p.peek().Text) {
+        case "}":
+            p.next()
+            return
+        case "rpc":
+            var line      int    = p.next().Line
+            var method    string = p.next().Text
+            var signature []string
+
+            // Everything up to the method's options or semicolon
+            for p.pos < len(p.tokens) && p.peek().Text != "{" &&
+                p.peek().Text != ";" {
+                signature = append(signature, p.next().Text)
+            }
+
+            p.schema.Rpcs[name + "." + method] = Rpc{
+                Signature: strings.Replace(
+                               strings.Replace(
+                                   strings.Join(signature, " "),
+                                   "( ", "(", -1),
+                               " )", ")", -1),
+                Line:      line}
+
+            p.skipStatement()
+        default:
+            p.skipStatement()
+        }
+    }
+}
+
+/**
+ * Parses a .proto file. Anything that isn't understood is skipped, so a
+ * malformed file gives a partial schema rather than an error.
+ */
+func Parse(source string) *Schema {
+    parser := &protoParser{tokens: Tokenize(source),
+                           schema: &Schema{
+                               Messages: make(map[string]*Message),
+                               Enums:    make(map[string]*Enum),
+                               Rpcs:     make(map[string]Rpc)}}
+
+    for parser.pos < len(parser.tokens) {
+        switch (parser.next().Text) {
+        case "message":
+            parser.message("")
+        case "enum":
+            parser.enum("")
+        case "service":
+            parser.service()
+        case ";":
+        default:
+            parser.skipStatement()
+        }
+    }
+
+    return parser.schema
+}
+
+/**
+ * Works out whether a number or name is reserved.
+ */
+func (r Reserved) Has(number int, name string) bool {
+    if (r.Names[name]) {
+        return true
+    }
+
+    for _, numbers := range r.Ranges {
+        if (number >= numbers[0] && number <= numbers[1]) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Compares a message's fields, before and after a change.
+ */
+func CompareMessage(name     string,
+                    original *Message,
+                    current  *Message) []Problem {
+    var problems []Problem
+
+    byNumber := make(map[int]Field)
+    byName   := make(map[string]Field)
+
+    for _, field := range current.Fields {
+        byNumber[field.Number] = field
+        byName[field.Name]     = field
+
+        if (original.Reserved.Has(field.Number, "")) {
+            problems = append(problems,
+                              Problem{Rule: "reused-number",
+                                      Text: "Field `" + name + "." +
+                                            field.Name + "` uses " +
+                                            strconv.Itoa(field.Number) +
+                                            ", which was reserved",
+                                      Line: field.Line})
+        }
+    }
+
+    for _, field := range original.Fields {
+        var number string = strconv.Itoa(field.Number)
+        var full   string = name + "." + field.Name
+
+        now, found := byNumber[field.Number]
+
+        if (!found) {
+            if renamed, moved := byName[field.Name]; moved {
+                problems = append(problems,
+                                  Problem{Rule: "renumbered-field",
+                                          Text: "Field `" + full + "` was " +
+                                                "renumbered from " + number +
+                                                " to " +
+                                                strconv.Itoa(renamed.Number),
+                                          Line: renamed.Line})
+            } else if (!current.Reserved.Has(field.Number, "")) {
+                problems = append(problems,
+                                  Problem{Rule:     "removed-field",
+                                          Text:     "Field `" + full + "` " +
+                                                    "was removed, but " +
+                                                    number + " isn't " +
+                                                    "reserved. Add " +
+                                                    "`reserved " + number +
+                                                    ";` and `reserved \"" +
+                                                    field.Name + "\";`",
+                                          Line:     field.Line,
+                                          Original: true})
+            }
+
+            continue
+        }
+
+        // A different field with the same number reuses it, whatever its
+        // type
+        if (now.Name != field.Name) {
+            problems = append(problems,
+                              Problem{Rule: "reused-number",
+                                      Text: "Field `" + name + "." +
+                                            now.Name + "` reuses " + number +
+                                            ", which was `" + field.Type +
+                                            " " + field.Name + "`",
+                                      Line: now.Line})
+        } else if (now.Type != field.Type) {
+            problems = append(problems,
+                              Problem{Rule: "field-type",
+                                      Text: "Field `" + full + "` changed " +
+                                            "type from `" + field.Type +
+                                            "` to `" + now.Type + "`",
+                                      Line: now.Line})
+        }
+    }
+
+    return problems
+}
+
+/**
+ * Compares an enum's values, before and after a change.
+ */
+func CompareEnum(name string, original *Enum, current *Enum) []Problem {
+    var problems []Problem
+    var names    []string
+
+    byNumber := make(map[int]string)
+
+    for value, after := range current.Values {
+        byNumber[after.Number] = value
+    }
+
+    for value := range original.Values {
+        names = append(names, value)
+    }
+
+    sort.Strings(names)
+
+    for _, value := range names {
+        var before Value  = original.Values[value]
+        var full   string = name + "." + value
+        var number string = strconv.Itoa(before.Number)
+
+        if after, found := current.Values[value]; found {
+            if (after.Number != before.Number) {
+                problems = append(problems,
+                                  Problem{Rule: "enum-value",
+                                          Text: "Enum value `" + full +
+                                                "` was renumbered from " +
+                                                number + " to " +
+                                                strconv.Itoa(after.Number),
+                                          Line: after.Line})
+            }
+
+            continue
+        }
+
+        if renamed, found := byNumber[before.Number]; found {
+            problems = append(problems,
+                              Problem{Rule: "enum-value",
+                                      Text: "Enum value `" + full + "` was " +
+                                            "renamed to `" + renamed + "`",
+                                      Line: current.Values[renamed].Line})
+        } else if (!current.Reserved.Has(before.Number, value)) {
+            problems = append(problems,
+                              Problem{Rule:     "enum-value",
+                                      Text:     "Enum value `" + full +
+                                                "` was removed, but " +
+                                                number + " isn't reserved",
+                                      Line:     before.Line,
+                                      Original: true})
+        }
+    }
+
+    return problems
+}
+
+/**
+ * Compares two versions of a .proto file.
+ *
+ * @returns The incompatible changes.
+ */
+func Compare(original *Schema, current *Schema) []Problem {
+    var problems []Problem
+    var names    []string
+
+    for name := range original.Messages {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    // A removed message or enum isn't a problem in itself, as whatever used
+    // it has to change too
+    for _, name := range names {
+        if message, found := current.Messages[name]; found {
+            problems = append(problems,
+                              CompareMessage(name,
+                                             original.Messages[name],
+                                             message)...)
+        }
+    }
+
+    names = nil
+
+    for name := range original.Enums {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    for _, name := range names {
+        if enum, found := current.Enums[name]; found {
+            problems = append(problems,
+                              CompareEnum(name, original.Enums[name], enum)...)
+        }
+    }
+
+    names = nil
+
+    for name := range original.Rpcs {
+        names = append(names, name)
+    }
+
+    sort.Strings(names)
+
+    for _, name := range names {
+        before       := original.Rpcs[name]
+        after, found := current.Rpcs[name]
+
+        if (!found) {
+            problems = append(problems,
+                              Problem{Rule:     "removed-rpc",
+                                      Text:     "RPC method `" + name +
+                                                "` was removed",
+                                      Line:     before.Line,
+                                      Original: true})
+        } else if (after.Signature != before.Signature) {
+            problems = append(problems,
+                              Problem{Rule: "rpc-signature",
+                                      Text: "RPC method `" + name + "` " +
+                                            "changed from `" +
+                                            before.Signature + "` to `" +
+                                            after.Signature + "`",
+                                      Line: after.Line})
+        }
+    }
+
+    return problems
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Incompatible changes raise issues on the lines that make them, or, for
+ * removals, where the removed lines were.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (!strings.HasSuffix(file.Filename, ".proto") ||
+        file.Status == reviewdata.FileAdded ||
+        file.Status == reviewdata.FileDeleted ||
+        len(file.OriginalFile) == 0) {
+        return
+    }
+
+    problems := Compare(Parse(string(file.OriginalFile)),
+                        Parse(string(file.EntireFile)))
+
+    var allowed bool = config.ProtoReviewer.ExperimentalOnly &&
+                       experimentalRegex != nil &&
+                       experimentalRegex.MatchString(file.Filename)
+
+    // Right-hand line to review line
+    lines, _ := file.ReviewLines()
+
+    for _, problem := range problems {
+        var line int = lines[problem.Line]
+
+        if (problem.Original) {
+            line = file.ReviewLineForLh(problem.Line)
+        }
+
+        if (line == 0) {
+            continue
+        }
+
+        var comment = reviewdata.Comment{
+                          Line:       line,
+                          NumLines:   1,
+                          Text:       problem.Text + ". This breaks " +
+                                      "compatibility with existing clients " +
+                                      "and data",
+                          RaiseIssue: true,
+                          Severity:   config.ProtoReviewer.Severity,
+                          Rule:       problem.Rule}
+
+        if (allowed) {
+            comment.Text       = problem.Text + ". This is allowed, as the " +
+                                 "file is experimental"
+            comment.RaiseIssue = false
+            comment.Severity   = reviewdata.SeverityInfo
+        }
+
+        commentChan <- comment
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.ProtoReviewer.Severity == "") {
+        config.ProtoReviewer.Severity = reviewdata.SeverityError
+    }
+
+    if (len(config.ProtoReviewer.ExperimentalPaths) == 0) {
+        config.ProtoReviewer.ExperimentalPaths = []string{"(^|/)experimental/"}
+    }
+
+    var err error
+
+    experimentalRegex, err = regexp.Compile(
+                    strings.Join(config.ProtoReviewer.ExperimentalPaths, "|"))
+
+    if (err != nil) {
+        fmt.Printf("ProtoReviewer: Bad experimental path: %s\n", err)
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
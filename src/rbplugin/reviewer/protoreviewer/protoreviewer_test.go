@@ -0,0 +1,82 @@
+/**
+ * Tests finding incompatible changes to a message's fields.
+ */
+package main
+
+import (
+    "testing"
+)
+
+func TestCompareMessage(t *testing.T) {
+    var original = `message User {
+                        string name = 1;
+                        int32 age = 2;
+                        reserved 9;
+                    }`
+
+    var tests = []struct {
+        name    string
+        current string
+        rules   []string
+    }{
+        {"unchanged", original, nil},
+        {"renamed, same type", `message User {
+                                    string full_name = 1;
+                                    int32 age = 2;
+                                }`, []string{"reused-number"}},
+        {"renamed, new type", `message User {
+                                   string name = 1;
+                                   int64 years = 2;
+                               }`, []string{"reused-number"}},
+        {"new type", `message User {
+                          string name = 1;
+                          int64 age = 2;
+                      }`, []string{"field-type"}},
+        {"renumbered", `message User {
+                            string name = 1;
+                            int32 age = 3;
+                        }`, []string{"renumbered-field"}},
+        {"removed", `message User {
+                         string name = 1;
+                     }`, []string{"removed-field"}},
+        {"removed and reserved", `message User {
+                                      string name = 1;
+                                      reserved 2;
+                                  }`, nil},
+        {"reserved number used", `message User {
+                                      string name = 1;
+                                      int32 age = 2;
+                                      string email = 9;
+                                  }`, []string{"reused-number"}},
+    }
+
+    for _, test := range tests {
+        problems := CompareMessage("User",
+                                   Parse(original).Messages["User"],
+                                   Parse(test.current).Messages["User"])
+
+        var rules []string
+
+        for _, problem := range problems {
+            rules = append(rules, problem.Rule)
+        }
+
+        if (len(rules) != len(test.rules)) {
+            t.Errorf("CompareMessage(%s) = %+v; want rules %v",
+                     test.name,
+                     problems,
+                     test.rules)
+            continue
+        }
+
+        for i := range rules {
+            if (rules[i] != test.rules[i]) {
+                t.Errorf("CompareMessage(%s) = %+v; want rules %v",
+                         test.name,
+                         problems,
+                         test.rules)
+                break
+            }
+        }
+    }
+}
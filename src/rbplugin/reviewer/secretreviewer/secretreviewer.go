@@ -0,0 +1,415 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "math"
+    "regexp"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * Finds one kind of secret in a line. If the regex has a group, the first
+ * group is the secret; otherwise the whole match is.
+ */
+type Detector struct {
+    Rule        string
+    Description string
+    Regex       *regexp.Regexp
+}
+
+type Config struct {
+    SecretReviewer struct {
+        Allowlist  []string          // Fingerprints of strings which aren't
+                                     // secrets, e.g. test fixtures
+        MinEntropy float64           // Bits per character a value assigned to
+                                     // a secret-sounding name needs
+        MinLength  int               // Characters that value needs
+        Exclude    []string          // Regexes of files not to check
+        Patterns   map[string]string // Extra detectors, by description
+        Severity   string
+    }
+}
+
+/**
+ * A secret found in a line.
+ */
+type Secret struct {
+    Rule        string
+    Description string
+    Value       string // What the fingerprint is taken from. Empty if the
+                       // secret can't be allowlisted
+}
+
+var (
+    config Config
+
+    allowlist    = make(map[string]bool)
+    excludeRegex *regexp.Regexp
+
+    detectors = []Detector{
+        {"private-key", "a private key",
+         regexp.MustCompile("-----BEGIN ((?:[A-Z]+ )?PRIVATE KEY)" +
+                            "(?: BLOCK)?-----")},
+        {"aws-access-key", "an AWS access key ID",
+         regexp.MustCompile("\\b((?:AKIA|ASIA)[0-9A-Z]{16})\\b")},
+        {"aws-secret-key", "an AWS secret access key",
+         regexp.MustCompile("(?i)aws_?secret_?(?:access_?)?key[\"']?\\s*" +
+                            "(?::=|=|:)\\s*[\"']?([A-Za-z0-9/+=]{40})\\b")},
+        {"bearer-token", "a bearer token",
+         regexp.MustCompile("(?i)\\b(?:bearer|token)\\s+" +
+                            "([A-Za-z0-9\\-._~+/]{20,}=*)")},
+        {"url-password", "a password in a URL",
+         regexp.MustCompile("\\b[a-zA-Z][a-zA-Z0-9+.\\-]*://[^/\\s:@]+:" +
+                            "([^/\\s:@]+)@")},
+    }
+
+    // Values assigned to names like these are checked for entropy
+    assignmentRegex = regexp.MustCompile(
+                        "(?i)[a-z0-9_.\\-]*(?:key|secret|token|passw(?:or)?d|" +
+                        "pwd|credential|auth)[a-z0-9_.\\-]*[\"']?\\s*" +
+                        "(?::=|=|:)\\s*[\"'`]([^\"'`\\s]+)[\"'`]")
+
+    // A private key's material: after its header on the same line, e.g. in a
+    // string with escaped newlines, or as the whole of a later line, perhaps
+    // quoted
+    inlineKeyRegex = regexp.MustCompile("^(?:\\s|\\\\[rn])*" +
+                                        "([A-Za-z0-9+/]{16,}={0,2})")
+    keyLineRegex   = regexp.MustCompile("^[\"'`]?([A-Za-z0-9+/]{16,}={0,2})" +
+                                        "(?:\\\\[rn])*[\"'`]?\\s*[,+]?$")
+
+    // The end of a header's line that's followed by its key, e.g. closing a
+    // string
+    headerEndRegex = regexp.MustCompile("^(?:\\s|\\\\[rn])*[\"'`]?" +
+                                        "\\s*[,+]?\\s*$")
+
+    // Encrypted keys' headers, e.g. "Proc-Type: 4,ENCRYPTED"
+    keyHeaderRegex = regexp.MustCompile("^[\"'`]?[A-Za-z-]+: ")
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "SecretReviewer"
+}
+
+/**
+ * Works out a string's fingerprint, which identifies it without revealing it.
+ */
+func Fingerprint(value string) string {
+    sum := sha256.Sum256([]byte(value))
+
+    return hex.EncodeToString(sum[:8])
+}
+
+/**
+ * Hides all but the start of a secret.
+ */
+func Redact(value string) string {
+    var shown int = 4
+
+    if (len(value) < 12) {
+        shown = 0
+    }
+
+    return value[:shown] + strings.Repeat("*", 8)
+}
+
+/**
+ * Works out whether a value is obviously a placeholder, such as "${TOKEN}" or
+ * "xxxxxxxx".
+ */
+func IsPlaceholder(value string) bool {
+    if (value == "" || strings.ContainsAny(value[:1], "${<%")) {
+        return true
+    }
+
+    return strings.Trim(value, value[:1]) == ""
+}
+
+/**
+ * Works out the Shannon entropy of a string, in bits per character.
+ */
+func Entropy(value string) float64 {
+    counts := make(map[rune]float64)
+    var total float64 = 0
+
+    for _, char := range value {
+        counts[char]++
+        total++
+    }
+
+    var entropy float64 = 0
+
+    for _, count := range counts {
+        entropy -= (count / total) * math.Log2(count / total)
+    }
+
+    return entropy
+}
+
+/**
+ * Finds a private key's first line of key material, from which its
+ * fingerprint is taken.
+ *
+ * @param after     The rest of the key's header line.
+ * @param following The lines after the header.
+ *
+ * @returns The key material, or an empty string if there's none to be found,
+ *          in which case the key can't be allowlisted.
+ */
+func KeyMaterial(after string, following []string) string {
+    if match := inlineKeyRegex.FindStringSubmatch(after); (match != nil) {
+        return match[1]
+    }
+
+    // Anything else on the header's line means it isn't followed by the key
+    if (!headerEndRegex.MatchString(after)) {
+        return ""
+    }
+
+    for i, line := range following {
+        line = strings.TrimSpace(line)
+
+        // Headers, and the blank line after them, come before the key
+        if (keyHeaderRegex.MatchString(line) ||
+            (line == "" && i > 0 &&
+             keyHeaderRegex.MatchString(strings.TrimSpace(following[i - 1])))) {
+            continue
+        }
+
+        if match := keyLineRegex.FindStringSubmatch(line); (match != nil) {
+            return match[1]
+        }
+
+        break
+    }
+
+    return ""
+}
+
+/**
+ * Finds the secrets in a line.
+ *
+ * @param text      The line.
+ * @param following The lines after it, if known. A private key's fingerprint
+ *                  is taken from its first line of key material.
+ *
+ * @returns The secrets, leaving out any that are allowlisted.
+ */
+func FindSecrets(text string, following []string) []Secret {
+    var secrets []Secret
+
+    found := make(map[string]bool)
+
+    add := func(rule          string,
+                description   string,
+                value         string,
+                fingerprinted string) {
+        if (found[value] ||
+            (fingerprinted != "" && allowlist[Fingerprint(fingerprinted)])) {
+            return
+        }
+
+        found[value] = true
+        secrets = append(secrets, Secret{Rule:        rule,
+                                         Description: description,
+                                         Value:       fingerprinted})
+    }
+
+    for _, detector := range detectors {
+        for _, indices := range detector.Regex.FindAllStringSubmatchIndex(text,
+                                                                          -1) {
+            var value string = text[indices[0]:indices[1]]
+
+            if (len(indices) > 2) {
+                value = text[indices[2]:indices[3]]
+            }
+
+            if (detector.Rule == "private-key") {
+                add(detector.Rule,
+                    detector.Description,
+                    value,
+                    KeyMaterial(text[indices[1]:], following))
+            } else if (!IsPlaceholder(value)) {
+                add(detector.Rule, detector.Description, value, value)
+            }
+        }
+    }
+
+    for _, match := range assignmentRegex.FindAllStringSubmatch(text, -1) {
+        value := match[1]
+
+        if (len(value) >= config.SecretReviewer.MinLength &&
+            !IsPlaceholder(value) &&
+            Entropy(value) >= config.SecretReviewer.MinEntropy) {
+            add("high-entropy", "a high-entropy secret", value, value)
+        }
+    }
+
+    return secrets
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Secrets are never logged, and are redacted from comments.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (excludeRegex != nil && excludeRegex.MatchString(file.Filename)) {
+        return
+    }
+
+    raw := strings.Split(string(file.EntireFile), "\n")
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        if (chunk.Change != "insert" && chunk.Change != "replace") {
+            continue
+        }
+
+        for _, line := range chunk.Lines {
+            if (line.RhLine == 0) {
+                continue
+            }
+
+            var following []string
+
+            if (line.RhLine < len(raw)) {
+                following = raw[line.RhLine:]
+            }
+
+            secrets := FindSecrets(line.RhText, following)
+
+            if (len(secrets) == 0) {
+                continue
+            }
+
+            var found        []string
+            var fingerprints []string
+
+            for _, secret := range secrets {
+                var shown string = secret.Description
+
+                // A private key's header line gives nothing away
+                if (secret.Rule != "private-key") {
+                    shown += " (`" + Redact(secret.Value) + "`)"
+                }
+
+                found = append(found, shown)
+
+                if (secret.Value != "") {
+                    fingerprints = append(fingerprints,
+                                          "`" + Fingerprint(secret.Value) +
+                                          "`")
+                }
+            }
+
+            var text string = "This line appears to contain " +
+                              strings.Join(found, " and ") + ". Secrets " +
+                              "must not be committed: remove it and revoke it."
+
+            if (len(fingerprints) < len(secrets)) {
+                text += " A private key can only be allowlisted when its " +
+                        "key material follows its header."
+            }
+
+            if (len(fingerprints) > 0) {
+                text += " If it isn't a secret, add " +
+                        strings.Join(fingerprints, " and ") + " to the " +
+                        "SecretReviewer allowlist."
+            }
+
+            commentChan <- reviewdata.Comment{
+                Line:       line.ReviewLine,
+                NumLines:   1,
+                Text:       text,
+                RaiseIssue: true,
+                Severity:   config.SecretReviewer.Severity,
+                Rule:       secrets[0].Rule}
+        }
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.SecretReviewer.MinEntropy <= 0) {
+        config.SecretReviewer.MinEntropy = 3.5
+    }
+
+    if (config.SecretReviewer.MinLength <= 0) {
+        config.SecretReviewer.MinLength = 16
+    }
+
+    if (config.SecretReviewer.Severity == "") {
+        config.SecretReviewer.Severity = reviewdata.SeverityError
+    }
+
+    for _, fingerprint := range config.SecretReviewer.Allowlist {
+        allowlist[strings.ToLower(fingerprint)] = true
+    }
+
+    for description, pattern := range config.SecretReviewer.Patterns {
+        regex, err := regexp.Compile(pattern)
+
+        if (err != nil) {
+            // The pattern itself is fine to log; only matches are secret
+            fmt.Printf("SecretReviewer: Bad pattern %s: %s\n", pattern, err)
+            continue
+        }
+
+        detectors = append(detectors, Detector{Rule:        "custom",
+                                               Description: description,
+                                               Regex:       regex})
+    }
+
+    if (len(config.SecretReviewer.Exclude) > 0) {
+        var err error
+
+        excludeRegex, err = regexp.Compile(
+                        strings.Join(config.SecretReviewer.Exclude, "|"))
+
+        if (err != nil) {
+            fmt.Printf("SecretReviewer: Bad exclusion: %s\n", err)
+        }
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,772 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    ShellReviewer struct {
+        Disabled   []string          // Rules not to check
+        Severities map[string]string // By rule
+        RaiseIssue bool
+    }
+}
+
+/**
+ * A word or operator of a shell script. Newlines are operators too.
+ */
+type Token struct {
+    Text     string // Raw, including any quotes
+    Line     int    // The line that the token starts on
+    Operator bool
+}
+
+/**
+ * A simple command, such as `cd "$dir"`, with the operators around it.
+ */
+type Command struct {
+    Words       []Token // Leading reserved words and assignments removed
+    Assignments []Token // The leading VAR=value words
+    Condition   bool    // Whether it's tested by if, while, until or !
+    Next        string  // The operator after it
+}
+
+/**
+ * A problem on a line.
+ */
+type Finding struct {
+    Rule string
+    Line int
+    Text string
+}
+
+var (
+    config Config
+
+    disabled = make(map[string]bool)
+
+    defaultSeverities = map[string]string{
+        "unquoted-variable":     reviewdata.SeverityWarning,
+        "cd-unchecked":          reviewdata.SeverityWarning,
+        "backticks":             reviewdata.SeverityInfo,
+        "unquoted-test-operand": reviewdata.SeverityWarning,
+        "rm-variable-path":      reviewdata.SeverityError,
+        "strict-mode":           reviewdata.SeverityWarning,
+    }
+
+    shebangRegex = regexp.MustCompile(
+                        "^#!\\s*(?:\\S*/)?(?:env\\s+)?(sh|bash|dash|ksh|zsh)" +
+                        "(?:\\s+(.*))?$")
+
+    suppressRegex = regexp.MustCompile("reviewbot:\\s*disable=([\\w,-]+)")
+
+    assignmentRegex = regexp.MustCompile("^[A-Za-z_]\\w*(\\[[^]]*\\])?\\+?=")
+
+    // $VAR/... or ${VAR}/..., once quotes are removed. ${VAR:?} isn't matched
+    variablePathRegex = regexp.MustCompile("^\\$(\\{\\w+\\}|\\w+)/")
+
+    // Separate commands, as opposed to redirections, which are part of one
+    separators = map[string]bool{
+        "\n": true, ";": true, "&": true, "&&": true, "||": true, "|": true,
+        "|&": true, "(": true, ")": true, ";;": true,
+    }
+
+    reserved = map[string]bool{
+        "if": true, "then": true, "else": true, "elif": true, "fi": true,
+        "do": true, "done": true, "while": true, "until": true, "!": true,
+        "{": true, "}": true, "time": true, "esac": true,
+    }
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "ShellReviewer"
+}
+
+/**
+ * Works out which shell a script is for.
+ *
+ * @returns The shell, or "" if the file isn't a shell script, and the options
+ *          given on the shebang line.
+ */
+func Shell(filename string, source string) (string, string) {
+    var firstLine string = strings.SplitN(source, "\n", 2)[0]
+
+    if match := shebangRegex.FindStringSubmatch(strings.TrimSpace(firstLine));
+       (match != nil) {
+        return match[1], match[2]
+    }
+
+    if (strings.HasSuffix(filename, ".sh") ||
+        strings.HasSuffix(filename, ".bash")) {
+        return "bash", ""
+    }
+
+    return "", ""
+}
+
+/**
+ * Skips a quoted string, command substitution or backtick substitution.
+ *
+ * @param source The script.
+ * @param i      The index of the opening quote, or of the $ of $( or ${.
+ * @param line   Counts the newlines skipped.
+ * @returns The index after the closing quote or bracket.
+ */
+func skipQuoted(source string, i int, line *int) int {
+    var opening byte = source[i]
+    var closing byte = opening
+    var depth   int  = 1
+
+    if (opening == '$') {
+        closing = ')'
+
+        if (source[i + 1] == '{') {
+            closing = '}'
+        }
+
+        i++
+    }
+
+    for i++; (i < len(source)); i++ {
+        var c byte = source[i]
+
+        switch {
+        case (c == '\n'):
+            *line++
+        case (opening == '\''):
+            if (c == '\'') {
+                return i + 1
+            }
+        case (c == '\\'):
+            if (i + 1 < len(source) && source[i + 1] == '\n') {
+                *line++
+            }
+
+            i++
+        case (c == closing && closing != ')'):
+            return i + 1
+        case (c == ')' && closing == ')'):
+            depth--
+
+            if (depth == 0) {
+                return i + 1
+            }
+        case (c == '(' && closing == ')'):
+            depth++
+        case (opening == '`'):
+        case (c == '`' || (c == '\'' && opening != '"') ||
+              (c == '"' && opening != '"')),
+             (c == '$' && i + 1 < len(source) &&
+              (source[i + 1] == '(' || source[i + 1] == '{')):
+            i = skipQuoted(source, i, line) - 1
+        }
+    }
+
+    return len(source)
+}
+
+/**
+ * Splits a shell script into words and operators. Here-document bodies are
+ * skipped, and comments are returned by line.
+ */
+func Tokenize(source string) ([]Token, map[int]string) {
+    var tokens    []Token
+    var heredocs  []string // Delimiters, with "-" in front to strip tabs
+    var line      int = 1
+    var tokenLine int = 0  // The last line that a token started on
+
+    comments := make(map[int]string)
+
+    for i := 0; (i < len(source)); {
+        var c byte = source[i]
+
+        switch {
+        case (c == '\\' && i + 1 < len(source) && source[i + 1] == '\n'):
+            line++
+            i += 2
+        case (c == ' ' || c == '\t' || c == '\r'):
+            i++
+        case (c == '\n'):
+            tokens = append(tokens, Token{"\n", line, true})
+            line++
+            i++
+
+            // Skip the bodies of any here-documents started on the line
+            for _, delimiter := range heredocs {
+                for (i < len(source)) {
+                    end := strings.IndexByte(source[i:], '\n')
+
+                    if (end < 0) {
+                        end = len(source) - i
+                    }
+
+                    body := source[i:i + end]
+                    i    += end + 1
+                    line++
+
+                    if (strings.HasPrefix(delimiter, "-")) {
+                        body = strings.TrimLeft(body, "\t")
+                    }
+
+                    if (body == strings.TrimPrefix(delimiter, "-")) {
+                        break
+                    }
+                }
+            }
+
+            heredocs = nil
+        case (c == '#'):
+            end := strings.IndexByte(source[i:], '\n')
+
+            if (end < 0) {
+                end = len(source) - i
+            }
+
+            // A comment on its own line is about the next one
+            if (tokenLine == line) {
+                comments[line] = source[i:i + end]
+            } else {
+                comments[line + 1] = source[i:i + end]
+            }
+
+            i += end
+        case (strings.IndexByte(";&|()<>", c) >= 0):
+            var operator string = string(c)
+
+            for _, long := range []string{"<<-", "&&", "||", ";;", "|&", "<<",
+                                          ">>", "&>", ">&", "<&"} {
+                if (strings.HasPrefix(source[i:], long)) {
+                    operator = long
+                    break
+                }
+            }
+
+            tokens    = append(tokens, Token{operator, line, true})
+            tokenLine = line
+            i        += len(operator)
+
+            if (operator == "<<" || operator == "<<-") {
+                for (i < len(source) && source[i] == ' ') {
+                    i++
+                }
+
+                start := i
+
+                for (i < len(source) &&
+                     strings.IndexByte(" \t\n;&|()<>", source[i]) < 0) {
+                    i++
+                }
+
+                tokens = append(tokens, Token{source[start:i], line, false})
+
+                delimiter := strings.NewReplacer("'", "", "\"", "", "\\", "").
+                                 Replace(source[start:i])
+
+                if (operator == "<<-") {
+                    delimiter = "-" + delimiter
+                }
+
+                heredocs = append(heredocs, delimiter)
+            }
+        default:
+            start     := i
+            startLine := line
+
+            for (i < len(source) &&
+                 strings.IndexByte(" \t\r\n;&|()<>", source[i]) < 0) {
+                switch {
+                case (source[i] == '\\'):
+                    if (i + 1 < len(source) && source[i + 1] == '\n') {
+                        line++
+                    }
+
+                    i += 2
+                case (strings.IndexByte("'\"`", source[i]) >= 0),
+                     (source[i] == '$' && i + 1 < len(source) &&
+                      (source[i + 1] == '(' || source[i + 1] == '{')):
+                    i = skipQuoted(source, i, &line)
+                default:
+                    i++
+                }
+            }
+
+            if (i > len(source)) {
+                i = len(source)
+            }
+
+            tokens    = append(tokens, Token{source[start:i], startLine, false})
+            tokenLine = startLine
+        }
+    }
+
+    return tokens, comments
+}
+
+/**
+ * Finds the variables that a word expands outside of quotes, where the shell
+ * will split them up and expand any wildcards in them.
+ *
+ * @returns The variables, and whether the word uses backticks.
+ */
+func Expansions(word string) ([]string, bool) {
+    var variables []string
+    var backticks bool
+
+    for i := 0; (i < len(word)); i++ {
+        switch (word[i]) {
+        case '\\':
+            i++
+        case '\'':
+            for i++; (i < len(word) && word[i] != '\''); i++ {
+            }
+        case '"':
+            // Only backticks matter inside double quotes
+            end := skipQuoted(word, i, new(int))
+
+            backticks = backticks || strings.Contains(word[i:end], "`")
+            i         = end - 1
+        case '`':
+            backticks = true
+            i = skipQuoted(word, i, new(int)) - 1
+        case '$':
+            if (i + 1 >= len(word)) {
+                break
+            }
+
+            rest := word[i + 1:]
+
+            switch {
+            case (rest[0] == '('):
+                i = skipQuoted(word, i, new(int)) - 1
+            case (rest[0] == '{'):
+                end := skipQuoted(word, i, new(int))
+
+                variables = append(variables, word[i:end])
+                i = end - 1
+            case (strings.IndexByte("@*", rest[0]) >= 0 ||
+                  (rest[0] >= '0' && rest[0] <= '9')):
+                variables = append(variables, word[i:i + 2])
+                i++
+            default:
+                var end int = 0
+
+                for (end < len(rest) && (rest[end] == '_' ||
+                     (rest[end] >= 'a' && rest[end] <= 'z') ||
+                     (rest[end] >= 'A' && rest[end] <= 'Z') ||
+                     (rest[end] >= '0' && rest[end] <= '9'))) {
+                    end++
+                }
+
+                if (end > 0) {
+                    variables = append(variables, word[i:i + end + 1])
+                    i += end
+                }
+            }
+        }
+    }
+
+    return variables, backticks
+}
+
+/**
+ * Splits the tokens into simple commands.
+ */
+func Commands(tokens []Token) []Command {
+    var commands  []Command
+    var current   Command
+    var started   bool
+
+    for i := 0; (i < len(tokens)); i++ {
+        var token Token = tokens[i]
+
+        if (token.Operator && separators[token.Text]) {
+            if (len(current.Words) > 0 || len(current.Assignments) > 0) {
+                current.Next = token.Text
+                commands     = append(commands, current)
+            }
+
+            current = Command{}
+            started = false
+
+            continue
+        }
+
+        // Redirections, and what they redirect to, aren't arguments
+        if (token.Operator) {
+            i++
+            continue
+        }
+
+        if (!started) {
+            if (reserved[token.Text]) {
+                current.Condition = current.Condition ||
+                                    (token.Text != "then" &&
+                                     token.Text != "else" &&
+                                     token.Text != "do" &&
+                                     token.Text != "{" && token.Text != "}")
+                continue
+            }
+
+            if (assignmentRegex.MatchString(token.Text)) {
+                current.Assignments = append(current.Assignments, token)
+                continue
+            }
+
+            started = true
+        }
+
+        current.Words = append(current.Words, token)
+    }
+
+    if (len(current.Words) > 0 || len(current.Assignments) > 0) {
+        commands = append(commands, current)
+    }
+
+    return commands
+}
+
+/**
+ * Removes the quotes from a word.
+ */
+func Unquote(word string) string {
+    return strings.NewReplacer("'", "", "\"", "").Replace(word)
+}
+
+/**
+ * Works out which strict mode options a set command turns on.
+ */
+func SetOptions(arguments []string, options map[string]bool) {
+    letters := map[byte]string{'e': "errexit", 'u': "nounset"}
+
+    for i := 0; (i < len(arguments)); i++ {
+        var argument string = arguments[i]
+
+        if (!strings.HasPrefix(argument, "-") ||
+            strings.HasPrefix(argument, "--")) {
+            continue
+        }
+
+        for j := 1; (j < len(argument)); j++ {
+            if (letters[argument[j]] != "") {
+                options[letters[argument[j]]] = true
+            }
+
+            if (argument[j] == 'o' && i + 1 < len(arguments)) {
+                i++
+                options[arguments[i]] = true
+            }
+        }
+    }
+}
+
+/**
+ * Checks a command's arguments.
+ */
+func CheckCommand(command Command, options map[string]bool) []Finding {
+    var findings []Finding
+    var name     string
+
+    if (len(command.Words) > 0) {
+        name = Unquote(command.Words[0].Text)
+    }
+
+    // Loops split their lists on purpose, and [[ doesn't split at all
+    if (name == "for" || name == "select" || name == "case" || name == "[[") {
+        return nil
+    }
+
+    words := append(append([]Token{}, command.Assignments...),
+                    command.Words...)
+
+    for _, word := range words {
+        if _, backticks := Expansions(word.Text); (backticks) {
+            findings = append(findings,
+                              Finding{"backticks", word.Line,
+                                      "Use `$(...)` rather than backticks, " +
+                                      "which are hard to nest and to read"})
+        }
+    }
+
+    switch (name) {
+    case "cd":
+        if (!command.Condition && !options["errexit"] &&
+            command.Next != "&&" && command.Next != "||") {
+            findings = append(findings,
+                              Finding{"cd-unchecked", command.Words[0].Line,
+                                      "If the `cd` fails, the rest of the " +
+                                      "script runs in the wrong directory. " +
+                                      "Add `|| exit`"})
+        }
+    case "rm":
+        var recursive, force bool
+
+        for _, word := range command.Words[1:] {
+            var text string = Unquote(word.Text)
+
+            if (strings.HasPrefix(text, "-") &&
+                !strings.HasPrefix(text, "--")) {
+                recursive = recursive || strings.ContainsAny(text, "rR")
+                force     = force || strings.Contains(text, "f")
+            }
+        }
+
+        for _, word := range command.Words[1:] {
+            if (recursive && force &&
+                variablePathRegex.MatchString(Unquote(word.Text))) {
+                findings = append(findings,
+                                  Finding{"rm-variable-path", word.Line,
+                                          "If the variable is empty, `" +
+                                          Unquote(word.Text) + "` deletes " +
+                                          "from the root directory. Use " +
+                                          "`${VAR:?}` so that it can't be"})
+            }
+        }
+    }
+
+    var rule    string = "unquoted-variable"
+    var unquoted []string
+    var line     int
+
+    if (name == "[" || name == "test") {
+        rule = "unquoted-test-operand"
+    }
+
+    if (len(command.Words) == 0) {
+        return findings
+    }
+
+    for _, word := range command.Words[1:] {
+        if (assignmentRegex.MatchString(word.Text)) {
+            continue
+        }
+
+        variables, _ := Expansions(word.Text)
+
+        if (len(variables) > 0 && len(unquoted) == 0) {
+            line = word.Line
+        }
+
+        for _, variable := range variables {
+            unquoted = append(unquoted, "`" + variable + "`")
+        }
+    }
+
+    if (len(unquoted) > 0 && rule == "unquoted-variable") {
+        findings = append(findings,
+                          Finding{rule, line,
+                                  "Quote " + strings.Join(unquoted, ", ") +
+                                  ", or spaces and wildcards in " +
+                                  "the value will split it into " +
+                                  "several arguments"})
+    } else if (len(unquoted) > 0) {
+        findings = append(findings,
+                          Finding{rule, line,
+                                  "Quote " + strings.Join(unquoted, ", ") +
+                                  " in the test. If the value is empty or " +
+                                  "has spaces in, the test is a syntax " +
+                                  "error, or tests the wrong thing"})
+    }
+
+    return findings
+}
+
+/**
+ * Checks a shell script.
+ *
+ * @param shell   The shell that it's for.
+ * @param options The options from the shebang line.
+ * @returns The findings, leaving out disabled and suppressed rules.
+ */
+func CheckScript(source string, shell string, options string) []Finding {
+    var findings []Finding
+    var all      []Finding
+
+    tokens, comments := Tokenize(source)
+
+    set := make(map[string]bool)
+
+    SetOptions(strings.Fields(options), set)
+
+    for _, command := range Commands(tokens) {
+        all = append(all, CheckCommand(command, set)...)
+
+        if (len(command.Words) > 0 &&
+            Unquote(command.Words[0].Text) == "set") {
+            var arguments []string
+
+            for _, word := range command.Words[1:] {
+                arguments = append(arguments, Unquote(word.Text))
+            }
+
+            SetOptions(arguments, set)
+        }
+    }
+
+    var wanted  string   = "set -euo pipefail"
+    var missing []string
+
+    // Plain sh doesn't have pipefail
+    if (shell == "sh" || shell == "dash") {
+        wanted = "set -eu"
+    }
+
+    for _, option := range []string{"errexit", "nounset", "pipefail"} {
+        if (!set[option] && (option != "pipefail" || wanted != "set -eu")) {
+            missing = append(missing, "`" + option + "`")
+        }
+    }
+
+    if (len(missing) > 0) {
+        all = append(all,
+                     Finding{"strict-mode", 1,
+                             "Start the script with `" + wanted + "`. " +
+                             "Without " + strings.Join(missing, ", ") +
+                             ", errors are carried on past"})
+    }
+
+    for _, finding := range all {
+        var suppressed bool = disabled[finding.Rule]
+
+        if match := suppressRegex.FindStringSubmatch(comments[finding.Line]);
+           (match != nil) {
+            for _, rule := range strings.Split(match[1], ",") {
+                suppressed = suppressed || rule == finding.Rule
+            }
+        }
+
+        if (!suppressed) {
+            findings = append(findings, finding)
+        }
+    }
+
+    return findings
+}
+
+/**
+ * Runs the plugin on a file.
+ *
+ * Findings on changed lines are commented on. The strict mode check is only
+ * made on new scripts, or when the first line changes.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    if (file.Status == reviewdata.FileDeleted) {
+        return
+    }
+
+    shell, options := Shell(file.Filename, string(file.EntireFile))
+
+    if (shell == "") {
+        return
+    }
+
+    // Changed right-hand line to review line
+    _, changed := file.ReviewLines()
+
+    byLine := make(map[int][]Finding)
+
+    for _, finding := range CheckScript(string(file.EntireFile), shell,
+                                        options) {
+        if (finding.Rule == "strict-mode" &&
+            file.Status != reviewdata.FileAdded && changed[1] == 0) {
+            continue
+        }
+
+        if (changed[finding.Line] != 0) {
+            byLine[finding.Line] = append(byLine[finding.Line], finding)
+        }
+    }
+
+    var lines []int
+
+    for line := range byLine {
+        lines = append(lines, line)
+    }
+
+    sort.Ints(lines)
+
+    for _, line := range lines {
+        var texts   []string
+        var comment = reviewdata.Comment{Line:       changed[line],
+                                         NumLines:   1,
+                                         RaiseIssue: config.ShellReviewer.
+                                                         RaiseIssue,
+                                         Severity:   reviewdata.SeverityInfo,
+                                         Rule:       byLine[line][0].Rule}
+
+        for _, finding := range byLine[line] {
+            texts = append(texts, finding.Text + " (`" + finding.Rule + "`)")
+
+            severity := config.ShellReviewer.Severities[finding.Rule]
+
+            if (reviewdata.SeverityRank(severity) >
+                reviewdata.SeverityRank(comment.Severity)) {
+                comment.Severity = severity
+                comment.Rule     = finding.Rule
+            }
+        }
+
+        comment.Text = strings.Join(texts, "\n\n")
+
+        commentChan <- comment
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.ShellReviewer.Severities == nil) {
+        config.ShellReviewer.Severities = make(map[string]string)
+    }
+
+    for rule, severity := range defaultSeverities {
+        if (config.ShellReviewer.Severities[rule] == "") {
+            config.ShellReviewer.Severities[rule] = severity
+        }
+    }
+
+    for _, rule := range config.ShellReviewer.Disabled {
+        disabled[rule] = true
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
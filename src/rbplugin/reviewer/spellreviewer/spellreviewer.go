@@ -0,0 +1,780 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "unicode"
+
+    "github.com/jdkato/prose/tokenize"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    SpellReviewer struct {
+        WordLists              []string            // Files of correct words,
+                                                   // one per line, or
+                                                   // Hunspell .dic files
+        Dictionary             []string            // Extra correct words
+        RepositoryDictionaries map[string][]string // Extra correct words, by
+                                                   // repository name
+        MinWordLength          int                 // Shorter words are
+                                                   // ignored
+        MaxDistance            int                 // The furthest edit
+                                                   // distance to suggest
+        MaxSuggestions         int
+        CheckIdentifiers       bool
+        Severity               string // For comments and strings
+        IdentifierSeverity     string
+        RaiseIssue             bool
+    }
+}
+
+/**
+ * A string delimiter.
+ */
+type Delimiter struct {
+    Open      string
+    Close     string
+    Escapes   bool // Whether backslash escapes the next character
+    MultiLine bool // Whether the string can run over more than one line
+}
+
+/**
+ * Describes how to find comments and strings in a language. Delimiters are
+ * tried in order, so longer ones must come before their prefixes.
+ */
+type Language struct {
+    LineComments  []string
+    BlockComments []Delimiter
+    Strings       []Delimiter
+    SpaceBefore   bool // Line comments must start a word, as in shell
+}
+
+/**
+ * A piece of prose or an identifier, on a single line of a file.
+ */
+type Segment struct {
+    Line       int
+    Text       string
+    Identifier bool
+}
+
+var (
+    config Config
+
+    // Without any word lists, the system's English dictionary is used, along
+    // with the bundled list of programming terms that dictionaries lack
+    systemDictionaries = []string{"/usr/share/hunspell/en_US.dic",
+                                  "/usr/share/myspell/en_US.dic",
+                                  "/usr/share/myspell/dicts/en_US.dic",
+                                  "/usr/local/share/hunspell/en_US.dic",
+                                  "/opt/homebrew/share/hunspell/en_US.dic",
+                                  "/usr/share/dict/words"}
+    programmingTerms   = "src/rbplugin/reviewer/spellreviewer/words.txt"
+
+    // Set if there's no dictionary to check against
+    disabled bool
+
+    // Relative word lists are found relative to the config file
+    configDir = "."
+
+    cLanguage = Language{
+        LineComments:  []string{"//"},
+        BlockComments: []Delimiter{{Open: "/*", Close: "*/", MultiLine: true}},
+        Strings:       []Delimiter{{Open: "\"", Close: "\"", Escapes: true},
+                                   {Open: "'",  Close: "'",  Escapes: true}}}
+
+    goLanguage = Language{
+        LineComments:  []string{"//"},
+        BlockComments: []Delimiter{{Open: "/*", Close: "*/", MultiLine: true}},
+        Strings:       []Delimiter{{Open: "\"", Close: "\"", Escapes: true},
+                                   {Open: "'",  Close: "'",  Escapes: true},
+                                   {Open: "`",  Close: "`",  MultiLine: true}}}
+
+    pythonLanguage = Language{
+        LineComments: []string{"#"},
+        Strings:      []Delimiter{
+                          {Open: "\"\"\"", Close: "\"\"\"", Escapes: true,
+                           MultiLine: true},
+                          {Open: "'''", Close: "'''", Escapes: true,
+                           MultiLine: true},
+                          {Open: "\"", Close: "\"", Escapes: true},
+                          {Open: "'",  Close: "'",  Escapes: true}}}
+
+    shellLanguage = Language{
+        LineComments: []string{"#"},
+        Strings:      []Delimiter{{Open: "\"", Close: "\"", Escapes: true,
+                                   MultiLine: true},
+                                  {Open: "'",  Close: "'",  MultiLine: true}},
+        SpaceBefore:  true}
+
+    languages = map[string]*Language{
+        ".go":   &goLanguage,
+        ".c":    &cLanguage,
+        ".h":    &cLanguage,
+        ".cc":   &cLanguage,
+        ".cpp":  &cLanguage,
+        ".cxx":  &cLanguage,
+        ".hh":   &cLanguage,
+        ".hpp":  &cLanguage,
+        ".py":   &pythonLanguage,
+        ".sh":   &shellLanguage,
+        ".bash": &shellLanguage,
+    }
+
+    words      = make(map[string]bool)
+    wordsByLen = make(map[int][]string)
+
+    wordTokenizer = tokenize.NewTreebankWordTokenizer()
+
+    // Things in prose that aren't words: URLs, paths, code and e-mail
+    // addresses
+    notProse = regexp.MustCompile("\\S+://\\S+|\\S*[/\\\\]\\S+|`[^`]*`|" +
+                                  "\\S+@\\S+")
+    wordRegex = regexp.MustCompile("^[A-Za-z]+('[a-z]+)?$")
+
+    // Split identifiers into words, e.g. "parseHTTPRequest" into "parse",
+    // "HTTP" and "Request"
+    lowerUpper  = regexp.MustCompile("([a-z0-9])([A-Z])")
+    acronymWord = regexp.MustCompile("([A-Z])([A-Z][a-z])")
+    letters     = regexp.MustCompile("[A-Za-z]+")
+
+    suffixes = []struct {
+        suffix      string
+        replacement string
+    }{
+        {"'s", ""}, {"ies", "y"}, {"ied", "y"}, {"ier", "y"}, {"iest", "y"},
+        {"ily", "y"}, {"es", ""}, {"s", ""}, {"ed", ""}, {"ed", "e"},
+        {"d", ""}, {"ing", ""}, {"ing", "e"}, {"ly", ""}, {"er", ""},
+        {"er", "e"}, {"ers", ""}, {"est", ""}, {"ness", ""}, {"ment", ""},
+        {"able", ""}, {"able", "e"},
+    }
+
+    prefixes = []string{"un", "re", "non", "pre", "de", "sub", "multi", "dis",
+                        "in", "mis", "over", "co"}
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "SpellReviewer"
+}
+
+/**
+ * Adds a word to the dictionary.
+ */
+func AddWord(word string) {
+    word = strings.ToLower(strings.TrimSpace(word))
+
+    if (word != "" && !words[word]) {
+        words[word] = true
+        wordsByLen[len(word)] = append(wordsByLen[len(word)], word)
+    }
+}
+
+/**
+ * Loads a word list into the dictionary.
+ *
+ * Hunspell .dic files are understood: their word count is skipped, as are
+ * each word's affix flags and morphological fields. Their affixes aren't
+ * applied, so only the common prefixes and suffixes that Known allows are.
+ */
+func LoadWordList(filename string) error {
+    file, err := os.Open(filename)
+
+    if (err != nil) {
+        return err
+    }
+    defer file.Close()
+
+    scanner := bufio.NewScanner(file)
+    isDic   := strings.HasSuffix(filename, ".dic")
+
+    for first := true; scanner.Scan(); first = false {
+        word := scanner.Text()
+
+        if (isDic) {
+            if (first) {
+                continue
+            }
+
+            word = strings.SplitN(strings.TrimSpace(word), "/", 2)[0]
+
+            if fields := strings.Fields(word); (len(fields) > 0) {
+                word = fields[0]
+            }
+        }
+
+        AddWord(word)
+    }
+
+    return scanner.Err()
+}
+
+/**
+ * Whether a word is spelled correctly. Words which are known words with a
+ * common prefix or suffix are.
+ *
+ * @param word  The word, in lower case.
+ * @param extra Extra correct words.
+ */
+func Known(word string, extra map[string]bool) bool {
+    if (words[word] || extra[word]) {
+        return true
+    }
+
+    for _, prefix := range prefixes {
+        if (strings.HasPrefix(word, prefix) &&
+            len(word) - len(prefix) > 2 &&
+            (words[word[len(prefix):]] ||
+             KnownWithSuffix(word[len(prefix):], extra))) {
+            return true
+        }
+    }
+
+    return KnownWithSuffix(word, extra)
+}
+
+/**
+ * Whether a word is a known word with a common suffix.
+ */
+func KnownWithSuffix(word string, extra map[string]bool) bool {
+    for _, suffix := range suffixes {
+        if (!strings.HasSuffix(word, suffix.suffix)) {
+            continue
+        }
+
+        stem := word[:len(word) - len(suffix.suffix)] + suffix.replacement
+
+        // A stem whose final consonant should have been doubled, e.g.
+        // "occured", is misspelled
+        if (len(stem) > 1 &&
+            words[stem + stem[len(stem) - 1:] + suffix.suffix]) {
+            return false
+        }
+
+        if (len(stem) > 1 && (words[stem] || extra[stem])) {
+            return true
+        }
+
+        // Consonants are doubled, e.g. "stopped"
+        if (len(stem) > 2 && stem[len(stem) - 1] == stem[len(stem) - 2] &&
+            words[stem[:len(stem) - 1]]) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Calculates the edit distance between two words, counting insertions,
+ * deletions, substitutions and transpositions.
+ *
+ * @param a     A word.
+ * @param b     Another word.
+ * @param limit The largest distance of interest.
+ *
+ * @returns The distance, or limit + 1 as soon as it's known to be more than
+ *          limit.
+ */
+func EditDistance(a string, b string, limit int) int {
+    if (len(a) - len(b) > limit || len(b) - len(a) > limit) {
+        return limit + 1
+    }
+
+    rows := make([][]int, len(a) + 1)
+
+    for i := range rows {
+        rows[i]    = make([]int, len(b) + 1)
+        rows[i][0] = i
+    }
+
+    for j := 0; j <= len(b); j++ {
+        rows[0][j] = j
+    }
+
+    for i := 1; i <= len(a); i++ {
+        // No row has a smaller distance than the smallest in the row before
+        // it, or the transposition that skips it, so once every distance in
+        // a row is past the limit, the end of the last row is too
+        var smallest int = rows[i][0]
+
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+
+            if (a[i - 1] == b[j - 1]) {
+                cost = 0
+            }
+
+            best := rows[i - 1][j] + 1
+
+            if (rows[i][j - 1] + 1 < best) {
+                best = rows[i][j - 1] + 1
+            }
+
+            if (rows[i - 1][j - 1] + cost < best) {
+                best = rows[i - 1][j - 1] + cost
+            }
+
+            if (i > 1 && j > 1 &&
+                a[i - 1] == b[j - 2] && a[i - 2] == b[j - 1] &&
+                rows[i - 2][j - 2] + 1 < best) {
+                best = rows[i - 2][j - 2] + 1
+            }
+
+            rows[i][j] = best
+
+            if (best < smallest) {
+                smallest = best
+            }
+        }
+
+        if (smallest > limit) {
+            return limit + 1
+        }
+    }
+
+    if (rows[len(a)][len(b)] > limit) {
+        return limit + 1
+    }
+
+    return rows[len(a)][len(b)]
+}
+
+/**
+ * Suggests corrections for a misspelled word: the closest dictionary words,
+ * by edit distance.
+ */
+func Suggest(word string) []string {
+    type candidate struct {
+        word     string
+        distance int
+    }
+
+    var candidates []candidate
+    var maxDistance int = config.SpellReviewer.MaxDistance
+
+    for length := len(word) - maxDistance;
+        length <= len(word) + maxDistance;
+        length++ {
+        for _, known := range wordsByLen[length] {
+            distance := EditDistance(word, known, maxDistance)
+
+            if (distance <= maxDistance) {
+                candidates = append(candidates, candidate{known, distance})
+            }
+        }
+    }
+
+    sort.Slice(candidates, func(i, j int) bool {
+        if (candidates[i].distance != candidates[j].distance) {
+            return candidates[i].distance < candidates[j].distance
+        }
+
+        return candidates[i].word < candidates[j].word
+    })
+
+    var suggestions []string
+
+    for i := 0;
+        i < len(candidates) && i < config.SpellReviewer.MaxSuggestions;
+        i++ {
+        suggestions = append(suggestions, candidates[i].word)
+    }
+
+    return suggestions
+}
+
+/**
+ * Splits a file into the comments, strings and identifiers on each of its
+ * lines.
+ *
+ * @param source   The file's content.
+ * @param language The file's language.
+ *
+ * @returns The file's segments, in order.
+ */
+func Lex(source string, language *Language) []Segment {
+    var segments []Segment
+    var line     int = 1
+    var text     strings.Builder
+
+    // What we're in the middle of: code, a line comment, a block comment or
+    // a string
+    var inLineComment bool
+    var inDelimited   *Delimiter
+
+    flush := func() {
+        if (text.Len() > 0) {
+            segments = append(segments, Segment{Line: line,
+                                                Text: text.String()})
+            text.Reset()
+        }
+    }
+
+    for i := 0; i < len(source); i++ {
+        c := source[i]
+
+        if (c == '\n') {
+            flush()
+            line++
+
+            inLineComment = false
+
+            if (inDelimited != nil && !inDelimited.MultiLine) {
+                // Unterminated string
+                inDelimited = nil
+            }
+
+            continue
+        }
+
+        if (inLineComment) {
+            text.WriteByte(c)
+            continue
+        }
+
+        if (inDelimited != nil) {
+            if (inDelimited.Escapes && c == '\\') {
+                // Skip the escaped character, unless it's a newline
+                if (i + 1 < len(source) && source[i + 1] != '\n') {
+                    i++
+                }
+                text.WriteByte(' ')
+            } else if (strings.HasPrefix(source[i:], inDelimited.Close)) {
+                i += len(inDelimited.Close) - 1
+                inDelimited = nil
+                flush()
+            } else {
+                text.WriteByte(c)
+            }
+            continue
+        }
+
+        // We're in code
+        var matched bool
+
+        for _, comment := range language.LineComments {
+            if (strings.HasPrefix(source[i:], comment) &&
+                (!language.SpaceBefore || i == 0 ||
+                 unicode.IsSpace(rune(source[i - 1])))) {
+                inLineComment = true
+                i += len(comment) - 1
+                matched = true
+                break
+            }
+        }
+
+        for j := 0; !matched && j < len(language.BlockComments); j++ {
+            if (strings.HasPrefix(source[i:], language.BlockComments[j].Open)) {
+                inDelimited = &language.BlockComments[j]
+                i += len(inDelimited.Open) - 1
+                matched = true
+            }
+        }
+
+        for j := 0; !matched && j < len(language.Strings); j++ {
+            if (strings.HasPrefix(source[i:], language.Strings[j].Open)) {
+                inDelimited = &language.Strings[j]
+                i += len(inDelimited.Open) - 1
+                matched = true
+            }
+        }
+
+        if (!matched && IsIdentifierStart(c) &&
+            (i == 0 || !IsIdentifierChar(source[i - 1]))) {
+            end := i + 1
+
+            for end < len(source) && IsIdentifierChar(source[end]) {
+                end++
+            }
+
+            segments = append(segments, Segment{Line:       line,
+                                                Text:       source[i:end],
+                                                Identifier: true})
+            i = end - 1
+        }
+    }
+
+    flush()
+
+    return segments
+}
+
+func IsIdentifierStart(c byte) bool {
+    return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func IsIdentifierChar(c byte) bool {
+    return IsIdentifierStart(c) || (c >= '0' && c <= '9')
+}
+
+/**
+ * Picks out the words to be checked in a piece of prose.
+ */
+func ProseWords(text string) []string {
+    var candidates []string
+
+    text = notProse.ReplaceAllString(text, " ")
+
+    for _, token := range wordTokenizer.Tokenize(text) {
+        for _, part := range strings.Split(token, "-") {
+            // Skip anything that looks like code: CamelCase, snake_case or
+            // CONSTANTS
+            if (!wordRegex.MatchString(part) ||
+                strings.ToLower(part[1:]) != part[1:]) {
+                continue
+            }
+
+            candidates = append(candidates, part)
+        }
+    }
+
+    return candidates
+}
+
+/**
+ * Splits an identifier into the words that make it up.
+ */
+func IdentifierWords(identifier string) []string {
+    var candidates []string
+
+    spaced := lowerUpper.ReplaceAllString(identifier, "$1 $2")
+    spaced  = acronymWord.ReplaceAllString(spaced, "$1 $2")
+
+    for _, word := range letters.FindAllString(spaced, -1) {
+        // Acronyms are rarely words
+        if (len(word) == 1 || strings.ToUpper(word) != word) {
+            candidates = append(candidates, word)
+        }
+    }
+
+    return candidates
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    language, found := languages[strings.ToLower(path.Ext(file.Filename))]
+
+    if (disabled || !found || len(file.EntireFile) == 0) {
+        return
+    }
+
+    extra, _ := passback.(map[string]bool)
+
+    // Only added lines are checked
+    changed := make(map[int]int)
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        if (chunk.Change == "insert" || chunk.Change == "replace") {
+            for _, line := range chunk.Lines {
+                changed[line.RhLine] = line.ReviewLine
+            }
+        }
+    }
+
+    // Each misspelling is reported once per file, on the first line it's
+    // found. Misspelled identifiers are reported separately from prose
+    type place struct {
+        line       int
+        identifier bool
+    }
+
+    reported := make(map[string]bool)
+    byPlace  := make(map[place][]string)
+    var places []place
+
+    for _, segment := range Lex(string(file.EntireFile), language) {
+        _, isChanged := changed[segment.Line]
+
+        if (!isChanged ||
+            (segment.Identifier && !config.SpellReviewer.CheckIdentifiers)) {
+            continue
+        }
+
+        var candidates []string
+
+        if (segment.Identifier) {
+            candidates = IdentifierWords(segment.Text)
+        } else {
+            candidates = ProseWords(segment.Text)
+        }
+
+        for _, candidate := range candidates {
+            word := strings.ToLower(candidate)
+
+            if (len(word) < config.SpellReviewer.MinWordLength ||
+                reported[word] ||
+                Known(word, extra)) {
+                continue
+            }
+
+            reported[word] = true
+
+            description := "`" + candidate + "`"
+            suggestions := Suggest(word)
+
+            if (len(suggestions) > 0) {
+                description += " (" + strings.Join(suggestions, ", ") + "?)"
+            }
+
+            at := place{line: segment.Line, identifier: segment.Identifier}
+
+            if (len(byPlace[at]) == 0) {
+                places = append(places, at)
+            }
+
+            byPlace[at] = append(byPlace[at], description)
+        }
+    }
+
+    for _, at := range places {
+        var comment reviewdata.Comment
+
+        comment.Line       = changed[at.line]
+        comment.NumLines   = 1
+        comment.RaiseIssue = config.SpellReviewer.RaiseIssue
+        comment.Rule       = "spelling"
+        comment.Severity   = config.SpellReviewer.Severity
+
+        if (at.identifier) {
+            comment.Rule     = "identifier"
+            comment.Severity = config.SpellReviewer.IdentifierSeverity
+        }
+
+        if (len(byPlace[at]) == 1) {
+            comment.Text = "Possible misspelling: " + byPlace[at][0]
+        } else {
+            comment.Text = "Possible misspellings: " +
+                           strings.Join(byPlace[at], ", ")
+        }
+
+        commentChan <- comment
+    }
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Returns the extra words that are correct in the review's repository, which
+ * are passed back into every file check.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    extra := make(map[string]bool)
+
+    repository := review.Links.Repository.Title
+
+    dictionary := config.SpellReviewer.RepositoryDictionaries[repository]
+
+    for _, word := range dictionary {
+        extra[strings.ToLower(word)] = true
+    }
+
+    return extra
+}
+
+/**
+ * Takes the directory that the config file is in.
+ */
+func (p Reviewer) UseConfigDir(dir string) {
+    configDir = dir
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (len(config.SpellReviewer.WordLists) == 0) {
+        for _, dictionary := range systemDictionaries {
+            if _, err := os.Stat(dictionary); (err == nil) {
+                config.SpellReviewer.WordLists = []string{dictionary,
+                                                          programmingTerms}
+                break
+            }
+        }
+    }
+
+    // Everything would be misspelled
+    if (len(config.SpellReviewer.WordLists) == 0) {
+        fmt.Printf("SpellReviewer: No dictionary found. Install Hunspell's " +
+                   "en_US dictionary, or set WordLists\n")
+        disabled = true
+        return
+    }
+
+    if (config.SpellReviewer.MinWordLength <= 0) {
+        config.SpellReviewer.MinWordLength = 4
+    }
+
+    if (config.SpellReviewer.MaxDistance <= 0) {
+        config.SpellReviewer.MaxDistance = 2
+    }
+
+    if (config.SpellReviewer.MaxSuggestions <= 0) {
+        config.SpellReviewer.MaxSuggestions = 3
+    }
+
+    if (config.SpellReviewer.Severity == "") {
+        config.SpellReviewer.Severity = reviewdata.SeverityInfo
+    }
+
+    if (config.SpellReviewer.IdentifierSeverity == "") {
+        config.SpellReviewer.IdentifierSeverity = reviewdata.SeverityInfo
+    }
+
+    for _, wordList := range config.SpellReviewer.WordLists {
+        if (!filepath.IsAbs(wordList)) {
+            wordList = filepath.Join(configDir, wordList)
+        }
+
+        err := LoadWordList(wordList)
+
+        if (err != nil) {
+            fmt.Printf("SpellReviewer: Could not load word list %s: %s\n",
+                       wordList,
+                       err)
+        }
+    }
+
+    for _, word := range config.SpellReviewer.Dictionary {
+        AddWord(word)
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
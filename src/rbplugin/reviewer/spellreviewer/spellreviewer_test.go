@@ -0,0 +1,393 @@
+/**
+ * Tests finding prose and identifiers in source files, splitting them into
+ * words, and checking those words against a dictionary.
+ */
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "sync"
+    "testing"
+
+    "rbplugindata/reviewdata"
+)
+
+var loadWords sync.Once
+
+/**
+ * Configures the plugin with a small Hunspell dictionary and the bundled
+ * programming terms, once.
+ */
+func useWordList(t *testing.T) {
+    loadWords.Do(func() {
+        var p Reviewer
+
+        p.UseConfigDir(".")
+        p.Configure(json.RawMessage(`{"SpellReviewer": {
+                                          "WordLists": ["testdata/en_US.dic",
+                                                        "words.txt"],
+                                          "CheckIdentifiers": true}}`))
+    })
+
+    if (disabled || !words["payment"] || !words["unmarshal"]) {
+        t.Fatalf("Loaded %d words; want the dictionary and terms", len(words))
+    }
+}
+
+func TestLoadWordList(t *testing.T) {
+    useWordList(t)
+
+    // The word count, affix flags and morphological fields aren't words
+    var tests = []struct {
+        word  string
+        known bool
+    }{
+        {"customer", true},
+        {"stop", true},
+        {"24", false},
+        {"customer/m", false},
+        {"po:verb", false},
+    }
+
+    for _, test := range tests {
+        if (words[test.word] != test.known) {
+            t.Errorf("Loaded %q = %t; want %t",
+                     test.word,
+                     words[test.word],
+                     test.known)
+        }
+    }
+}
+
+/**
+ * Checks that lexing a source finds the expected segments.
+ */
+func testLex(t *testing.T, name string, source string, language *Language,
+             expected []Segment) {
+    segments := Lex(source, language)
+
+    if (len(segments) != len(expected)) {
+        t.Errorf("Lex(%s) = %+v; want %+v", name, segments, expected)
+        return
+    }
+
+    for i := range segments {
+        if (segments[i] != expected[i]) {
+            t.Errorf("Lex(%s) segment %d = %+v; want %+v",
+                     name,
+                     i,
+                     segments[i],
+                     expected[i])
+        }
+    }
+}
+
+func TestLexGo(t *testing.T) {
+    var source = "package main // Teh package\n" +
+                 "s := \"Say \\\"hi\\\"\\n\" + `raw\n" +
+                 "text` /* block\n" +
+                 "comment */\n"
+
+    // Escapes are blanked out, and raw strings and block comments run over
+    // lines
+    testLex(t, "Go", source, &goLanguage, []Segment{
+        {1, "package", true},
+        {1, "main", true},
+        {1, " Teh package", false},
+        {2, "s", true},
+        {2, "Say  hi  ", false},
+        {2, "raw", false},
+        {3, "text", false},
+        {3, " block", false},
+        {4, "comment ", false},
+    })
+}
+
+func TestLexC(t *testing.T) {
+    var source = "// line\n" +
+                 "/* a */ char c = '\\''; char *s = \"x\n"
+
+    // An unterminated string ends at the end of the file
+    testLex(t, "C", source, &cLanguage, []Segment{
+        {1, " line", false},
+        {2, " a ", false},
+        {2, "char", true},
+        {2, "c", true},
+        {2, " ", false},
+        {2, "char", true},
+        {2, "s", true},
+        {2, "x", false},
+    })
+}
+
+func TestLexPython(t *testing.T) {
+    var source = "x = 'it''s'  # Comment\n" +
+                 "\"\"\"Doc\n" +
+                 "string\"\"\"\n"
+
+    // Triple quotes are found before single ones
+    testLex(t, "Python", source, &pythonLanguage, []Segment{
+        {1, "x", true},
+        {1, "it", false},
+        {1, "s", false},
+        {1, " Comment", false},
+        {2, "Doc", false},
+        {3, "string", false},
+    })
+}
+
+func TestLexShell(t *testing.T) {
+    var source = "echo \"$HOME/dir\" # comment\n" +
+                 "url=http://x#anchor 'single\n" +
+                 "quoted'\n"
+
+    // A hash only starts a comment after a space
+    testLex(t, "shell", source, &shellLanguage, []Segment{
+        {1, "echo", true},
+        {1, "$HOME/dir", false},
+        {1, " comment", false},
+        {2, "url", true},
+        {2, "http", true},
+        {2, "x", true},
+        {2, "anchor", true},
+        {2, "single", false},
+        {3, "quoted", false},
+    })
+}
+
+func TestProseWords(t *testing.T) {
+    var tests = []struct {
+        text  string
+        words []string
+    }{
+        {"Teh quick-brown fox's", []string{"Teh", "quick", "brown", "fox"}},
+        // Code, URLs, paths and addresses aren't prose
+        {"Run `go vet` at http://x.y/z on /usr/bin, or mail me@x.com",
+         []string{"Run", "at", "on", "or", "mail"}},
+        // Nor are identifiers and constants
+        {"Call parseHTTP with CONSTANT or snake_case (wrold).",
+         []string{"Call", "with", "or", "wrold"}},
+        {"", nil},
+    }
+
+    for _, test := range tests {
+        words := ProseWords(test.text)
+
+        if (strings.Join(words, " ") != strings.Join(test.words, " ")) {
+            t.Errorf("ProseWords(%q) = %q; want %q",
+                     test.text,
+                     words,
+                     test.words)
+        }
+    }
+}
+
+func TestIdentifierWords(t *testing.T) {
+    var tests = []struct {
+        identifier string
+        words      []string
+    }{
+        {"parseRequest", []string{"parse", "Request"}},
+        {"snake_case_name", []string{"snake", "case", "name"}},
+        // Acronyms and digits are dropped
+        {"parseHTTPRequest", []string{"parse", "Request"}},
+        {"getURL2Path", []string{"get", "Path"}},
+        {"ID", nil},
+    }
+
+    for _, test := range tests {
+        words := IdentifierWords(test.identifier)
+
+        if (strings.Join(words, " ") != strings.Join(test.words, " ")) {
+            t.Errorf("IdentifierWords(%q) = %q; want %q",
+                     test.identifier,
+                     words,
+                     test.words)
+        }
+    }
+}
+
+func TestEditDistance(t *testing.T) {
+    var tests = []struct {
+        a        string
+        b        string
+        distance int
+    }{
+        {"same", "same", 0},
+        {"", "abc", 3},
+        {"kitten", "sitting", 3},
+        {"recieve", "receive", 1},
+        {"teh", "the", 1},
+    }
+
+    // Past the limit, only that is known
+    var limited = []struct {
+        a        string
+        b        string
+        limit    int
+        distance int
+    }{
+        {"kitten", "sitting", 2, 3},
+        {"kitten", "sitting", 3, 3},
+        {"recieve", "receive", 1, 1},
+        {"a", "abcdef", 2, 3},
+        {"abcdef", "badcfe", 2, 3},
+        {"abcdef", "badcfe", 3, 3},
+    }
+
+    for _, test := range limited {
+        distance := EditDistance(test.a, test.b, test.limit)
+
+        if (distance != test.distance) {
+            t.Errorf("EditDistance(%q, %q, %d) = %d; want %d",
+                     test.a,
+                     test.b,
+                     test.limit,
+                     distance,
+                     test.distance)
+        }
+    }
+
+    for _, test := range tests {
+        distance := EditDistance(test.a, test.b, 10)
+
+        if (distance != test.distance) {
+            t.Errorf("EditDistance(%q, %q) = %d; want %d",
+                     test.a,
+                     test.b,
+                     distance,
+                     test.distance)
+        }
+    }
+}
+
+func TestKnown(t *testing.T) {
+    useWordList(t)
+
+    var extra = map[string]bool{"reviewbot": true}
+
+    var tests = []struct {
+        word  string
+        known bool
+    }{
+        {"customer", true},
+        {"payment", true},
+        {"invoices", true},
+        {"weather", true},
+        {"throttled", true},
+        {"definitely", true},
+        {"unmarshaling", true},
+        {"reviewbot", true},
+        {"reviewbots", true},
+        {"occured", false},
+        {"recieve", false},
+        {"definately", false},
+        {"teh", false},
+    }
+
+    for _, test := range tests {
+        if (Known(test.word, extra) != test.known) {
+            t.Errorf("Known(%q) = %t; want %t",
+                     test.word,
+                     !test.known,
+                     test.known)
+        }
+    }
+}
+
+func TestSuggest(t *testing.T) {
+    useWordList(t)
+
+    var tests = []struct {
+        word       string
+        suggestion string
+    }{
+        {"recieve", "receive"},
+        {"occured", "occurred"},
+        {"seperate", "separate"},
+    }
+
+    for _, test := range tests {
+        suggestions := Suggest(test.word)
+
+        if (len(suggestions) == 0 ||
+            len(suggestions) > config.SpellReviewer.MaxSuggestions ||
+            !strings.Contains(strings.Join(suggestions, " "),
+                              test.suggestion)) {
+            t.Errorf("Suggest(%q) = %q; want %q among at most %d",
+                     test.word,
+                     suggestions,
+                     test.suggestion,
+                     config.SpellReviewer.MaxSuggestions)
+        }
+    }
+}
+
+func TestCheck(t *testing.T) {
+    useWordList(t)
+
+    var source = "package main\n" +
+                 "\n" +
+                 "// Recieve the customer's payment\n" +
+                 "func handlePaymnt() {\n" +
+                 "    send(\"Seperate invoices sent\") // Recieve\n" +
+                 "}\n"
+
+    var file reviewdata.FileDiff
+
+    file.Filename   = "payment.go"
+    file.EntireFile = []byte(source)
+
+    // Lines 3 to 5 were added, the rest are context
+    file.Diff_Data.Chunks = []reviewdata.DiffChunk{
+        {Change: "equal",
+         Lines:  []reviewdata.Line{{ReviewLine: 1, RhLine: 1},
+                                   {ReviewLine: 2, RhLine: 2}}},
+        {Change: "insert",
+         Lines:  []reviewdata.Line{{ReviewLine: 3, RhLine: 3},
+                                   {ReviewLine: 4, RhLine: 4},
+                                   {ReviewLine: 5, RhLine: 5}}},
+    }
+
+    var wg sync.WaitGroup
+    commentChan := make(chan reviewdata.Comment, 10)
+
+    wg.Add(1)
+    ReviewerPlugin.Check(file, nil, commentChan, &wg)
+    close(commentChan)
+
+    // Each misspelling is reported once, and identifiers are reported apart
+    // from prose
+    var expected = []struct {
+        line int
+        rule string
+        text string
+    }{
+        {3, "spelling", "Possible misspelling: `Recieve` (receive"},
+        {4, "identifier", "Possible misspelling: `Paymnt` (payment"},
+        {5, "spelling", "Possible misspelling: `Seperate` (separate"},
+    }
+
+    var comments []reviewdata.Comment
+
+    for comment := range commentChan {
+        comments = append(comments, comment)
+    }
+
+    if (len(comments) != len(expected)) {
+        t.Fatalf("Check(%s) = %+v; want %+v", file.Filename, comments, expected)
+    }
+
+    for i, comment := range comments {
+        if (comment.Line != expected[i].line ||
+            comment.Rule != expected[i].rule ||
+            !strings.HasPrefix(comment.Text, expected[i].text)) {
+            t.Errorf("Check(%s) comment %d = %+v; want %+v",
+                     file.Filename,
+                     i,
+                     comment,
+                     expected[i])
+        }
+    }
+}
@@ -0,0 +1,378 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "regexp"
+    "strings"
+    "sync"
+    "unicode"
+    "unicode/utf8"
+
+    "github.com/jdkato/prose/transform"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    SummaryReviewer struct {
+        Heading             string   // Starts the plugin's part of the
+                                     // review body
+        MaxLength           int      // Zero doesn't check length
+        AllowTrailingPeriod bool
+        CheckImperative     bool     // Whether the summary must start with
+                                     // an imperative verb
+        PrefixRegexes       []string // If given, the summary must start with
+                                     // a match for one of these, e.g.
+                                     // "\\[[a-z-]+\\] "
+        Case                string   // "sentence", "lower", "title-ap" or
+                                     // "title-chicago". Empty doesn't check
+    }
+}
+
+var (
+    config Config
+
+    prefixRegexes []*regexp.Regexp
+
+    // Any spaces left after a prefix, the first word, and whether it's part
+    // of a compound, e.g. "Left-align", or a label, e.g. "Logging: ..."
+    firstWord = regexp.MustCompile("^(\\s*)([A-Za-z]+)([-:]?)")
+
+    titleStyles = map[string]transform.IgnoreFunc{
+        "title-ap":      transform.APStyle,
+        "title-chicago": transform.ChicagoStyle,
+    }
+
+    caseNames = map[string]string{
+        "sentence":      "sentence case",
+        "lower":         "lower case",
+        "title-ap":      "AP-style title case",
+        "title-chicago": "Chicago-style title case",
+    }
+
+    // Verbs that commonly start summaries. A summary starting with another
+    // form of one of these, e.g. "Fixes", "Fixed" or "Fixing", isn't in the
+    // imperative mood. Also used to work out base forms
+    commonVerbs = make(map[string]bool)
+
+    // "Left" isn't here, as summaries more often mean the side, e.g. "Left
+    // panel overflows"
+    irregularVerbs = map[string]string{
+        "made": "make", "wrote": "write", "written": "write",
+        "rewrote": "rewrite", "rewritten": "rewrite", "built": "build",
+        "rebuilt": "rebuild", "ran": "run", "did": "do", "done": "do",
+        "got": "get", "took": "take", "taken": "take", "gave": "give",
+        "given": "give", "kept": "keep", "hid": "hide", "hidden": "hide",
+        "went": "go", "brought": "bring", "began": "begin", "begun": "begin",
+        "chose": "choose", "chosen": "choose", "threw": "throw",
+        "thrown": "throw", "caught": "catch",
+    }
+)
+
+func init() {
+    for _, verb := range strings.Fields(
+            "add allow avoid bump build cache call change check clean close " +
+            "configure convert copy correct create declare decrease define " +
+            "delete deprecate determine disable document drop enable ensure " +
+            "escape exclude expose extend extract fill filter fix format " +
+            "generate handle hide ignore implement improve include increase " +
+            "initialise initialize install introduce log make merge migrate " +
+            "move normalise normalize optimise optimize parse pass prepare " +
+            "prevent provide raise reduce refactor release remove rename " +
+            "replace resolve restore return revert rewrite run save schedule " +
+            "serve set share show simplify skip sort split start stop store " +
+            "support test tidy tune update upgrade use validate wrap write") {
+        commonVerbs[verb] = true
+    }
+}
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "SummaryReviewer"
+}
+
+/**
+ * Makes a guess at the base form of a verb, e.g. "fixes" to "fix".
+ *
+ * @param word The verb, in lower case.
+ *
+ * @returns The base form.
+ */
+func BaseForm(word string) string {
+    base, irregular := irregularVerbs[word]
+
+    if (irregular) {
+        return base
+    }
+
+    var stem string = word
+
+    switch {
+    case strings.HasSuffix(word, "ies"), strings.HasSuffix(word, "ied"):
+        return word[:len(word) - 3] + "y"
+    case strings.HasSuffix(word, "ing"):
+        stem = word[:len(word) - 3]
+    case strings.HasSuffix(word, "ed"):
+        stem = word[:len(word) - 2]
+    case strings.HasSuffix(word, "es"):
+        stem = word[:len(word) - 2]
+
+        if (commonVerbs[stem + "e"] || commonVerbs[word[:len(word) - 1]]) {
+            return word[:len(word) - 1]
+        }
+
+        return stem
+    case strings.HasSuffix(word, "s"):
+        return word[:len(word) - 1]
+    }
+
+    var last int = len(stem) - 1
+
+    if (commonVerbs[stem] || last < 1) {
+        return stem
+    } else if (commonVerbs[stem + "e"]) {
+        return stem + "e"
+    } else if (stem[last] == stem[last - 1] &&
+               !strings.ContainsRune("aeioulsfz", rune(stem[last]))) {
+        // A doubled consonant, e.g. "stopped"
+        return stem[:last]
+    }
+
+    return stem
+}
+
+/**
+ * Works out whether a summary's first word is a verb that isn't in the
+ * imperative mood, e.g. "Added" or "Fixes".
+ *
+ * @param word The word, in lower case.
+ *
+ * @returns The verb's base form, or an empty string if the word is already in
+ *          its base form or isn't a verb that we know.
+ */
+func NonImperative(word string) string {
+    base, irregular := irregularVerbs[word]
+
+    if (irregular) {
+        return base
+    }
+
+    base = BaseForm(word)
+
+    if (base == word || !commonVerbs[base]) {
+        return ""
+    }
+
+    return base
+}
+
+/**
+ * Copies the case of the first letter of one word onto another.
+ */
+func MatchCase(word string, like string) string {
+    first, _ := utf8.DecodeRuneInString(like)
+
+    if (unicode.IsUpper(first)) {
+        return strings.ToUpper(word[:1]) + word[1:]
+    }
+
+    return word
+}
+
+/**
+ * Changes the case of a string's first letter.
+ */
+func CaseFirst(text string, upper bool) string {
+    first, size := utf8.DecodeRuneInString(text)
+
+    if (upper) {
+        return string(unicode.ToUpper(first)) + text[size:]
+    }
+
+    return string(unicode.ToLower(first)) + text[size:]
+}
+
+/**
+ * Checks a summary.
+ *
+ * @param summary The summary.
+ *
+ * @returns A list of problems with the summary, and a corrected summary.
+ */
+func CheckSummary(summary string) ([]string, string) {
+    var problems []string
+    var prefix   string
+
+    summary = strings.TrimSpace(summary)
+
+    if (summary == "") {
+        return []string{"The summary is empty"}, summary
+    }
+
+    // Split off any prefix, which the other checks ignore
+    if (len(prefixRegexes) > 0) {
+        for _, prefixRegex := range prefixRegexes {
+            prefix = prefixRegex.FindString(summary)
+
+            if (prefix != "") {
+                break
+            }
+        }
+
+        if (prefix == "") {
+            problems = append(problems,
+                              "The summary should start with a prefix " +
+                              "matching `" +
+                              strings.Join(
+                                  config.SummaryReviewer.PrefixRegexes,
+                                  "` or `") +
+                              "`")
+        }
+    }
+
+    var rest string = summary[len(prefix):]
+
+    if (config.SummaryReviewer.MaxLength > 0 &&
+        utf8.RuneCountInString(summary) > config.SummaryReviewer.MaxLength) {
+        problems = append(problems,
+                          fmt.Sprintf("The summary is %d characters long; " +
+                                      "it should be at most %d",
+                                      utf8.RuneCountInString(summary),
+                                      config.SummaryReviewer.MaxLength))
+    }
+
+    if (!config.SummaryReviewer.AllowTrailingPeriod &&
+        strings.HasSuffix(rest, ".") &&
+        !strings.HasSuffix(rest, "..")) {
+        problems = append(problems,
+                          "The summary shouldn't end with a full stop")
+        rest = strings.TrimSuffix(rest, ".")
+    }
+
+    var match []string = firstWord.FindStringSubmatch(rest)
+
+    // Compounds and labels aren't verbs
+    if (config.SummaryReviewer.CheckImperative && match != nil &&
+        match[3] == "") {
+        space := match[1]
+        first := match[2]
+        base  := NonImperative(strings.ToLower(first))
+
+        if (base != "") {
+            imperative := MatchCase(base, first)
+
+            problems = append(problems,
+                              "The summary should use the imperative " +
+                              "mood: \"" + imperative + "\" rather " +
+                              "than \"" + first + "\"")
+
+            rest = space + imperative + rest[len(space + first):]
+        }
+    }
+
+    var corrected string = rest
+
+    switch (config.SummaryReviewer.Case) {
+    case "sentence":
+        corrected = CaseFirst(rest, true)
+    case "lower":
+        corrected = CaseFirst(rest, false)
+    case "title-ap", "title-chicago":
+        corrected = transform.NewTitleConverter(
+                        titleStyles[config.SummaryReviewer.Case]).Title(rest)
+    }
+
+    if (corrected != rest) {
+        problems = append(problems,
+                          "The summary should be in " +
+                          caseNames[config.SummaryReviewer.Case])
+    }
+
+    return problems, prefix + corrected
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    (*wg).Done()
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * Problems with the summary, along with a suggested correction, are added to
+ * the review body.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    problems, corrected := CheckSummary(review.Summary)
+
+    if (len(problems) > 0) {
+        var body string = config.SummaryReviewer.Heading + "\n\n- " +
+                          strings.Join(problems, "\n- ") + "\n"
+
+        if (corrected != "" && corrected != strings.TrimSpace(review.Summary)) {
+            body += "\nPerhaps: `" + corrected + "`\n"
+        }
+
+        commentChan <- body
+    }
+
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.SummaryReviewer.Heading == "") {
+        config.SummaryReviewer.Heading = "**Review request summary**"
+    }
+
+    for _, prefix := range config.SummaryReviewer.PrefixRegexes {
+        // Prefixes can only match at the start of the summary
+        prefixRegex, err := regexp.Compile("^(?:" + prefix + ")")
+
+        if (err != nil) {
+            fmt.Printf("SummaryReviewer: Bad prefix regex %s: %s\n",
+                       prefix,
+                       err)
+        } else {
+            prefixRegexes = append(prefixRegexes, prefixRegex)
+        }
+    }
+
+    _, known := caseNames[config.SummaryReviewer.Case]
+
+    if (config.SummaryReviewer.Case != "" && !known) {
+        fmt.Printf("SummaryReviewer: Unknown case %s\n",
+                   config.SummaryReviewer.Case)
+        config.SummaryReviewer.Case = ""
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
@@ -0,0 +1,144 @@
+/**
+ * Tests checking review request summaries, and the corrections suggested.
+ */
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+/**
+ * Configures the plugin afresh.
+ */
+func configure(rawConfig string) {
+    config        = Config{}
+    prefixRegexes = nil
+
+    ReviewerPlugin.Configure(json.RawMessage(rawConfig))
+}
+
+func TestBaseForm(t *testing.T) {
+    var tests = []struct {
+        word string
+        base string
+    }{
+        {"fixes", "fix"},
+        {"fixed", "fix"},
+        {"fixing", "fix"},
+        {"adds", "add"},
+        {"updates", "update"},
+        {"updated", "update"},
+        {"updating", "update"},
+        {"copies", "copy"},
+        {"stopped", "stop"},
+        {"wrote", "write"},
+        {"fix", "fix"},
+    }
+
+    for _, test := range tests {
+        base := BaseForm(test.word)
+
+        if (base != test.base) {
+            t.Errorf("BaseForm(%q) = %q; want %q", test.word, base, test.base)
+        }
+    }
+}
+
+func TestCheckImperative(t *testing.T) {
+    configure(`{"SummaryReviewer": {"CheckImperative": true}}`)
+
+    var tests = []struct {
+        summary   string
+        corrected string
+    }{
+        {"Added a flag", "Add a flag"},
+        {"Fixes crash on start", "Fix crash on start"},
+        {"fixing the build", "fix the build"},
+        {"Updated docs", "Update docs"},
+        {"Removes dead code", "Remove dead code"},
+        {"Wrote docs", "Write docs"},
+        // Already imperative
+        {"Fix crash", ""},
+        {"Log requests", ""},
+        {"Set up CI", ""},
+        {"Remove dead code", ""},
+        // Nouns
+        {"Documentation fixes", ""},
+        // Compound words aren't verbs
+        {"Left-align the table", ""},
+        {"Fixed-size buffers for logs", ""},
+        // "Left" is usually a side
+        {"Left panel overflows", ""},
+        // Nor are labels
+        {"Logging: add request ids", ""},
+        {"README: fix typo", ""},
+        {"Fixes: crash on start", ""},
+    }
+
+    for _, test := range tests {
+        problems, corrected := CheckSummary(test.summary)
+
+        if (test.corrected == "") {
+            if (len(problems) != 0) {
+                t.Errorf("CheckSummary(%q) = %q; want no problems",
+                         test.summary,
+                         problems)
+            }
+        } else if (len(problems) != 1 ||
+                   !strings.Contains(problems[0], "imperative") ||
+                   corrected != test.corrected) {
+            t.Errorf("CheckSummary(%q) = %q, %q; want the imperative %q",
+                     test.summary,
+                     problems,
+                     corrected,
+                     test.corrected)
+        }
+    }
+}
+
+func TestCheckSummary(t *testing.T) {
+    var tests = []struct {
+        config    string
+        summary   string
+        problems  int
+        corrected string
+    }{
+        {`{}`, "Fix crash", 0, "Fix crash"},
+        {`{}`, "  ", 1, ""},
+        {`{}`, "Fix crash.", 1, "Fix crash"},
+        {`{}`, "Wait for it...", 0, "Wait for it..."},
+        {`{"AllowTrailingPeriod": true}`, "Fix crash.", 0, "Fix crash."},
+        {`{"MaxLength": 10}`, "Fix the crash", 1, "Fix the crash"},
+        {`{"MaxLength": 13}`, "Fix the crash", 0, "Fix the crash"},
+        // Prefixes are left alone by the other checks
+        {`{"PrefixRegexes": ["\\[[a-z-]+\\] "]}`, "Fix crash", 1, "Fix crash"},
+        {`{"PrefixRegexes": ["\\[[a-z-]+\\] "], "Case": "lower"}`,
+         "[ui] Fix crash", 1, "[ui] fix crash"},
+        {`{"PrefixRegexes": ["\\[[a-z-]+\\]"], "CheckImperative": true}`,
+         "[ui] Fixed crash", 1, "[ui] Fix crash"},
+        {`{"Case": "sentence", "CheckImperative": true}`,
+         "added a flag.", 3, "Add a flag"},
+        {`{"Case": "title-ap"}`,
+         "Fix the crash in the parser", 1, "Fix the Crash in the Parser"},
+        {`{"Case": "title-ap"}`, "Fix the Crash in the Parser", 0,
+         "Fix the Crash in the Parser"},
+    }
+
+    for _, test := range tests {
+        configure(`{"SummaryReviewer": ` + test.config + `}`)
+
+        problems, corrected := CheckSummary(test.summary)
+
+        if (len(problems) != test.problems || corrected != test.corrected) {
+            t.Errorf("CheckSummary(%q) with %s = %q, %q; want %d problems, %q",
+                     test.summary,
+                     test.config,
+                     problems,
+                     corrected,
+                     test.problems,
+                     test.corrected)
+        }
+    }
+}
@@ -0,0 +1,430 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "path"
+    "strings"
+    "sync"
+    "unicode/utf8"
+
+    "rbplugindata/reviewdata"
+)
+
+/**
+ * The checks, which are also the rules that comments are made under.
+ */
+const (
+    checkTrailingWhitespace = "trailing-whitespace"
+    checkIndentation        = "indentation"
+    checkLineEndings        = "line-endings"
+    checkFinalNewline       = "final-newline"
+    checkBom                = "bom"
+    checkUtf8               = "utf8"
+)
+
+/**
+ * The checks to run on a file.
+ */
+type Rules struct {
+    Checks      []string // The checks to run. All are run if this is empty
+    Indentation string   // "spaces" or "tabs". If empty, it's worked out from
+                         // the file
+}
+
+type Config struct {
+    WhitespaceReviewer struct {
+        Default             Rules
+        Files               map[string]Rules // By file name, e.g. "Makefile",
+                                             // or extension, e.g. ".go".
+                                             // Replaces Default
+        RaiseIssue          bool
+        Severity            string
+        LabelWhitespaceOnly bool   // Whether to say so in the review body if
+                                   // every change is to whitespace only
+        WhitespaceOnlyLabel string
+    }
+}
+
+/**
+ * A changed line, as it is in the patched file.
+ */
+type ChangedLine struct {
+    RhLine     int
+    ReviewLine int
+    Text       string // Including any carriage return
+}
+
+var (
+    config Config
+
+    // The order in which checks are run
+    checks = []string{checkTrailingWhitespace,
+                      checkIndentation,
+                      checkLineEndings,
+                      checkFinalNewline,
+                      checkBom,
+                      checkUtf8}
+
+    utf8Bom = []byte("\xef\xbb\xbf")
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Reviewer struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Reviewer) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Reviewer) CanonicalName() string {
+    return "WhitespaceReviewer"
+}
+
+/**
+ * Finds the rules for a file: those for its name, then those for its
+ * extension, then the default.
+ */
+func RulesFor(filename string) Rules {
+    var base string = path.Base(filename)
+
+    rules, found := config.WhitespaceReviewer.Files[base]
+
+    if (!found) {
+        rules, found = config.WhitespaceReviewer.Files[path.Ext(base)]
+    }
+
+    if (!found) {
+        rules = config.WhitespaceReviewer.Default
+    }
+
+    return rules
+}
+
+/**
+ * Works out whether a check is enabled.
+ */
+func (r Rules) Enabled(check string) bool {
+    if (len(r.Checks) == 0) {
+        return true
+    }
+
+    for _, enabled := range r.Checks {
+        if (enabled == check) {
+            return true
+        }
+    }
+
+    return false
+}
+
+/**
+ * Works out how a file is indented, from how most of its lines are.
+ *
+ * @returns "spaces", "tabs", or an empty string if it can't tell.
+ */
+func DetectIndentation(lines []string) string {
+    var spaces int = 0
+    var tabs   int = 0
+
+    for _, line := range lines {
+        if (strings.HasPrefix(line, "\t")) {
+            tabs++
+        } else if (strings.HasPrefix(line, "  ")) {
+            spaces++
+        }
+    }
+
+    if (spaces > tabs) {
+        return "spaces"
+    } else if (tabs > spaces) {
+        return "tabs"
+    }
+
+    return ""
+}
+
+/**
+ * Works out which line endings are out of place in a file with mixed endings.
+ *
+ * @param lines The file's lines, split on LF.
+ *
+ * @returns Whether the file's endings are mixed, and if so, whether the CRLF
+ *          endings are the ones out of place.
+ */
+func MixedEndings(lines []string) (bool, bool) {
+    var crlf int = 0
+    var lf   int = 0
+
+    // The last line has no ending
+    for i := 0; i < len(lines) - 1; i++ {
+        if (strings.HasSuffix(lines[i], "\r")) {
+            crlf++
+        } else {
+            lf++
+        }
+    }
+
+    return crlf > 0 && lf > 0, crlf <= lf
+}
+
+/**
+ * Builds a comment with this plugin's issue settings.
+ */
+func NewComment(rule string, text string) reviewdata.Comment {
+    return reviewdata.Comment{Text:       text,
+                              RaiseIssue: config.WhitespaceReviewer.RaiseIssue,
+                              Severity:   config.WhitespaceReviewer.Severity,
+                              Rule:       rule}
+}
+
+/**
+ * Comments on lines which have problems, merging adjacent lines into a single
+ * comment.
+ *
+ * @param rule        The check which found the problem.
+ * @param lines       The lines with the problem, in diff order.
+ * @param single      The comment to make on a single line.
+ * @param multi       The comment to make on several lines.
+ * @param commentChan The channel on which to send comments.
+ */
+func CommentOnLines(rule        string,
+                    lines       []ChangedLine,
+                    single      string,
+                    multi       string,
+                    commentChan chan <- reviewdata.Comment) {
+    var comment reviewdata.Comment
+
+    for i, line := range lines {
+        if (i > 0 && line.ReviewLine == lines[i - 1].ReviewLine + 1) {
+            comment.NumLines++
+            comment.Text = multi
+            continue
+        }
+
+        if (comment.NumLines > 0) {
+            commentChan <- comment
+        }
+
+        comment          = NewComment(rule, single)
+        comment.Line     = line.ReviewLine
+        comment.NumLines = 1
+    }
+
+    if (comment.NumLines > 0) {
+        commentChan <- comment
+    }
+}
+
+/**
+ * Runs the plugin on a file.
+ */
+func (p Reviewer) Check(file        reviewdata.FileDiff,
+                        passback    interface{},
+                        commentChan chan <- reviewdata.Comment,
+                        wg          *sync.WaitGroup) {
+    defer (*wg).Done()
+
+    // Binary files have no lines to speak of
+    if (bytes.IndexByte(file.EntireFile, 0) >= 0) {
+        return
+    }
+
+    var rules Rules = RulesFor(file.Filename)
+    var raw   []string
+
+    if (len(file.EntireFile) > 0) {
+        raw = strings.Split(string(file.EntireFile), "\n")
+    }
+
+    // Take changed lines from the patched file where possible, as the diff
+    // may not keep line endings
+    var changed []ChangedLine
+
+    for _, chunk := range file.Diff_Data.Chunks {
+        if (chunk.Change != "insert" && chunk.Change != "replace") {
+            continue
+        }
+
+        for _, line := range chunk.Lines {
+            if (line.RhLine == 0) {
+                continue
+            }
+
+            var text string = line.RhText
+
+            if (line.RhLine <= len(raw)) {
+                text = raw[line.RhLine - 1]
+            }
+
+            changed = append(changed, ChangedLine{RhLine:     line.RhLine,
+                                                  ReviewLine: line.ReviewLine,
+                                                  Text:       text})
+        }
+    }
+
+    if (len(changed) == 0) {
+        return
+    }
+
+    var indentation string = rules.Indentation
+
+    if (indentation == "") {
+        indentation = DetectIndentation(raw)
+    }
+
+    mixed, crlfOutOfPlace := MixedEndings(raw)
+
+    hits := make(map[string][]ChangedLine)
+
+    for _, line := range changed {
+        hasCr := strings.HasSuffix(line.Text, "\r")
+        body  := strings.TrimSuffix(line.Text, "\r")
+
+        if (rules.Enabled(checkTrailingWhitespace) &&
+            body != strings.TrimRight(body, " \t")) {
+            hits[checkTrailingWhitespace] =
+                        append(hits[checkTrailingWhitespace], line)
+        }
+
+        trimmed := strings.TrimLeft(body, " \t")
+        indent  := body[:len(body) - len(trimmed)]
+
+        if (rules.Enabled(checkIndentation) &&
+            trimmed != "" &&
+            ((indentation == "spaces" && strings.Contains(indent, "\t")) ||
+             (indentation == "tabs" && strings.HasPrefix(indent, "  ")))) {
+            hits[checkIndentation] = append(hits[checkIndentation], line)
+        }
+
+        // The last line has no ending to be out of place
+        if (rules.Enabled(checkLineEndings) &&
+            mixed &&
+            line.RhLine < len(raw) &&
+            hasCr == crlfOutOfPlace) {
+            hits[checkLineEndings] = append(hits[checkLineEndings], line)
+        }
+
+        if (rules.Enabled(checkFinalNewline) &&
+            line.RhLine == len(raw) &&
+            line.Text != "") {
+            hits[checkFinalNewline] = append(hits[checkFinalNewline], line)
+        }
+
+        if (rules.Enabled(checkBom) &&
+            line.RhLine == 1 &&
+            bytes.HasPrefix(file.EntireFile, utf8Bom)) {
+            hits[checkBom] = append(hits[checkBom], line)
+        }
+
+        if (rules.Enabled(checkUtf8) && !utf8.ValidString(line.Text)) {
+            hits[checkUtf8] = append(hits[checkUtf8], line)
+        }
+    }
+
+    var otherIndentation string = "spaces"
+    var wrongEnding      string = "LF"
+    var rightEnding      string = "CRLF"
+
+    if (indentation == "spaces") {
+        otherIndentation = "tabs"
+    }
+
+    if (crlfOutOfPlace) {
+        wrongEnding, rightEnding = rightEnding, wrongEnding
+    }
+
+    texts := map[string][2]string{
+        checkTrailingWhitespace: {"This line has trailing whitespace",
+                                  "These lines have trailing whitespace"},
+        checkIndentation:        {"This line is indented with " +
+                                  otherIndentation + ", but the file is " +
+                                  "indented with " + indentation,
+                                  "These lines are indented with " +
+                                  otherIndentation + ", but the file is " +
+                                  "indented with " + indentation},
+        checkLineEndings:        {"This line ends with " + wrongEnding +
+                                  ", but the rest of the file uses " +
+                                  rightEnding,
+                                  "These lines end with " + wrongEnding +
+                                  ", but the rest of the file uses " +
+                                  rightEnding},
+        checkFinalNewline:       {"The file doesn't end with a newline", ""},
+        checkBom:                {"The file starts with a UTF-8 byte order " +
+                                  "mark", ""},
+        checkUtf8:               {"This line isn't valid UTF-8",
+                                  "These lines aren't valid UTF-8"},
+    }
+
+    for _, check := range checks {
+        CommentOnLines(check,
+                       hits[check],
+                       texts[check][0],
+                       texts[check][1],
+                       commentChan)
+    }
+}
+
+/**
+ * Works out whether every change in a set of files is to whitespace only.
+ */
+func WhitespaceOnly(files []reviewdata.FileDiff) bool {
+    var changes int = 0
+
+    for _, file := range files {
+        for _, chunk := range file.Diff_Data.Chunks {
+            if (chunk.Change == "equal") {
+                continue
+            }
+
+            for _, line := range chunk.Lines {
+                if (!line.WhitespaceOnly) {
+                    return false
+                }
+
+                changes++
+            }
+        }
+    }
+
+    return changes > 0
+}
+
+/**
+ * Runs the plugin on a review request.
+ *
+ * If configured to, labels review requests which only change whitespace.
+ */
+func (p Reviewer) CheckReview(review      reviewdata.ReviewRequest,
+                              commentChan chan <- string) interface{} {
+    if (config.WhitespaceReviewer.LabelWhitespaceOnly &&
+        WhitespaceOnly(review.Files)) {
+        commentChan <- config.WhitespaceReviewer.WhitespaceOnlyLabel
+    }
+
+    return nil
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Reviewer) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+
+    if (config.WhitespaceReviewer.WhitespaceOnlyLabel == "") {
+        config.WhitespaceReviewer.WhitespaceOnlyLabel =
+                    "This review request only changes whitespace"
+    }
+}
+
+// Export our plugin as a ReviewerPlugin for main to pick up
+var ReviewerPlugin Reviewer
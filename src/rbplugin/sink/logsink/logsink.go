@@ -0,0 +1,95 @@
+// Plugins must be built in the main package
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "sort"
+    "strings"
+
+    "rbplugindata/reviewdata"
+)
+
+type Config struct {
+    LogSink struct {
+        Ignore []string // Event types which should not be logged
+    }
+}
+
+var (
+    config Config
+)
+
+/**
+ * Base plugin struct, to which we'll add methods.
+ */
+type Sink struct {
+}
+
+/**
+ * Returns the plugin version.
+ */
+func (p Sink) Version() (int, int, int) {
+    return 0,0,0
+}
+
+/**
+ * Returns the plugin's canonical name.
+ */
+func (p Sink) CanonicalName() string {
+    return "LogSink"
+}
+
+/**
+ * Configures the plugin.
+ */
+func (p Sink) Configure(rawConfig json.RawMessage) {
+    json.Unmarshal(rawConfig, &config)
+}
+
+/**
+ * Formats an event's details as a sorted list of key=value pairs.
+ */
+func FormatDetails(details map[string]string) string {
+    var pairs []string
+
+    for k, v := range details {
+        pairs = append(pairs, k + "=" + v)
+    }
+
+    sort.Strings(pairs)
+
+    return strings.Join(pairs, " ")
+}
+
+/**
+ * Runs the plugin, logging every event that it receives.
+ */
+func (p Sink) Run(events <- chan reviewdata.Event) {
+    ignored := make(map[reviewdata.EventType]bool)
+
+    for _, eventType := range config.LogSink.Ignore {
+        ignored[reviewdata.EventType(eventType)] = true
+    }
+
+    for event := range events {
+        if (!ignored[event.Type]) {
+            // The default server has no name
+            var server string
+
+            if (event.Server != "") {
+                server = event.Server + "/"
+            }
+
+            fmt.Printf("[%s] Review %s%s: %s %s\n",
+                       event.Time.Format("15:04:05.000"),
+                       server,
+                       event.ReviewId,
+                       event.Type,
+                       FormatDetails(event.Details))
+        }
+    }
+}
+
+// Export our plugin as an EventSink for main to pick up
+var EventSink Sink
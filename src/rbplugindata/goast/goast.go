@@ -0,0 +1,33 @@
+/**
+ * Package contains helpers for plugins which analyse Go source. Kept apart
+ * from reviewdata, so that other plugins and the bot don't link go/ast.
+ */
+package goast
+
+import (
+    "go/ast"
+)
+
+/**
+ * Names a Go method's receiver type, as it is written in the method's
+ * declaration, without any type parameters.
+ *
+ * @param expr The receiver's type.
+ *
+ * @returns The name, or "?" if the type can't be named.
+ */
+func ReceiverName(expr ast.Expr) string {
+    switch e := expr.(type) {
+    case *ast.StarExpr:
+        return "*" + ReceiverName(e.X)
+    case *ast.IndexExpr:
+        return ReceiverName(e.X)
+    case *ast.IndexListExpr:
+        // More than one type parameter, e.g. Map[K, V]
+        return ReceiverName(e.X)
+    case *ast.Ident:
+        return e.Name
+    }
+
+    return "?"
+}
@@ -0,0 +1,48 @@
+/**
+ * Tests naming method receivers.
+ */
+package goast
+
+import (
+    "go/ast"
+    "go/parser"
+    "go/token"
+    "testing"
+)
+
+func TestReceiverName(t *testing.T) {
+    var tests = []struct {
+        receiver string
+        name     string
+    }{
+        {"(m Map)", "Map"},
+        {"(m *Map)", "*Map"},
+        {"(m *Map[K])", "*Map"},
+        {"(m Map[K, V])", "Map"},
+        {"(m *Map[K, V])", "*Map"},
+        {"(m *Map[_, _, _])", "*Map"},
+    }
+
+    for _, test := range tests {
+        file, err := parser.ParseFile(token.NewFileSet(),
+                                      "map.go",
+                                      "package p\nfunc " + test.receiver +
+                                      " Get() {}\n",
+                                      0)
+
+        if (err != nil) {
+            t.Errorf("Parsing %s failed: %s", test.receiver, err)
+            continue
+        }
+
+        decl := file.Decls[0].(*ast.FuncDecl)
+        name := ReceiverName(decl.Recv.List[0].Type)
+
+        if (name != test.name) {
+            t.Errorf("ReceiverName(%s) = %q; want %q",
+                     test.receiver,
+                     name,
+                     test.name)
+        }
+    }
+}
@@ -8,6 +8,7 @@ package reviewdata
 import (
     "encoding/json"
     "html"
+    "time"
 )
 
 // Contains structs which are used by plugins and passed through main
@@ -19,6 +20,15 @@ type ReviewResult struct {
     NumComments int
 }
 
+/**
+ * How serious a comment is.
+ */
+const (
+    SeverityInfo    = "info"
+    SeverityWarning = "warning"
+    SeverityError   = "error"
+)
+
 /**
  * A comment, as returned from review processing.
  */
@@ -30,6 +40,39 @@ type Comment struct {
     Text       string /**< The comment text. */
     RaiseIssue bool   /**< Whether an issue should be raised alongside the
                        *   comment. */
+    Severity   string /**< Optional. One of the Severity constants. If empty,
+                       *   comments raising issues are warnings, and others
+                       *   are info */
+    Rule       string /**< Optional. Identifies the check, within the plugin,
+                       *   which made the comment */
+    Plugin     string /**< The canonical name of the plugin which made the
+                       *   comment. Set by the bot */
+}
+
+/**
+ * A comment, along with the file on which it was made.
+ */
+type Finding struct {
+    Filename string
+    Line     int /**< The line in the modified file on which the comment
+                  *   starts, or zero if not known */
+    Comment  Comment
+}
+
+/**
+ * A summary of a finished review, for anything that wants to report on it.
+ */
+type ReviewSummary struct {
+    Server     string
+    ReviewId   string
+    Summary    string         /**< The review request's summary */
+    Link       string         /**< The review request's URL */
+    Comments   int            /**< The number of comments posted */
+    Issues     int            /**< The number of findings raising issues */
+    Files      []string       /**< Every file that was reviewed */
+    Severities map[string]int /**< The number of findings of each severity */
+    Findings   []Finding      /**< Every finding, most severe first. Includes
+                               *   any not posted due to the comment limit */
 }
 
 /**
@@ -38,16 +81,29 @@ type Comment struct {
 type Link struct {
     Href   string
     Method string
+    Title  string
 }
 
 /**
  * ReviewBoard's link contianer
  */
 type LinkContainer struct {
-    Diffs        Link
-    Latest_Diff  Link
-    Patched_File Link
-    Self         Link
+    Diffs         Link
+    Latest_Diff   Link
+    Original_File Link
+    Patched_File  Link
+    Repository    Link // Its title is the repository's name
+    Self          Link
+}
+
+/**
+ * What a requester plugin is told about one of the bot's review servers.
+ */
+type ServerProfile struct {
+    Name    string /**< Empty for the default server */
+    Backend string /**< "reviewboard" or "gerrit" */
+    ApiUrl  string /**< ReviewBoard's API URL, or Gerrit's URL */
+    Token   string /**< ReviewBoard's API token. Empty for Gerrit */
 }
 
 /**
@@ -57,19 +113,32 @@ type ReviewRequest struct {
     // Fields provided by ReviewBoard
     Id           int
     Summary      string
+    Description  string
     Commit_Id    string
+    Branch       string
     Bugs_Closed  []string
     Links        LinkContainer
     Testing_Done string
     Last_Updated string
+    Absolute_Url string
 
     //Fields that are used internally
     ReviewId  string /**< Populated if the review needs retrieval */
+    Server    string /**< The name of the server on which the review lives.
+                      *   Empty for the default server */
     Requester string /**< The name of the entity that requested the review
                	      *   request */
     SeenBefore bool  /**< Whether this review request has been seen before */
     Force      bool  /**< Whether we should force review, regardless of whether
                       *   we've seen the diff before */
+    StaleOnly  bool  /**< Only review if we've reviewed this before, with a
+                      *   different set of plugins, plugin versions or
+                      *   plugin config. Implies Force if so */
+    Files      []FileDiff /**< The files being reviewed. Populated before
+                           *   plugins' CheckReview is run */
+    SkippedFiles []FileDiff /**< Files left out of the review by their
+                             *   class's policy, e.g. vendored files.
+                             *   Populated along with Files */
 
     /** A  channel into which a ReviewResult shall be pushed when the review
      *  is complete. NOTE: This _must_ be created as a buffered channel. */
@@ -82,6 +151,9 @@ type ReviewRequest struct {
 type Line struct {
     ReviewLine     int    /**< The INTERNAL line against which comments should
                            *   be made. */
+    LhLine         int    /**< The line number from the left-hand file in a
+                           *   diff. Zero for added lines */
+    LhText         string /**< The original line text */
     RhLine         int    /**< The line number from the right-hand file in a
                            *   diff. */
     RhText         string /**< The modified line text */
@@ -98,18 +170,82 @@ type DiffChunk struct {
     Change string
 }
 
+/**
+ * What happened to a file in a diff.
+ */
+const (
+    FileAdded    = "added"
+    FileModified = "modified"
+    FileDeleted  = "deleted"
+    FileMoved    = "moved"
+    FileCopied   = "copied"
+)
+
+/**
+ * What kind of file a file is, as classified by the bot before any plugins
+ * are run.
+ */
+const (
+    ClassSource    = "source"
+    ClassGenerated = "generated" /**< e.g. "Code generated ... DO NOT EDIT." */
+    ClassVendored  = "vendored"  /**< Third-party code, copied in */
+    ClassBinary    = "binary"
+    ClassOversized = "oversized" /**< Too large to review */
+)
+
 /**
  * The diff of an entire file - consisting of an ordered list of chunks.
  */
 type FileDiff struct {
     Id       int
     Filename string
+    Status   string /**< One of the File constants, or empty if not known */
+    Class    string /**< One of the Class constants. Set by the bot */
 
     Diff_Data struct {
+        Binary bool /**< Whether the backend considers the file binary, in
+                     *   which case there are no chunks */
         Chunks []DiffChunk
     }
 
-    EntireFile []byte // The whole, raw, file
+    EntireFile   []byte // The whole, raw, file
+    OriginalFile []byte // The whole file before the change. Empty for new
+                        // files
+}
+
+/**
+ * The kind of thing that happened to a review.
+ */
+type EventType string
+
+const (
+    EventQueued         EventType = "queued"          /**< A review request has
+                                                       *   been received */
+    EventStarted        EventType = "started"         /**< Processing began */
+    EventFilesFetched   EventType = "files-fetched"   /**< All files retrieved */
+    EventPluginFinished EventType = "plugin-finished" /**< A plugin has finished
+                                                       *   checking a file */
+    EventCommentPosted  EventType = "comment-posted"  /**< A file comment was
+                                                       *   sent */
+    EventPublished      EventType = "published"       /**< The review is public */
+    EventSkipped        EventType = "skipped"         /**< The review was not
+                                                       *   performed */
+    EventFailed         EventType = "failed"          /**< Something went wrong */
+)
+
+/**
+ * A review lifecycle event, published by the bot as it works through a review.
+ */
+type Event struct {
+    Type     EventType
+    Server   string /**< The server on which the review lives */
+    ReviewId string
+    Time     time.Time
+    Details  map[string]string /**< Event-specific details, e.g. "plugin",
+                                *   "file" or "reason". Shared between all
+                                *   subscribers, so must not be modified. */
+    Review   *ReviewSummary    /**< Populated for published events. Also
+                                *   shared, so must not be modified. */
 }
 
 type CommentedFile struct {
@@ -120,6 +256,112 @@ type CommentedFile struct {
                                  *   lines, and start on the same line. */
 }
 
+/**
+ * Retrieves a comment's severity, defaulting it if the plugin did not set one.
+ */
+func (c Comment) EffectiveSeverity() string {
+    if (c.Severity != "") {
+        return c.Severity
+    } else if (c.RaiseIssue) {
+        return SeverityWarning
+    }
+
+    return SeverityInfo
+}
+
+/**
+ * Ranks a severity, such that more severe severities rank higher. Unknown
+ * severities rank lowest.
+ */
+func SeverityRank(severity string) int {
+    switch (severity) {
+    case SeverityError:
+        return 3
+    case SeverityWarning:
+        return 2
+    case SeverityInfo:
+        return 1
+    }
+
+    return 0
+}
+
+/**
+ * Finds the line in the modified file which corresponds to one of
+ * ReviewBoard's internal review lines.
+ *
+ * @param reviewLine The review line.
+ *
+ * @returns The modified file's line, or zero if the review line is not part of
+ *          the diff or was deleted.
+ */
+func (f FileDiff) RhLineFor(reviewLine int) int {
+    for _, chunk := range f.Diff_Data.Chunks {
+        for _, line := range chunk.Lines {
+            if (line.ReviewLine == reviewLine) {
+                return line.RhLine
+            }
+        }
+    }
+
+    return 0
+}
+
+/**
+ * Finds the review line nearest to a line in the original file: the line's own
+ * review line if it can be commented on, or else that of the next line that
+ * can be. Useful for commenting on something that a diff removes.
+ *
+ * @param lhLine The original file's line.
+ *
+ * @returns The review line, or zero if there isn't one.
+ */
+func (f FileDiff) ReviewLineForLh(lhLine int) int {
+    var found bool = false
+
+    for _, chunk := range f.Diff_Data.Chunks {
+        for _, line := range chunk.Lines {
+            if (line.LhLine >= lhLine && line.LhLine > 0) {
+                found = true
+            }
+
+            if (found && line.ReviewLine > 0) {
+                return line.ReviewLine
+            }
+        }
+    }
+
+    return 0
+}
+
+/**
+ * Maps the lines in the modified file onto the review lines against which
+ * comments on them are made.
+ *
+ * @returns A map of right-hand line to review line for every line in the diff,
+ *          and another for only the inserted and replaced lines.
+ */
+func (f FileDiff) ReviewLines() (map[int]int, map[int]int) {
+    all     := make(map[int]int)
+    changed := make(map[int]int)
+
+    for _, chunk := range f.Diff_Data.Chunks {
+        for _, line := range chunk.Lines {
+            if (line.RhLine == 0) {
+                continue
+            }
+
+            all[line.RhLine] = line.ReviewLine
+
+            if (chunk.Change == "insert" || chunk.Change == "replace") {
+                changed[line.RhLine] = line.ReviewLine
+            }
+        }
+    }
+
+    return all, changed
+}
+
 /**
  * Decodes a json object into a Line struct.
  */
@@ -135,6 +377,13 @@ func (c *Line) UnmarshalJSON(bs []byte) error {
         c.WhitespaceOnly = arr[7].(bool)
     }
 
+    // Pick up the line from the original file
+    lhLine, ok := arr[1].(float64)
+    if (ok) {
+        c.LhLine = int(lhLine)
+        c.LhText = html.UnescapeString(arr[2].(string))
+    }
+
     // Pick up the line from the modified file
     rhLine, ok := arr[4].(float64)
     if (ok) {